@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var addr, figi string
+	var price, step, balance float64
+	var tick time.Duration
+	flag.StringVar(&addr, "addr", "localhost:8443", "address to listen on")
+	flag.StringVar(&figi, "figi", "BBG004730N88", "figi of simulated share")
+	flag.Float64Var(&price, "price", 250, "initial price of simulated share")
+	flag.Float64Var(&step, "step", 0.1, "max price change per tick")
+	flag.DurationVar(&tick, "tick", time.Second, "price update interval")
+	flag.Float64Var(&balance, "balance", 100000, "initial balance of the account in rub")
+	flag.Parse()
+
+	server := fake.NewServer()
+	server.AddShare(&pb.Share{
+		Figi:                  figi,
+		Ticker:                "SBER",
+		ClassCode:             "TQBR",
+		Lot:                   10,
+		Currency:              "rub",
+		Name:                  "Simulated share",
+		MinPriceIncrement:     &pb.Quotation{Nano: 10000000},
+		ApiTradeAvailableFlag: true,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		TradingStatus:         pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+	})
+
+	accountID := server.AddAccount("fake")
+	if err := server.PayIn(accountID, &pb.MoneyValue{Units: int64(balance), Currency: "rub"}); err != nil {
+		fmt.Printf("can not pay in: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := server.Listen(addr); err != nil {
+		fmt.Printf("can not listen on %s: %v\n", addr, err)
+		os.Exit(1)
+	}
+	defer server.Stop()
+
+	fmt.Printf("fake API is listening on %s\n", server.Addr())
+	fmt.Printf("SDK_ENDPOINT=%s SDK_INSECURE=true TRADEBOT_ACCOUNT_ID=%s TRADEBOT_FIGI=%s\n", server.Addr(), accountID, figi)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	server.RandomWalk(ctx, figi, price, step, tick)
+}
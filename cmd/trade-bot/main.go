@@ -6,16 +6,15 @@
 	"github.com/elkopass/BITA/internal/loggy"
 	"github.com/elkopass/BITA/internal/metrics"
 	"github.com/elkopass/BITA/internal/sdk"
-	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	"github.com/elkopass/BITA/internal/trade/common"
 	"github.com/elkopass/BITA/internal/trade/strategy"
-	"github.com/elkopass/BITA/internal/trade/strategy/crumble"
-	"github.com/elkopass/BITA/internal/trade/strategy/gamble"
-	"github.com/elkopass/BITA/internal/trade/strategy/tumble"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 )
 
 func main() {
@@ -27,25 +26,50 @@ func main() {
 		log.Fatalf("please set your own API token in TRADEBOT_TOKEN env variable")
 	}
 
-	if cnf.IsSandbox {
+	strat, err := strategy.Get(cnf.Strategy)
+	if err != nil {
+		log.Fatalf("please check TRADEBOT_STRATEGY env variable: %v", err)
+	}
+	strategyConfig, err := strat.LoadConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	client, err := sdk.NewClient(sdk.DefaultClientConfig())
+	if err != nil {
+		log.Fatalf("can not connect to API: %v", err)
+	}
+	defer client.Close()
+
+	services := client.ServicePool()
+
+	switch broker.Kind() {
+	case broker.SANDBOX:
 		log.Infof("running in sandbox mode with %s strategy", cnf.Strategy)
 
-		service := sdk.NewSandboxService()
-		_, err := service.GetSandboxAccounts()
+		_, err := services.SandboxService.GetSandboxAccounts(context.Background())
 		if err != nil {
 			log.Fatalf("your API token does not exist")
 		}
-	} else {
+	case broker.SIMULATED:
+		log.Infof("running with simulated broker and %s strategy, orders are never sent to exchange", cnf.Strategy)
+
+		_, err := services.UsersService.GetInfo(context.Background())
+		if err != nil {
+			log.Fatalf("your API token is invalid or does not exist")
+		}
+	default:
 		if cnf.AccountID == "<your_api_token>" {
 			log.Fatalf("please specify your own account ID in TRADEBOT_ACCOUNT_ID env variable " +
 				"(compile and run '$ trade-utils -mode accounts' to get it)")
 		}
 
-		service := sdk.NewUsersService()
-		_, err := service.GetInfo()
+		info, err := services.UsersService.GetInfo(context.Background())
 		if err != nil {
 			log.Fatalf("your API token is invalid or does not exist")
 		}
+		log.Infof("user tariff: %s, qualified for work with %s",
+			info.Tariff, strings.Join(info.QualifiedForWorkWith, ","))
 
 		log.Warnf("[DANGER] running without sandbox with %s strategy and %s account ID, "+
 			"I hope you know what you doing", cnf.Strategy, cnf.AccountID)
@@ -59,20 +83,23 @@ func main() {
 		log.Fatalf("you need to specify at least one FIGI for trading in TRADEBOT_FIGI env variable")
 	}
 
-	// init trade bot
-	var bot trade.Trader
+	figi, err := common.ResolveInstruments(context.Background(), services.Instruments, cnf.Figi)
+	if err != nil {
+		log.Fatalf("please check TRADEBOT_FIGI env variable: %v", err)
+	}
 
-	switch cnf.Strategy {
-	case strategy.GAMBLE:
-		bot = gamble.NewTradeBot()
-	case strategy.TUMBLE:
-		bot = tumble.NewTradeBot()
-	case strategy.CRUMBLE:
-		bot = crumble.NewTradeBot()
+	// init broker
+	b, err := broker.New(context.Background(), services)
+	if err != nil {
+		log.Fatalf("can not create broker: %v", err)
+	}
+	log.Infof("trading with %s broker on account %s", b.Name(), b.AccountID())
 
-	default:
-		log.Fatalf("unknown strategy '%s'", cnf.Strategy)
-		return
+	// init trade bot
+	bot, err := strat.New(client, b, figi, strategyConfig)
+	if err != nil {
+		_ = b.Close(context.Background())
+		log.Fatalf("can not create %s trade bot: %v", strat.Name, err)
 	}
 
 	// setting up server for metricsConfig
@@ -97,7 +124,7 @@ func main() {
 			loggy.GetBotID(),
 			sdk.Version,
 			cnf.Strategy,
-			strconv.Itoa(len(cnf.Figi)),
+			strconv.Itoa(len(figi)),
 		).Inc()
 	}
 
@@ -117,5 +144,12 @@ func main() {
 		log.Errorf("failed to shutdown trade bot: +%v\n", err)
 	}
 
+	// ctx is already cancelled at this point
+	if err := b.Close(context.Background()); err != nil {
+		log.Errorf("can not close %s broker: %v", b.Name(), err)
+	} else {
+		log.Infof("%s broker on account %s closed successfully", b.Name(), b.AccountID())
+	}
+
 	log.Info("trade bot exited properly")
 }
@@ -0,0 +1,10 @@
+package main
+
+// Strategies register themselves in strategy registry when their packages are linked in.
+// To link in a strategy kept out of this repository, add a file with a blank import
+// of its package next to this one, e.g. guarded by a build tag.
+import (
+	_ "github.com/elkopass/BITA/internal/trade/strategy/crumble"
+	_ "github.com/elkopass/BITA/internal/trade/strategy/gamble"
+	_ "github.com/elkopass/BITA/internal/trade/strategy/tumble"
+)
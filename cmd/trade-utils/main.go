@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/elkopass/BITA/internal/config"
@@ -9,23 +10,43 @@
 	tradeutil "github.com/elkopass/BITA/internal/trade/util"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"os"
+	"strings"
 	"time"
 )
 
 var services *sdk.ServicePool
 
 func main() {
-	services = sdk.NewServicePool()
-
-	var mode string
+	var mode, figi, interval, format string
+	var days int
+	var offline bool
 	flag.StringVar(&mode, "mode", "", "running module")
+	flag.StringVar(&figi, "figi", "", "instrument for candles module, comma-separated list for warm module")
+	flag.StringVar(&interval, "interval", "1h", "candle interval for candles and warm modules: 1m, 5m, 15m, 1h or 1d")
+	flag.IntVar(&days, "days", 30, "how many last days candles, warm, report and dividends modules load")
+	flag.BoolVar(&offline, "offline", false, "candles module prints stored candles only, without API requests")
+	flag.StringVar(&format, "format", "csv", "output format for report and dividends modules: csv or json")
 	flag.Parse()
 
 	if len(mode) == 0 {
-		fmt.Println("Usage: trade-utils -mode [accounts|figi|operations]")
+		fmt.Println("Usage: trade-utils -mode [accounts|figi|operations|candles|warm|report|dividends|strategies]")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if mode == "strategies" {
+		printStrategies()
+		return
+	}
+
+	client, err := sdk.NewClient(sdk.DefaultClientConfig())
+	if err != nil {
+		fmt.Printf("can not connect to API: %v", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	services = client.ServicePool()
+
 	switch mode {
 	case "accounts":
 		printAvailableAccounts()
@@ -33,15 +54,21 @@ func main() {
 		printAvailableFigiList()
 	case "operations":
 		printLastOperations()
+	case "candles":
+		printCandles(figi, interval, days, offline)
+	case "warm":
+		warmCandleStore(strings.Split(figi, ","), interval, days)
+	case "report", "dividends":
+		exportReport(mode, format, days)
 	default:
-		fmt.Printf("unknown mode '%s'; possible values: accounts, figi", mode)
+		fmt.Printf("unknown mode '%s'; possible values: accounts, figi, operations, candles, warm, report, dividends, strategies", mode)
 		os.Exit(1)
 	}
 }
 
 // printAvailableAccounts gets accounts from sdk.UsersService.GetAccounts.
 func printAvailableAccounts() {
-	accounts, err := services.UsersService.GetAccounts()
+	accounts, err := services.UsersService.GetAccounts(context.Background())
 	if err != nil {
 		fmt.Printf("error getting accounts: %v", err)
 		os.Exit(1)
@@ -51,15 +78,15 @@ func printAvailableAccounts() {
 	for _, acc := range accounts {
 		fmt.Printf("[%s] %s (%s, %s)\n", acc.Id, acc.Name, acc.Status, acc.AccessLevel)
 
-		positions, err := services.OperationsService.GetPositions(acc.Id)
+		positions, err := services.OperationsService.GetPositions(context.Background(), acc.Id)
 		if err != nil {
 			fmt.Printf("not enough rights to get portfolio: %v \n", err)
 		} else {
 			fmt.Printf("Funds in the account:\n")
 			for _, mon := range positions.Money {
-				fmt.Printf("%s: %d.%d\n", mon.Currency, mon.Units, mon.Nano)
+				fmt.Printf("%s: %s\n", mon.Currency, tradeutil.DecimalFromMoneyValue(mon))
 			}
-			portfolio, err := services.OperationsService.GetPortfolio(acc.Id)
+			portfolio, err := services.OperationsService.GetPortfolio(context.Background(), acc.Id)
 			if err != nil {
 				fmt.Printf("not enough rights to get portfolio: %v \n", err)
 			} else {
@@ -73,18 +100,19 @@ func printAvailableAccounts() {
 func printPortfolio(portfolio pb.PortfolioResponse) {
 	fmt.Printf("Available tools:\n")
 	for _, pos := range portfolio.Positions {
-		instrument, err := services.InstrumentsService.GetInstrumentBy(
-			pb.InstrumentRequest{Id: pos.Figi, IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI})
+		instrument, err := services.Instruments.ByFigi(context.Background(), pos.Figi)
 		if err != nil {
 			fmt.Printf("error getting asset %s: %v\n", pos.Figi, err)
 			continue
 		}
 
-		candles, err := services.MarketDataService.GetCandles(
+		candles, err := services.Candles.GetCandles(
+			context.Background(),
 			pos.Figi,
-			timestamppb.New(time.Now().Add(-24*7*time.Hour)),
-			timestamppb.Now(),
+			time.Now().Add(-24*7*time.Hour),
+			time.Now(),
 			pb.CandleInterval_CANDLE_INTERVAL_HOUR,
+			false,
 		)
 		if err != nil {
 			fmt.Printf("error getting candles for %s: %v\n", pos.Figi, err)
@@ -92,8 +120,8 @@ func printPortfolio(portfolio pb.PortfolioResponse) {
 		}
 
 		volume, liquidity := tradeutil.CalculateVolumeAndLiquidity(candles)
-		averagePrice := tradeutil.MoneyValueToFloat(*pos.AveragePositionPrice)
-		currentPrice := tradeutil.MoneyValueToFloat(*pos.CurrentPrice)
+		averagePrice := tradeutil.DecimalFromMoneyValue(pos.AveragePositionPrice).Float64()
+		currentPrice := tradeutil.DecimalFromMoneyValue(pos.CurrentPrice).Float64()
 		currency := pos.AveragePositionPrice.Currency
 		yield := (currentPrice / averagePrice - 1) * 100
 
@@ -106,8 +134,7 @@ func printPortfolio(portfolio pb.PortfolioResponse) {
 
 // printAvailableFigiList gets shares and etfs with normal trading status.
 func printAvailableFigiList() {
-	services := sdk.NewServicePool()
-	shares, err := services.InstrumentsService.Shares(pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	shares, err := services.InstrumentsService.Shares(context.Background(), pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
 	if err != nil {
 		fmt.Printf("error getting shares: %v\n", err)
 		os.Exit(1)
@@ -123,7 +150,7 @@ func printAvailableFigiList() {
 		}
 	}
 
-	etfs, err := services.InstrumentsService.Etfs(pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	etfs, err := services.InstrumentsService.Etfs(context.Background(), pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
 	if err != nil {
 		fmt.Printf("error getting etfs: %v\n", err)
 		os.Exit(1)
@@ -142,8 +169,8 @@ func printAvailableFigiList() {
 
 // printLastOperations gets operations from sdk.OperationsService.GetOperations.
 func printLastOperations() {
-	services := sdk.NewServicePool()
 	operations, err := services.OperationsService.GetOperations(
+		context.Background(),
 		config.TradeBotConfig().AccountID,
 		timestamppb.New(time.Now().Add(-24*time.Hour)),
 		timestamppb.Now(),
@@ -155,34 +182,135 @@ func printLastOperations() {
 		os.Exit(1)
 	}
 
-	totalIncome := make(map[string]pb.MoneyValue)
+	totalIncome := make(map[string]tradeutil.Money)
 
 	fmt.Println("Executed orders (last 24 hours):")
 	for _, o := range operations {
 		mt := totalIncome[o.Currency]
+		price := tradeutil.MoneyFromMoneyValue(o.Price)
 
+		var err error
 		switch o.OperationType {
 		case pb.OperationType_OPERATION_TYPE_SELL:
-			mt.Units += o.Price.Units
-			mt.Nano += o.Price.Nano
+			mt, err = mt.Add(price)
 		case pb.OperationType_OPERATION_TYPE_BUY:
-			mt.Units -= o.Price.Units
-			mt.Nano -= o.Price.Nano
+			mt, err = mt.Sub(price)
 		case pb.OperationType_OPERATION_TYPE_BROKER_FEE:
-			mt.Units -= o.Price.Units
-			mt.Nano -= o.Price.Nano
+			mt, err = mt.Sub(price)
 		default:
 			fmt.Printf("%s is not supported!\n", o.OperationType.String())
 		}
+		if err != nil {
+			fmt.Printf("can not count income: %v\n", err)
+			continue
+		}
 		totalIncome[o.Currency] = mt
 
-		fmt.Printf("[%s] %s: %d.%d, %s (%s)\n",
-			o.Figi, o.OperationType.String(), o.Price.Units, o.Price.Nano, o.Currency, o.Date.AsTime())
+		fmt.Printf("[%s] %s: %s, %s (%s)\n",
+			o.Figi, o.OperationType.String(), price.Amount, o.Currency, o.Date.AsTime())
 	}
 
 	fmt.Println()
 	fmt.Printf("total income:\n")
-	for currency, mt := range totalIncome {
-		fmt.Printf("%d.%d %s\n", mt.Units, mt.Nano, currency)
+	for _, mt := range totalIncome {
+		fmt.Println(mt)
+	}
+}
+
+// candleIntervals maps -interval flag values to pb.CandleInterval.
+var candleIntervals = map[string]pb.CandleInterval{
+	"1m":  pb.CandleInterval_CANDLE_INTERVAL_1_MIN,
+	"5m":  pb.CandleInterval_CANDLE_INTERVAL_5_MIN,
+	"15m": pb.CandleInterval_CANDLE_INTERVAL_15_MIN,
+	"1h":  pb.CandleInterval_CANDLE_INTERVAL_HOUR,
+	"1d":  pb.CandleInterval_CANDLE_INTERVAL_DAY,
+}
+
+// parseCandleInterval converts -interval flag or exits with a hint.
+func parseCandleInterval(interval string) pb.CandleInterval {
+	candleInterval, ok := candleIntervals[interval]
+	if !ok {
+		fmt.Printf("unknown interval '%s'; possible values: 1m, 5m, 15m, 1h, 1d\n", interval)
+		os.Exit(1)
+	}
+
+	return candleInterval
+}
+
+// candleStoreDir returns configured candle store directory or exits with a hint.
+func candleStoreDir() string {
+	dir := config.SdkConfig().CandlesStoreDir
+	if dir == "" {
+		fmt.Println("candle store is disabled, please set SDK_CANDLES_STORE_DIR")
+		os.Exit(1)
+	}
+
+	return dir
+}
+
+// printCandles prints complete candles for the last days in CSV format;
+// candles are read through the candle store, if it is configured.
+func printCandles(figi, interval string, days int, offline bool) {
+	candleInterval := parseCandleInterval(interval)
+	if figi == "" {
+		fmt.Println("please specify instrument with -figi flag")
+		os.Exit(1)
+	}
+
+	source := services.Candles
+	if offline {
+		source = sdk.NewCandleStore(candleStoreDir(), nil)
+	}
+
+	candles, err := source.GetCandles(
+		context.Background(),
+		figi,
+		time.Now().AddDate(0, 0, -days),
+		time.Now(),
+		candleInterval,
+		true,
+	)
+	if err != nil {
+		fmt.Printf("error getting candles: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("time,open,high,low,close,volume")
+	for _, c := range candles {
+		fmt.Printf("%s,%s,%s,%s,%s,%d\n",
+			c.Time.AsTime().Format(time.RFC3339),
+			tradeutil.DecimalFromQuotation(c.Open),
+			tradeutil.DecimalFromQuotation(c.High),
+			tradeutil.DecimalFromQuotation(c.Low),
+			tradeutil.DecimalFromQuotation(c.Close),
+			c.Volume)
+	}
+}
+
+// warmCandleStore loads candles for the last days into the candle store, so bots and backtests
+// find them there; only candles missing in the store are requested.
+func warmCandleStore(figi []string, interval string, days int) {
+	candleInterval := parseCandleInterval(interval)
+	if len(figi) == 1 && figi[0] == "" {
+		fmt.Println("please specify instruments with -figi flag")
+		os.Exit(1)
+	}
+	store := sdk.NewCandleStore(candleStoreDir(), services.CandleFetcher)
+
+	for _, f := range figi {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		count, err := store.Warm(context.Background(), f, time.Now().AddDate(0, 0, -days), time.Now(), candleInterval)
+		if err != nil {
+			fmt.Printf("[%s] error loading candles: %v\n", f, err)
+			continue
+		}
+
+		from, to, _ := store.Covered(f, candleInterval)
+		fmt.Printf("[%s] %d candles stored, covered from %s to %s\n",
+			f, count, from.Format(time.RFC3339), to.Format(time.RFC3339))
 	}
 }
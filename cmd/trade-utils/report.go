@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	pb "github.com/elkopass/BITA/internal/proto"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"time"
+)
+
+// reportTimeout limits waiting for API to generate all parts of a report.
+const reportTimeout = 10 * time.Minute
+
+// exportReport prints broker or dividends report for the last days of TRADEBOT_ACCOUNT_ID in CSV or JSON format.
+func exportReport(mode, format string, days int) {
+	if format != "csv" && format != "json" {
+		fmt.Printf("unknown format '%s'; possible values: csv, json\n", format)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	accountID := config.TradeBotConfig().AccountID
+	from, to := time.Now().AddDate(0, 0, -days), time.Now()
+
+	var rows []proto.Message
+	var err error
+	switch mode {
+	case "report":
+		var report []*pb.BrokerReport
+		report, err = services.OperationsService.BrokerReport(ctx, accountID, from, to)
+		for _, r := range report {
+			rows = append(rows, r)
+		}
+	case "dividends":
+		var report []*pb.DividendsForeignIssuerReport
+		report, err = services.OperationsService.DividendsForeignIssuerReport(ctx, accountID, from, to)
+		for _, r := range report {
+			rows = append(rows, r)
+		}
+	}
+	if err != nil {
+		fmt.Printf("error getting report: %v\n", err)
+		os.Exit(1)
+	}
+
+	var columns []string
+	if mode == "report" {
+		columns = reportColumns(&pb.BrokerReport{})
+	} else {
+		columns = reportColumns(&pb.DividendsForeignIssuerReport{})
+	}
+
+	if format == "json" {
+		err = writeReportJSON(rows)
+	} else {
+		err = writeReportCSV(columns, rows)
+	}
+	if err != nil {
+		fmt.Printf("error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func writeReportCSV(columns []string, rows []proto.Message) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		values := reportRow(row)
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			record[i] = values[c]
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+func writeReportJSON(rows []proto.Message) error {
+	records := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, reportRow(row))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(records)
+}
+
+// reportColumns returns field names of a report row in proto order;
+// money fields get an extra <field>_currency column.
+func reportColumns(row proto.Message) []string {
+	var columns []string
+	fields := proto.MessageV2(row).ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		columns = append(columns, string(fd.Name()))
+		if isMessage(fd, "MoneyValue") {
+			columns = append(columns, string(fd.Name())+"_currency")
+		}
+	}
+
+	return columns
+}
+
+// reportRow flattens a report row: timestamps are formatted as RFC3339,
+// quotations and money values as decimals.
+func reportRow(row proto.Message) map[string]string {
+	values := make(map[string]string)
+	m := proto.MessageV2(row).ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := string(fd.Name())
+		v := m.Get(fd)
+
+		switch {
+		case fd.Message() == nil:
+			if ev := fd.Enum(); ev != nil && ev.Values().ByNumber(v.Enum()) != nil {
+				values[name] = string(ev.Values().ByNumber(v.Enum()).Name())
+			} else {
+				values[name] = fmt.Sprint(v.Interface())
+			}
+		case !m.Has(fd):
+			values[name] = ""
+			if isMessage(fd, "MoneyValue") {
+				values[name+"_currency"] = ""
+			}
+		case fd.Message().FullName() == "google.protobuf.Timestamp":
+			values[name] = v.Message().Interface().(*timestamppb.Timestamp).AsTime().Format(time.RFC3339)
+		case isMessage(fd, "Quotation"):
+			q := proto.MessageV1(v.Message().Interface()).(*pb.Quotation)
+			values[name] = tradeutil.DecimalFromQuotation(q).String()
+		case isMessage(fd, "MoneyValue"):
+			mv := proto.MessageV1(v.Message().Interface()).(*pb.MoneyValue)
+			values[name] = tradeutil.DecimalFromMoneyValue(mv).String()
+			values[name+"_currency"] = mv.Currency
+		default:
+			values[name] = fmt.Sprint(v.Message().Interface())
+		}
+	}
+
+	return values
+}
+
+func isMessage(fd protoreflect.FieldDescriptor, name protoreflect.Name) bool {
+	return fd.Message() != nil && fd.Message().Name() == name
+}
@@ -0,0 +1,25 @@
+package main
+
+// Strategies register themselves in strategy registry when their packages are linked in.
+// To link in a strategy kept out of this repository, add a file with a blank import
+// of its package next to this one, e.g. guarded by a build tag.
+import (
+	"fmt"
+	"github.com/elkopass/BITA/internal/trade/strategy"
+	_ "github.com/elkopass/BITA/internal/trade/strategy/crumble"
+	_ "github.com/elkopass/BITA/internal/trade/strategy/gamble"
+	_ "github.com/elkopass/BITA/internal/trade/strategy/tumble"
+	"os"
+)
+
+// printStrategies prints registered strategies with their config env variables.
+func printStrategies() {
+	for _, s := range strategy.List() {
+		fmt.Printf("%s: %s\n", s.Name, s.Description)
+		if err := s.PrintParameters(os.Stdout); err != nil {
+			fmt.Printf("error describing %s config: %v\n", s.Name, err)
+			os.Exit(1)
+		}
+		fmt.Println()
+	}
+}
@@ -4,26 +4,73 @@
 import (
 	"github.com/elkopass/BITA/internal/loggy"
 	"github.com/kelseyhightower/envconfig"
-	"time"
 )
 
 const (
-	ApiURL  = "invest-public-api.tinkoff.ru:443"
-	AppName = "elkopass.BITA"
-
-	DefaultRequestTimeout = 30 * time.Second
+	ApiURL        = "invest-public-api.tinkoff.ru:443"
+	SandboxApiURL = "sandbox-invest-public-api.tinkoff.ru:443"
+	AppName       = "elkopass.BITA"
 )
 
 type tradeBotConfig struct {
 	Figi []string `split_words:"true"`
 
 	IsSandbox  bool   `default:"true" split_words:"true"`
-	Token      string `required:"true"`
+	Broker     string // sandbox, production or simulated; chosen by IsSandbox if empty
+	Token      string // full access token, used for orders and for market data if MarketDataToken is not set
 	AccountID  string `split_words:"true"` // required in non-sandbox mode
 	Env        string `default:"UNSPECIFIED"`
 	LogLevel   string `default:"INFO" split_words:"true"`
 	Strategy   string `default:"gamble"`
 	SellOnExit bool   `default:"false" split_words:"true"`
+
+	TokenFile           string `split_words:"true"` // read Token from file, e.g. Docker secret; reloaded on change
+	MarketDataToken     string `split_words:"true"` // read-only token for market data, instruments and portfolio
+	MarketDataTokenFile string `split_words:"true"`
+
+	SimulatedBalance int `default:"100000" split_words:"true"` // initial rub balance of simulated broker
+
+	StateDir    string `split_words:"true"`                 // directory to persist worker state in, empty disables it
+	StrayOrders string `default:"adopt" split_words:"true"` // adopt, cancel or keep active orders found on start
+}
+
+type sdkConfig struct {
+	Endpoint string `default:"production"` // production, sandbox or any host:port
+	Insecure bool   `default:"false"`      // plaintext connection, e.g. to a local fake server
+
+	KeepaliveTimeSeconds    int `default:"60" split_words:"true"`
+	KeepaliveTimeoutSeconds int `default:"10" split_words:"true"`
+
+	RequestTimeoutSeconds int            `default:"30" split_words:"true"`
+	RequestTimeouts       map[string]int `split_words:"true"` // seconds by "Service" or "Service/Method"
+
+	RetryMaxAttempts                int `default:"3" split_words:"true"` // 1 disables retries
+	RetryInitialBackoffMilliseconds int `default:"200" split_words:"true"`
+	RetryMaxBackoffMilliseconds     int `default:"5000" split_words:"true"`
+
+	StreamReconnectInitialBackoffMilliseconds int `default:"500" split_words:"true"`
+	StreamReconnectMaxBackoffSeconds          int `default:"30" split_words:"true"`
+	StreamSilenceTimeoutSeconds               int `default:"300" split_words:"true"` // 0 disables silence detection
+
+	CandlesConcurrency int    `default:"4" split_words:"true"` // parallel GetCandles requests for long ranges
+	CandlesStoreDir    string `split_words:"true"`             // directory of local candle store, empty disables it
+
+	InstrumentsTtlMinutes int    `default:"1440" split_words:"true"` // how often instrument lists are reloaded
+	InstrumentsCacheFile  string `split_words:"true"`                // persist instrument lists, empty disables it
+
+	RecordFile string `split_words:"true"` // record all API traffic to this file
+	ReplayFile string `split_words:"true"` // serve recorded traffic instead of dialling API
+
+	// requests per minute by "Service" or "Service/Method", see https://tinkoff.github.io/investAPI/limits/
+	RateLimits map[string]int `default:"UsersService:100,InstrumentsService:200,MarketDataService:600,OperationsService:200,OrdersService:100,StopOrdersService:50,SandboxService:200" split_words:"true"`
+}
+
+type sandboxConfig struct {
+	AccountID   string `split_words:"true"`                // reuse this sandbox account instead of opening a new one
+	Reset       bool   `default:"false"`                   // cancel orders and sell all positions of reused account
+	CloseOnExit bool   `default:"true" split_words:"true"` // close the account opened on start, a reused one is never closed
+
+	PayIn map[string]string `default:"rub:100000" split_words:"true"` // target balance by currency
 }
 
 type metricsConfig struct {
@@ -49,6 +96,28 @@ type circuitBreakerConfig struct {
 		return config
 	}
 
+	// SdkConfig returns config for Invest API connection.
+	SdkConfig = func() sdkConfig {
+		var config sdkConfig
+		err := envconfig.Process("sdk", &config)
+		if err != nil {
+			loggy.GetLogger().Sugar().Fatalf("failed to process config: %v", err)
+		}
+
+		return config
+	}
+
+	// SandboxConfig returns config for sandbox account lifecycle.
+	SandboxConfig = func() sandboxConfig {
+		var config sandboxConfig
+		err := envconfig.Process("sandbox", &config)
+		if err != nil {
+			loggy.GetLogger().Sugar().Fatalf("failed to process config: %v", err)
+		}
+
+		return config
+	}
+
 	// MetricsConfig returns config for Prometheus exporter.
 	MetricsConfig = func() metricsConfig {
 		var config metricsConfig
@@ -7,6 +7,15 @@
 	"go.uber.org/zap/zapcore"
 	"os"
 	"strings"
+	"sync"
+)
+
+// redacted replaces secrets in logs and errors.
+const redacted = "***"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
 )
 
 var logger *zap.Logger
@@ -54,9 +63,88 @@ func init() {
 			EncodeCaller: zapcore.ShortCallerEncoder,
 		},
 	}
-	logger, _ = cfg.Build()
+	logger, _ = cfg.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactingCore{core}
+	}))
 }
 
 func GetLogger() *zap.Logger {
 	return logger
 }
+
+// minSecretLength protects logs from being garbled by placeholder tokens like "x":
+// real API tokens are much longer.
+const minSecretLength = 8
+
+// AddSecret makes the logger and Redact replace s, e.g. API token, with "***";
+// a secret shorter than minSecretLength is refused with a warning.
+func AddSecret(s string) {
+	if len(s) == 0 {
+		return
+	}
+	if len(s) < minSecretLength {
+		logger.Sugar().Warnf("a secret of %d characters is too short to be redacted in logs, "+
+			"it is probably not a real API token", len(s))
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, known := range secrets {
+		if known == s {
+			return
+		}
+	}
+	secrets = append(secrets, s)
+}
+
+// Redact replaces all secrets added with AddSecret in s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+
+	return s
+}
+
+// redactingCore removes secrets from messages, string and error fields before they are written.
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redactedFields := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = Redact(f.String)
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, Redact(err.Error()))
+			}
+		}
+		redactedFields[i] = f
+	}
+
+	return redactedFields
+}
@@ -0,0 +1,56 @@
+package loggy
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+const testSecret = "t.secret-api-token"
+
+func TestRedact(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	defer func(l *zap.Logger) { logger = l }(logger)
+	logger = zap.New(core)
+
+	AddSecret(testSecret)
+	AddSecret(testSecret)
+	AddSecret("x")
+	AddSecret("")
+
+	if got := Redact("Bearer " + testSecret); got != "Bearer ***" {
+		t.Errorf("Redact() = %q, want %q", got, "Bearer ***")
+	}
+	if got := Redact("x-tracking-id"); got != "x-tracking-id" {
+		t.Errorf("short secret must be refused, got %q", got)
+	}
+	if logs.Len() != 1 {
+		t.Errorf("only the short secret must be warned about, got %d warnings", logs.Len())
+	}
+}
+
+func TestRedactingCore(t *testing.T) {
+	AddSecret(testSecret)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(redactingCore{core}).With(zap.String("token", testSecret))
+	logger.Error("token "+testSecret+" is rejected",
+		zap.String("header", "Bearer "+testSecret),
+		zap.Error(errors.New("invalid token "+testSecret)))
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one log entry, got %d", len(entries))
+	}
+	want := map[string]interface{}{"token": "***", "header": "Bearer ***", "error": "invalid token ***"}
+	for key, value := range want {
+		if got := entries[0].ContextMap()[key]; got != value {
+			t.Errorf("field %s = %v, want %v", key, got, value)
+		}
+	}
+	if entries[0].Message != "token *** is rejected" {
+		t.Errorf("message = %q, want the token redacted", entries[0].Message)
+	}
+}
@@ -17,11 +17,32 @@
 		Name: "tradebot_api_requests",
 		Help: "Total requests to Tinkoff Invest API counter",
 	}, []string{"bot_id", "service", "method"})
-	// ApiCallErrors counts total number of failed API requests.
+	// ApiCallErrors counts total number of failed API requests by gRPC and Invest API error codes.
 	ApiCallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "tradebot_api_call_errors",
 		Help: "Total failed requests to Tinkoff Invest API counter",
-	}, []string{"bot_id", "service", "method", "error"})
+	}, []string{"bot_id", "service", "method", "code", "api_code"})
+	// ApiRequestDuration measures API requests latency including retries and throttling.
+	ApiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tradebot_api_request_duration_seconds",
+		Help:    "Tinkoff Invest API requests latency histogram",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"bot_id", "service", "method", "code"})
+	// ApiThrottledCalls counts requests delayed or rejected by client-side rate limiter.
+	ApiThrottledCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tradebot_api_throttled_calls",
+		Help: "Requests to Tinkoff Invest API throttled by client rate limiter counter",
+	}, []string{"bot_id", "service", "method", "outcome"})
+	// StreamReconnects counts reopened market data and trades streams.
+	StreamReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tradebot_stream_reconnects",
+		Help: "Broken or silent streams reconnect counter",
+	}, []string{"bot_id", "stream"})
+	// StreamDroppedMessages counts market data messages dropped for slow consumers.
+	StreamDroppedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tradebot_stream_dropped_messages",
+		Help: "Market data messages dropped for slow subscribers counter",
+	}, []string{"bot_id", "topic"})
 
 	// InstrumentsPurchased stores number of currently purchased instruments.
 	InstrumentsPurchased = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -53,6 +74,11 @@
 		Name: "tradebot_take_profit_decisions",
 		Help: "Take profit decisions counter",
 	}, []string{"bot_id", "figi"})
+	// StopOrdersTriggered counts exchange-side stop orders which closed a position.
+	StopOrdersTriggered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tradebot_stop_orders_triggered",
+		Help: "Triggered stop-loss and take-profit orders counter",
+	}, []string{"bot_id", "figi", "type"})
 	// StoppedByCircuitBreaker counts unhealthy workers removed by circuit breaker.
 	StoppedByCircuitBreaker = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "tradebot_stopped_by_circuit_breaker",
@@ -102,6 +128,10 @@ func init() {
 	/* sdk related metrics */
 	prometheus.MustRegister(ApiRequests)
 	prometheus.MustRegister(ApiCallErrors)
+	prometheus.MustRegister(ApiRequestDuration)
+	prometheus.MustRegister(ApiThrottledCalls)
+	prometheus.MustRegister(StreamReconnects)
+	prometheus.MustRegister(StreamDroppedMessages)
 
 	/* bot key actions */
 	prometheus.MustRegister(InstrumentsPurchased)
@@ -110,6 +140,7 @@ func init() {
 	prometheus.MustRegister(OrdersCancelled)
 	prometheus.MustRegister(StopLossDecisions)
 	prometheus.MustRegister(TakeProfitDecisions)
+	prometheus.MustRegister(StopOrdersTriggered)
 	prometheus.MustRegister(StoppedByCircuitBreaker)
 
 	/* additional trade statistics */
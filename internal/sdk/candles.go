@@ -0,0 +1,137 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultCandlesConcurrency = 4
+
+// maxCandlesWindows are the longest ranges GetCandles accepts for each interval,
+// see https://tinkoff.github.io/investAPI/load_history/
+var maxCandlesWindows = map[pb.CandleInterval]time.Duration{
+	pb.CandleInterval_CANDLE_INTERVAL_1_MIN:  24 * time.Hour,
+	pb.CandleInterval_CANDLE_INTERVAL_5_MIN:  24 * time.Hour,
+	pb.CandleInterval_CANDLE_INTERVAL_15_MIN: 24 * time.Hour,
+	pb.CandleInterval_CANDLE_INTERVAL_HOUR:   7 * 24 * time.Hour,
+	pb.CandleInterval_CANDLE_INTERVAL_DAY:    365 * 24 * time.Hour,
+}
+
+// CandleFetcher loads candles for ranges of any length: the range is split into
+// windows allowed by API, which are requested concurrently. Every request still
+// goes through client rate limiter, so long ranges just take longer.
+type CandleFetcher struct {
+	service     MarketDataInterface
+	concurrency int
+}
+
+// NewCandleFetcher creates CandleFetcher sending at most concurrency requests at once.
+func NewCandleFetcher(service MarketDataInterface, concurrency int) *CandleFetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &CandleFetcher{service: service, concurrency: concurrency}
+}
+
+// GetCandles returns candles in [from, to) ordered by time and without duplicates;
+// if completeOnly is set, candles which are still being formed are dropped.
+func (cf CandleFetcher) GetCandles(ctx context.Context, figi string, from, to time.Time, interval pb.CandleInterval, completeOnly bool) ([]*pb.HistoricCandle, error) {
+	windows, err := splitCandlesRange(from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([][]*pb.HistoricCandle, len(windows))
+	errs := make([]error, len(windows))
+	semaphore := make(chan struct{}, cf.concurrency)
+	wg := &sync.WaitGroup{}
+
+	for i, w := range windows {
+		wg.Add(1)
+		go func(i int, w [2]time.Time) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+
+			results[i], errs[i] = cf.service.GetCandles(ctx, figi, timestamppb.New(w[0]), timestamppb.New(w[1]), interval)
+			if errs[i] != nil {
+				cancel() // the rest is useless without this window
+			}
+		}(i, w)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("can not get candles from %s to %s: %v",
+				windows[i][0].Format(time.RFC3339), windows[i][1].Format(time.RFC3339), err)
+		}
+	}
+
+	return mergeCandles(results, completeOnly), nil
+}
+
+// splitCandlesRange splits [from, to) into consecutive windows allowed for interval.
+func splitCandlesRange(from, to time.Time, interval pb.CandleInterval) ([][2]time.Time, error) {
+	window, ok := maxCandlesWindows[interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported candle interval %s", interval)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid candles range: %s is not before %s",
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	var windows [][2]time.Time
+	for start := from; start.Before(to); start = start.Add(window) {
+		end := start.Add(window)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, [2]time.Time{start, end})
+	}
+
+	return windows, nil
+}
+
+// mergeCandles joins windows, removing candles returned twice on window borders.
+func mergeCandles(windows [][]*pb.HistoricCandle, completeOnly bool) []*pb.HistoricCandle {
+	seen := make(map[int64]bool)
+	var candles []*pb.HistoricCandle
+	for _, w := range windows {
+		for _, c := range w {
+			if c.Time == nil || (completeOnly && !c.IsComplete) {
+				continue
+			}
+
+			key := c.Time.AsTime().UnixNano()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			candles = append(candles, c)
+		}
+	}
+
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].Time.AsTime().Before(candles[j].Time.AsTime())
+	})
+
+	return candles
+}
@@ -0,0 +1,139 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sync"
+	"testing"
+	"time"
+)
+
+var candlesFrom = time.Date(2022, 5, 20, 0, 0, 0, 0, time.UTC)
+
+// testCandlesService returns a complete candle at the start and an incomplete one at the end
+// of every requested window, so neighbouring windows share a candle.
+type testCandlesService struct {
+	MarketDataInterface
+
+	mu       sync.Mutex
+	inFlight int
+	maxSeen  int
+	requests int
+	fail     func(from time.Time) error
+}
+
+func (s *testCandlesService) GetCandles(_ context.Context, _ string, from, to *timestamp.Timestamp, _ pb.CandleInterval) ([]*pb.HistoricCandle, error) {
+	s.mu.Lock()
+	s.requests++
+	s.inFlight++
+	if s.inFlight > s.maxSeen {
+		s.maxSeen = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+
+	if s.fail != nil {
+		if err := s.fail(from.AsTime()); err != nil {
+			return nil, err
+		}
+	}
+
+	return []*pb.HistoricCandle{
+		{Time: to, IsComplete: to.AsTime().Before(candlesFrom.Add(72 * time.Hour))},
+		{Time: from, IsComplete: true},
+	}, nil
+}
+
+func TestSplitCandlesRange(t *testing.T) {
+	to := candlesFrom.Add(50 * time.Hour)
+	windows, err := splitCandlesRange(candlesFrom, to, pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil {
+		t.Fatalf("can not split range: %v", err)
+	}
+
+	want := [][2]time.Time{
+		{candlesFrom, candlesFrom.Add(24 * time.Hour)},
+		{candlesFrom.Add(24 * time.Hour), candlesFrom.Add(48 * time.Hour)},
+		{candlesFrom.Add(48 * time.Hour), to},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("splitCandlesRange() = %v, want %v", windows, want)
+	}
+	for i := range want {
+		if !windows[i][0].Equal(want[i][0]) || !windows[i][1].Equal(want[i][1]) {
+			t.Errorf("window %d = %v, want %v", i, windows[i], want[i])
+		}
+	}
+
+	if _, err := splitCandlesRange(to, candlesFrom, pb.CandleInterval_CANDLE_INTERVAL_1_MIN); err == nil {
+		t.Error("reversed range must be rejected")
+	}
+	if _, err := splitCandlesRange(candlesFrom, to, pb.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED); err == nil {
+		t.Error("unspecified interval must be rejected")
+	}
+}
+
+func TestCandleFetcherGetCandles(t *testing.T) {
+	service := &testCandlesService{}
+	cf := NewCandleFetcher(service, 2)
+
+	to := candlesFrom.Add(72 * time.Hour)
+	candles, err := cf.GetCandles(context.Background(), "figi", candlesFrom, to, pb.CandleInterval_CANDLE_INTERVAL_HOUR, false)
+	if err != nil {
+		t.Fatalf("can not get candles: %v", err)
+	}
+	if service.requests != 1 || len(candles) != 2 {
+		t.Errorf("3 days of hour candles must be loaded by one request, got %d requests and %d candles", service.requests, len(candles))
+	}
+
+	service = &testCandlesService{}
+	cf = NewCandleFetcher(service, 2)
+	candles, err = cf.GetCandles(context.Background(), "figi", candlesFrom, to, pb.CandleInterval_CANDLE_INTERVAL_1_MIN, true)
+	if err != nil {
+		t.Fatalf("can not get candles: %v", err)
+	}
+	if service.requests != 3 || service.maxSeen > 2 {
+		t.Errorf("expected 3 requests with at most 2 at once, got %d requests and %d at once", service.requests, service.maxSeen)
+	}
+
+	// window borders are returned twice and the last candle is incomplete
+	if len(candles) != 3 {
+		t.Fatalf("expected 3 complete candles without duplicates, got %v", candles)
+	}
+	for i, c := range candles {
+		if want := candlesFrom.Add(time.Duration(i) * 24 * time.Hour); !c.Time.AsTime().Equal(want) {
+			t.Errorf("candle %d at %s, want %s", i, c.Time.AsTime(), want)
+		}
+	}
+}
+
+func TestCandleFetcherFailsWithWindow(t *testing.T) {
+	failed := errors.New("unavailable")
+	service := &testCandlesService{fail: func(from time.Time) error {
+		if from.After(candlesFrom) {
+			return failed
+		}
+		return nil
+	}}
+
+	cf := NewCandleFetcher(service, 1)
+	_, err := cf.GetCandles(context.Background(), "figi", candlesFrom, candlesFrom.Add(72*time.Hour), pb.CandleInterval_CANDLE_INTERVAL_1_MIN, false)
+	if err == nil {
+		t.Error("failed window must fail the whole range")
+	}
+}
+
+func TestMergeCandlesSkipsCandlesWithoutTime(t *testing.T) {
+	candles := mergeCandles([][]*pb.HistoricCandle{{{}, {Time: timestamppb.New(candlesFrom)}}}, false)
+	if len(candles) != 1 {
+		t.Errorf("mergeCandles() = %v, want one candle", candles)
+	}
+}
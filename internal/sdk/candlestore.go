@@ -0,0 +1,365 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStoredChunkSize protects from reading garbage as a huge chunk length.
+const maxStoredChunkSize = 256 << 20
+
+// candleDurations are the lengths of candles of each interval; day candles are truncated
+// to UTC midnight, which is not later than the start of the trading day.
+var candleDurations = map[pb.CandleInterval]time.Duration{
+	pb.CandleInterval_CANDLE_INTERVAL_1_MIN:  time.Minute,
+	pb.CandleInterval_CANDLE_INTERVAL_5_MIN:  5 * time.Minute,
+	pb.CandleInterval_CANDLE_INTERVAL_15_MIN: 15 * time.Minute,
+	pb.CandleInterval_CANDLE_INTERVAL_HOUR:   time.Hour,
+	pb.CandleInterval_CANDLE_INTERVAL_DAY:    24 * time.Hour,
+}
+
+// CandleSource loads candles in [from, to) ordered by time, see CandleFetcher.GetCandles.
+type CandleSource interface {
+	GetCandles(ctx context.Context, figi string, from, to time.Time, interval pb.CandleInterval, completeOnly bool) ([]*pb.HistoricCandle, error)
+}
+
+// CandleStore keeps complete candles on disk, one file per FIGI and interval.
+// For every series it knows a covered range, in which all complete candles are stored;
+// requests are served from the file and only ranges outside of it are loaded from source.
+// Candles which are still being formed are never stored, they are loaded every time.
+//
+// A file is a sequence of chunks appended after every load: the range the chunk covers
+// (two int64 unix nanoseconds) and a length-prefixed marshalled pb.GetCandlesResponse.
+// The file is read once, later requests are served from memory.
+type CandleStore struct {
+	dir    string
+	source CandleSource // nil means offline store
+
+	mu     sync.Mutex
+	series map[string]*candleSeries
+}
+
+type candleSeries struct {
+	mu      sync.Mutex
+	path    string
+	loaded  bool
+	from    time.Time // covered range, zero if nothing is stored
+	to      time.Time
+	candles []*pb.HistoricCandle // ordered by time
+}
+
+// NewCandleStore creates CandleStore in dir; the directory is created on the first write.
+// With nil source the store is offline: it serves stored candles only, e.g. for backtests.
+func NewCandleStore(dir string, source CandleSource) *CandleStore {
+	return &CandleStore{dir: dir, source: source, series: make(map[string]*candleSeries)}
+}
+
+// GetCandles returns candles in [from, to), loading the missing head and tail of the range from source.
+// Loaded complete candles are stored even if the file can not be written, the error is only logged.
+func (cs *CandleStore) GetCandles(ctx context.Context, figi string, from, to time.Time, interval pb.CandleInterval, completeOnly bool) ([]*pb.HistoricCandle, error) {
+	if _, ok := maxCandlesWindows[interval]; !ok {
+		return nil, fmt.Errorf("unsupported candle interval %s", interval)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid candles range: %s is not before %s",
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	s := cs.getSeries(figi, interval)
+	s.mu.Lock()
+	if err := s.load(); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if cs.source == nil {
+		defer s.mu.Unlock()
+		return s.between(from, to), nil
+	}
+	missing := s.missing(from, to)
+	s.mu.Unlock()
+
+	// the series is not locked while loading, so requests of other ranges are not blocked by it
+	loaded := make([][]*pb.HistoricCandle, len(missing))
+	for i, r := range missing {
+		candles, err := cs.source.GetCandles(ctx, figi, r[0], r[1], interval, false)
+		if err != nil {
+			return nil, err
+		}
+		loaded[i] = candles
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unstored []*pb.HistoricCandle
+	for i, r := range missing {
+		unstored = append(unstored, s.store(r[0], r[1], loaded[i], interval)...)
+	}
+
+	return s.result(from, to, unstored, completeOnly), nil
+}
+
+// Warm loads all missing candles in [from, to) and returns how many complete candles are stored for the range.
+func (cs *CandleStore) Warm(ctx context.Context, figi string, from, to time.Time, interval pb.CandleInterval) (int, error) {
+	candles, err := cs.GetCandles(ctx, figi, from, to, interval, true)
+	return len(candles), err
+}
+
+// Covered returns the range in which all complete candles of the series are stored, zero times if there are none.
+func (cs *CandleStore) Covered(figi string, interval pb.CandleInterval) (from, to time.Time, err error) {
+	s := cs.getSeries(figi, interval)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return s.from, s.to, nil
+}
+
+func (cs *CandleStore) getSeries(figi string, interval pb.CandleInterval) *candleSeries {
+	name := fmt.Sprintf("%s_%s.candles", figi, strings.TrimPrefix(interval.String(), "CANDLE_INTERVAL_"))
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	s, ok := cs.series[name]
+	if !ok {
+		s = &candleSeries{path: filepath.Join(cs.dir, name)}
+		cs.series[name] = s
+	}
+
+	return s
+}
+
+// store keeps complete candles loaded for [from, to) and returns the loaded candles which are not stored.
+// The range is covered up to the first forming candle, or up to the start of the current candle,
+// which may be not returned yet if nothing is traded in it, so it is loaded again next time.
+// The series is not locked while candles are loaded, so a range already covered by a concurrent
+// request is not stored twice, and a range apart from the covered one is not stored at all:
+// the covered range can not have gaps.
+func (s *candleSeries) store(from, to time.Time, candles []*pb.HistoricCandle, interval pb.CandleInterval) []*pb.HistoricCandle {
+	coveredTo := to
+	if current := time.Now().Truncate(candleDurations[interval]); coveredTo.After(current) {
+		coveredTo = current
+	}
+	for _, c := range candles {
+		if t := c.Time.AsTime(); !c.IsComplete && t.Before(coveredTo) {
+			coveredTo = t
+		}
+	}
+
+	switch {
+	case !from.Before(coveredTo):
+		return candles
+	case s.from.IsZero():
+	case from.After(s.to) || coveredTo.Before(s.from):
+		return candles // apart from the covered range
+	case !from.Before(s.from) && !coveredTo.After(s.to):
+		return nil // covered meanwhile
+	}
+
+	var stored, unstored []*pb.HistoricCandle
+	for _, c := range candles {
+		if c.IsComplete && c.Time.AsTime().Before(coveredTo) {
+			stored = append(stored, c)
+		} else {
+			unstored = append(unstored, c)
+		}
+	}
+
+	s.add(from, coveredTo, stored)
+	if len(stored) > 0 {
+		if err := s.append(from, coveredTo, stored); err != nil {
+			loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+				Warnf("can not write candles to %s: %v", s.path, err)
+		}
+	}
+
+	return unstored
+}
+
+// missing returns the head and the tail of [from, to) which are not covered.
+func (s *candleSeries) missing(from, to time.Time) [][2]time.Time {
+	if s.from.IsZero() {
+		return [][2]time.Time{{from, to}}
+	}
+
+	var missing [][2]time.Time
+	if from.Before(s.from) {
+		missing = append(missing, [2]time.Time{from, s.from})
+	}
+	if to.After(s.to) {
+		missing = append(missing, [2]time.Time{s.to, to})
+	}
+
+	return missing
+}
+
+// load reads the series file, if it was not read yet; a chunk cut by a crash is truncated.
+func (s *candleSeries) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can not open candles file: %v", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var valid int64
+	for {
+		from, to, candles, size, err := readCandlesChunk(r)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := os.Truncate(s.path, valid); err != nil {
+				return fmt.Errorf("can not truncate broken candles file: %v", err)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can not read candles file %s: %v", s.path, err)
+		}
+
+		s.add(from, to, candles)
+		valid += size
+	}
+
+	s.loaded = true
+	return nil
+}
+
+// add merges candles of [from, to) into memory and extends the covered range.
+func (s *candleSeries) add(from, to time.Time, candles []*pb.HistoricCandle) {
+	if s.from.IsZero() || from.Before(s.from) {
+		s.from = from
+	}
+	if to.After(s.to) {
+		s.to = to
+	}
+	switch {
+	case len(candles) == 0:
+	case len(s.candles) == 0 || s.candles[len(s.candles)-1].Time.AsTime().Before(candles[0].Time.AsTime()):
+		s.candles = append(s.candles, candles...) // the usual case: a tail of new candles
+	default:
+		s.candles = mergeCandles([][]*pb.HistoricCandle{s.candles, candles}, false)
+	}
+}
+
+// between returns stored candles in [from, to).
+func (s *candleSeries) between(from, to time.Time) []*pb.HistoricCandle {
+	start := sort.Search(len(s.candles), func(i int) bool {
+		return !s.candles[i].Time.AsTime().Before(from)
+	})
+	end := sort.Search(len(s.candles), func(i int) bool {
+		return !s.candles[i].Time.AsTime().Before(to)
+	})
+
+	return append([]*pb.HistoricCandle(nil), s.candles[start:end]...)
+}
+
+// result returns stored candles in [from, to) together with the loaded ones which are not stored.
+func (s *candleSeries) result(from, to time.Time, unstored []*pb.HistoricCandle, completeOnly bool) []*pb.HistoricCandle {
+	candles := s.between(from, to)
+	for _, c := range unstored {
+		if t := c.Time.AsTime(); !t.Before(from) && t.Before(to) {
+			candles = append(candles, c)
+		}
+	}
+
+	return mergeCandles([][]*pb.HistoricCandle{candles}, completeOnly)
+}
+
+// append writes a chunk to the end of the series file.
+func (s *candleSeries) append(from, to time.Time, candles []*pb.HistoricCandle) error {
+	data, err := proto.Marshal(&pb.GetCandlesResponse{Candles: candles})
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 16, 16+binary.MaxVarintLen64+len(data))
+	binary.BigEndian.PutUint64(chunk[0:8], uint64(from.UnixNano()))
+	binary.BigEndian.PutUint64(chunk[8:16], uint64(to.UnixNano()))
+	chunk = chunk[:16+binary.PutUvarint(chunk[16:16+binary.MaxVarintLen64], uint64(len(data)))]
+	chunk = append(chunk, data...)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(chunk); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// readCandlesChunk reads a chunk written by candleSeries.append and returns its size in bytes.
+func readCandlesChunk(r *bufio.Reader) (from, to time.Time, candles []*pb.HistoricCandle, size int64, err error) {
+	var bounds [16]byte
+	n, err := io.ReadFull(r, bounds[:])
+	if err == io.EOF {
+		return from, to, nil, 0, io.EOF
+	}
+	if err != nil {
+		return from, to, nil, 0, io.ErrUnexpectedEOF
+	}
+	size += int64(n)
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return from, to, nil, 0, io.ErrUnexpectedEOF
+	}
+	if length > maxStoredChunkSize {
+		return from, to, nil, 0, fmt.Errorf("chunk of %d bytes is too big", length)
+	}
+	size += int64(uvarintSize(length))
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return from, to, nil, 0, io.ErrUnexpectedEOF
+	}
+	size += int64(length)
+
+	response := &pb.GetCandlesResponse{}
+	if err := proto.Unmarshal(data, response); err != nil {
+		return from, to, nil, 0, err
+	}
+
+	from = time.Unix(0, int64(binary.BigEndian.Uint64(bounds[0:8])))
+	to = time.Unix(0, int64(binary.BigEndian.Uint64(bounds[8:16])))
+
+	return from, to, response.Candles, size, nil
+}
+
+func uvarintSize(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
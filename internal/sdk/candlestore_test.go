@@ -0,0 +1,205 @@
+package sdk
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var storeDay = time.Date(2022, 5, 20, 0, 0, 0, 0, time.UTC)
+
+// testCandleSource has a complete minute candle for every minute before formingFrom
+// and a forming one for every minute after it; it remembers requested ranges.
+type testCandleSource struct {
+	formingFrom time.Time
+	requests    [][2]time.Time
+}
+
+func (s *testCandleSource) GetCandles(_ context.Context, _ string, from, to time.Time, _ pb.CandleInterval, _ bool) ([]*pb.HistoricCandle, error) {
+	s.requests = append(s.requests, [2]time.Time{from, to})
+
+	var candles []*pb.HistoricCandle
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		candles = append(candles, &pb.HistoricCandle{
+			Time:       timestamppb.New(t),
+			Close:      &pb.Quotation{Units: int64(t.Minute())},
+			IsComplete: s.formingFrom.IsZero() || t.Before(s.formingFrom),
+		})
+	}
+
+	return candles, nil
+}
+
+func storeTime(hour, minute int) time.Time {
+	return storeDay.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func getStoredCandles(t *testing.T, cs *CandleStore, from, to time.Time, completeOnly bool) []*pb.HistoricCandle {
+	t.Helper()
+
+	candles, err := cs.GetCandles(context.Background(), "figi", from, to, pb.CandleInterval_CANDLE_INTERVAL_1_MIN, completeOnly)
+	if err != nil {
+		t.Fatalf("can not get candles: %v", err)
+	}
+	for i := 1; i < len(candles); i++ {
+		if !candles[i-1].Time.AsTime().Before(candles[i].Time.AsTime()) {
+			t.Fatalf("candles are not ordered at %d", i)
+		}
+	}
+
+	return candles
+}
+
+func TestCandleStoreFetchesMissingRanges(t *testing.T) {
+	source := &testCandleSource{}
+	cs := NewCandleStore(t.TempDir(), source)
+
+	if candles := getStoredCandles(t, cs, storeTime(10, 0), storeTime(11, 0), true); len(candles) != 60 {
+		t.Fatalf("expected 60 candles, got %d", len(candles))
+	}
+	if candles := getStoredCandles(t, cs, storeTime(10, 15), storeTime(10, 45), true); len(candles) != 30 || len(source.requests) != 1 {
+		t.Errorf("covered range must be served from store, got %d candles after %d requests", len(candles), len(source.requests))
+	}
+
+	if candles := getStoredCandles(t, cs, storeTime(9, 30), storeTime(11, 30), true); len(candles) != 120 {
+		t.Fatalf("expected 120 candles, got %d", len(candles))
+	}
+	want := [][2]time.Time{{storeTime(9, 30), storeTime(10, 0)}, {storeTime(11, 0), storeTime(11, 30)}}
+	if len(source.requests) != 3 {
+		t.Fatalf("expected head and tail requests, got %v", source.requests)
+	}
+	for i, w := range want {
+		if got := source.requests[i+1]; !got[0].Equal(w[0]) || !got[1].Equal(w[1]) {
+			t.Errorf("request %d = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestCandleStorePersistsCandles(t *testing.T) {
+	dir := t.TempDir()
+	getStoredCandles(t, NewCandleStore(dir, &testCandleSource{}), storeTime(10, 0), storeTime(11, 0), true)
+
+	offline := NewCandleStore(dir, nil)
+	from, to, err := offline.Covered("figi", pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil {
+		t.Fatalf("can not read store: %v", err)
+	}
+	if !from.Equal(storeTime(10, 0)) || !to.Equal(storeTime(11, 0)) {
+		t.Errorf("Covered() = %s, %s, want 10:00, 11:00", from, to)
+	}
+	if candles := getStoredCandles(t, offline, storeTime(9, 0), storeTime(12, 0), true); len(candles) != 60 {
+		t.Errorf("offline store must serve stored candles only, got %d", len(candles))
+	}
+}
+
+func TestCandleStoreDoesNotStoreFormingCandles(t *testing.T) {
+	source := &testCandleSource{formingFrom: storeTime(10, 50)}
+	cs := NewCandleStore(t.TempDir(), source)
+
+	if candles := getStoredCandles(t, cs, storeTime(10, 0), storeTime(11, 0), false); len(candles) != 60 {
+		t.Fatalf("forming candles must be returned, got %d candles", len(candles))
+	}
+	if candles := getStoredCandles(t, cs, storeTime(10, 0), storeTime(11, 0), true); len(candles) != 50 {
+		t.Errorf("expected 50 complete candles, got %d", len(candles))
+	}
+	if got := source.requests[len(source.requests)-1]; !got[0].Equal(storeTime(10, 50)) {
+		t.Errorf("range must be covered up to the first forming candle, then loaded from %s", got[0])
+	}
+}
+
+func TestCandleStoreDoesNotCoverCurrentCandle(t *testing.T) {
+	cs := NewCandleStore(t.TempDir(), &testCandleSource{})
+
+	// the current minute has no candle yet if nothing is traded in it
+	from := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	getStoredCandles(t, cs, from, from.Add(time.Hour), true)
+	_, to, err := cs.Covered("figi", pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil {
+		t.Fatalf("can not read store: %v", err)
+	}
+	if current := time.Now().Truncate(time.Minute); to.After(current) {
+		t.Errorf("Covered() = %s, must not be after the current candle start %s", to, current)
+	}
+}
+
+// blockingCandleSource holds a request of the range starting at blockFrom until release is closed.
+type blockingCandleSource struct {
+	blockFrom time.Time
+	blocked   chan struct{}
+	release   chan struct{}
+
+	mu     sync.Mutex
+	source testCandleSource
+}
+
+func (s *blockingCandleSource) GetCandles(ctx context.Context, figi string, from, to time.Time, interval pb.CandleInterval, completeOnly bool) ([]*pb.HistoricCandle, error) {
+	if from.Equal(s.blockFrom) {
+		close(s.blocked)
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.source.GetCandles(ctx, figi, from, to, interval, completeOnly)
+}
+
+func TestCandleStoreDoesNotLockSeriesWhileLoading(t *testing.T) {
+	source := &blockingCandleSource{blockFrom: storeTime(12, 0), blocked: make(chan struct{}), release: make(chan struct{})}
+	cs := NewCandleStore(t.TempDir(), source)
+
+	loaded := make(chan []*pb.HistoricCandle, 1)
+	go func() {
+		candles, _ := cs.GetCandles(context.Background(), "figi", storeTime(12, 0), storeTime(13, 0),
+			pb.CandleInterval_CANDLE_INTERVAL_1_MIN, true)
+		loaded <- candles
+	}()
+	<-source.blocked
+
+	// another range of the series is loaded while the first request waits for its candles
+	if candles := getStoredCandles(t, cs, storeTime(9, 0), storeTime(10, 0), true); len(candles) != 60 {
+		t.Fatalf("expected 60 candles, got %d", len(candles))
+	}
+	close(source.release)
+	if candles := <-loaded; len(candles) != 60 {
+		t.Errorf("blocked request: expected 60 candles, got %d", len(candles))
+	}
+
+	// candles of a range apart from the covered one are returned, but not stored, or 10:00-12:00 would be covered
+	from, to, err := cs.Covered("figi", pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil {
+		t.Fatalf("can not read store: %v", err)
+	}
+	if !from.Equal(storeTime(9, 0)) || !to.Equal(storeTime(10, 0)) {
+		t.Errorf("Covered() = %s, %s, want 09:00, 10:00", from, to)
+	}
+}
+
+func TestCandleStoreTruncatesBrokenChunk(t *testing.T) {
+	dir := t.TempDir()
+	getStoredCandles(t, NewCandleStore(dir, &testCandleSource{}), storeTime(10, 0), storeTime(11, 0), true)
+
+	path := filepath.Join(dir, "figi_1_MIN.candles")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("candles file is not written: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("can not open candles file: %v", err)
+	}
+	_, _ = file.Write([]byte{1, 2, 3}) // a chunk cut by a crash
+	_ = file.Close()
+
+	if candles := getStoredCandles(t, NewCandleStore(dir, nil), storeTime(10, 0), storeTime(11, 0), true); len(candles) != 60 {
+		t.Errorf("stored candles must survive a broken chunk, got %d", len(candles))
+	}
+	if truncated, err := os.Stat(path); err != nil || truncated.Size() != info.Size() {
+		t.Errorf("broken chunk must be truncated, got %v, %v", truncated, err)
+	}
+}
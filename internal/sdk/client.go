@@ -0,0 +1,197 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
+	"time"
+)
+
+// ClientConfig describes how Client reaches Invest API.
+type ClientConfig struct {
+	Target   string // config.ApiURL, config.SandboxApiURL or any host:port
+	Insecure bool   // plaintext connection, e.g. to a local fake server
+
+	TradingToken    *Token // used for orders, stop orders and sandbox; MarketDataToken if nil
+	MarketDataToken *Token // used for the rest of the API; TradingToken if nil
+
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+
+	RequestTimeout  time.Duration   // default deadline of unary calls, 0 means no deadline
+	RequestTimeouts RequestTimeouts // per service or method deadlines
+
+	Retry      RetryPolicy
+	RateLimits RateLimits // nil disables client-side rate limiting
+	Stream     StreamConfig
+
+	CandlesConcurrency int    // how many GetCandles windows CandleFetcher requests at once
+	CandlesStoreDir    string // if set, ServicePool.Candles keeps loaded candles in CandleStore there
+
+	InstrumentsTTL       time.Duration // how long InstrumentRegistry uses loaded instrument lists
+	InstrumentsCacheFile string        // if set, InstrumentRegistry persists instrument lists there
+
+	RecordFile string // if set, all API traffic is recorded to this file
+	ReplayFile string // if set, responses are served from this record file and API is never dialled
+
+	// DialOptions are appended to the defaults, e.g. grpc.WithContextDialer for bufconn.
+	DialOptions []grpc.DialOption
+}
+
+// DefaultClientConfig returns ClientConfig built from config.SdkConfig.
+func DefaultClientConfig() ClientConfig {
+	cnf := config.SdkConfig()
+
+	target := cnf.Endpoint
+	switch cnf.Endpoint {
+	case "production":
+		target = config.ApiURL
+	case "sandbox":
+		target = config.SandboxApiURL
+	}
+
+	trading, marketData := tokensFromConfig()
+
+	requestTimeouts := make(RequestTimeouts)
+	for key, seconds := range cnf.RequestTimeouts {
+		requestTimeouts[key] = time.Duration(seconds) * time.Second
+	}
+
+	return ClientConfig{
+		Target:           target,
+		Insecure:         cnf.Insecure,
+		TradingToken:     trading,
+		MarketDataToken:  marketData,
+		KeepaliveTime:    time.Duration(cnf.KeepaliveTimeSeconds) * time.Second,
+		KeepaliveTimeout: time.Duration(cnf.KeepaliveTimeoutSeconds) * time.Second,
+		RequestTimeout:   time.Duration(cnf.RequestTimeoutSeconds) * time.Second,
+		RequestTimeouts:  requestTimeouts,
+		Retry: RetryPolicy{
+			MaxAttempts:    cnf.RetryMaxAttempts,
+			InitialBackoff: time.Duration(cnf.RetryInitialBackoffMilliseconds) * time.Millisecond,
+			MaxBackoff:     time.Duration(cnf.RetryMaxBackoffMilliseconds) * time.Millisecond,
+		},
+		RateLimits:           cnf.RateLimits,
+		RecordFile:           cnf.RecordFile,
+		CandlesConcurrency:   cnf.CandlesConcurrency,
+		CandlesStoreDir:      cnf.CandlesStoreDir,
+		InstrumentsTTL:       time.Duration(cnf.InstrumentsTtlMinutes) * time.Minute,
+		InstrumentsCacheFile: cnf.InstrumentsCacheFile,
+		ReplayFile:           cnf.ReplayFile,
+		Stream: StreamConfig{
+			Reconnect: RetryPolicy{
+				InitialBackoff: time.Duration(cnf.StreamReconnectInitialBackoffMilliseconds) * time.Millisecond,
+				MaxBackoff:     time.Duration(cnf.StreamReconnectMaxBackoffSeconds) * time.Second,
+			},
+			SilenceTimeout: time.Duration(cnf.StreamSilenceTimeoutSeconds) * time.Second,
+		},
+	}
+}
+
+// clientConn is either *grpc.ClientConn or *replayConn.
+type clientConn interface {
+	grpc.ClientConnInterface
+	Close() error
+}
+
+// Client owns a single connection to Invest API and hands out all services and streams.
+type Client struct {
+	conn     clientConn
+	recorder *Recorder
+	services *ServicePool
+	stream   StreamConfig
+}
+
+func NewClient(cfg ClientConfig) (*Client, error) {
+	if cfg.ReplayFile != "" {
+		conn, err := newReplayConn(cfg.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Client{conn: conn, services: newClientServicePool(conn, cfg), stream: cfg.Stream}, nil
+	}
+
+	var recorder *Recorder
+	if cfg.RecordFile != "" {
+		var err error
+		recorder, err = NewRecorder(cfg.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := createClientConn(cfg, recorder)
+	if err != nil {
+		if recorder != nil {
+			_ = recorder.Close()
+		}
+		return nil, err
+	}
+
+	return &Client{conn: conn, recorder: recorder, services: newClientServicePool(conn, cfg), stream: cfg.Stream}, nil
+}
+
+func newClientServicePool(conn grpc.ClientConnInterface, cfg ClientConfig) *ServicePool {
+	services := NewServicePool(conn)
+	if cfg.CandlesConcurrency > 0 {
+		services.CandleFetcher = *NewCandleFetcher(services.MarketDataService, cfg.CandlesConcurrency)
+		services.Candles = services.CandleFetcher
+	}
+	if cfg.CandlesStoreDir != "" {
+		services.Candles = NewCandleStore(cfg.CandlesStoreDir, services.CandleFetcher)
+	}
+	if cfg.InstrumentsTTL > 0 || cfg.InstrumentsCacheFile != "" {
+		services.Instruments = NewInstrumentRegistry(&services.InstrumentsService, cfg.InstrumentsTTL, cfg.InstrumentsCacheFile)
+	}
+
+	return services
+}
+
+// Conn returns the underlying connection shared by all services.
+func (c *Client) Conn() grpc.ClientConnInterface {
+	return c.conn
+}
+
+// ServicePool returns non-stream services bound to the client connection.
+func (c *Client) ServicePool() *ServicePool {
+	return c.services
+}
+
+// NewMarketDataStream opens a new self-healing market data stream over the client connection.
+// The stream is closed when ctx is cancelled.
+func (c *Client) NewMarketDataStream(ctx context.Context) (*MarketDataStream, error) {
+	return NewMarketDataStream(ctx, c.conn, c.stream)
+}
+
+// NewSubscriptionManager opens a new market data stream shared by typed subscriptions.
+// All subscriptions are closed when ctx is cancelled.
+func (c *Client) NewSubscriptionManager(ctx context.Context) (*SubscriptionManager, error) {
+	stream, err := c.NewMarketDataStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSubscriptionManager(stream), nil
+}
+
+// NewOrdersStream opens a new self-healing trades stream over the client connection.
+// The stream is closed when ctx is cancelled.
+func (c *Client) NewOrdersStream(ctx context.Context, request *pb.TradesStreamRequest) (*OrdersStream, error) {
+	return NewOrdersStream(ctx, c.conn, request, c.stream)
+}
+
+// Close closes the underlying connection and record file; all services become unusable.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	if c.recorder != nil {
+		if recErr := c.recorder.Close(); recErr != nil && err == nil {
+			err = fmt.Errorf("can not write record file: %v", recErr)
+		}
+	}
+
+	return err
+}
@@ -3,13 +3,46 @@
 
 import (
 	"crypto/tls"
-	"github.com/elkopass/BITA/internal/config"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
-func createClientConn() (*grpc.ClientConn, error) {
-	tlsConfig := tls.Config{}
+// createClientConn dials Invest API (or anything pretending to be it) once per Client.
+func createClientConn(cfg ClientConfig, recorder *Recorder) (*grpc.ClientConn, error) {
+	transport := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	if cfg.Insecure {
+		transport = grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
 
-	return grpc.Dial(config.ApiURL, grpc.WithTransportCredentials(credentials.NewTLS(&tlsConfig)))
+	unary := []grpc.UnaryClientInterceptor{metricsUnaryInterceptor()}
+	stream := []grpc.StreamClientInterceptor{metricsStreamInterceptor()}
+	if recorder != nil {
+		// recorder sees calls the same way service wrappers do, after retries and timeouts
+		unary = append(unary, recordUnaryInterceptor(recorder))
+		stream = append(stream, recordStreamInterceptor(recorder))
+	}
+	// timeout covers all retries, every retry attempt goes through the rate limiter separately
+	unary = append(unary,
+		timeoutUnaryInterceptor(cfg.RequestTimeout, cfg.RequestTimeouts),
+		retryUnaryInterceptor(cfg.Retry),
+		rateLimitUnaryInterceptor(newRateLimiter(cfg.RateLimits)),
+		authUnaryInterceptor(cfg.TradingToken, cfg.MarketDataToken),
+	)
+	stream = append(stream, authStreamInterceptor(cfg.TradingToken, cfg.MarketDataToken))
+
+	opts := []grpc.DialOption{
+		transport,
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.KeepaliveTime,
+			Timeout:             cfg.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	}
+	opts = append(opts, cfg.DialOptions...)
+
+	return grpc.Dial(cfg.Target, opts...)
 }
@@ -3,32 +3,55 @@
 
 import (
 	"context"
-	"fmt"
 	"github.com/elkopass/BITA/internal/config"
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
 	grpcMetadata "google.golang.org/grpc/metadata"
+	"time"
 )
 
-// createRequestContext returns context for API calls with timeout and auth headers attached.
-func createRequestContext() (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithTimeout(context.Background(), config.DefaultRequestTimeout)
-
-	authHeader := fmt.Sprintf("Bearer %s", config.TradeBotConfig().Token)
-	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "authorization", authHeader)
+// createRequestContext attaches tracking headers to the caller context;
+// authorization is added by authUnaryInterceptor and deadlines by timeoutUnaryInterceptor.
+func createRequestContext(ctx context.Context) context.Context {
 	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-tracking-id", uuid.New().String())
 	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-app-name", config.AppName)
 
-	return ctx, cancel
+	return ctx
 }
 
-// createRequestContext returns context for streams with auth headers attached.
-func createStreamContext() context.Context {
-	ctx := context.TODO()
+// createStreamContext returns cancellable context for streams with tracking headers attached.
+func createStreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
 
-	authHeader := fmt.Sprintf("Bearer %s", config.TradeBotConfig().Token)
-	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "authorization", authHeader)
 	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-tracking-id", uuid.New().String())
 	ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-app-name", config.AppName)
 
-	return ctx
+	return ctx, cancel
+}
+
+// RequestTimeouts maps "Service" or "Service/Method" to a deadline of the whole call, retries included.
+type RequestTimeouts map[string]time.Duration
+
+// timeoutUnaryInterceptor limits every call with its configured timeout;
+// caller deadline wins if it is earlier.
+func timeoutUnaryInterceptor(defaultTimeout time.Duration, timeouts RequestTimeouts) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, method := splitMethodName(fullMethod)
+
+		timeout, ok := timeouts[service+"/"+method]
+		if !ok {
+			timeout, ok = timeouts[service]
+		}
+		if !ok {
+			timeout = defaultTimeout
+		}
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, fullMethod, req, reply, cc, opts...)
+	}
 }
@@ -0,0 +1,122 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+)
+
+// ApiError is returned by all unary service calls that reached (or tried to reach) the API.
+// It keeps gRPC status, so status.Code(err) still works on it.
+type ApiError struct {
+	Method     string     // full gRPC method name
+	Code       codes.Code // gRPC status code
+	ApiCode    string     // numeric Invest API error code, e.g. "30042"; empty if API did not send it
+	Message    string     // API error description from "message" trailer or status message
+	TrackingID string     // x-tracking-id of the request, useful for support tickets
+	Attempts   int        // how many times the request was sent
+
+	err error
+}
+
+func (e *ApiError) Error() string {
+	code := e.Code.String()
+	if e.ApiCode != "" && e.ApiCode != e.Message {
+		code += " " + e.ApiCode
+	}
+
+	return loggy.Redact(fmt.Sprintf("%s failed after %d attempt(s): %s: %s (tracking id: %s)",
+		e.Method, e.Attempts, code, e.Message, e.TrackingID))
+}
+
+// Is makes errors.Is(err, ErrInsufficientBalance) and other sentinels from apiErrorCodes work.
+func (e *ApiError) Is(target error) bool {
+	if target == ErrRateLimited && e.Code == codes.ResourceExhausted {
+		return true // client rate limiter and API limits without code
+	}
+
+	sentinel, ok := apiErrorCodes[e.ApiCode]
+	return ok && sentinel == target
+}
+
+// GRPCStatus allows status.FromError and status.Code to see the original status.
+func (e *ApiError) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.err
+}
+
+// NewApiError creates ApiError for an error produced without a request to API, e.g. by simulated
+// broker, so callers handle it the same way as a real one.
+func NewApiError(method string, code codes.Code, apiCode, message string) *ApiError {
+	return &ApiError{
+		Method:  method,
+		Code:    code,
+		ApiCode: apiCode,
+		Message: message,
+		err:     status.Error(code, apiCode),
+	}
+}
+
+// IsTransient reports whether err is a temporary API failure that is worth retrying later.
+func IsTransient(err error) bool {
+	return transientCodes[status.Code(err)]
+}
+
+var transientCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// Errors for known Invest API error codes, check them with errors.Is.
+var (
+	ErrInsufficientBalance   = errors.New("insufficient balance")
+	ErrInstrumentNotTradable = errors.New("instrument is not available for trading")
+	ErrOrderNotFound         = errors.New("order not found")
+	ErrStopOrderNotFound     = errors.New("stop order not found")
+	ErrPermissionDenied      = errors.New("token has not enough rights")
+	ErrUnauthenticated       = errors.New("token is invalid or expired")
+	ErrRateLimited           = errors.New("request limit exceeded")
+	ErrApiInternal           = errors.New("internal API error")
+	ErrReportNotReady        = errors.New("report is being generated")
+)
+
+// apiErrorCodes maps Invest API error codes to errors above,
+// see https://tinkoff.github.io/investAPI/errors/ for the full list.
+var apiErrorCodes = map[string]error{
+	"30034": ErrInsufficientBalance,   // not enough balance for the order
+	"30042": ErrInsufficientBalance,   // not enough assets for a margin trade
+	"30052": ErrInstrumentNotTradable, // instrument is forbidden for trading via API
+	"30079": ErrInstrumentNotTradable, // instrument is not available for trading now
+	"40002": ErrPermissionDenied,      // e.g. read-only token posts an order
+	"40003": ErrUnauthenticated,       // token is not found or not active
+	"50002": ErrInstrumentNotFound,    // instrument is not found
+	"50005": ErrOrderNotFound,         // order is not found, e.g. it is already executed or cancelled
+	"50006": ErrStopOrderNotFound,     // stop order is not found
+	"50010": ErrReportNotReady,        // ordered report is not generated yet, its pages are requested later
+	"70001": ErrApiInternal,           // internal error, worth retrying later
+	"80001": ErrRateLimited,           // concurrent streams limit exceeded
+	"80002": ErrRateLimited,           // requests per minute limit exceeded
+}
+
+// parseApiCode returns numeric Invest API error code (e.g. "30042") sent as status message;
+// free-form messages are ignored.
+func parseApiCode(err error) string {
+	msg := status.Convert(err).Message()
+	if len(msg) == 0 || len(msg) > 6 {
+		return ""
+	}
+	if _, convErr := strconv.Atoi(msg); convErr != nil {
+		return ""
+	}
+
+	return msg
+}
@@ -0,0 +1,84 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestParseApiCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{status.Error(codes.InvalidArgument, "30042"), "30042"},
+		{status.Error(codes.NotFound, "50005"), "50005"},
+		{status.Error(codes.Unavailable, "transport is closing"), ""},
+		{status.Error(codes.Internal, ""), ""},
+		{status.Error(codes.Internal, "1234567"), ""},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseApiCode(tt.err); got != tt.want {
+			t.Errorf("parseApiCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestApiErrorIs(t *testing.T) {
+	tests := []struct {
+		err    error
+		target error
+	}{
+		{status.Error(codes.InvalidArgument, "30042"), ErrInsufficientBalance},
+		{status.Error(codes.FailedPrecondition, "30079"), ErrInstrumentNotTradable},
+		{status.Error(codes.NotFound, "50005"), ErrOrderNotFound},
+		{status.Error(codes.NotFound, "50002"), ErrInstrumentNotFound},
+		{status.Error(codes.ResourceExhausted, "80002"), ErrRateLimited},
+		{status.Error(codes.ResourceExhausted, "request limit exceeded"), ErrRateLimited},
+	}
+
+	for _, tt := range tests {
+		calls := 0
+		err := retryUnaryInterceptor(RetryPolicy{})(context.Background(), getOrdersMethod, &pb.GetOrdersRequest{}, nil, nil,
+			failingInvoker(&calls, tt.err))
+
+		if !errors.Is(err, tt.target) {
+			t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.target)
+		}
+		if status.Code(err) != status.Code(tt.err) {
+			t.Errorf("status.Code(%v) = %s, want %s", err, status.Code(err), status.Code(tt.err))
+		}
+	}
+
+	err := NewApiError("PostOrder", codes.InvalidArgument, "30042", "not enough assets")
+	if !errors.Is(err, ErrInsufficientBalance) || errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("NewApiError() must match only its own sentinel, got %v", err)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("status.Code(NewApiError()) = %s, want %s", status.Code(err), codes.InvalidArgument)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{status.Error(codes.InvalidArgument, "30042"), false},
+		{NewApiError("GetOrders", codes.Unavailable, "", "unavailable"), true},
+		{errors.New("some error"), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc/codes"
+)
+
+type instrumentsServer struct {
+	pb.UnimplementedInstrumentsServiceServer
+	*Server
+}
+
+// AddShare registers a share; trading status is also used by GetTradingStatus.
+func (s *Server) AddShare(share *pb.Share) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shares = append(s.shares, share)
+	s.tradingStatuses[share.Figi] = share.TradingStatus
+}
+
+// AddEtf registers an investment fund.
+func (s *Server) AddEtf(etf *pb.Etf) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.etfs = append(s.etfs, etf)
+	s.tradingStatuses[etf.Figi] = etf.TradingStatus
+}
+
+// AddBond registers a bond.
+func (s *Server) AddBond(bond *pb.Bond) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bonds = append(s.bonds, bond)
+	s.tradingStatuses[bond.Figi] = bond.TradingStatus
+}
+
+// AddFuture registers a future.
+func (s *Server) AddFuture(future *pb.Future) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.futures = append(s.futures, future)
+	s.tradingStatuses[future.Figi] = future.TradingStatus
+}
+
+// AddCurrency registers a currency.
+func (s *Server) AddCurrency(currency *pb.Currency) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.currencies = append(s.currencies, currency)
+	s.tradingStatuses[currency.Figi] = currency.TradingStatus
+}
+
+func (is *instrumentsServer) Shares(context.Context, *pb.InstrumentsRequest) (*pb.SharesResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return &pb.SharesResponse{Instruments: is.shares}, nil
+}
+
+func (is *instrumentsServer) Etfs(context.Context, *pb.InstrumentsRequest) (*pb.EtfsResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return &pb.EtfsResponse{Instruments: is.etfs}, nil
+}
+
+func (is *instrumentsServer) Bonds(context.Context, *pb.InstrumentsRequest) (*pb.BondsResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return &pb.BondsResponse{Instruments: is.bonds}, nil
+}
+
+func (is *instrumentsServer) Futures(context.Context, *pb.InstrumentsRequest) (*pb.FuturesResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return &pb.FuturesResponse{Instruments: is.futures}, nil
+}
+
+func (is *instrumentsServer) Currencies(context.Context, *pb.InstrumentsRequest) (*pb.CurrenciesResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return &pb.CurrenciesResponse{Instruments: is.currencies}, nil
+}
+
+func (is *instrumentsServer) ShareBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.ShareResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for _, i := range is.shares {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.ShareResponse{Instrument: i}, nil
+		}
+	}
+	return nil, instrumentNotFound(ctx)
+}
+
+func (is *instrumentsServer) EtfBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.EtfResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for _, i := range is.etfs {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.EtfResponse{Instrument: i}, nil
+		}
+	}
+	return nil, instrumentNotFound(ctx)
+}
+
+func (is *instrumentsServer) BondBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.BondResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for _, i := range is.bonds {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.BondResponse{Instrument: i}, nil
+		}
+	}
+	return nil, instrumentNotFound(ctx)
+}
+
+func (is *instrumentsServer) FutureBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.FutureResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for _, i := range is.futures {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.FutureResponse{Instrument: i}, nil
+		}
+	}
+	return nil, instrumentNotFound(ctx)
+}
+
+func (is *instrumentsServer) CurrencyBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.CurrencyResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for _, i := range is.currencies {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.CurrencyResponse{Instrument: i}, nil
+		}
+	}
+	return nil, instrumentNotFound(ctx)
+}
+
+func (is *instrumentsServer) GetInstrumentBy(ctx context.Context, req *pb.InstrumentRequest) (*pb.InstrumentResponse, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	i := is.findInstrument(req)
+	if i == nil {
+		return nil, instrumentNotFound(ctx)
+	}
+
+	return &pb.InstrumentResponse{Instrument: i}, nil
+}
+
+// findInstrument must be called with s.mu held.
+func (s *Server) findInstrument(req *pb.InstrumentRequest) *pb.Instrument {
+	for _, i := range s.shares {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.Instrument{Figi: i.Figi, Ticker: i.Ticker, ClassCode: i.ClassCode, Isin: i.Isin,
+				Lot: i.Lot, Currency: i.Currency, Name: i.Name, Exchange: i.Exchange, InstrumentType: "share",
+				TradingStatus: i.TradingStatus, BuyAvailableFlag: i.BuyAvailableFlag, SellAvailableFlag: i.SellAvailableFlag,
+				MinPriceIncrement: i.MinPriceIncrement, ApiTradeAvailableFlag: i.ApiTradeAvailableFlag, Uid: i.Uid}
+		}
+	}
+	for _, i := range s.etfs {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.Instrument{Figi: i.Figi, Ticker: i.Ticker, ClassCode: i.ClassCode, Isin: i.Isin,
+				Lot: i.Lot, Currency: i.Currency, Name: i.Name, Exchange: i.Exchange, InstrumentType: "etf",
+				TradingStatus: i.TradingStatus, BuyAvailableFlag: i.BuyAvailableFlag, SellAvailableFlag: i.SellAvailableFlag,
+				MinPriceIncrement: i.MinPriceIncrement, ApiTradeAvailableFlag: i.ApiTradeAvailableFlag, Uid: i.Uid}
+		}
+	}
+	for _, i := range s.bonds {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.Instrument{Figi: i.Figi, Ticker: i.Ticker, ClassCode: i.ClassCode, Isin: i.Isin,
+				Lot: i.Lot, Currency: i.Currency, Name: i.Name, Exchange: i.Exchange, InstrumentType: "bond",
+				TradingStatus: i.TradingStatus, BuyAvailableFlag: i.BuyAvailableFlag, SellAvailableFlag: i.SellAvailableFlag,
+				MinPriceIncrement: i.MinPriceIncrement, ApiTradeAvailableFlag: i.ApiTradeAvailableFlag, Uid: i.Uid}
+		}
+	}
+	for _, i := range s.futures {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.Instrument{Figi: i.Figi, Ticker: i.Ticker, ClassCode: i.ClassCode,
+				Lot: i.Lot, Currency: i.Currency, Name: i.Name, Exchange: i.Exchange, InstrumentType: "futures",
+				TradingStatus: i.TradingStatus, BuyAvailableFlag: i.BuyAvailableFlag, SellAvailableFlag: i.SellAvailableFlag,
+				MinPriceIncrement: i.MinPriceIncrement, ApiTradeAvailableFlag: i.ApiTradeAvailableFlag, Uid: i.Uid}
+		}
+	}
+	for _, i := range s.currencies {
+		if matches(req, i.Figi, i.Ticker, i.ClassCode, i.Uid) {
+			return &pb.Instrument{Figi: i.Figi, Ticker: i.Ticker, ClassCode: i.ClassCode, Isin: i.Isin,
+				Lot: i.Lot, Currency: i.Currency, Name: i.Name, Exchange: i.Exchange, InstrumentType: "currency",
+				TradingStatus: i.TradingStatus, BuyAvailableFlag: i.BuyAvailableFlag, SellAvailableFlag: i.SellAvailableFlag,
+				MinPriceIncrement: i.MinPriceIncrement, ApiTradeAvailableFlag: i.ApiTradeAvailableFlag, Uid: i.Uid}
+		}
+	}
+
+	return nil
+}
+
+// lotSize must be called with s.mu held; unknown instruments are traded by one piece.
+func (s *Server) lotSize(figi string) int64 {
+	i := s.findInstrument(&pb.InstrumentRequest{IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI, Id: figi})
+	if i == nil || i.Lot == 0 {
+		return 1
+	}
+	return int64(i.Lot)
+}
+
+func matches(req *pb.InstrumentRequest, figi, ticker, classCode, uid string) bool {
+	switch req.IdType {
+	case pb.InstrumentIdType_INSTRUMENT_ID_TYPE_TICKER:
+		return req.Id == ticker && (req.ClassCode == "" || req.ClassCode == classCode)
+	case pb.InstrumentIdType_INSTRUMENT_ID_TYPE_UID:
+		return req.Id == uid
+	default:
+		return req.Id == figi
+	}
+}
+
+func instrumentNotFound(ctx context.Context) error {
+	return apiError(ctx, codes.NotFound, "50002", "Instrument not found")
+}
@@ -0,0 +1,210 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"sort"
+)
+
+type marketDataServer struct {
+	pb.UnimplementedMarketDataServiceServer
+	*Server
+}
+
+// SetCandles replaces historic candles of the given interval for figi.
+func (s *Server) SetCandles(figi string, interval pb.CandleInterval, candles []*pb.HistoricCandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]*pb.HistoricCandle(nil), candles...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.AsTime().Before(sorted[j].Time.AsTime())
+	})
+	s.candles[candleKey{figi: figi, interval: interval}] = sorted
+}
+
+// AddCandle appends a historic candle and pushes it to candle subscribers.
+func (s *Server) AddCandle(figi string, interval pb.CandleInterval, candle *pb.HistoricCandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := candleKey{figi: figi, interval: interval}
+	s.candles[key] = append(s.candles[key], candle)
+
+	var subscriptionInterval pb.SubscriptionInterval
+	switch interval {
+	case pb.CandleInterval_CANDLE_INTERVAL_1_MIN:
+		subscriptionInterval = pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE
+	case pb.CandleInterval_CANDLE_INTERVAL_5_MIN:
+		subscriptionInterval = pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_FIVE_MINUTES
+	default:
+		return // no streaming for other intervals
+	}
+
+	s.broadcastMarketData(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Candle{Candle: &pb.Candle{
+		Figi:     figi,
+		Interval: subscriptionInterval,
+		Open:     candle.Open,
+		High:     candle.High,
+		Low:      candle.Low,
+		Close:    candle.Close,
+		Volume:   candle.Volume,
+		Time:     candle.Time,
+	}}})
+}
+
+// SetOrderBook replaces the order book for figi, updates last price,
+// pushes the book to subscribers and triggers matching stop orders.
+func (s *Server) SetOrderBook(book *pb.GetOrderBookResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orderBooks[book.Figi] = book
+	s.broadcastMarketData(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Orderbook{Orderbook: &pb.OrderBook{
+		Figi:         book.Figi,
+		Depth:        book.Depth,
+		IsConsistent: true,
+		Bids:         book.Bids,
+		Asks:         book.Asks,
+		Time:         nowTimestamp(),
+		LimitUp:      book.LimitUp,
+		LimitDown:    book.LimitDown,
+	}}})
+
+	if book.LastPrice != nil {
+		s.setLastPrice(book.Figi, book.LastPrice)
+	}
+}
+
+// SetTradingStatus changes instrument trading status and pushes it to info subscribers.
+func (s *Server) SetTradingStatus(figi string, status pb.SecurityTradingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tradingStatuses[figi] = status
+	s.broadcastMarketData(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_TradingStatus{
+		TradingStatus: s.tradingStatus(figi),
+	}})
+}
+
+// SetLastPrice changes instrument last price, pushes it to subscribers and triggers stop orders.
+func (s *Server) SetLastPrice(figi string, price *pb.Quotation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setLastPrice(figi, price)
+}
+
+// PushTrade sends an anonymous trade to trade subscribers.
+func (s *Server) PushTrade(trade *pb.Trade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcastMarketData(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Trade{Trade: trade}})
+}
+
+// setLastPrice must be called with s.mu held.
+func (s *Server) setLastPrice(figi string, price *pb.Quotation) {
+	lastPrice := &pb.LastPrice{Figi: figi, Price: price, Time: nowTimestamp()}
+	s.lastPrices[figi] = lastPrice
+
+	s.broadcastMarketData(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_LastPrice{LastPrice: lastPrice}})
+	s.triggerStopOrders(figi, quotationToNano(price))
+}
+
+// tradingStatus must be called with s.mu held; unknown instruments are traded normally.
+func (s *Server) tradingStatus(figi string) *pb.TradingStatus {
+	status, ok := s.tradingStatuses[figi]
+	if !ok || status == pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_UNSPECIFIED {
+		status = pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+	}
+
+	normal := status == pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+	return &pb.TradingStatus{
+		Figi:                     figi,
+		TradingStatus:            status,
+		Time:                     nowTimestamp(),
+		LimitOrderAvailableFlag:  normal,
+		MarketOrderAvailableFlag: normal,
+	}
+}
+
+func (mds *marketDataServer) GetCandles(_ context.Context, req *pb.GetCandlesRequest) (*pb.GetCandlesResponse, error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	var candles []*pb.HistoricCandle
+	for _, c := range mds.candles[candleKey{figi: req.Figi, interval: req.Interval}] {
+		t := c.Time.AsTime()
+		if req.From != nil && t.Before(req.From.AsTime()) {
+			continue
+		}
+		if req.To != nil && !t.Before(req.To.AsTime()) {
+			continue
+		}
+		candles = append(candles, c)
+	}
+
+	return &pb.GetCandlesResponse{Candles: candles}, nil
+}
+
+func (mds *marketDataServer) GetLastPrices(_ context.Context, req *pb.GetLastPricesRequest) (*pb.GetLastPricesResponse, error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	var prices []*pb.LastPrice
+	for _, f := range req.Figi {
+		if p, ok := mds.lastPrices[f]; ok {
+			prices = append(prices, p)
+		}
+	}
+
+	return &pb.GetLastPricesResponse{LastPrices: prices}, nil
+}
+
+func (mds *marketDataServer) GetOrderBook(ctx context.Context, req *pb.GetOrderBookRequest) (*pb.GetOrderBookResponse, error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	book, ok := mds.orderBooks[req.Figi]
+	if !ok {
+		return nil, instrumentNotFound(ctx)
+	}
+
+	res := &pb.GetOrderBookResponse{
+		Figi:       book.Figi,
+		Depth:      req.Depth,
+		Bids:       book.Bids,
+		Asks:       book.Asks,
+		LastPrice:  book.LastPrice,
+		ClosePrice: book.ClosePrice,
+		LimitUp:    book.LimitUp,
+		LimitDown:  book.LimitDown,
+	}
+	if int(req.Depth) < len(res.Bids) {
+		res.Bids = res.Bids[:req.Depth]
+	}
+	if int(req.Depth) < len(res.Asks) {
+		res.Asks = res.Asks[:req.Depth]
+	}
+
+	return res, nil
+}
+
+func (mds *marketDataServer) GetTradingStatus(_ context.Context, req *pb.GetTradingStatusRequest) (*pb.GetTradingStatusResponse, error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	status := mds.tradingStatus(req.Figi)
+	return &pb.GetTradingStatusResponse{
+		Figi:                     req.Figi,
+		TradingStatus:            status.TradingStatus,
+		LimitOrderAvailableFlag:  status.LimitOrderAvailableFlag,
+		MarketOrderAvailableFlag: status.MarketOrderAvailableFlag,
+		ApiTradeAvailableFlag:    true,
+	}, nil
+}
+
+func (mds *marketDataServer) GetLastTrades(context.Context, *pb.GetLastTradesRequest) (*pb.GetLastTradesResponse, error) {
+	return &pb.GetLastTradesResponse{}, nil
+}
@@ -0,0 +1,252 @@
+package fake
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+const subscriberBufferSize = 256
+
+type marketDataStreamServer struct {
+	pb.UnimplementedMarketDataStreamServiceServer
+	*Server
+}
+
+// mdSubscriber is a single open MarketDataStream with its active subscriptions.
+type mdSubscriber struct {
+	out       chan *pb.MarketDataResponse
+	done      chan struct{}
+	closeOnce sync.Once
+
+	orderBooks map[string]int32 // figi -> depth
+	candles    map[string]pb.SubscriptionInterval
+	trades     map[string]bool
+	info       map[string]bool
+	lastPrices map[string]bool
+}
+
+func newMdSubscriber() *mdSubscriber {
+	return &mdSubscriber{
+		out:        make(chan *pb.MarketDataResponse, subscriberBufferSize),
+		done:       make(chan struct{}),
+		orderBooks: make(map[string]int32),
+		candles:    make(map[string]pb.SubscriptionInterval),
+		trades:     make(map[string]bool),
+		info:       make(map[string]bool),
+		lastPrices: make(map[string]bool),
+	}
+}
+
+func (sub *mdSubscriber) close() {
+	sub.closeOnce.Do(func() { close(sub.done) })
+}
+
+// push drops the message if subscriber is too slow, just like a real stream would lag.
+func (sub *mdSubscriber) push(msg *pb.MarketDataResponse) {
+	select {
+	case sub.out <- msg:
+	default:
+	}
+}
+
+func (mdss *marketDataStreamServer) MarketDataStream(stream pb.MarketDataStreamService_MarketDataStreamServer) error {
+	sub := newMdSubscriber()
+
+	mdss.mu.Lock()
+	mdss.mdSubscribers[sub] = struct{}{}
+	pingInterval := mdss.pingInterval
+	mdss.mu.Unlock()
+
+	defer func() {
+		mdss.mu.Lock()
+		delete(mdss.mdSubscribers, sub)
+		mdss.mu.Unlock()
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			mdss.handleRequest(sub, req)
+		}
+	}()
+
+	var ping <-chan time.Time
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case msg := <-sub.out:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-ping:
+			err := stream.Send(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Ping{
+				Ping: &pb.Ping{Time: nowTimestamp()},
+			}})
+			if err != nil {
+				return err
+			}
+		case err := <-errc:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-sub.done:
+			return status.Error(codes.Unavailable, "stream closed by server")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// handleRequest updates subscriptions and pushes responses along with current snapshots.
+func (mdss *marketDataStreamServer) handleRequest(sub *mdSubscriber, req *pb.MarketDataRequest) {
+	mdss.mu.Lock()
+	defer mdss.mu.Unlock()
+
+	trackingID := uuid.New().String()
+	success := pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS
+
+	switch {
+	case req.GetSubscribeOrderBookRequest() != nil:
+		r := req.GetSubscribeOrderBookRequest()
+		res := &pb.SubscribeOrderBookResponse{TrackingId: trackingID}
+		for _, i := range r.Instruments {
+			if r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+				sub.orderBooks[i.Figi] = i.Depth
+			} else {
+				delete(sub.orderBooks, i.Figi)
+			}
+			res.OrderBookSubscriptions = append(res.OrderBookSubscriptions, &pb.OrderBookSubscription{
+				Figi: i.Figi, Depth: i.Depth, SubscriptionStatus: success,
+			})
+		}
+		sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_SubscribeOrderBookResponse{
+			SubscribeOrderBookResponse: res,
+		}})
+
+		for _, i := range r.Instruments {
+			if book, ok := mdss.orderBooks[i.Figi]; ok && r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+				sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Orderbook{Orderbook: &pb.OrderBook{
+					Figi: book.Figi, Depth: i.Depth, IsConsistent: true, Bids: book.Bids, Asks: book.Asks, Time: nowTimestamp(),
+				}}})
+			}
+		}
+	case req.GetSubscribeCandlesRequest() != nil:
+		r := req.GetSubscribeCandlesRequest()
+		res := &pb.SubscribeCandlesResponse{TrackingId: trackingID}
+		for _, i := range r.Instruments {
+			if r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+				sub.candles[i.Figi] = i.Interval
+			} else {
+				delete(sub.candles, i.Figi)
+			}
+			res.CandlesSubscriptions = append(res.CandlesSubscriptions, &pb.CandleSubscription{
+				Figi: i.Figi, Interval: i.Interval, SubscriptionStatus: success,
+			})
+		}
+		sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_SubscribeCandlesResponse{
+			SubscribeCandlesResponse: res,
+		}})
+	case req.GetSubscribeTradesRequest() != nil:
+		r := req.GetSubscribeTradesRequest()
+		res := &pb.SubscribeTradesResponse{TrackingId: trackingID}
+		for _, i := range r.Instruments {
+			sub.trades[i.Figi] = r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+			res.TradeSubscriptions = append(res.TradeSubscriptions, &pb.TradeSubscription{
+				Figi: i.Figi, SubscriptionStatus: success,
+			})
+		}
+		sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_SubscribeTradesResponse{
+			SubscribeTradesResponse: res,
+		}})
+	case req.GetSubscribeInfoRequest() != nil:
+		r := req.GetSubscribeInfoRequest()
+		res := &pb.SubscribeInfoResponse{TrackingId: trackingID}
+		for _, i := range r.Instruments {
+			sub.info[i.Figi] = r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+			res.InfoSubscriptions = append(res.InfoSubscriptions, &pb.InfoSubscription{
+				Figi: i.Figi, SubscriptionStatus: success,
+			})
+		}
+		sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_SubscribeInfoResponse{
+			SubscribeInfoResponse: res,
+		}})
+
+		for _, i := range r.Instruments {
+			if sub.info[i.Figi] {
+				sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_TradingStatus{
+					TradingStatus: mdss.tradingStatus(i.Figi),
+				}})
+			}
+		}
+	case req.GetSubscribeLastPriceRequest() != nil:
+		r := req.GetSubscribeLastPriceRequest()
+		res := &pb.SubscribeLastPriceResponse{TrackingId: trackingID}
+		for _, i := range r.Instruments {
+			sub.lastPrices[i.Figi] = r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+			res.LastPriceSubscriptions = append(res.LastPriceSubscriptions, &pb.LastPriceSubscription{
+				Figi: i.Figi, SubscriptionStatus: success,
+			})
+		}
+		sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_SubscribeLastPriceResponse{
+			SubscribeLastPriceResponse: res,
+		}})
+
+		for _, i := range r.Instruments {
+			if p, ok := mdss.lastPrices[i.Figi]; ok && sub.lastPrices[i.Figi] {
+				sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_LastPrice{LastPrice: p}})
+			}
+		}
+	}
+}
+
+// broadcastMarketData must be called with s.mu held.
+func (s *Server) broadcastMarketData(msg *pb.MarketDataResponse) {
+	for sub := range s.mdSubscribers {
+		switch {
+		case msg.GetOrderbook() != nil:
+			if depth, ok := sub.orderBooks[msg.GetOrderbook().Figi]; ok {
+				book := *msg.GetOrderbook()
+				book.Depth = depth
+				if int(depth) < len(book.Bids) {
+					book.Bids = book.Bids[:depth]
+				}
+				if int(depth) < len(book.Asks) {
+					book.Asks = book.Asks[:depth]
+				}
+				sub.push(&pb.MarketDataResponse{Payload: &pb.MarketDataResponse_Orderbook{Orderbook: &book}})
+			}
+		case msg.GetCandle() != nil:
+			if interval, ok := sub.candles[msg.GetCandle().Figi]; ok && interval == msg.GetCandle().Interval {
+				sub.push(msg)
+			}
+		case msg.GetTrade() != nil:
+			if sub.trades[msg.GetTrade().Figi] {
+				sub.push(msg)
+			}
+		case msg.GetTradingStatus() != nil:
+			if sub.info[msg.GetTradingStatus().Figi] {
+				sub.push(msg)
+			}
+		case msg.GetLastPrice() != nil:
+			if sub.lastPrices[msg.GetLastPrice().Figi] {
+				sub.push(msg)
+			}
+		}
+	}
+}
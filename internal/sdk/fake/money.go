@@ -0,0 +1,35 @@
+package fake
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const nanoPerUnit = 1000000000
+
+func quotationToNano(q *pb.Quotation) int64 {
+	if q == nil {
+		return 0
+	}
+	return q.Units*nanoPerUnit + int64(q.Nano)
+}
+
+func moneyToNano(m *pb.MoneyValue) int64 {
+	if m == nil {
+		return 0
+	}
+	return m.Units*nanoPerUnit + int64(m.Nano)
+}
+
+func nanoToQuotation(n int64) *pb.Quotation {
+	return &pb.Quotation{Units: n / nanoPerUnit, Nano: int32(n % nanoPerUnit)}
+}
+
+func nanoToMoney(n int64, currency string) *pb.MoneyValue {
+	return &pb.MoneyValue{Units: n / nanoPerUnit, Nano: int32(n % nanoPerUnit), Currency: currency}
+}
+
+func nowTimestamp() *timestamp.Timestamp {
+	return timestamppb.Now()
+}
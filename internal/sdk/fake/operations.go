@@ -0,0 +1,146 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"sort"
+)
+
+type operationsServer struct {
+	pb.UnimplementedOperationsServiceServer
+	*Server
+}
+
+func (os *operationsServer) GetOperations(_ context.Context, req *pb.OperationsRequest) (*pb.OperationsResponse, error) {
+	return os.getOperations(req, false)
+}
+
+func (os *operationsServer) GetPortfolio(_ context.Context, req *pb.PortfolioRequest) (*pb.PortfolioResponse, error) {
+	return os.getPortfolio(req, false)
+}
+
+func (os *operationsServer) GetPositions(_ context.Context, req *pb.PositionsRequest) (*pb.PositionsResponse, error) {
+	return os.getPositions(req, false)
+}
+
+func (os *operationsServer) GetWithdrawLimits(_ context.Context, req *pb.WithdrawLimitsRequest) (*pb.WithdrawLimitsResponse, error) {
+	res, err := os.getPositions(&pb.PositionsRequest{AccountId: req.AccountId}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WithdrawLimitsResponse{Money: res.Money}, nil
+}
+
+func (s *Server) getOperations(req *pb.OperationsRequest, sandbox bool) (*pb.OperationsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.OperationsResponse{}
+	for _, o := range acc.operations {
+		if req.Figi != "" && o.Figi != req.Figi {
+			continue
+		}
+		if req.State != pb.OperationState_OPERATION_STATE_UNSPECIFIED && o.State != req.State {
+			continue
+		}
+		if req.From != nil && o.Date.AsTime().Before(req.From.AsTime()) {
+			continue
+		}
+		if req.To != nil && o.Date.AsTime().After(req.To.AsTime()) {
+			continue
+		}
+		res.Operations = append(res.Operations, o)
+	}
+
+	return res, nil
+}
+
+func (s *Server) getPortfolio(req *pb.PortfolioRequest, sandbox bool) (*pb.PortfolioResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]int64)
+	var expectedYield int64
+
+	res := &pb.PortfolioResponse{}
+	for _, figi := range sortedKeys(acc.positions) {
+		quantity := acc.positions[figi]
+		if quantity == 0 {
+			continue
+		}
+
+		currency := s.currency(figi)
+		instrumentType := s.instrumentType(figi)
+		average := acc.averages[figi]
+		current := average
+		if p, ok := s.lastPrices[figi]; ok {
+			current = quotationToNano(p.Price)
+		}
+
+		positionYield := (current - average) * quantity
+		expectedYield += positionYield
+		totals[instrumentType] += current * quantity
+
+		res.Positions = append(res.Positions, &pb.PortfolioPosition{
+			Figi:                 figi,
+			InstrumentType:       instrumentType,
+			Quantity:             nanoToQuotation(quantity * nanoPerUnit),
+			QuantityLots:         nanoToQuotation(quantity * nanoPerUnit / s.lotSize(figi)),
+			AveragePositionPrice: nanoToMoney(average, currency),
+			CurrentPrice:         nanoToMoney(current, currency),
+			ExpectedYield:        nanoToQuotation(positionYield),
+		})
+	}
+
+	res.TotalAmountShares = nanoToMoney(totals["share"], defaultCurrency)
+	res.TotalAmountBonds = nanoToMoney(totals["bond"], defaultCurrency)
+	res.TotalAmountEtf = nanoToMoney(totals["etf"], defaultCurrency)
+	res.TotalAmountFutures = nanoToMoney(totals["futures"], defaultCurrency)
+	res.TotalAmountCurrencies = nanoToMoney(acc.money[defaultCurrency], defaultCurrency)
+	res.ExpectedYield = nanoToQuotation(expectedYield)
+
+	return res, nil
+}
+
+func (s *Server) getPositions(req *pb.PositionsRequest, sandbox bool) (*pb.PositionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.PositionsResponse{}
+	for _, currency := range sortedKeys(acc.money) {
+		res.Money = append(res.Money, nanoToMoney(acc.money[currency], currency))
+	}
+	for _, figi := range sortedKeys(acc.positions) {
+		if acc.positions[figi] != 0 {
+			res.Securities = append(res.Securities, &pb.PositionsSecurities{Figi: figi, Balance: acc.positions[figi]})
+		}
+	}
+
+	return res, nil
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
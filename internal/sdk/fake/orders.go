@@ -0,0 +1,368 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const defaultCurrency = "rub"
+
+type ordersServer struct {
+	pb.UnimplementedOrdersServiceServer
+	*Server
+}
+
+// OrderFiller decides how many lots of a freshly posted order are executed immediately.
+type OrderFiller func(order *pb.PostOrderRequest) int64
+
+// FillAll executes every order immediately and completely.
+func FillAll(order *pb.PostOrderRequest) int64 {
+	return order.Quantity
+}
+
+// FillNone leaves every order pending until Server.FillOrder is called.
+func FillNone(*pb.PostOrderRequest) int64 {
+	return 0
+}
+
+// FillPartially executes at most lots of each order immediately.
+func FillPartially(lots int64) OrderFiller {
+	return func(order *pb.PostOrderRequest) int64 {
+		if order.Quantity < lots {
+			return order.Quantity
+		}
+		return lots
+	}
+}
+
+// SetOrderFiller changes how new orders are executed, FillAll by default.
+func (s *Server) SetOrderFiller(filler OrderFiller) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filler = filler
+}
+
+// FillOrder executes lots of an active order by its initial price.
+func (s *Server) FillOrder(accountID, orderID string, lots int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", accountID)
+	}
+	state, ok := acc.orders[orderID]
+	if !ok || !isActive(state) {
+		return fmt.Errorf("active order %s not found", orderID)
+	}
+	if lots > state.LotsRequested-state.LotsExecuted {
+		return fmt.Errorf("order %s has only %d lots left", orderID, state.LotsRequested-state.LotsExecuted)
+	}
+
+	s.fill(acc, state, lots, moneyToNano(state.InitialSecurityPrice))
+	return nil
+}
+
+// RejectOrder marks an active order as rejected by exchange.
+func (s *Server) RejectOrder(accountID, orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", accountID)
+	}
+	state, ok := acc.orders[orderID]
+	if !ok || !isActive(state) {
+		return fmt.Errorf("active order %s not found", orderID)
+	}
+
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED
+	return nil
+}
+
+// Orders returns all orders ever posted to the account, including finished ones.
+func (s *Server) Orders(accountID string) []*pb.OrderState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[accountID]
+	if !ok {
+		return nil
+	}
+
+	var orders []*pb.OrderState
+	for _, o := range acc.orders {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+func (os *ordersServer) PostOrder(ctx context.Context, req *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	return os.postOrder(ctx, req, false)
+}
+
+func (os *ordersServer) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.CancelOrderResponse, error) {
+	return os.cancelOrder(ctx, req, false)
+}
+
+func (os *ordersServer) GetOrderState(ctx context.Context, req *pb.GetOrderStateRequest) (*pb.OrderState, error) {
+	return os.getOrderState(ctx, req, false)
+}
+
+func (os *ordersServer) GetOrders(_ context.Context, req *pb.GetOrdersRequest) (*pb.GetOrdersResponse, error) {
+	return os.getOrders(req, false)
+}
+
+func (s *Server) postOrder(ctx context.Context, req *pb.PostOrderRequest, sandbox bool) (*pb.PostOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.placeOrder(ctx, acc, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.PostOrderResponse{
+		OrderId:               state.OrderId,
+		ExecutionReportStatus: state.ExecutionReportStatus,
+		LotsRequested:         state.LotsRequested,
+		LotsExecuted:          state.LotsExecuted,
+		InitialOrderPrice:     state.InitialOrderPrice,
+		ExecutedOrderPrice:    state.ExecutedOrderPrice,
+		TotalOrderAmount:      state.TotalOrderAmount,
+		Figi:                  state.Figi,
+		Direction:             state.Direction,
+		InitialSecurityPrice:  state.InitialSecurityPrice,
+		OrderType:             state.OrderType,
+	}, nil
+}
+
+// placeOrder must be called with s.mu held; the same OrderId always returns the same order.
+func (s *Server) placeOrder(ctx context.Context, acc *account, req *pb.PostOrderRequest) (*pb.OrderState, error) {
+	if req.OrderId != "" {
+		if state, ok := acc.orders[req.OrderId]; ok {
+			return state, nil
+		}
+	} else {
+		req.OrderId = uuid.New().String()
+	}
+
+	if s.tradingStatus(req.Figi).TradingStatus != pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING {
+		return nil, apiError(ctx, codes.FailedPrecondition, "30079", "Instrument is not available for trading")
+	}
+	if req.Quantity <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "quantity must be positive")
+	}
+
+	price := s.executionPrice(req)
+	lot := s.lotSize(req.Figi)
+	currency := s.currency(req.Figi)
+	amount := price * req.Quantity * lot
+
+	if s.enforceBalance && req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY && acc.money[currency] < amount {
+		return nil, apiError(ctx, codes.InvalidArgument, "30042", "Not enough assets for a margin trade")
+	}
+
+	state := &pb.OrderState{
+		OrderId:               req.OrderId,
+		ExecutionReportStatus: pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		LotsRequested:         req.Quantity,
+		InitialOrderPrice:     nanoToMoney(amount, currency),
+		ExecutedOrderPrice:    nanoToMoney(0, currency),
+		TotalOrderAmount:      nanoToMoney(amount, currency),
+		AveragePositionPrice:  nanoToMoney(0, currency),
+		InitialCommission:     nanoToMoney(0, currency),
+		ExecutedCommission:    nanoToMoney(0, currency),
+		ServiceCommission:     nanoToMoney(0, currency),
+		Figi:                  req.Figi,
+		Direction:             req.Direction,
+		InitialSecurityPrice:  nanoToMoney(price, currency),
+		Currency:              currency,
+		OrderType:             req.OrderType,
+		OrderDate:             nowTimestamp(),
+	}
+	acc.orders[state.OrderId] = state
+
+	if lots := s.filler(req); lots > 0 {
+		s.fill(acc, state, lots, price)
+	}
+
+	return state, nil
+}
+
+// fill must be called with s.mu held; it moves money and instruments and notifies trades stream.
+func (s *Server) fill(acc *account, state *pb.OrderState, lots, price int64) {
+	lot := s.lotSize(state.Figi)
+	amount := price * lots * lot
+	executed := moneyToNano(state.ExecutedOrderPrice) + amount
+
+	state.LotsExecuted += lots
+	state.ExecutedOrderPrice = nanoToMoney(executed, state.Currency)
+	state.AveragePositionPrice = nanoToMoney(executed/(state.LotsExecuted*lot), state.Currency)
+	state.Stages = append(state.Stages, &pb.OrderStage{
+		Price:    nanoToMoney(price, state.Currency),
+		Quantity: lots,
+		TradeId:  uuid.New().String(),
+	})
+
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+	if state.LotsExecuted == state.LotsRequested {
+		state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	}
+
+	operationType := pb.OperationType_OPERATION_TYPE_BUY
+	payment := -amount
+	if state.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		held := acc.positions[state.Figi]
+		if held+lots*lot != 0 {
+			acc.averages[state.Figi] = (acc.averages[state.Figi]*held + amount) / (held + lots*lot)
+		}
+		acc.positions[state.Figi] += lots * lot
+	} else {
+		acc.positions[state.Figi] -= lots * lot
+		operationType = pb.OperationType_OPERATION_TYPE_SELL
+		payment = amount
+	}
+	acc.money[state.Currency] += payment
+
+	acc.operations = append(acc.operations, &pb.Operation{
+		Id:             uuid.New().String(),
+		Currency:       state.Currency,
+		Payment:        nanoToMoney(payment, state.Currency),
+		Price:          nanoToMoney(price, state.Currency),
+		State:          pb.OperationState_OPERATION_STATE_EXECUTED,
+		Quantity:       lots * lot,
+		Figi:           state.Figi,
+		InstrumentType: s.instrumentType(state.Figi),
+		Date:           nowTimestamp(),
+		Type:           strings.ToLower(operationType.String()),
+		OperationType:  operationType,
+	})
+
+	s.broadcastTrades(acc.info.Id, &pb.OrderTrades{
+		OrderId:   state.OrderId,
+		CreatedAt: nowTimestamp(),
+		Direction: state.Direction,
+		Figi:      state.Figi,
+		AccountId: acc.info.Id,
+		Trades: []*pb.OrderTrade{{
+			DateTime: nowTimestamp(),
+			Price:    nanoToQuotation(price),
+			Quantity: lots,
+		}},
+	})
+}
+
+// executionPrice must be called with s.mu held; market orders take the best opposite price.
+func (s *Server) executionPrice(req *pb.PostOrderRequest) int64 {
+	if req.OrderType == pb.OrderType_ORDER_TYPE_LIMIT && req.Price != nil {
+		return quotationToNano(req.Price)
+	}
+
+	if book, ok := s.orderBooks[req.Figi]; ok {
+		if req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY && len(book.Asks) > 0 {
+			return quotationToNano(book.Asks[0].Price)
+		}
+		if req.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL && len(book.Bids) > 0 {
+			return quotationToNano(book.Bids[0].Price)
+		}
+	}
+	if p, ok := s.lastPrices[req.Figi]; ok {
+		return quotationToNano(p.Price)
+	}
+
+	return quotationToNano(req.Price)
+}
+
+// currency must be called with s.mu held.
+func (s *Server) currency(figi string) string {
+	i := s.findInstrument(&pb.InstrumentRequest{IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI, Id: figi})
+	if i == nil || i.Currency == "" {
+		return defaultCurrency
+	}
+	return i.Currency
+}
+
+// instrumentType must be called with s.mu held.
+func (s *Server) instrumentType(figi string) string {
+	i := s.findInstrument(&pb.InstrumentRequest{IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI, Id: figi})
+	if i == nil {
+		return "share"
+	}
+	return i.InstrumentType
+}
+
+func (s *Server) cancelOrder(ctx context.Context, req *pb.CancelOrderRequest, sandbox bool) (*pb.CancelOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := acc.orders[req.OrderId]
+	if !ok || !isActive(state) {
+		return nil, orderNotFound(ctx) // API does not tell executed and cancelled orders from unknown ones
+	}
+
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return &pb.CancelOrderResponse{Time: nowTimestamp()}, nil
+}
+
+func (s *Server) getOrderState(ctx context.Context, req *pb.GetOrderStateRequest, sandbox bool) (*pb.OrderState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := acc.orders[req.OrderId]
+	if !ok {
+		return nil, orderNotFound(ctx)
+	}
+
+	return state, nil
+}
+
+func (s *Server) getOrders(req *pb.GetOrdersRequest, sandbox bool) (*pb.GetOrdersResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.GetOrdersResponse{}
+	for _, o := range acc.orders {
+		if isActive(o) {
+			res.Orders = append(res.Orders, o)
+		}
+	}
+
+	return res, nil
+}
+
+func isActive(state *pb.OrderState) bool {
+	return state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW ||
+		state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+}
+
+func orderNotFound(ctx context.Context) error {
+	return apiError(ctx, codes.NotFound, "50005", "Order not found")
+}
@@ -0,0 +1,89 @@
+package fake
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+type ordersStreamServer struct {
+	pb.UnimplementedOrdersStreamServiceServer
+	*Server
+}
+
+// tradesSubscriber is a single open TradesStream.
+type tradesSubscriber struct {
+	accounts  map[string]bool
+	out       chan *pb.TradesStreamResponse
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (sub *tradesSubscriber) close() {
+	sub.closeOnce.Do(func() { close(sub.done) })
+}
+
+func (oss *ordersStreamServer) TradesStream(req *pb.TradesStreamRequest, stream pb.OrdersStreamService_TradesStreamServer) error {
+	sub := &tradesSubscriber{
+		accounts: make(map[string]bool),
+		out:      make(chan *pb.TradesStreamResponse, subscriberBufferSize),
+		done:     make(chan struct{}),
+	}
+	for _, a := range req.Accounts {
+		sub.accounts[a] = true
+	}
+
+	oss.mu.Lock()
+	oss.tradesSubscribers[sub] = struct{}{}
+	pingInterval := oss.pingInterval
+	oss.mu.Unlock()
+
+	defer func() {
+		oss.mu.Lock()
+		delete(oss.tradesSubscribers, sub)
+		oss.mu.Unlock()
+	}()
+
+	var ping <-chan time.Time
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case msg := <-sub.out:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-ping:
+			err := stream.Send(&pb.TradesStreamResponse{Payload: &pb.TradesStreamResponse_Ping{
+				Ping: &pb.Ping{Time: nowTimestamp()},
+			}})
+			if err != nil {
+				return err
+			}
+		case <-sub.done:
+			return status.Error(codes.Unavailable, "stream closed by server")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// broadcastTrades must be called with s.mu held.
+func (s *Server) broadcastTrades(accountID string, trades *pb.OrderTrades) {
+	msg := &pb.TradesStreamResponse{Payload: &pb.TradesStreamResponse_OrderTrades{OrderTrades: trades}}
+	for sub := range s.tradesSubscribers {
+		if len(sub.accounts) > 0 && !sub.accounts[accountID] {
+			continue
+		}
+		select {
+		case sub.out <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+const reportPageSize = 100
+
+// report is built when it is ordered; like the real API, it is not ready on the first request.
+type report struct {
+	ready     bool
+	trades    []*pb.BrokerReport
+	dividends []*pb.DividendsForeignIssuerReport
+}
+
+func (os *operationsServer) GetBrokerReport(_ context.Context, req *pb.BrokerReportRequest) (*pb.BrokerReportResponse, error) {
+	if generate := req.GetGenerateBrokerReportRequest(); generate != nil {
+		taskID, err := os.generateReport(generate.AccountId, generate.From.AsTime(), generate.To.AsTime(), false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.BrokerReportResponse{Payload: &pb.BrokerReportResponse_GenerateBrokerReportResponse{
+			GenerateBrokerReportResponse: &pb.GenerateBrokerReportResponse{TaskId: taskID},
+		}}, nil
+	}
+
+	get := req.GetGetBrokerReportRequest()
+	if get == nil {
+		return nil, status.Error(codes.InvalidArgument, "payload is required")
+	}
+	r, err := os.readyReport(get.TaskId)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, pages := reportPage(len(r.trades), get.Page)
+	return &pb.BrokerReportResponse{Payload: &pb.BrokerReportResponse_GetBrokerReportResponse{
+		GetBrokerReportResponse: &pb.GetBrokerReportResponse{
+			BrokerReport: r.trades[start:end],
+			ItemsCount:   int32(len(r.trades)),
+			PagesCount:   pages,
+			Page:         get.Page,
+		},
+	}}, nil
+}
+
+func (os *operationsServer) GetDividendsForeignIssuer(_ context.Context, req *pb.GetDividendsForeignIssuerRequest) (*pb.GetDividendsForeignIssuerResponse, error) {
+	if generate := req.GetGenerateDivForeignIssuerReport(); generate != nil {
+		taskID, err := os.generateReport(generate.AccountId, generate.From.AsTime(), generate.To.AsTime(), true)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.GetDividendsForeignIssuerResponse{Payload: &pb.GetDividendsForeignIssuerResponse_GenerateDivForeignIssuerReportResponse{
+			GenerateDivForeignIssuerReportResponse: &pb.GenerateDividendsForeignIssuerReportResponse{TaskId: taskID},
+		}}, nil
+	}
+
+	get := req.GetGetDivForeignIssuerReport()
+	if get == nil {
+		return nil, status.Error(codes.InvalidArgument, "payload is required")
+	}
+	r, err := os.readyReport(get.TaskId)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, pages := reportPage(len(r.dividends), get.Page)
+	return &pb.GetDividendsForeignIssuerResponse{Payload: &pb.GetDividendsForeignIssuerResponse_DivForeignIssuerReport{
+		DivForeignIssuerReport: &pb.GetDividendsForeignIssuerReportResponse{
+			DividendsForeignIssuerReport: r.dividends[start:end],
+			ItemsCount:                   int32(len(r.dividends)),
+			PagesCount:                   pages,
+			Page:                         get.Page,
+		},
+	}}, nil
+}
+
+// generateReport builds a report of executed trades in [from, to); dividends reports are always empty.
+func (s *Server) generateReport(accountID string, from, to time.Time, dividends bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, err := s.getAccount(accountID, false)
+	if err != nil {
+		return "", err
+	}
+	if !from.Before(to) {
+		return "", status.Error(codes.InvalidArgument, "from must be before to")
+	}
+
+	r := &report{dividends: []*pb.DividendsForeignIssuerReport{}}
+	if !dividends {
+		r.trades = s.tradesReport(acc, from, to)
+	}
+
+	taskID := uuid.New().String()
+	s.reports[taskID] = r
+
+	return taskID, nil
+}
+
+func (s *Server) tradesReport(acc *account, from, to time.Time) []*pb.BrokerReport {
+	var rows []*pb.BrokerReport
+	for _, o := range acc.operations {
+		if o.OperationType != pb.OperationType_OPERATION_TYPE_BUY && o.OperationType != pb.OperationType_OPERATION_TYPE_SELL {
+			continue
+		}
+		if t := o.Date.AsTime(); t.Before(from) || !t.Before(to) {
+			continue
+		}
+
+		i := s.findInstrument(&pb.InstrumentRequest{IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI, Id: o.Figi})
+		if i == nil {
+			i = &pb.Instrument{Figi: o.Figi}
+		}
+
+		amount := moneyToNano(o.Price) * o.Quantity
+		rows = append(rows, &pb.BrokerReport{
+			TradeId:          o.Id,
+			Figi:             o.Figi,
+			TradeDatetime:    o.Date,
+			Exchange:         i.Exchange,
+			ClassCode:        i.ClassCode,
+			Direction:        strings.TrimPrefix(o.Type, "operation_type_"),
+			Name:             i.Name,
+			Ticker:           i.Ticker,
+			Price:            o.Price,
+			Quantity:         o.Quantity,
+			OrderAmount:      nanoToMoney(amount, o.Currency),
+			TotalOrderAmount: nanoToMoney(amount, o.Currency),
+			BrokerCommission: nanoToMoney(0, o.Currency),
+			BrokerStatus:     "executed",
+		})
+	}
+
+	return rows
+}
+
+// readyReport returns the report or FailedPrecondition on the first request for it.
+func (s *Server) readyReport(taskID string) (*report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reports[taskID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "report %s not found", taskID)
+	}
+	if !r.ready {
+		r.ready = true
+		return nil, status.Error(codes.FailedPrecondition, "50010") // report is being generated
+	}
+
+	return r, nil
+}
+
+// reportPage returns bounds of the page rows and the number of pages, which is never zero.
+func reportPage(items int, page int32) (start, end int, pages int32) {
+	pages = int32((items + reportPageSize - 1) / reportPageSize)
+	if pages == 0 {
+		pages = 1
+	}
+
+	start = int(page) * reportPageSize
+	if start > items || page < 0 {
+		start = items
+	}
+	end = start + reportPageSize
+	if end > items {
+		end = items
+	}
+
+	return start, end, pages
+}
@@ -0,0 +1,94 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"sort"
+)
+
+type sandboxServer struct {
+	pb.UnimplementedSandboxServiceServer
+	*Server
+}
+
+func (ss *sandboxServer) OpenSandboxAccount(context.Context, *pb.OpenSandboxAccountRequest) (*pb.OpenSandboxAccountResponse, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	acc := ss.openAccount("", true)
+	return &pb.OpenSandboxAccountResponse{AccountId: acc.info.Id}, nil
+}
+
+func (ss *sandboxServer) GetSandboxAccounts(context.Context, *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
+	return &pb.GetAccountsResponse{Accounts: ss.listAccounts(true)}, nil
+}
+
+func (ss *sandboxServer) CloseSandboxAccount(_ context.Context, req *pb.CloseSandboxAccountRequest) (*pb.CloseSandboxAccountResponse, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, err := ss.getAccount(req.AccountId, true); err != nil {
+		return nil, err
+	}
+
+	delete(ss.accounts, req.AccountId)
+	return &pb.CloseSandboxAccountResponse{}, nil
+}
+
+func (ss *sandboxServer) PostSandboxOrder(ctx context.Context, req *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	return ss.postOrder(ctx, req, true)
+}
+
+func (ss *sandboxServer) GetSandboxOrders(_ context.Context, req *pb.GetOrdersRequest) (*pb.GetOrdersResponse, error) {
+	return ss.getOrders(req, true)
+}
+
+func (ss *sandboxServer) CancelSandboxOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.CancelOrderResponse, error) {
+	return ss.cancelOrder(ctx, req, true)
+}
+
+func (ss *sandboxServer) GetSandboxOrderState(ctx context.Context, req *pb.GetOrderStateRequest) (*pb.OrderState, error) {
+	return ss.getOrderState(ctx, req, true)
+}
+
+func (ss *sandboxServer) GetSandboxPositions(_ context.Context, req *pb.PositionsRequest) (*pb.PositionsResponse, error) {
+	return ss.getPositions(req, true)
+}
+
+func (ss *sandboxServer) GetSandboxOperations(_ context.Context, req *pb.OperationsRequest) (*pb.OperationsResponse, error) {
+	return ss.getOperations(req, true)
+}
+
+func (ss *sandboxServer) GetSandboxPortfolio(_ context.Context, req *pb.PortfolioRequest) (*pb.PortfolioResponse, error) {
+	return ss.getPortfolio(req, true)
+}
+
+func (ss *sandboxServer) SandboxPayIn(_ context.Context, req *pb.SandboxPayInRequest) (*pb.SandboxPayInResponse, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	acc, err := ss.getAccount(req.AccountId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	acc.money[req.Amount.Currency] += moneyToNano(req.Amount)
+	return &pb.SandboxPayInResponse{Balance: nanoToMoney(acc.money[req.Amount.Currency], req.Amount.Currency)}, nil
+}
+
+func (s *Server) listAccounts(sandbox bool) []*pb.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var accounts []*pb.Account
+	for _, acc := range s.accounts {
+		if acc.sandbox == sandbox {
+			accounts = append(accounts, acc.info)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].OpenedDate.AsTime().Before(accounts[j].OpenedDate.AsTime())
+	})
+
+	return accounts
+}
@@ -0,0 +1,268 @@
+// Package fake provides an in-process stand-in for Tinkoff Invest API.
+//
+// Server implements every generated pb.*ServiceServer with scriptable
+// candles, order books, trading statuses and order fills, so strategies
+// can be tested deterministically and the bot can be demoed without a token.
+package fake
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync"
+	"time"
+)
+
+const bufconnSize = 1024 * 1024
+
+// Server keeps the whole fake market in memory; all setters are safe for concurrent use.
+type Server struct {
+	mu sync.Mutex
+
+	grpcServer *grpc.Server
+	listener   net.Listener
+	bufconn    *bufconn.Listener
+
+	shares     []*pb.Share
+	etfs       []*pb.Etf
+	bonds      []*pb.Bond
+	futures    []*pb.Future
+	currencies []*pb.Currency
+
+	candles         map[candleKey][]*pb.HistoricCandle
+	orderBooks      map[string]*pb.GetOrderBookResponse
+	tradingStatuses map[string]pb.SecurityTradingStatus
+	lastPrices      map[string]*pb.LastPrice
+
+	accounts       map[string]*account
+	reports        map[string]*report
+	info           *pb.GetInfoResponse
+	filler         OrderFiller
+	enforceBalance bool
+
+	mdSubscribers     map[*mdSubscriber]struct{}
+	tradesSubscribers map[*tradesSubscriber]struct{}
+	pingInterval      time.Duration
+}
+
+type candleKey struct {
+	figi     string
+	interval pb.CandleInterval
+}
+
+// account is either a real (production) or a sandbox account.
+type account struct {
+	info    *pb.Account
+	sandbox bool
+
+	orders     map[string]*pb.OrderState
+	money      map[string]int64 // currency -> nano
+	positions  map[string]int64 // figi -> quantity in pieces
+	averages   map[string]int64 // figi -> average position price in nano
+	operations []*pb.Operation
+	stopOrders map[string]*pb.StopOrder
+}
+
+func NewServer() *Server {
+	s := &Server{
+		candles:           make(map[candleKey][]*pb.HistoricCandle),
+		orderBooks:        make(map[string]*pb.GetOrderBookResponse),
+		tradingStatuses:   make(map[string]pb.SecurityTradingStatus),
+		lastPrices:        make(map[string]*pb.LastPrice),
+		accounts:          make(map[string]*account),
+		reports:           make(map[string]*report),
+		mdSubscribers:     make(map[*mdSubscriber]struct{}),
+		tradesSubscribers: make(map[*tradesSubscriber]struct{}),
+		filler:            FillAll,
+		info: &pb.GetInfoResponse{
+			Tariff:               "fake",
+			QualifiedForWorkWith: []string{},
+		},
+	}
+
+	s.grpcServer = grpc.NewServer()
+	pb.RegisterInstrumentsServiceServer(s.grpcServer, &instrumentsServer{Server: s})
+	pb.RegisterMarketDataServiceServer(s.grpcServer, &marketDataServer{Server: s})
+	pb.RegisterMarketDataStreamServiceServer(s.grpcServer, &marketDataStreamServer{Server: s})
+	pb.RegisterOperationsServiceServer(s.grpcServer, &operationsServer{Server: s})
+	pb.RegisterOrdersServiceServer(s.grpcServer, &ordersServer{Server: s})
+	pb.RegisterOrdersStreamServiceServer(s.grpcServer, &ordersStreamServer{Server: s})
+	pb.RegisterSandboxServiceServer(s.grpcServer, &sandboxServer{Server: s})
+	pb.RegisterStopOrdersServiceServer(s.grpcServer, &stopOrdersServer{Server: s})
+	pb.RegisterUsersServiceServer(s.grpcServer, &usersServer{Server: s})
+
+	return s
+}
+
+// StartBufconn serves the API over an in-memory listener, see ClientConfig.
+func (s *Server) StartBufconn() {
+	s.bufconn = bufconn.Listen(bufconnSize)
+	go func() {
+		_ = s.grpcServer.Serve(s.bufconn)
+	}()
+}
+
+// Listen serves the API on a real address (e.g. "localhost:50051") without TLS.
+func (s *Server) Listen(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = lis
+	go func() {
+		_ = s.grpcServer.Serve(lis)
+	}()
+
+	return nil
+}
+
+// Addr returns the address the server listens on after Listen.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// ClientConfig returns sdk.ClientConfig pointing to this server.
+func (s *Server) ClientConfig() sdk.ClientConfig {
+	if s.bufconn == nil {
+		return sdk.ClientConfig{Target: s.Addr(), Insecure: true}
+	}
+
+	lis := s.bufconn
+	return sdk.ClientConfig{
+		Target:   "bufnet",
+		Insecure: true,
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+		},
+	}
+}
+
+// Stop closes all streams and stops serving immediately.
+func (s *Server) Stop() {
+	s.grpcServer.Stop()
+}
+
+// SetInfo replaces the response of UsersService.GetInfo.
+func (s *Server) SetInfo(info *pb.GetInfoResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.info = info
+}
+
+// AddAccount registers a production account and returns its ID.
+func (s *Server) AddAccount(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.openAccount(name, false).info.Id
+}
+
+// PayIn adds money to any (production or sandbox) account.
+func (s *Server) PayIn(accountID string, amount *pb.MoneyValue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", accountID)
+	}
+
+	acc.money[amount.Currency] += moneyToNano(amount)
+	return nil
+}
+
+// SetPosition sets instrument quantity (in pieces) on the account.
+func (s *Server) SetPosition(accountID, figi string, quantity int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[accountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", accountID)
+	}
+
+	acc.positions[figi] = quantity
+	return nil
+}
+
+// EnforceBalance makes buy orders fail if there is not enough money on the account.
+func (s *Server) EnforceBalance(enforce bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enforceBalance = enforce
+}
+
+// SetPingInterval makes streams send pb.Ping messages; zero disables pings.
+func (s *Server) SetPingInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pingInterval = d
+}
+
+// CloseStreams breaks all open streams with codes.Unavailable, e.g. to test reconnection.
+func (s *Server) CloseStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.mdSubscribers {
+		sub.close()
+	}
+	for sub := range s.tradesSubscribers {
+		sub.close()
+	}
+}
+
+func (s *Server) openAccount(name string, sandbox bool) *account {
+	acc := &account{
+		info: &pb.Account{
+			Id:          uuid.New().String(),
+			Type:        pb.AccountType_ACCOUNT_TYPE_TINKOFF,
+			Name:        name,
+			Status:      pb.AccountStatus_ACCOUNT_STATUS_OPEN,
+			OpenedDate:  nowTimestamp(),
+			AccessLevel: pb.AccessLevel_ACCOUNT_ACCESS_LEVEL_FULL_ACCESS,
+		},
+		sandbox:    sandbox,
+		orders:     make(map[string]*pb.OrderState),
+		money:      make(map[string]int64),
+		positions:  make(map[string]int64),
+		averages:   make(map[string]int64),
+		stopOrders: make(map[string]*pb.StopOrder),
+	}
+	s.accounts[acc.info.Id] = acc
+
+	return acc
+}
+
+// getAccount must be called with s.mu held.
+func (s *Server) getAccount(accountID string, sandbox bool) (*account, error) {
+	acc, ok := s.accounts[accountID]
+	if !ok || acc.sandbox != sandbox {
+		return nil, status.Errorf(codes.NotFound, "account %s not found", accountID)
+	}
+
+	return acc, nil
+}
+
+// apiError mimics Invest API errors: numeric code as status message and description in trailers.
+func apiError(ctx context.Context, code codes.Code, apiCode, description string) error {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("message", description))
+	return status.Error(code, apiCode)
+}
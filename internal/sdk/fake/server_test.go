@@ -0,0 +1,273 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const testFigi = "BBG004730N88"
+
+// newTestServer starts a server with a share of 10 pieces per lot and an account with 10000 rub on it.
+func newTestServer(t *testing.T) (*Server, *grpc.ClientConn, string) {
+	s := NewServer()
+	s.AddShare(&pb.Share{Figi: testFigi, Ticker: "SBER", ClassCode: "TQBR", Lot: 10, Currency: "rub"})
+	s.SetOrderBook(&pb.GetOrderBookResponse{
+		Figi: testFigi,
+		Bids: []*pb.Order{{Price: &pb.Quotation{Units: 99}, Quantity: 100}},
+		Asks: []*pb.Order{{Price: &pb.Quotation{Units: 100}, Quantity: 100}},
+	})
+
+	accountID := s.AddAccount("test")
+	if err := s.PayIn(accountID, &pb.MoneyValue{Currency: "rub", Units: 10000}); err != nil {
+		t.Fatalf("can not pay in: %v", err)
+	}
+
+	s.StartBufconn()
+	t.Cleanup(s.Stop)
+
+	cnf := s.ClientConfig()
+	conn, err := grpc.Dial(cnf.Target, append(cnf.DialOptions, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("can not dial fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return s, conn, accountID
+}
+
+func TestMarketOrderIsFilled(t *testing.T) {
+	_, conn, accountID := newTestServer(t)
+	ctx := context.Background()
+
+	order, err := pb.NewOrdersServiceClient(conn).PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  2,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		AccountId: accountID,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if err != nil {
+		t.Fatalf("can not post order: %v", err)
+	}
+	if order.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL || order.LotsExecuted != 2 {
+		t.Errorf("market order must be filled at once, got %v", order)
+	}
+	if order.InitialSecurityPrice.Units != 100 {
+		t.Errorf("market buy must take the best ask 100, got %v", order.InitialSecurityPrice)
+	}
+
+	positions, err := pb.NewOperationsServiceClient(conn).GetPositions(ctx, &pb.PositionsRequest{AccountId: accountID})
+	if err != nil {
+		t.Fatalf("can not get positions: %v", err)
+	}
+	if len(positions.Securities) != 1 || positions.Securities[0].Balance != 20 {
+		t.Errorf("expected 20 pieces of %s, got %v", testFigi, positions.Securities)
+	}
+	if len(positions.Money) != 1 || positions.Money[0].Units != 8000 {
+		t.Errorf("expected 8000 rub left, got %v", positions.Money)
+	}
+
+	portfolio, err := pb.NewOperationsServiceClient(conn).GetPortfolio(ctx, &pb.PortfolioRequest{AccountId: accountID})
+	if err != nil {
+		t.Fatalf("can not get portfolio: %v", err)
+	}
+	if len(portfolio.Positions) != 1 || portfolio.Positions[0].AveragePositionPrice.Units != 100 {
+		t.Errorf("expected position bought at 100, got %v", portfolio.Positions)
+	}
+}
+
+func TestOrderIsFilledPartially(t *testing.T) {
+	s, conn, accountID := newTestServer(t)
+	s.SetOrderFiller(FillPartially(1))
+	ctx := context.Background()
+	orders := pb.NewOrdersServiceClient(conn)
+
+	order, err := orders.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  3,
+		Price:     &pb.Quotation{Units: 98},
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		AccountId: accountID,
+		OrderType: pb.OrderType_ORDER_TYPE_LIMIT,
+	})
+	if err != nil {
+		t.Fatalf("can not post order: %v", err)
+	}
+	if order.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL || order.LotsExecuted != 1 {
+		t.Errorf("expected 1 of 3 lots executed, got %v", order)
+	}
+
+	if err := s.FillOrder(accountID, order.OrderId, 3); err == nil {
+		t.Error("FillOrder must not execute more lots than left")
+	}
+	if err := s.FillOrder(accountID, order.OrderId, 2); err != nil {
+		t.Fatalf("can not fill order: %v", err)
+	}
+
+	state, err := orders.GetOrderState(ctx, &pb.GetOrderStateRequest{AccountId: accountID, OrderId: order.OrderId})
+	if err != nil {
+		t.Fatalf("can not get order state: %v", err)
+	}
+	if state.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL || len(state.Stages) != 2 {
+		t.Errorf("expected order filled in 2 stages, got %v", state)
+	}
+	if state.AveragePositionPrice.Units != 98 {
+		t.Errorf("limit order must be executed by its price 98, got %v", state.AveragePositionPrice)
+	}
+
+	active, err := orders.GetOrders(ctx, &pb.GetOrdersRequest{AccountId: accountID})
+	if err != nil || len(active.Orders) != 0 {
+		t.Errorf("filled order must not be active, got %v, %v", active, err)
+	}
+}
+
+func TestOrderIDIsIdempotent(t *testing.T) {
+	_, conn, accountID := newTestServer(t)
+	ctx := context.Background()
+	orders := pb.NewOrdersServiceClient(conn)
+
+	req := &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		AccountId: accountID,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+		OrderId:   "a5bd4b9c-5a0f-4dbf-8a5e-3c6d1e6b7f00",
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := orders.PostOrder(ctx, req); err != nil {
+			t.Fatalf("can not post order: %v", err)
+		}
+	}
+
+	positions, err := pb.NewOperationsServiceClient(conn).GetPositions(ctx, &pb.PositionsRequest{AccountId: accountID})
+	if err != nil {
+		t.Fatalf("can not get positions: %v", err)
+	}
+	if len(positions.Securities) != 1 || positions.Securities[0].Balance != 10 {
+		t.Errorf("the same order ID must be executed once, got %v", positions.Securities)
+	}
+}
+
+func TestOrderIsRejectedWhenNotTrading(t *testing.T) {
+	s, conn, accountID := newTestServer(t)
+	s.SetTradingStatus(testFigi, pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NOT_AVAILABLE_FOR_TRADING)
+
+	_, err := pb.NewOrdersServiceClient(conn).PostOrder(context.Background(), &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		AccountId: accountID,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if status.Code(err) != codes.FailedPrecondition || status.Convert(err).Message() != "30079" {
+		t.Errorf("expected API error 30079, got %v", err)
+	}
+}
+
+func TestOrderIsRejectedWithoutMoney(t *testing.T) {
+	s, conn, accountID := newTestServer(t)
+	s.EnforceBalance(true)
+
+	_, err := pb.NewOrdersServiceClient(conn).PostOrder(context.Background(), &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  11, // 11000 rub
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		AccountId: accountID,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != "30042" {
+		t.Errorf("expected API error 30042, got %v", err)
+	}
+}
+
+func TestStopOrderIsTriggeredByLastPrice(t *testing.T) {
+	s, conn, accountID := newTestServer(t)
+	ctx := context.Background()
+	stopOrders := pb.NewStopOrdersServiceClient(conn)
+
+	if err := s.SetPosition(accountID, testFigi, 10); err != nil {
+		t.Fatalf("can not set position: %v", err)
+	}
+	_, err := stopOrders.PostStopOrder(ctx, &pb.PostStopOrderRequest{
+		Figi:          testFigi,
+		Quantity:      1,
+		Price:         &pb.Quotation{Units: 110},
+		StopPrice:     &pb.Quotation{Units: 110},
+		Direction:     pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL,
+		AccountId:     accountID,
+		StopOrderType: pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT,
+	})
+	if err != nil {
+		t.Fatalf("can not post stop order: %v", err)
+	}
+
+	s.SetLastPrice(testFigi, &pb.Quotation{Units: 105})
+	res, err := stopOrders.GetStopOrders(ctx, &pb.GetStopOrdersRequest{AccountId: accountID})
+	if err != nil || len(res.StopOrders) != 1 {
+		t.Fatalf("take profit must wait for its price, got %v, %v", res, err)
+	}
+
+	s.SetLastPrice(testFigi, &pb.Quotation{Units: 110})
+	res, err = stopOrders.GetStopOrders(ctx, &pb.GetStopOrdersRequest{AccountId: accountID})
+	if err != nil || len(res.StopOrders) != 0 {
+		t.Fatalf("triggered take profit must disappear, got %v, %v", res, err)
+	}
+
+	orders := s.Orders(accountID)
+	if len(orders) != 1 || orders[0].Direction != pb.OrderDirection_ORDER_DIRECTION_SELL || orders[0].LotsExecuted != 1 {
+		t.Errorf("triggered take profit must sell a lot, got %v", orders)
+	}
+}
+
+func TestMarketDataStream(t *testing.T) {
+	s, conn, _ := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := pb.NewMarketDataStreamServiceClient(conn).MarketDataStream(ctx)
+	if err != nil {
+		t.Fatalf("can not open stream: %v", err)
+	}
+	err = stream.Send(&pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeLastPriceRequest{
+		SubscribeLastPriceRequest: &pb.SubscribeLastPriceRequest{
+			SubscriptionAction: pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE,
+			Instruments:        []*pb.LastPriceInstrument{{Figi: testFigi}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil || res.GetSubscribeLastPriceResponse() == nil {
+		t.Fatalf("expected subscription response, got %v, %v", res, err)
+	}
+
+	s.SetLastPrice(testFigi, &pb.Quotation{Units: 101})
+	for {
+		res, err = stream.Recv()
+		if err != nil {
+			t.Fatalf("can not receive last price: %v", err)
+		}
+		if res.GetLastPrice() != nil {
+			break
+		}
+	}
+	if res.GetLastPrice().Figi != testFigi || res.GetLastPrice().Price.Units != 101 {
+		t.Errorf("expected last price 101, got %v", res.GetLastPrice())
+	}
+
+	s.CloseStreams()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("closed stream must fail with Unavailable, got %v", err)
+	}
+}
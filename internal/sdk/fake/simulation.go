@@ -0,0 +1,58 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math/rand"
+	"time"
+)
+
+// RandomWalk moves instrument price randomly by at most step every tick,
+// publishing order books, last prices and 1-minute candles until ctx is done.
+// It is meant for demo runs, tests should script market data explicitly.
+func (s *Server) RandomWalk(ctx context.Context, figi string, price, step float64, tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	current := int64(price * nanoPerUnit)
+	stepNano := int64(step * nanoPerUnit)
+	var candle *pb.HistoricCandle
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current += rand.Int63n(2*stepNano+1) - stepNano
+			if current < stepNano {
+				current = stepNano
+			}
+			q := nanoToQuotation(current)
+
+			s.SetOrderBook(&pb.GetOrderBookResponse{
+				Figi:      figi,
+				Depth:     1,
+				Bids:      []*pb.Order{{Price: nanoToQuotation(current - stepNano/2), Quantity: 100}},
+				Asks:      []*pb.Order{{Price: nanoToQuotation(current + stepNano/2), Quantity: 100}},
+				LastPrice: q,
+			})
+
+			minute := now.Truncate(time.Minute)
+			if candle == nil || !candle.Time.AsTime().Equal(minute) {
+				if candle != nil {
+					s.AddCandle(figi, pb.CandleInterval_CANDLE_INTERVAL_1_MIN, candle)
+				}
+				candle = &pb.HistoricCandle{Open: q, High: q, Low: q, Time: timestamppb.New(minute)}
+			}
+			if current > quotationToNano(candle.High) {
+				candle.High = q
+			}
+			if current < quotationToNano(candle.Low) {
+				candle.Low = q
+			}
+			candle.Close = q
+			candle.Volume++
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+type stopOrdersServer struct {
+	pb.UnimplementedStopOrdersServiceServer
+	*Server
+}
+
+func (sos *stopOrdersServer) PostStopOrder(_ context.Context, req *pb.PostStopOrderRequest) (*pb.PostStopOrderResponse, error) {
+	sos.mu.Lock()
+	defer sos.mu.Unlock()
+
+	acc, err := sos.getAccount(req.AccountId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := sos.currency(req.Figi)
+	stopOrder := &pb.StopOrder{
+		StopOrderId:    uuid.New().String(),
+		LotsRequested:  req.Quantity,
+		Figi:           req.Figi,
+		Direction:      req.Direction,
+		Currency:       currency,
+		OrderType:      req.StopOrderType,
+		CreateDate:     nowTimestamp(),
+		ExpirationTime: req.ExpireDate,
+		Price:          nanoToMoney(quotationToNano(req.Price), currency),
+		StopPrice:      nanoToMoney(quotationToNano(req.StopPrice), currency),
+	}
+	acc.stopOrders[stopOrder.StopOrderId] = stopOrder
+
+	return &pb.PostStopOrderResponse{StopOrderId: stopOrder.StopOrderId}, nil
+}
+
+func (sos *stopOrdersServer) GetStopOrders(_ context.Context, req *pb.GetStopOrdersRequest) (*pb.GetStopOrdersResponse, error) {
+	sos.mu.Lock()
+	defer sos.mu.Unlock()
+
+	acc, err := sos.getAccount(req.AccountId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.GetStopOrdersResponse{}
+	for _, so := range acc.stopOrders {
+		res.StopOrders = append(res.StopOrders, so)
+	}
+
+	return res, nil
+}
+
+func (sos *stopOrdersServer) CancelStopOrder(ctx context.Context, req *pb.CancelStopOrderRequest) (*pb.CancelStopOrderResponse, error) {
+	sos.mu.Lock()
+	defer sos.mu.Unlock()
+
+	acc, err := sos.getAccount(req.AccountId, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := acc.stopOrders[req.StopOrderId]; !ok {
+		return nil, apiError(ctx, codes.NotFound, "50006", "Stop-order not found")
+	}
+
+	delete(acc.stopOrders, req.StopOrderId)
+	return &pb.CancelStopOrderResponse{Time: nowTimestamp()}, nil
+}
+
+// triggerStopOrders must be called with s.mu held; activated stop orders become market
+// (or limit for STOP_LIMIT) orders and disappear from the stop orders list.
+func (s *Server) triggerStopOrders(figi string, price int64) {
+	for _, acc := range s.accounts {
+		for id, so := range acc.stopOrders {
+			if so.Figi != figi || !stopOrderIsActivated(so, price) {
+				continue
+			}
+
+			delete(acc.stopOrders, id)
+
+			req := &pb.PostOrderRequest{
+				Figi:      so.Figi,
+				Quantity:  so.LotsRequested,
+				Direction: pb.OrderDirection_ORDER_DIRECTION_SELL,
+				AccountId: acc.info.Id,
+				OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+				OrderId:   uuid.New().String(),
+			}
+			if so.Direction == pb.StopOrderDirection_STOP_ORDER_DIRECTION_BUY {
+				req.Direction = pb.OrderDirection_ORDER_DIRECTION_BUY
+			}
+			if so.OrderType == pb.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT {
+				req.OrderType = pb.OrderType_ORDER_TYPE_LIMIT
+				req.Price = nanoToQuotation(moneyToNano(so.Price))
+			}
+
+			_, _ = s.placeOrder(context.Background(), acc, req)
+		}
+	}
+}
+
+func stopOrderIsActivated(so *pb.StopOrder, price int64) bool {
+	stopPrice := moneyToNano(so.StopPrice)
+	sell := so.Direction == pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+
+	switch so.OrderType {
+	case pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT:
+		if sell {
+			return price >= stopPrice
+		}
+		return price <= stopPrice
+	default: // stop loss and stop limit
+		if sell {
+			return price <= stopPrice
+		}
+		return price >= stopPrice
+	}
+}
@@ -0,0 +1,26 @@
+package fake
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+)
+
+type usersServer struct {
+	pb.UnimplementedUsersServiceServer
+	*Server
+}
+
+func (us *usersServer) GetAccounts(context.Context, *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
+	return &pb.GetAccountsResponse{Accounts: us.listAccounts(false)}, nil
+}
+
+func (us *usersServer) GetInfo(context.Context, *pb.GetInfoRequest) (*pb.GetInfoResponse, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	return us.info, nil
+}
+
+func (us *usersServer) GetUserTariff(context.Context, *pb.GetUserTariffRequest) (*pb.GetUserTariffResponse, error) {
+	return &pb.GetUserTariffResponse{}, nil
+}
@@ -2,8 +2,11 @@
 package sdk
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
+	"context"
+	"fmt"
 	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
+	"sync"
 )
 
 type MarketDataStreamInterface interface {
@@ -11,34 +14,207 @@ type MarketDataStreamInterface interface {
 	Recv() (*pb.MarketDataResponse, error)
 	// Send puts pb.MarketDataRequest into a stream.
 	Send(request *pb.MarketDataRequest) error
+	// Events returns connection state changes.
+	Events() <-chan StreamEvent
+	// Close stops the stream, Recv returns ErrStreamClosed after that.
+	Close()
 }
 
+// MarketDataStream survives broken connections: it reopens the stream
+// and replays all active subscriptions, so callers only see a gap in messages.
 type MarketDataStream struct {
 	client pb.MarketDataStreamServiceClient
-	stream pb.MarketDataStreamService_MarketDataStreamClient
+	keeper *streamKeeper
+
+	mu            sync.Mutex // guards stream and subscriptions, serializes Send
+	stream        pb.MarketDataStreamService_MarketDataStreamClient
+	subscriptions *marketDataSubscriptions
+}
+
+// NewMarketDataStream opens a stream which lives until Close is called or ctx is cancelled.
+func NewMarketDataStream(ctx context.Context, conn grpc.ClientConnInterface, cfg StreamConfig) (*MarketDataStream, error) {
+	mds := &MarketDataStream{
+		client:        pb.NewMarketDataStreamServiceClient(conn),
+		subscriptions: newMarketDataSubscriptions(),
+	}
+
+	mds.keeper = newStreamKeeper(ctx, "MarketDataStream", cfg, mds.open)
+	if err := mds.keeper.start(); err != nil {
+		return nil, err
+	}
+
+	return mds, nil
 }
 
-func NewMarketDataStream() *MarketDataStream {
-	conn, err := createClientConn()
+// open creates a new stream connection and replays active subscriptions on it.
+func (mds *MarketDataStream) open(ctx context.Context) (func() (interface{}, error), error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	stream, err := mds.client.MarketDataStream(ctx)
 	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
+		return nil, err
 	}
 
-	client := pb.NewMarketDataStreamServiceClient(conn)
-	ctx := createStreamContext()
+	for _, request := range mds.subscriptions.requests() {
+		if err := stream.Send(request); err != nil {
+			return nil, fmt.Errorf("can not resubscribe: %v", err)
+		}
+	}
+	mds.stream = stream
 
-	stream, err := client.MarketDataStream(ctx)
+	return func() (interface{}, error) {
+		return stream.Recv()
+	}, nil
+}
+
+func (mds *MarketDataStream) Recv() (*pb.MarketDataResponse, error) {
+	msg, err := mds.keeper.recv()
 	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
+		return nil, err
+	}
+
+	return msg.(*pb.MarketDataResponse), nil
+}
+
+// Send remembers subscription changes and sends them to the current connection.
+// If the connection is broken, request is replayed after reconnect and nil is returned.
+func (mds *MarketDataStream) Send(request *pb.MarketDataRequest) error {
+	if mds.keeper.ctx.Err() != nil {
+		return ErrStreamClosed
 	}
 
-	return &MarketDataStream{client: client, stream: stream}
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	mds.subscriptions.apply(request)
+	_ = mds.stream.Send(request)
+
+	return nil
+}
+
+func (mds *MarketDataStream) Events() <-chan StreamEvent {
+	return mds.keeper.events
 }
 
-func (mds MarketDataStream) Recv() (*pb.MarketDataResponse, error) {
-	return mds.stream.Recv()
+func (mds *MarketDataStream) Close() {
+	mds.keeper.close()
 }
 
-func (mds MarketDataStream) Send(request *pb.MarketDataRequest) error {
-	return mds.stream.Send(request)
+// marketDataSubscriptions tracks active subscriptions to replay them on a new connection.
+type marketDataSubscriptions struct {
+	candles    map[string]*pb.CandleInstrument
+	orderBooks map[string]*pb.OrderBookInstrument
+	trades     map[string]*pb.TradeInstrument
+	info       map[string]*pb.InfoInstrument
+	lastPrices map[string]*pb.LastPriceInstrument
+}
+
+func newMarketDataSubscriptions() *marketDataSubscriptions {
+	return &marketDataSubscriptions{
+		candles:    make(map[string]*pb.CandleInstrument),
+		orderBooks: make(map[string]*pb.OrderBookInstrument),
+		trades:     make(map[string]*pb.TradeInstrument),
+		info:       make(map[string]*pb.InfoInstrument),
+		lastPrices: make(map[string]*pb.LastPriceInstrument),
+	}
+}
+
+func (s *marketDataSubscriptions) apply(request *pb.MarketDataRequest) {
+	if r := request.GetSubscribeCandlesRequest(); r != nil {
+		subscribe := r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+		for _, i := range r.Instruments {
+			key := fmt.Sprintf("%s/%d", i.Figi, i.Interval)
+			if subscribe {
+				s.candles[key] = i
+			} else {
+				delete(s.candles, key)
+			}
+		}
+	}
+	if r := request.GetSubscribeOrderBookRequest(); r != nil {
+		subscribe := r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+		for _, i := range r.Instruments {
+			key := fmt.Sprintf("%s/%d", i.Figi, i.Depth)
+			if subscribe {
+				s.orderBooks[key] = i
+			} else {
+				delete(s.orderBooks, key)
+			}
+		}
+	}
+	if r := request.GetSubscribeTradesRequest(); r != nil {
+		subscribe := r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+		for _, i := range r.Instruments {
+			if subscribe {
+				s.trades[i.Figi] = i
+			} else {
+				delete(s.trades, i.Figi)
+			}
+		}
+	}
+	if r := request.GetSubscribeInfoRequest(); r != nil {
+		subscribe := r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+		for _, i := range r.Instruments {
+			if subscribe {
+				s.info[i.Figi] = i
+			} else {
+				delete(s.info, i.Figi)
+			}
+		}
+	}
+	if r := request.GetSubscribeLastPriceRequest(); r != nil {
+		subscribe := r.SubscriptionAction == pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+		for _, i := range r.Instruments {
+			if subscribe {
+				s.lastPrices[i.Figi] = i
+			} else {
+				delete(s.lastPrices, i.Figi)
+			}
+		}
+	}
+}
+
+// requests returns one subscribe request per non-empty subscription kind.
+func (s *marketDataSubscriptions) requests() []*pb.MarketDataRequest {
+	subscribe := pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+	var requests []*pb.MarketDataRequest
+
+	if len(s.candles) > 0 {
+		r := &pb.SubscribeCandlesRequest{SubscriptionAction: subscribe}
+		for _, i := range s.candles {
+			r.Instruments = append(r.Instruments, i)
+		}
+		requests = append(requests, &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeCandlesRequest{SubscribeCandlesRequest: r}})
+	}
+	if len(s.orderBooks) > 0 {
+		r := &pb.SubscribeOrderBookRequest{SubscriptionAction: subscribe}
+		for _, i := range s.orderBooks {
+			r.Instruments = append(r.Instruments, i)
+		}
+		requests = append(requests, &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeOrderBookRequest{SubscribeOrderBookRequest: r}})
+	}
+	if len(s.trades) > 0 {
+		r := &pb.SubscribeTradesRequest{SubscriptionAction: subscribe}
+		for _, i := range s.trades {
+			r.Instruments = append(r.Instruments, i)
+		}
+		requests = append(requests, &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeTradesRequest{SubscribeTradesRequest: r}})
+	}
+	if len(s.info) > 0 {
+		r := &pb.SubscribeInfoRequest{SubscriptionAction: subscribe}
+		for _, i := range s.info {
+			r.Instruments = append(r.Instruments, i)
+		}
+		requests = append(requests, &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeInfoRequest{SubscribeInfoRequest: r}})
+	}
+	if len(s.lastPrices) > 0 {
+		r := &pb.SubscribeLastPriceRequest{SubscriptionAction: subscribe}
+		for _, i := range s.lastPrices {
+			r.Instruments = append(r.Instruments, i)
+		}
+		requests = append(requests, &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeLastPriceRequest{SubscribeLastPriceRequest: r}})
+	}
+
+	return requests
 }
@@ -0,0 +1,155 @@
+package sdk_test
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"testing"
+	"time"
+)
+
+const testFigi = "BBG004730N88"
+
+// newTestClient starts a fake server and connects to it with fast stream reconnects.
+func newTestClient(t *testing.T) (*fake.Server, *sdk.Client) {
+	server := fake.NewServer()
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	cnf := server.ClientConfig()
+	cnf.Stream = sdk.StreamConfig{Reconnect: sdk.RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}}
+	client, err := sdk.NewClient(cnf)
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return server, client
+}
+
+func waitForState(t *testing.T, events <-chan sdk.StreamEvent, state sdk.StreamState) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.State == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s stream", state)
+		}
+	}
+}
+
+// recvUntil receives market data until found returns true for a message.
+func recvUntil(t *testing.T, stream *sdk.MarketDataStream, found func(res *pb.MarketDataResponse) bool) {
+	t.Helper()
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("can not receive market data: %v", err)
+		}
+		if found(res) {
+			return
+		}
+	}
+}
+
+func TestMarketDataStreamResubscribes(t *testing.T) {
+	server, client := newTestClient(t)
+
+	stream, err := client.NewMarketDataStream(context.Background())
+	if err != nil {
+		t.Fatalf("can not open market data stream: %v", err)
+	}
+	defer stream.Close()
+	waitForState(t, stream.Events(), sdk.StreamConnected)
+
+	err = stream.Send(&pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeLastPriceRequest{
+		SubscribeLastPriceRequest: &pb.SubscribeLastPriceRequest{
+			SubscriptionAction: pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE,
+			Instruments:        []*pb.LastPriceInstrument{{Figi: testFigi}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	subscribed := func(res *pb.MarketDataResponse) bool { return res.GetSubscribeLastPriceResponse() != nil }
+	recvUntil(t, stream, subscribed)
+
+	server.CloseStreams()
+	waitForState(t, stream.Events(), sdk.StreamReconnecting)
+	waitForState(t, stream.Events(), sdk.StreamConnected)
+	recvUntil(t, stream, subscribed) // the subscription is replayed on the new connection
+
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 101})
+	recvUntil(t, stream, func(res *pb.MarketDataResponse) bool {
+		return res.GetLastPrice() != nil && res.GetLastPrice().Price.Units == 101
+	})
+}
+
+func TestOrdersStreamReconnects(t *testing.T) {
+	server, client := newTestClient(t)
+	accountID := server.AddAccount("test")
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 100})
+
+	stream, err := client.NewOrdersStream(context.Background(), &pb.TradesStreamRequest{Accounts: []string{accountID}})
+	if err != nil {
+		t.Fatalf("can not open trades stream: %v", err)
+	}
+	defer stream.Close()
+
+	trades := make(chan *pb.OrderTrades, 16)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if res.GetOrderTrades() != nil {
+				trades <- res.GetOrderTrades()
+			}
+		}
+	}()
+
+	waitForTrade(t, client, accountID, trades)
+	server.CloseStreams()
+	waitForState(t, stream.Events(), sdk.StreamReconnecting)
+	waitForState(t, stream.Events(), sdk.StreamConnected)
+	waitForTrade(t, client, accountID, trades)
+}
+
+// waitForTrade posts orders until a trade comes from the stream: a just opened stream
+// is not served at once.
+func waitForTrade(t *testing.T, client *sdk.Client, accountID string, trades <-chan *pb.OrderTrades) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		_, err := client.ServicePool().OrdersService.PostOrder(context.Background(), &pb.PostOrderRequest{
+			Figi:      testFigi,
+			Quantity:  1,
+			Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+			AccountId: accountID,
+			OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+		})
+		if err != nil {
+			t.Fatalf("can not post order: %v", err)
+		}
+
+		select {
+		case trade := <-trades:
+			if trade.Figi != testFigi || trade.AccountId != accountID {
+				t.Errorf("unexpected trade: %v", trade)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for a trade")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"time"
+)
+
+// noApiCode is used as api_code label for errors without Invest API error code.
+const noApiCode = "none"
+
+// metricsUnaryInterceptor records requests, latency and errors of every unary call.
+// It is the outermost interceptor, so retries and throttling are included into latency.
+func metricsUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, name := splitMethodName(method)
+		metrics.ApiRequests.WithLabelValues(loggy.GetBotID(), service, name).Inc()
+
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		observeCall(service, name, start, err)
+
+		return err
+	}
+}
+
+// metricsStreamInterceptor records stream openings and errors the stream is broken with;
+// latency is measured until the stream is established.
+func metricsStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		service, name := splitMethodName(method)
+		metrics.ApiRequests.WithLabelValues(loggy.GetBotID(), service, name).Inc()
+
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		observeCall(service, name, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		return &measuredStream{ClientStream: stream, service: service, method: name}, nil
+	}
+}
+
+// measuredStream counts the error that terminated the stream.
+type measuredStream struct {
+	grpc.ClientStream
+	service string
+	method  string
+}
+
+func (s *measuredStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && err != io.EOF && status.Code(err) != codes.Canceled {
+		incrementApiCallErrors(s.service, s.method, err)
+	}
+
+	return err
+}
+
+func observeCall(service, method string, start time.Time, err error) {
+	metrics.ApiRequestDuration.WithLabelValues(loggy.GetBotID(), service, method, status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		incrementApiCallErrors(service, method, err)
+	}
+}
+
+func incrementApiCallErrors(service, method string, err error) {
+	metrics.ApiCallErrors.WithLabelValues(loggy.GetBotID(), service, method,
+		status.Code(err).String(), apiErrorCode(err)).Inc()
+}
+
+// apiErrorCode returns numeric Invest API error code (e.g. "30042") or noApiCode;
+// free-form messages are never used as label values.
+func apiErrorCode(err error) string {
+	if code := parseApiCode(err); code != "" {
+		return code
+	}
+
+	return noApiCode
+}
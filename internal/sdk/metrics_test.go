@@ -0,0 +1,76 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"testing"
+)
+
+func TestApiErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{status.Error(codes.InvalidArgument, "30042"), "30042"},
+		{status.Error(codes.Unavailable, "connection refused"), noApiCode},
+		{status.Error(codes.Internal, "1234567"), noApiCode},
+		{status.Error(codes.Internal, ""), noApiCode},
+		{errors.New("connection reset"), noApiCode}, // not a gRPC status
+	}
+
+	for _, tt := range tests {
+		if got := apiErrorCode(tt.err); got != tt.want {
+			t.Errorf("apiErrorCode(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsUnaryInterceptor(t *testing.T) {
+	requests := metrics.ApiRequests.WithLabelValues(loggy.GetBotID(), "OrdersService", "GetOrders")
+	errs := metrics.ApiCallErrors.WithLabelValues(loggy.GetBotID(), "OrdersService", "GetOrders", "InvalidArgument", "30042")
+	requestsBefore, errsBefore := testutil.ToFloat64(requests), testutil.ToFloat64(errs)
+
+	succeeded, failed := 0, 0
+	interceptor := metricsUnaryInterceptor()
+	_ = interceptor(context.Background(), getOrdersMethod, nil, nil, nil, failingInvoker(&succeeded))
+	_ = interceptor(context.Background(), getOrdersMethod, nil, nil, nil,
+		failingInvoker(&failed, status.Error(codes.InvalidArgument, "30042")))
+
+	if got := testutil.ToFloat64(requests) - requestsBefore; got != 2 {
+		t.Errorf("%v requests counted, want 2", got)
+	}
+	if got := testutil.ToFloat64(errs) - errsBefore; got != 1 {
+		t.Errorf("%v errors counted, want 1", got)
+	}
+}
+
+// testClientStream fails RecvMsg with err.
+type testClientStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s testClientStream) RecvMsg(interface{}) error {
+	return s.err
+}
+
+func TestMeasuredStreamCountsBreaks(t *testing.T) {
+	errs := metrics.ApiCallErrors.WithLabelValues(loggy.GetBotID(), "MarketDataStreamService", "MarketDataStream", "Unavailable", noApiCode)
+	before := testutil.ToFloat64(errs)
+
+	for _, err := range []error{io.EOF, status.Error(codes.Canceled, "closed"), status.Error(codes.Unavailable, "reset")} {
+		s := &measuredStream{ClientStream: testClientStream{err: err}, service: "MarketDataStreamService", method: "MarketDataStream"}
+		_ = s.RecvMsg(nil)
+	}
+
+	if got := testutil.ToFloat64(errs) - before; got != 1 {
+		t.Errorf("%v stream errors counted, want only the broken one", got)
+	}
+}
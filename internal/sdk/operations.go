@@ -2,42 +2,48 @@
 package sdk
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
-	"github.com/elkopass/BITA/internal/metrics"
+	"context"
 	pb "github.com/elkopass/BITA/internal/proto"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/grpc"
+	"time"
 )
 
 type OperationsInterface interface {
 	// Method for getting a list of account transactions.
-	GetOperations(accountID string, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error)
+	GetOperations(ctx context.Context, accountID string, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error)
 	// The method of obtaining a portfolio by account.
-	GetPortfolio(accountID string) (*pb.PortfolioResponse, error)
+	GetPortfolio(ctx context.Context, accountID string) (*pb.PortfolioResponse, error)
 	// Method for getting a list of account positions.
-	GetPositions(accountID string) (*pb.PositionsResponse, error)
+	GetPositions(ctx context.Context, accountID string) (*pb.PositionsResponse, error)
 	// The method of obtaining the available balance for withdrawal of funds.
-	GetWithdrawLimits(accountID string) (*pb.WithdrawLimitsResponse, error)
+	GetWithdrawLimits(ctx context.Context, accountID string) (*pb.WithdrawLimitsResponse, error)
+	// The method of ordering a broker report, it returns ID of the report generation task.
+	GenerateBrokerReport(ctx context.Context, accountID string, from, to *timestamp.Timestamp) (string, error)
+	// The method of getting a page of the generated broker report.
+	GetBrokerReport(ctx context.Context, taskID string, page int32) (*pb.GetBrokerReportResponse, error)
+	// The method of ordering a report on dividends from foreign issuers, it returns ID of the report generation task.
+	GenerateDividendsForeignIssuerReport(ctx context.Context, accountID string, from, to *timestamp.Timestamp) (string, error)
+	// The method of getting a page of the generated report on dividends from foreign issuers.
+	GetDividendsForeignIssuerReport(ctx context.Context, taskID string, page int32) (*pb.GetDividendsForeignIssuerReportResponse, error)
+	// The method of getting the whole broker report, see OperationsService.BrokerReport.
+	BrokerReport(ctx context.Context, accountID string, from, to time.Time) ([]*pb.BrokerReport, error)
+	// The method of getting the whole report on dividends from foreign issuers, see OperationsService.DividendsForeignIssuerReport.
+	DividendsForeignIssuerReport(ctx context.Context, accountID string, from, to time.Time) ([]*pb.DividendsForeignIssuerReport, error)
 }
 
 type OperationsService struct {
 	client pb.OperationsServiceClient
 }
 
-func NewOperationsService() *OperationsService {
-	conn, err := createClientConn()
-	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
-	}
-
+func NewOperationsService(conn grpc.ClientConnInterface) *OperationsService {
 	client := pb.NewOperationsServiceClient(conn)
 	return &OperationsService{client: client}
 }
 
-func (os OperationsService) GetOperations(accountID string, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (os OperationsService) GetOperations(ctx context.Context, accountID string, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error) {
+	ctx = createRequestContext(ctx)
 
-	os.incrementRequestsCounter("GetOperations")
 	res, err := os.client.GetOperations(ctx, &pb.OperationsRequest{
 		AccountId: accountID,
 		From:      from,
@@ -46,65 +52,121 @@ func (os OperationsService) GetOperations(accountID string, from, to *timestamp.
 		Figi:      figi,
 	})
 	if err != nil {
-		os.incrementApiCallErrors("GetOperations", err.Error())
 		return nil, err
 	}
 
 	return res.Operations, nil
 }
 
-func (os OperationsService) GetPortfolio(accountID string) (*pb.PortfolioResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (os OperationsService) GetPortfolio(ctx context.Context, accountID string) (*pb.PortfolioResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	os.incrementRequestsCounter("GetPortfolio")
 	res, err := os.client.GetPortfolio(ctx, &pb.PortfolioRequest{
 		AccountId: accountID,
 	})
 	if err != nil {
-		os.incrementApiCallErrors("GetPortfolio", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
 
-func (os OperationsService) GetPositions(accountID string) (*pb.PositionsResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (os OperationsService) GetPositions(ctx context.Context, accountID string) (*pb.PositionsResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	os.incrementRequestsCounter("GetPositions")
 	res, err := os.client.GetPositions(ctx, &pb.PositionsRequest{
 		AccountId: accountID,
 	})
 	if err != nil {
-		os.incrementApiCallErrors("GetPositions", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
 
-func (os OperationsService) GetWithdrawLimits(accountID string) (*pb.WithdrawLimitsResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (os OperationsService) GetWithdrawLimits(ctx context.Context, accountID string) (*pb.WithdrawLimitsResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	os.incrementRequestsCounter("GetWithdrawLimits")
 	res, err := os.client.GetWithdrawLimits(ctx, &pb.WithdrawLimitsRequest{
 		AccountId: accountID,
 	})
 	if err != nil {
-		os.incrementApiCallErrors("GetWithdrawLimits", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
 
-func (os OperationsService) incrementRequestsCounter(method string) {
-	metrics.ApiRequests.WithLabelValues(loggy.GetBotID(), "OperationsService", method).Inc()
+func (os OperationsService) GenerateBrokerReport(ctx context.Context, accountID string, from, to *timestamp.Timestamp) (string, error) {
+	ctx = createRequestContext(ctx)
+
+	res, err := os.client.GetBrokerReport(ctx, &pb.BrokerReportRequest{
+		Payload: &pb.BrokerReportRequest_GenerateBrokerReportRequest{
+			GenerateBrokerReportRequest: &pb.GenerateBrokerReportRequest{
+				AccountId: accountID,
+				From:      from,
+				To:        to,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return res.GetGenerateBrokerReportResponse().GetTaskId(), nil
 }
 
-func (os OperationsService) incrementApiCallErrors(method string, error string) {
-	metrics.ApiCallErrors.WithLabelValues(loggy.GetBotID(), "OperationsService", method, error).Inc()
+func (os OperationsService) GetBrokerReport(ctx context.Context, taskID string, page int32) (*pb.GetBrokerReportResponse, error) {
+	ctx = createRequestContext(ctx)
+
+	res, err := os.client.GetBrokerReport(ctx, &pb.BrokerReportRequest{
+		Payload: &pb.BrokerReportRequest_GetBrokerReportRequest{
+			GetBrokerReportRequest: &pb.GetBrokerReportRequest{
+				TaskId: taskID,
+				Page:   page,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.GetGetBrokerReportResponse(), nil
+}
+
+func (os OperationsService) GenerateDividendsForeignIssuerReport(ctx context.Context, accountID string, from, to *timestamp.Timestamp) (string, error) {
+	ctx = createRequestContext(ctx)
+
+	res, err := os.client.GetDividendsForeignIssuer(ctx, &pb.GetDividendsForeignIssuerRequest{
+		Payload: &pb.GetDividendsForeignIssuerRequest_GenerateDivForeignIssuerReport{
+			GenerateDivForeignIssuerReport: &pb.GenerateDividendsForeignIssuerReportRequest{
+				AccountId: accountID,
+				From:      from,
+				To:        to,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return res.GetGenerateDivForeignIssuerReportResponse().GetTaskId(), nil
+}
+
+func (os OperationsService) GetDividendsForeignIssuerReport(ctx context.Context, taskID string, page int32) (*pb.GetDividendsForeignIssuerReportResponse, error) {
+	ctx = createRequestContext(ctx)
+
+	res, err := os.client.GetDividendsForeignIssuer(ctx, &pb.GetDividendsForeignIssuerRequest{
+		Payload: &pb.GetDividendsForeignIssuerRequest_GetDivForeignIssuerReport{
+			GetDivForeignIssuerReport: &pb.GetDividendsForeignIssuerReportRequest{
+				TaskId: taskID,
+				Page:   page,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.GetDivForeignIssuerReport(), nil
 }
@@ -2,37 +2,66 @@
 package sdk
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
+	"context"
 	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
 )
 
 type OrdersStreamInterface interface {
 	// Recv listens for incoming messages and block until first one is received.
 	Recv() (*pb.TradesStreamResponse, error)
+	// Events returns connection state changes.
+	Events() <-chan StreamEvent
+	// Close stops the stream, Recv returns ErrStreamClosed after that.
+	Close()
 }
 
+// OrdersStream reopens trades stream with the same request whenever it breaks.
 type OrdersStream struct {
-	client pb.OrdersStreamServiceClient
-	stream pb.OrdersStreamService_TradesStreamClient
+	client  pb.OrdersStreamServiceClient
+	request *pb.TradesStreamRequest
+	keeper  *streamKeeper
 }
 
-func NewOrdersStream(request *pb.TradesStreamRequest) *OrdersStream{
-	conn, err := createClientConn()
+// NewOrdersStream opens a stream which lives until Close is called or ctx is cancelled.
+func NewOrdersStream(ctx context.Context, conn grpc.ClientConnInterface, request *pb.TradesStreamRequest, cfg StreamConfig) (*OrdersStream, error) {
+	os := &OrdersStream{
+		client:  pb.NewOrdersStreamServiceClient(conn),
+		request: request,
+	}
+
+	os.keeper = newStreamKeeper(ctx, "OrdersStream", cfg, os.open)
+	if err := os.keeper.start(); err != nil {
+		return nil, err
+	}
+
+	return os, nil
+}
+
+func (os *OrdersStream) open(ctx context.Context) (func() (interface{}, error), error) {
+	stream, err := os.client.TradesStream(ctx, os.request)
 	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
+		return nil, err
 	}
 
-	client := pb.NewOrdersStreamServiceClient(conn)
-	ctx := createStreamContext()
+	return func() (interface{}, error) {
+		return stream.Recv()
+	}, nil
+}
 
-	stream, err := client.TradesStream(ctx, request)
+func (os *OrdersStream) Recv() (*pb.TradesStreamResponse, error) {
+	msg, err := os.keeper.recv()
 	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
+		return nil, err
 	}
 
-	return &OrdersStream{client: client, stream: stream}
+	return msg.(*pb.TradesStreamResponse), nil
+}
+
+func (os *OrdersStream) Events() <-chan StreamEvent {
+	return os.keeper.events
 }
 
-func (os OrdersStream) Recv() (*pb.TradesStreamResponse, error) {
-	return os.stream.Recv()
+func (os *OrdersStream) Close() {
+	os.keeper.close()
 }
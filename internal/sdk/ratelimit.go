@@ -0,0 +1,213 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits maps "Service" or "Service/Method" to allowed requests per minute.
+// Method limits take precedence, methods without any limit are not throttled.
+type RateLimits map[string]int
+
+// rateLimiter keeps a token bucket for every configured service or method.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  RateLimits
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// bucket must be called with rl.mu held; returns nil for unlimited methods.
+func (rl *rateLimiter) bucket(service, method string) (string, *tokenBucket) {
+	key := service + "/" + method
+	limit, ok := rl.limits[key]
+	if !ok {
+		key = service
+		limit, ok = rl.limits[key]
+	}
+	if !ok || limit <= 0 {
+		return "", nil
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{capacity: float64(limit), tokens: float64(limit), rate: float64(limit) / 60, last: time.Now()}
+		rl.buckets[key] = b
+	}
+
+	return key, b
+}
+
+// reserve takes a token and returns how long the caller must wait for it.
+// If the wait does not fit into deadline, nothing is taken and ok is false.
+func (rl *rateLimiter) reserve(service, method string, deadline time.Time, hasDeadline bool) (wait time.Duration, ok bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	_, b := rl.bucket(service, method)
+	if b == nil {
+		return 0, true
+	}
+
+	now := time.Now()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	// tokens come back only after b.last, which is in the future until the server window resets
+	wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if b.last.After(now) {
+		wait += b.last.Sub(now)
+	}
+	if hasDeadline && now.Add(wait).After(deadline) {
+		return wait, false
+	}
+
+	b.tokens--
+	return wait, true
+}
+
+// update adjusts bucket from x-ratelimit-* headers returned by API.
+func (rl *rateLimiter) update(service, method string, header metadata.MD) {
+	limit, hasLimit := headerInt(header, "x-ratelimit-limit")
+	remaining, hasRemaining := headerInt(header, "x-ratelimit-remaining")
+	reset, hasReset := headerInt(header, "x-ratelimit-reset")
+	if !hasLimit && !hasRemaining {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	_, b := rl.bucket(service, method)
+	if b == nil {
+		return
+	}
+
+	now := time.Now()
+	b.refill(now)
+	if hasLimit && limit > 0 && float64(limit) != b.capacity {
+		b.capacity = float64(limit)
+		b.rate = float64(limit) / 60
+	}
+	if hasRemaining && float64(remaining) < b.tokens {
+		b.tokens = float64(remaining)
+	}
+	if hasRemaining && remaining == 0 && hasReset && reset > 0 {
+		// nothing left until the window resets: push refill into the future
+		b.tokens = math.Min(b.tokens, 0)
+		b.last = now.Add(time.Duration(reset) * time.Second)
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.Before(b.last) {
+		return
+	}
+
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// rateLimitUnaryInterceptor waits for a token before every attempt, or fails
+// with ResourceExhausted if the wait does not fit into the context deadline.
+func rateLimitUnaryInterceptor(rl *rateLimiter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, method := splitMethodName(fullMethod)
+
+		deadline, hasDeadline := ctx.Deadline()
+		wait, ok := rl.reserve(service, method, deadline, hasDeadline)
+		if !ok {
+			metrics.ApiThrottledCalls.WithLabelValues(loggy.GetBotID(), service, method, "rejected").Inc()
+			return &throttledError{status.Newf(codes.ResourceExhausted, "client rate limit for %s/%s exceeded, next request in %s", service, method, wait)}
+		}
+		if wait > 0 {
+			metrics.ApiThrottledCalls.WithLabelValues(loggy.GetBotID(), service, method, "delayed").Inc()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return status.FromContextError(ctx.Err()).Err()
+			case <-timer.C:
+			}
+		}
+
+		var header metadata.MD
+		err := invoker(ctx, fullMethod, req, reply, cc, append(opts, grpc.Header(&header))...)
+		rl.update(service, method, header)
+
+		return err
+	}
+}
+
+// throttledError is returned when request was not sent because of client rate limit;
+// retrying it within the same context is pointless.
+type throttledError struct {
+	s *status.Status
+}
+
+func (e *throttledError) Error() string {
+	return e.s.Err().Error()
+}
+
+func (e *throttledError) GRPCStatus() *status.Status {
+	return e.s
+}
+
+// splitMethodName turns "/package.Service/Method" into "Service" and "Method".
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	service, method := fullMethod, ""
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		service, method = fullMethod[:i], fullMethod[i+1:]
+	}
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+
+	return service, method
+}
+
+// headerInt parses leading integer of a header like "100" or "100, 100;w=60".
+func headerInt(header metadata.MD, key string) (int, bool) {
+	values := header.Get(key)
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	fields := strings.FieldsFunc(values[0], func(r rune) bool { return r == ',' || r == ';' })
+	if len(fields) == 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
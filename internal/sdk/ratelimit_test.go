@@ -0,0 +1,125 @@
+package sdk
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestSplitMethodName(t *testing.T) {
+	service, method := splitMethodName("/tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder")
+	if service != "OrdersService" || method != "PostOrder" {
+		t.Errorf("splitMethodName() = %s, %s, want OrdersService, PostOrder", service, method)
+	}
+}
+
+func TestHeaderInt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"100", 100, true},
+		{"100, 100;w=60", 100, true},
+		{"", 0, false},
+		{"many", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := headerInt(metadata.Pairs("x-ratelimit-limit", tt.value), "x-ratelimit-limit")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("headerInt(%q) = %d, %t, want %d, %t", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := headerInt(metadata.MD{}, "x-ratelimit-limit"); ok {
+		t.Error("headerInt() of a missing header must not be ok")
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	rl := newRateLimiter(RateLimits{"OrdersService": 60, "OrdersService/PostOrder": 2})
+	var noDeadline time.Time
+
+	for i := 0; i < 2; i++ {
+		if wait, ok := rl.reserve("OrdersService", "PostOrder", noDeadline, false); wait != 0 || !ok {
+			t.Fatalf("request %d within the method limit must not wait, got %s, %t", i, wait, ok)
+		}
+	}
+	wait, ok := rl.reserve("OrdersService", "PostOrder", time.Now().Add(time.Second), true)
+	if ok || wait < 29*time.Second {
+		t.Errorf("request over 2 per minute must not fit into a second, got %s, %t", wait, ok)
+	}
+	if wait, ok := rl.reserve("OrdersService", "PostOrder", noDeadline, false); !ok || wait < 29*time.Second {
+		t.Errorf("request over the limit without deadline must wait, got %s, %t", wait, ok)
+	}
+
+	if wait, ok := rl.reserve("OrdersService", "GetOrders", noDeadline, false); wait != 0 || !ok {
+		t.Errorf("service limit must not be taken by a method with its own limit, got %s, %t", wait, ok)
+	}
+	for i := 0; i < 100; i++ {
+		if wait, ok := rl.reserve("UsersService", "GetInfo", noDeadline, false); wait != 0 || !ok {
+			t.Fatalf("service without limit must not be throttled, got %s, %t", wait, ok)
+		}
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	rl := newRateLimiter(RateLimits{"OrdersService": 600})
+	var noDeadline time.Time
+
+	rl.update("OrdersService", "GetOrders", metadata.Pairs("x-ratelimit-limit", "60", "x-ratelimit-remaining", "1"))
+	if wait, ok := rl.reserve("OrdersService", "GetOrders", noDeadline, false); wait != 0 || !ok {
+		t.Fatalf("the remaining request must not wait, got %s, %t", wait, ok)
+	}
+	wait, _ := rl.reserve("OrdersService", "GetOrders", noDeadline, false)
+	if wait < 900*time.Millisecond || wait > time.Second {
+		t.Errorf("limit of 60 per minute from headers must give a token per second, got %s", wait)
+	}
+}
+
+func TestRateLimiterWaitsForReset(t *testing.T) {
+	rl := newRateLimiter(RateLimits{"OrdersService": 600})
+	rl.update("OrdersService", "GetOrders",
+		metadata.Pairs("x-ratelimit-remaining", "0", "x-ratelimit-reset", "30"))
+	wait, ok := rl.reserve("OrdersService", "GetOrders", time.Now().Add(time.Second), true)
+	if ok || wait < 30*time.Second {
+		t.Errorf("request before the server window reset must wait for it, got %s, %t", wait, ok)
+	}
+}
+
+func TestRateLimitUnaryInterceptor(t *testing.T) {
+	rl := newRateLimiter(RateLimits{"OrdersService/PostOrder": 1})
+	interceptor := rateLimitUnaryInterceptor(rl)
+
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return nil
+	}
+
+	if err := interceptor(context.Background(), postOrderMethod, nil, nil, nil, invoker); err != nil {
+		t.Fatalf("the first request must pass: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := interceptor(ctx, postOrderMethod, nil, nil, nil, invoker)
+	if status.Code(err) != codes.ResourceExhausted || calls != 1 {
+		t.Errorf("request over the limit must be rejected without a call, got %v after %d calls", err, calls)
+	}
+
+	// the rejected request is not retried within the same context
+	retried := 0
+	err = retryUnaryInterceptor(testRetryPolicy)(ctx, getOrdersMethod, nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			retried++
+			return interceptor(ctx, postOrderMethod, req, reply, cc, invoker, opts...)
+		})
+	if status.Code(err) != codes.ResourceExhausted || retried != 1 {
+		t.Errorf("throttled request must not be retried, got %v after %d attempts", err, retried)
+	}
+}
@@ -0,0 +1,245 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordKind tells what a recordedCall stands for.
+type recordKind int
+
+const (
+	recordUnary      recordKind = iota // unary request with its response or error
+	recordStreamOpen                   // stream was opened (or failed to open)
+	recordStreamSend                   // request sent to stream
+	recordStreamRecv                   // message received from stream or the error stream was broken with
+)
+
+// recordedCall is a single entry of a record file; files are gzip-compressed gob streams of entries.
+type recordedCall struct {
+	Kind     recordKind
+	Method   string // full gRPC method name
+	StreamID int64  // non-zero for stream entries
+	Time     time.Time
+
+	Request      []byte // marshalled request, if any
+	LooseRequest []byte // marshalled request without volatile fields, see looseRequestKey
+	Response     []byte // marshalled response or stream message, if any
+	SendsBefore  int    // for stream messages: requests sent to the stream before the message
+
+	// error details, Code is codes.OK on success
+	Code        uint32
+	Message     string
+	Description string // ApiError.Message
+	TrackingID  string
+	Attempts    int
+}
+
+// Recorder writes all API traffic of a Client to a file, so it can be replayed later.
+type Recorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	zw       *gzip.Writer
+	enc      *gob.Encoder
+	streamID int64
+	err      error // the first write error, recording stops after it
+}
+
+// NewRecorder creates (or truncates) the record file.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not create record file: %v", err)
+	}
+
+	zw := gzip.NewWriter(file)
+	return &Recorder{file: file, zw: zw, enc: gob.NewEncoder(zw)}, nil
+}
+
+// write appends an entry and flushes it, so the file survives a crash of the bot.
+func (r *Recorder) write(call *recordedCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil || r.enc == nil {
+		return
+	}
+
+	call.Time = time.Now()
+	if err := r.enc.Encode(call); err != nil {
+		r.err = err
+		return
+	}
+	r.err = r.zw.Flush()
+}
+
+func (r *Recorder) nextStreamID() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.streamID++
+	return r.streamID
+}
+
+// Close flushes and closes the record file; it returns the first write error, if any.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enc == nil {
+		return r.err
+	}
+	r.enc = nil
+
+	if err := r.zw.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	return r.err
+}
+
+// recordUnaryInterceptor records calls as service wrappers see them, i.e. after retries.
+func recordUnaryInterceptor(r *Recorder) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		call := &recordedCall{
+			Kind:         recordUnary,
+			Method:       method,
+			Request:      marshalRecorded(req),
+			LooseRequest: []byte(looseRequestKey(req)),
+		}
+		if err == nil {
+			call.Response = marshalRecorded(reply)
+		}
+		setRecordedError(call, err)
+		r.write(call)
+
+		return err
+	}
+}
+
+// recordStreamInterceptor records stream openings, sent requests and received messages.
+func recordStreamInterceptor(r *Recorder) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		id := r.nextStreamID()
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+
+		call := &recordedCall{Kind: recordStreamOpen, Method: method, StreamID: id}
+		setRecordedError(call, err)
+		r.write(call)
+		if err != nil {
+			return nil, err
+		}
+
+		return &recordedStream{ClientStream: stream, recorder: r, method: method, id: id}, nil
+	}
+}
+
+type recordedStream struct {
+	grpc.ClientStream
+	recorder *Recorder
+	method   string
+	id       int64
+
+	mu    sync.Mutex
+	sends int
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		s.sends++
+		s.mu.Unlock()
+
+		s.recorder.write(&recordedCall{Kind: recordStreamSend, Method: s.method, StreamID: s.id, Request: marshalRecorded(m)})
+	}
+
+	return err
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	s.mu.Lock()
+	call := &recordedCall{Kind: recordStreamRecv, Method: s.method, StreamID: s.id, SendsBefore: s.sends}
+	s.mu.Unlock()
+
+	switch {
+	case err == nil:
+		call.Response = marshalRecorded(m)
+	case err == io.EOF || status.Code(err) == codes.Canceled:
+		return err // stream was closed by us, replay will wait for cancellation instead
+	default:
+		setRecordedError(call, err)
+	}
+	s.recorder.write(call)
+
+	return err
+}
+
+func marshalRecorded(m interface{}) []byte {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+
+	data, _ := proto.Marshal(msg)
+	return data
+}
+
+func setRecordedError(call *recordedCall, err error) {
+	if err == nil {
+		return
+	}
+
+	s := status.Convert(err)
+	call.Code = uint32(s.Code())
+	call.Message = s.Message()
+
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		call.Description = apiErr.Message
+		call.TrackingID = apiErr.TrackingID
+		call.Attempts = apiErr.Attempts
+	}
+}
+
+// recordedError restores error of a recorded call, ApiError is restored as well.
+func recordedError(call *recordedCall) error {
+	if codes.Code(call.Code) == codes.OK {
+		return nil
+	}
+
+	err := status.Error(codes.Code(call.Code), call.Message)
+	if call.Attempts == 0 {
+		return err
+	}
+
+	return &ApiError{
+		Method:     call.Method,
+		Code:       codes.Code(call.Code),
+		ApiCode:    parseApiCode(err),
+		Message:    call.Description,
+		TrackingID: call.TrackingID,
+		Attempts:   call.Attempts,
+		err:        err,
+	}
+}
@@ -0,0 +1,430 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInstrumentsTTL = 24 * time.Hour
+	// instrumentsRetryInterval postpones the next refresh after a failed one, stale data is served meanwhile.
+	instrumentsRetryInterval = time.Minute
+)
+
+var (
+	ErrInstrumentNotFound  = errors.New("instrument not found")
+	ErrAmbiguousInstrument = errors.New("instrument is ambiguous")
+)
+
+// InstrumentRegistry keeps shares, ETFs, bonds, futures and currencies in memory and answers
+// lookups by FIGI, ticker or ISIN. Lists are loaded on the first lookup and reloaded after TTL;
+// if cache file is set, they are persisted there and read on start, so restarts cost no requests.
+// Returned instruments are shared, callers must not modify them.
+type InstrumentRegistry struct {
+	service InstrumentsInterface
+	ttl     time.Duration
+	path    string
+
+	refreshMu sync.Mutex // only one refresh at a time
+
+	mu          sync.RWMutex
+	byFigi      map[string]*pb.Instrument
+	byTicker    map[string][]*pb.Instrument
+	byIsin      map[string][]*pb.Instrument
+	updatedAt   time.Time
+	nextRefresh time.Time
+}
+
+// instrumentsCache is the content of a cache file.
+type instrumentsCache struct {
+	UpdatedAt   time.Time
+	Instruments [][]byte // marshalled pb.Instrument
+}
+
+// NewInstrumentRegistry creates InstrumentRegistry; empty path disables the cache file.
+func NewInstrumentRegistry(service InstrumentsInterface, ttl time.Duration, path string) *InstrumentRegistry {
+	if ttl <= 0 {
+		ttl = defaultInstrumentsTTL
+	}
+
+	return &InstrumentRegistry{service: service, ttl: ttl, path: path}
+}
+
+// ByFigi returns instrument by FIGI; instruments missing in lists (e.g. delisted ones)
+// are requested by GetInstrumentBy and remembered.
+func (r *InstrumentRegistry) ByFigi(ctx context.Context, figi string) (*pb.Instrument, error) {
+	if err := r.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	instrument, ok := r.byFigi[figi]
+	r.mu.RUnlock()
+	if ok {
+		return instrument, nil
+	}
+
+	instrument, err := r.service.GetInstrumentBy(ctx, pb.InstrumentRequest{
+		IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     figi,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrInstrumentNotFound, figi)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not get instrument %s: %v", figi, err)
+	}
+
+	r.mu.Lock()
+	r.add(instrument)
+	r.mu.Unlock()
+
+	return instrument, nil
+}
+
+// ByTicker returns instruments with the ticker on all trading modes (class codes), the case is ignored.
+func (r *InstrumentRegistry) ByTicker(ctx context.Context, ticker string) ([]*pb.Instrument, error) {
+	return r.lookup(ctx, func() []*pb.Instrument { return r.byTicker[strings.ToUpper(ticker)] }, ticker)
+}
+
+// ByISIN returns instruments with the ISIN on all trading modes (class codes).
+func (r *InstrumentRegistry) ByISIN(ctx context.Context, isin string) ([]*pb.Instrument, error) {
+	return r.lookup(ctx, func() []*pb.Instrument { return r.byIsin[strings.ToUpper(isin)] }, isin)
+}
+
+// Resolve finds instrument by FIGI, "CLASS_CODE:TICKER" (e.g. "TQBR:SBER"), ISIN or ticker.
+// If ISIN or ticker is traded in several modes, the only one available for trading via API
+// is chosen; otherwise ErrAmbiguousInstrument lists all candidates.
+func (r *InstrumentRegistry) Resolve(ctx context.Context, id string) (*pb.Instrument, error) {
+	id = strings.TrimSpace(id)
+	if err := r.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	if parts := strings.SplitN(id, ":", 2); len(parts) == 2 {
+		candidates, err := r.ByTicker(ctx, parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInstrumentNotFound, id)
+		}
+		for _, c := range candidates {
+			if strings.EqualFold(c.ClassCode, parts[0]) {
+				return c, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInstrumentNotFound, id)
+	}
+
+	r.mu.RLock()
+	instrument, ok := r.byFigi[id]
+	r.mu.RUnlock()
+	if ok {
+		return instrument, nil
+	}
+
+	candidates, err := r.ByISIN(ctx, id)
+	if err != nil {
+		candidates, err = r.ByTicker(ctx, id)
+	}
+	if err != nil {
+		return r.ByFigi(ctx, id) // FIGI of an instrument missing in lists
+	}
+
+	return chooseInstrument(id, candidates)
+}
+
+// chooseInstrument picks the only candidate or the only one available for trading via API.
+func chooseInstrument(id string, candidates []*pb.Instrument) (*pb.Instrument, error) {
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	var tradable []*pb.Instrument
+	var names []string
+	for _, c := range candidates {
+		if c.ApiTradeAvailableFlag {
+			tradable = append(tradable, c)
+		}
+		names = append(names, fmt.Sprintf("%s:%s (%s)", c.ClassCode, c.Ticker, c.Figi))
+	}
+	if len(tradable) == 1 {
+		return tradable[0], nil
+	}
+
+	return nil, fmt.Errorf("%w: %s matches %s, please use CLASS_CODE:TICKER or FIGI",
+		ErrAmbiguousInstrument, id, strings.Join(names, ", "))
+}
+
+func (r *InstrumentRegistry) lookup(ctx context.Context, find func() []*pb.Instrument, id string) ([]*pb.Instrument, error) {
+	if err := r.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	instruments := append([]*pb.Instrument(nil), find()...)
+	r.mu.RUnlock()
+
+	if len(instruments) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInstrumentNotFound, id)
+	}
+
+	return instruments, nil
+}
+
+// Refresh reloads all instrument lists from API and rewrites the cache file.
+func (r *InstrumentRegistry) Refresh(ctx context.Context) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	return r.refresh(ctx)
+}
+
+// ensureLoaded makes sure lists are loaded and not older than TTL; if they can not be
+// reloaded, stale lists are used and the refresh is retried later.
+func (r *InstrumentRegistry) ensureLoaded(ctx context.Context) error {
+	if r.isFresh() {
+		return nil
+	}
+
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	if r.isFresh() {
+		return nil // refreshed while we were waiting
+	}
+
+	r.mu.RLock()
+	empty := r.updatedAt.IsZero()
+	r.mu.RUnlock()
+
+	if empty && r.path != "" {
+		if err := r.readCache(); err != nil && !os.IsNotExist(err) {
+			loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+				Warnf("can not read instruments cache: %v", err)
+		}
+		if r.isFresh() {
+			return nil
+		}
+	}
+
+	err := r.refresh(ctx)
+	if err == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.updatedAt.IsZero() {
+		return err
+	}
+
+	loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+		Warnf("using instruments loaded at %s: %v", r.updatedAt.Format(time.RFC3339), err)
+	r.nextRefresh = time.Now().Add(instrumentsRetryInterval)
+
+	return nil
+}
+
+func (r *InstrumentRegistry) isFresh() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !r.updatedAt.IsZero() && time.Now().Before(r.nextRefresh)
+}
+
+func (r *InstrumentRegistry) refresh(ctx context.Context) error {
+	var instruments []*pb.Instrument
+
+	shares, err := r.service.Shares(ctx, pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		return fmt.Errorf("can not load shares: %v", err)
+	}
+	for _, s := range shares {
+		instruments = append(instruments, toInstrument(s, "share"))
+	}
+
+	etfs, err := r.service.Etfs(ctx, pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		return fmt.Errorf("can not load etfs: %v", err)
+	}
+	for _, e := range etfs {
+		instruments = append(instruments, toInstrument(e, "etf"))
+	}
+
+	bonds, err := r.service.Bonds(ctx, pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		return fmt.Errorf("can not load bonds: %v", err)
+	}
+	for _, b := range bonds {
+		instruments = append(instruments, toInstrument(b, "bond"))
+	}
+
+	futures, err := r.service.Futures(ctx, pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		return fmt.Errorf("can not load futures: %v", err)
+	}
+	for _, f := range futures {
+		instruments = append(instruments, toInstrument(f, "futures"))
+	}
+
+	currencies, err := r.service.Currencies(ctx, pb.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		return fmt.Errorf("can not load currencies: %v", err)
+	}
+	for _, c := range currencies {
+		instruments = append(instruments, toInstrument(c, "currency"))
+	}
+
+	updatedAt := time.Now()
+	r.replace(instruments, updatedAt)
+
+	if r.path != "" {
+		if err := r.writeCache(instruments, updatedAt); err != nil {
+			loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+				Warnf("can not write instruments cache: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *InstrumentRegistry) replace(instruments []*pb.Instrument, updatedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.byFigi = make(map[string]*pb.Instrument, len(instruments))
+	r.byTicker = make(map[string][]*pb.Instrument, len(instruments))
+	r.byIsin = make(map[string][]*pb.Instrument, len(instruments))
+	for _, i := range instruments {
+		r.add(i)
+	}
+
+	r.updatedAt = updatedAt
+	r.nextRefresh = updatedAt.Add(r.ttl)
+}
+
+// add indexes instrument, r.mu must be locked.
+func (r *InstrumentRegistry) add(instrument *pb.Instrument) {
+	if r.byFigi == nil {
+		r.byFigi = make(map[string]*pb.Instrument)
+		r.byTicker = make(map[string][]*pb.Instrument)
+		r.byIsin = make(map[string][]*pb.Instrument)
+	}
+	if _, ok := r.byFigi[instrument.Figi]; ok {
+		return
+	}
+
+	r.byFigi[instrument.Figi] = instrument
+	if instrument.Ticker != "" {
+		ticker := strings.ToUpper(instrument.Ticker)
+		r.byTicker[ticker] = append(r.byTicker[ticker], instrument)
+	}
+	if instrument.Isin != "" {
+		isin := strings.ToUpper(instrument.Isin)
+		r.byIsin[isin] = append(r.byIsin[isin], instrument)
+	}
+}
+
+func (r *InstrumentRegistry) readCache() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var cache instrumentsCache
+	if err := gob.NewDecoder(file).Decode(&cache); err != nil {
+		return err
+	}
+
+	instruments := make([]*pb.Instrument, 0, len(cache.Instruments))
+	for _, data := range cache.Instruments {
+		instrument := &pb.Instrument{}
+		if err := proto.Unmarshal(data, instrument); err != nil {
+			return err
+		}
+		instruments = append(instruments, instrument)
+	}
+
+	r.replace(instruments, cache.UpdatedAt)
+	return nil
+}
+
+// writeCache replaces the cache file atomically, so a crash never leaves a half-written one.
+func (r *InstrumentRegistry) writeCache(instruments []*pb.Instrument, updatedAt time.Time) error {
+	cache := instrumentsCache{UpdatedAt: updatedAt}
+	for _, i := range instruments {
+		data, err := proto.Marshal(i)
+		if err != nil {
+			return err
+		}
+		cache.Instruments = append(cache.Instruments, data)
+	}
+
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmp := r.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(cache); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
+
+// toInstrument copies fields shared with pb.Instrument from pb.Share, pb.Etf, pb.Bond, pb.Future or pb.Currency.
+func toInstrument(m proto.Message, instrumentType string) *pb.Instrument {
+	instrument := &pb.Instrument{}
+	dst := proto.MessageV2(instrument).ProtoReflect()
+	fields := dst.Descriptor().Fields()
+
+	proto.MessageV2(m).ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		target := fields.ByName(fd.Name())
+		if target != nil && sameFieldType(fd, target) {
+			dst.Set(target, v)
+		}
+		return true
+	})
+	instrument.InstrumentType = instrumentType
+
+	return instrument
+}
+
+func sameFieldType(a, b protoreflect.FieldDescriptor) bool {
+	if a.Kind() != b.Kind() || a.Cardinality() != b.Cardinality() {
+		return false
+	}
+	if a.Message() != nil {
+		return a.Message().FullName() == b.Message().FullName()
+	}
+	if a.Enum() != nil {
+		return a.Enum().FullName() == b.Enum().FullName()
+	}
+
+	return true
+}
@@ -0,0 +1,202 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testInstrumentsService lists shares and knows one more delisted instrument by FIGI.
+type testInstrumentsService struct {
+	InstrumentsInterface
+
+	mu     sync.Mutex
+	shares []*pb.Share
+	loads  int
+	fail   error
+}
+
+func newTestInstrumentsService() *testInstrumentsService {
+	return &testInstrumentsService{shares: []*pb.Share{
+		{Figi: "BBG004730N88", Ticker: "SBER", ClassCode: "TQBR", Isin: "RU0009029540", Lot: 10, Currency: "rub",
+			MinPriceIncrement: &pb.Quotation{Nano: 10000000}, ApiTradeAvailableFlag: true},
+		{Figi: "BBG004730N89", Ticker: "SBER", ClassCode: "SPBXM", Isin: "RU0009029540", Lot: 1, Currency: "usd"},
+		{Figi: "BBG004731032", Ticker: "LKOH", ClassCode: "TQBR", Isin: "RU0009024277", Lot: 1, Currency: "rub",
+			ApiTradeAvailableFlag: true},
+	}}
+}
+
+func (s *testInstrumentsService) Shares(context.Context, pb.InstrumentStatus) ([]*pb.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loads++
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	return s.shares, nil
+}
+
+func (s *testInstrumentsService) Etfs(context.Context, pb.InstrumentStatus) ([]*pb.Etf, error) {
+	return nil, nil
+}
+
+func (s *testInstrumentsService) Bonds(context.Context, pb.InstrumentStatus) ([]*pb.Bond, error) {
+	return nil, nil
+}
+
+func (s *testInstrumentsService) Futures(context.Context, pb.InstrumentStatus) ([]*pb.Future, error) {
+	return nil, nil
+}
+
+func (s *testInstrumentsService) Currencies(context.Context, pb.InstrumentStatus) ([]*pb.Currency, error) {
+	return nil, nil
+}
+
+func (s *testInstrumentsService) GetInstrumentBy(_ context.Context, filters pb.InstrumentRequest) (*pb.Instrument, error) {
+	if filters.Id == "BBG000DELIST" {
+		return &pb.Instrument{Figi: filters.Id, Ticker: "GONE", InstrumentType: "share"}, nil
+	}
+	return nil, status.Error(codes.NotFound, "50002")
+}
+
+func (s *testInstrumentsService) loadCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.loads
+}
+
+func TestInstrumentRegistryLookups(t *testing.T) {
+	service := newTestInstrumentsService()
+	r := NewInstrumentRegistry(service, time.Hour, "")
+	ctx := context.Background()
+
+	instrument, err := r.ByFigi(ctx, "BBG004730N88")
+	if err != nil {
+		t.Fatalf("can not find instrument by FIGI: %v", err)
+	}
+	if instrument.Lot != 10 || instrument.InstrumentType != "share" || instrument.MinPriceIncrement.Nano != 10000000 {
+		t.Errorf("share fields are not copied to instrument: %v", instrument)
+	}
+
+	if instruments, err := r.ByTicker(ctx, "sber"); err != nil || len(instruments) != 2 {
+		t.Errorf("ByTicker(sber) = %v, %v, want both class codes", instruments, err)
+	}
+	if instruments, err := r.ByISIN(ctx, "RU0009024277"); err != nil || len(instruments) != 1 || instruments[0].Ticker != "LKOH" {
+		t.Errorf("ByISIN(RU0009024277) = %v, %v, want LKOH", instruments, err)
+	}
+
+	if instrument, err := r.ByFigi(ctx, "BBG000DELIST"); err != nil || instrument.Ticker != "GONE" {
+		t.Errorf("instrument missing in lists must be requested, got %v, %v", instrument, err)
+	}
+	if _, err := r.ByFigi(ctx, "BBG000000000"); !errors.Is(err, ErrInstrumentNotFound) {
+		t.Errorf("ByFigi() of unknown instrument = %v, want %v", err, ErrInstrumentNotFound)
+	}
+	if _, err := r.ByTicker(ctx, "NONE"); !errors.Is(err, ErrInstrumentNotFound) {
+		t.Errorf("ByTicker() of unknown instrument = %v, want %v", err, ErrInstrumentNotFound)
+	}
+
+	if loads := service.loadCount(); loads != 1 {
+		t.Errorf("lists must be loaded once within TTL, loaded %d times", loads)
+	}
+}
+
+func TestInstrumentRegistryRefreshesAfterTTL(t *testing.T) {
+	service := newTestInstrumentsService()
+	r := NewInstrumentRegistry(service, time.Millisecond, "")
+	ctx := context.Background()
+
+	if _, err := r.ByFigi(ctx, "BBG004730N88"); err != nil {
+		t.Fatalf("can not find instrument: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	service.mu.Lock()
+	service.fail = status.Error(codes.Unavailable, "unavailable")
+	service.mu.Unlock()
+
+	// stale lists are used while API is unavailable, the next refresh is postponed
+	for i := 0; i < 3; i++ {
+		if _, err := r.ByFigi(ctx, "BBG004730N88"); err != nil {
+			t.Fatalf("stale instrument must be served: %v", err)
+		}
+	}
+	if loads := service.loadCount(); loads != 2 {
+		t.Errorf("expected one failed refresh after TTL, got %d loads", loads)
+	}
+}
+
+func TestInstrumentRegistryFailsWithoutLists(t *testing.T) {
+	service := newTestInstrumentsService()
+	service.fail = status.Error(codes.Unavailable, "unavailable")
+
+	if _, err := NewInstrumentRegistry(service, time.Hour, "").ByTicker(context.Background(), "SBER"); err == nil {
+		t.Error("lookup must fail if lists were never loaded")
+	}
+}
+
+func TestInstrumentRegistryCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instruments", "cache")
+	if err := NewInstrumentRegistry(newTestInstrumentsService(), time.Hour, path).Refresh(context.Background()); err != nil {
+		t.Fatalf("can not load instruments: %v", err)
+	}
+
+	service := newTestInstrumentsService()
+	service.fail = status.Error(codes.Unavailable, "unavailable")
+	instruments, err := NewInstrumentRegistry(service, time.Hour, path).ByTicker(context.Background(), "LKOH")
+	if err != nil || len(instruments) != 1 {
+		t.Fatalf("instruments must be read from cache, got %v, %v", instruments, err)
+	}
+	if loads := service.loadCount(); loads != 0 {
+		t.Errorf("fresh cache must not be reloaded, got %d loads", loads)
+	}
+}
+
+func TestInstrumentRegistryResolve(t *testing.T) {
+	r := NewInstrumentRegistry(newTestInstrumentsService(), time.Hour, "")
+
+	tests := []struct {
+		id   string
+		figi string
+	}{
+		{"BBG004731032", "BBG004731032"},
+		{" LKOH ", "BBG004731032"},
+		{"SPBXM:sber", "BBG004730N89"},
+		{"RU0009029540", "BBG004730N88"}, // the only class code available via API
+		{"sber", "BBG004730N88"},
+		{"BBG000DELIST", "BBG000DELIST"},
+	}
+	for _, tt := range tests {
+		instrument, err := r.Resolve(context.Background(), tt.id)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %v", tt.id, err)
+			continue
+		}
+		if instrument.Figi != tt.figi {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.id, instrument.Figi, tt.figi)
+		}
+	}
+
+	for _, id := range []string{"TQBR:NONE", "SPBXM:LKOH", "NONE"} {
+		if _, err := r.Resolve(context.Background(), id); !errors.Is(err, ErrInstrumentNotFound) {
+			t.Errorf("Resolve(%q) = %v, want %v", id, err, ErrInstrumentNotFound)
+		}
+	}
+}
+
+func TestChooseInstrument(t *testing.T) {
+	candidates := []*pb.Instrument{
+		{Figi: "A", Ticker: "T", ClassCode: "X", ApiTradeAvailableFlag: true},
+		{Figi: "B", Ticker: "T", ClassCode: "Y", ApiTradeAvailableFlag: true},
+	}
+	if _, err := chooseInstrument("T", candidates); !errors.Is(err, ErrAmbiguousInstrument) {
+		t.Errorf("chooseInstrument() of two tradable instruments = %v, want %v", err, ErrAmbiguousInstrument)
+	}
+}
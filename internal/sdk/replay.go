@@ -0,0 +1,300 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// replaySendGracePeriod limits how long a stream message waits for requests
+// (e.g. subscriptions) that were sent before it during recording.
+const replaySendGracePeriod = time.Second
+
+// replayConn serves responses from a record file instead of dialling the API.
+// Unary calls are matched by method and request; requests differing only in
+// timestamps and order IDs (e.g. candles for "last hour") match as well; any
+// other request fails with codes.NotFound instead of getting a response recorded
+// for another instrument or account. Streams of a method are served in recorded
+// order, so reconnects happen at the same points as they did.
+type replayConn struct {
+	mu      sync.Mutex
+	unary   map[string][]*replayedCall      // method -> calls
+	streams map[string][]*replayedStreamLog // method -> streams
+}
+
+type replayedCall struct {
+	call     *recordedCall
+	exact    string // request as recorded
+	loose    string // request without volatile fields
+	consumed bool
+}
+
+type replayedStreamLog struct {
+	open     *recordedCall
+	messages []*recordedCall
+}
+
+// newReplayConn reads the whole record file written by Recorder.
+func newReplayConn(path string) (*replayConn, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not open replay file: %v", err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("can not read replay file: %v", err)
+	}
+
+	rc := &replayConn{
+		unary:   make(map[string][]*replayedCall),
+		streams: make(map[string][]*replayedStreamLog),
+	}
+	streams := make(map[int64]*replayedStreamLog)
+
+	dec := gob.NewDecoder(zr)
+	for {
+		call := &recordedCall{}
+		err := dec.Decode(call)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break // the file may be cut if recording bot was killed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can not read replay file: %v", err)
+		}
+
+		switch call.Kind {
+		case recordUnary:
+			rc.unary[call.Method] = append(rc.unary[call.Method], &replayedCall{
+				call:  call,
+				exact: string(call.Request),
+				loose: string(call.LooseRequest),
+			})
+		case recordStreamOpen:
+			log := &replayedStreamLog{open: call}
+			streams[call.StreamID] = log
+			rc.streams[call.Method] = append(rc.streams[call.Method], log)
+		case recordStreamRecv:
+			if log, ok := streams[call.StreamID]; ok {
+				log.messages = append(log.messages, call)
+			}
+		}
+	}
+
+	return rc, nil
+}
+
+func (rc *replayConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, _ ...grpc.CallOption) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	call, err := rc.takeUnary(method, args)
+	if err != nil {
+		return err
+	}
+	if err := recordedError(call); err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(call.Response, reply.(proto.Message))
+}
+
+func (rc *replayConn) NewStream(ctx context.Context, _ *grpc.StreamDesc, method string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+	rc.mu.Lock()
+	var log *replayedStreamLog
+	if logs := rc.streams[method]; len(logs) > 0 {
+		log, rc.streams[method] = logs[0], logs[1:]
+	}
+	rc.mu.Unlock()
+
+	if log == nil {
+		// recording is over, stream stays silent until it is closed
+		return newReplayedStream(ctx, nil), nil
+	}
+	if err := recordedError(log.open); err != nil {
+		return nil, err
+	}
+
+	return newReplayedStream(ctx, log.messages), nil
+}
+
+// Close does nothing, the file is read completely on start.
+func (rc *replayConn) Close() error {
+	return nil
+}
+
+// takeUnary finds the best matching recorded call and marks it consumed.
+func (rc *replayConn) takeUnary(method string, args interface{}) (*recordedCall, error) {
+	exact := string(marshalRecorded(args))
+	loose := looseRequestKey(args)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var found *replayedCall
+	left := 0
+	for _, match := range []func(c *replayedCall) bool{
+		func(c *replayedCall) bool { return c.exact == exact },
+		func(c *replayedCall) bool { return c.loose == loose },
+	} {
+		left = 0
+		for _, c := range rc.unary[method] {
+			if c.consumed {
+				continue
+			}
+			left++
+			if match(c) {
+				found = c
+				break
+			}
+		}
+		if found != nil {
+			break
+		}
+	}
+	if found == nil && left == 0 {
+		return nil, status.Errorf(codes.Unavailable, "no recorded response for %s", method)
+	}
+	if found == nil {
+		return nil, status.Errorf(codes.NotFound, "no recorded response for %s matches the request, %d responses of other requests are left", method, left)
+	}
+
+	found.consumed = true
+	return found.call, nil
+}
+
+// looseRequestKey returns request without timestamps and order IDs, which differ on every run.
+func looseRequestKey(request interface{}) string {
+	msg, ok := request.(proto.Message)
+	if !ok {
+		return ""
+	}
+
+	msg = proto.Clone(msg)
+	clearVolatileFields(proto.MessageV2(msg).ProtoReflect())
+
+	return string(marshalRecorded(msg))
+}
+
+func clearVolatileFields(m protoreflect.Message) {
+	var volatile []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Message() != nil && fd.Message().FullName() == "google.protobuf.Timestamp":
+			volatile = append(volatile, fd)
+		case fd.Name() == "order_id":
+			volatile = append(volatile, fd)
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			clearVolatileFields(v.Message())
+		}
+		return true
+	})
+
+	for _, fd := range volatile {
+		m.Clear(fd)
+	}
+}
+
+type replayedStream struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	messages []*recordedCall
+	sends    int
+	sent     chan struct{} // closed and replaced on every SendMsg
+}
+
+func newReplayedStream(ctx context.Context, messages []*recordedCall) *replayedStream {
+	return &replayedStream{ctx: ctx, messages: messages, sent: make(chan struct{})}
+}
+
+func (s *replayedStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	var call *recordedCall
+	if len(s.messages) > 0 {
+		call, s.messages = s.messages[0], s.messages[1:]
+	}
+	s.mu.Unlock()
+
+	if call == nil {
+		<-s.ctx.Done()
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+	if err := s.waitForSends(call.SendsBefore); err != nil {
+		return err
+	}
+	if err := recordedError(call); err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(call.Response, m.(proto.Message))
+}
+
+// SendMsg accepts everything, recorded messages do not depend on request contents.
+func (s *replayedStream) SendMsg(interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.sends++
+	close(s.sent)
+	s.sent = make(chan struct{})
+	s.mu.Unlock()
+
+	return nil
+}
+
+// waitForSends holds a message until as many requests are sent as it was during recording,
+// so subscribers are in place; it gives up after replaySendGracePeriod without new requests.
+func (s *replayedStream) waitForSends(sends int) error {
+	for {
+		s.mu.Lock()
+		if s.sends >= sends {
+			s.mu.Unlock()
+			return nil
+		}
+		sent := s.sent
+		s.mu.Unlock()
+
+		timer := time.NewTimer(replaySendGracePeriod)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return status.FromContextError(s.ctx.Err()).Err()
+		case <-timer.C:
+			return nil
+		case <-sent:
+			timer.Stop()
+		}
+	}
+}
+
+func (s *replayedStream) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
+func (s *replayedStream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+func (s *replayedStream) CloseSend() error {
+	return nil
+}
+
+func (s *replayedStream) Context() context.Context {
+	return s.ctx
+}
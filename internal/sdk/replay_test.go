@@ -0,0 +1,158 @@
+package sdk_test
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var recordedAt = time.Date(2022, 5, 20, 10, 0, 0, 0, time.UTC)
+
+// recordSession records candles, last prices, a failed request and a market data
+// stream with a subscription and one last price.
+func recordSession(t *testing.T) string {
+	server := fake.NewServer()
+	server.SetCandles(testFigi, pb.CandleInterval_CANDLE_INTERVAL_1_MIN, []*pb.HistoricCandle{
+		{Time: timestamppb.New(recordedAt), Close: &pb.Quotation{Units: 100}, IsComplete: true},
+	})
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 100})
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	path := filepath.Join(t.TempDir(), "session.rec")
+	cnf := server.ClientConfig()
+	cnf.RecordFile = path
+	client, err := sdk.NewClient(cnf)
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	ctx := context.Background()
+	services := client.ServicePool()
+
+	candles, err := services.MarketDataService.GetCandles(ctx, testFigi,
+		timestamppb.New(recordedAt.Add(-time.Hour)), timestamppb.New(recordedAt.Add(time.Hour)), pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil || len(candles) != 1 {
+		t.Fatalf("can not get candles: %v, %v", candles, err)
+	}
+	if _, err := services.MarketDataService.GetLastPrices(ctx, []string{testFigi}); err != nil {
+		t.Fatalf("can not get last prices: %v", err)
+	}
+	_, err = services.InstrumentsService.GetInstrumentBy(ctx, pb.InstrumentRequest{
+		IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     "unknown",
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown instrument must not be found, got %v", err)
+	}
+
+	stream, err := client.NewMarketDataStream(ctx)
+	if err != nil {
+		t.Fatalf("can not open market data stream: %v", err)
+	}
+	subscribeLastPrice(t, stream)
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 101})
+	recvUntil(t, stream, func(res *pb.MarketDataResponse) bool {
+		return res.GetLastPrice() != nil && res.GetLastPrice().Price.Units == 101
+	})
+	stream.Close()
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("can not close client: %v", err)
+	}
+
+	return path
+}
+
+func subscribeLastPrice(t *testing.T, stream *sdk.MarketDataStream) {
+	t.Helper()
+
+	err := stream.Send(&pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeLastPriceRequest{
+		SubscribeLastPriceRequest: &pb.SubscribeLastPriceRequest{
+			SubscriptionAction: pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE,
+			Instruments:        []*pb.LastPriceInstrument{{Figi: testFigi}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	recvUntil(t, stream, func(res *pb.MarketDataResponse) bool { return res.GetSubscribeLastPriceResponse() != nil })
+}
+
+func newReplayClient(t *testing.T, path string) *sdk.Client {
+	client, err := sdk.NewClient(sdk.ClientConfig{ReplayFile: path})
+	if err != nil {
+		t.Fatalf("can not open replay file: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestReplayUnaryCalls(t *testing.T) {
+	client := newReplayClient(t, recordSession(t))
+	ctx := context.Background()
+	services := client.ServicePool()
+
+	// the same candles requested later match without timestamps
+	now := time.Now()
+	candles, err := services.MarketDataService.GetCandles(ctx, testFigi,
+		timestamppb.New(now.Add(-time.Hour)), timestamppb.New(now), pb.CandleInterval_CANDLE_INTERVAL_1_MIN)
+	if err != nil || len(candles) != 1 || candles[0].Close.Units != 100 {
+		t.Errorf("expected recorded candle, got %v, %v", candles, err)
+	}
+
+	prices, err := services.MarketDataService.GetLastPrices(ctx, []string{testFigi})
+	if err != nil || len(prices) != 1 || prices[0].Price.Units != 100 {
+		t.Errorf("expected recorded last price, got %v, %v", prices, err)
+	}
+
+	_, err = services.InstrumentsService.GetInstrumentBy(ctx, pb.InstrumentRequest{
+		IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     "unknown",
+	})
+	var apiErr *sdk.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code != codes.NotFound {
+		t.Errorf("expected recorded ApiError, got %v", err)
+	}
+
+	_, err = services.MarketDataService.GetLastPrices(ctx, []string{testFigi})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("request after the recording is over must fail with Unavailable, got %v", err)
+	}
+}
+
+func TestReplayFailsUnmatchedRequest(t *testing.T) {
+	client := newReplayClient(t, recordSession(t))
+
+	_, err := client.ServicePool().MarketDataService.GetLastPrices(context.Background(), []string{"BBG000B9XRY4"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("request of another instrument must fail with NotFound, got %v", err)
+	}
+	prices, err := client.ServicePool().MarketDataService.GetLastPrices(context.Background(), []string{testFigi})
+	if err != nil || len(prices) != 1 {
+		t.Errorf("unmatched request must not consume recorded response, got %v, %v", prices, err)
+	}
+}
+
+func TestReplayStream(t *testing.T) {
+	client := newReplayClient(t, recordSession(t))
+
+	stream, err := client.NewMarketDataStream(context.Background())
+	if err != nil {
+		t.Fatalf("can not open market data stream: %v", err)
+	}
+	defer stream.Close()
+
+	subscribeLastPrice(t, stream)
+	recvUntil(t, stream, func(res *pb.MarketDataResponse) bool {
+		return res.GetLastPrice() != nil && res.GetLastPrice().Price.Units == 101
+	})
+}
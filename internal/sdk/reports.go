@@ -0,0 +1,123 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+const (
+	// reportWindow is the longest period API generates a single report for.
+	reportWindow = 31 * 24 * time.Hour
+	// reportPollInterval is the delay between attempts to get a report which is still being generated.
+	reportPollInterval = 3 * time.Second
+)
+
+// BrokerReport orders broker reports for [from, to) split into periods API accepts,
+// waits until they are generated and returns rows of all pages.
+// Reports may take minutes to generate, use ctx deadline to limit the wait.
+func (os OperationsService) BrokerReport(ctx context.Context, accountID string, from, to time.Time) ([]*pb.BrokerReport, error) {
+	var rows []*pb.BrokerReport
+	err := collectReport(ctx, from, to,
+		func(from, to time.Time) (string, error) {
+			return os.GenerateBrokerReport(ctx, accountID, timestamppb.New(from), timestamppb.New(to))
+		},
+		func(taskID string, page int32) (int32, error) {
+			res, err := os.GetBrokerReport(ctx, taskID, page)
+			if err != nil {
+				return 0, err
+			}
+			rows = append(rows, res.BrokerReport...)
+			return res.PagesCount, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// DividendsForeignIssuerReport does the same as BrokerReport for the report on dividends from foreign issuers.
+func (os OperationsService) DividendsForeignIssuerReport(ctx context.Context, accountID string, from, to time.Time) ([]*pb.DividendsForeignIssuerReport, error) {
+	var rows []*pb.DividendsForeignIssuerReport
+	err := collectReport(ctx, from, to,
+		func(from, to time.Time) (string, error) {
+			return os.GenerateDividendsForeignIssuerReport(ctx, accountID, timestamppb.New(from), timestamppb.New(to))
+		},
+		func(taskID string, page int32) (int32, error) {
+			res, err := os.GetDividendsForeignIssuerReport(ctx, taskID, page)
+			if err != nil {
+				return 0, err
+			}
+			rows = append(rows, res.DividendsForeignIssuerReport...)
+			return res.PagesCount, nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// collectReport orders a report for every period and reads all its pages;
+// getPage keeps rows of the page and returns the number of pages.
+func collectReport(ctx context.Context, from, to time.Time,
+	generate func(from, to time.Time) (string, error),
+	getPage func(taskID string, page int32) (int32, error),
+) error {
+	if !from.Before(to) {
+		return fmt.Errorf("invalid report period: %s is not before %s",
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	for start := from; start.Before(to); start = start.Add(reportWindow) {
+		end := start.Add(reportWindow)
+		if end.After(to) {
+			end = to
+		}
+
+		taskID, err := generate(start, end)
+		if err != nil {
+			return fmt.Errorf("can not order report from %s to %s: %v",
+				start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+		}
+
+		pages, err := waitForReport(ctx, func() (int32, error) { return getPage(taskID, 0) })
+		if err != nil {
+			return fmt.Errorf("can not get report %s: %v", taskID, err)
+		}
+		for page := int32(1); page < pages; page++ {
+			if _, err := getPage(taskID, page); err != nil {
+				return fmt.Errorf("can not get page %d of report %s: %v", page, taskID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// waitForReport polls the first page while API answers that the report is being generated;
+// any other error is returned at once.
+func waitForReport(ctx context.Context, firstPage func() (int32, error)) (int32, error) {
+	for {
+		pages, err := firstPage()
+		if err == nil {
+			return pages, nil
+		}
+		if !errors.Is(err, ErrReportNotReady) {
+			return 0, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("report is not generated in time: %v", err)
+		case <-time.After(reportPollInterval):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var reportFrom = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var errReportNotReady = NewApiError("GetBrokerReport", codes.FailedPrecondition, "50010", "report is being generated")
+
+func TestCollectReportSplitsPeriod(t *testing.T) {
+	var periods [][2]time.Time
+	var pages []string
+
+	to := reportFrom.Add(70 * 24 * time.Hour)
+	err := collectReport(context.Background(), reportFrom, to,
+		func(from, to time.Time) (string, error) {
+			periods = append(periods, [2]time.Time{from, to})
+			return fmt.Sprintf("task%d", len(periods)), nil
+		},
+		func(taskID string, page int32) (int32, error) {
+			pages = append(pages, fmt.Sprintf("%s/%d", taskID, page))
+			return 2, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("can not collect report: %v", err)
+	}
+
+	wantPeriods := [][2]time.Time{
+		{reportFrom, reportFrom.Add(reportWindow)},
+		{reportFrom.Add(reportWindow), reportFrom.Add(2 * reportWindow)},
+		{reportFrom.Add(2 * reportWindow), to},
+	}
+	if fmt.Sprint(periods) != fmt.Sprint(wantPeriods) {
+		t.Errorf("reports ordered for %v, want %v", periods, wantPeriods)
+	}
+	wantPages := "[task1/0 task1/1 task2/0 task2/1 task3/0 task3/1]"
+	if fmt.Sprint(pages) != wantPages {
+		t.Errorf("pages %v are read, want %s", pages, wantPages)
+	}
+
+	if err := collectReport(context.Background(), to, reportFrom, nil, nil); err == nil {
+		t.Error("reversed period must be rejected")
+	}
+}
+
+func TestWaitForReport(t *testing.T) {
+	calls := 0
+	pages, err := waitForReport(context.Background(), func() (int32, error) {
+		calls++
+		if calls == 1 {
+			return 0, errReportNotReady
+		}
+		return 3, nil
+	})
+	if err != nil || pages != 3 || calls != 2 {
+		t.Errorf("waitForReport() = %d, %v after %d calls, want 3 pages after 2 calls", pages, err, calls)
+	}
+}
+
+func TestWaitForReportFailsAtOnce(t *testing.T) {
+	// only the report in progress is polled, even errors worth retrying are left to the caller
+	for _, failure := range []error{
+		status.Error(codes.InvalidArgument, "30001"),
+		status.Error(codes.NotFound, "report is not found"),
+		status.Error(codes.Unavailable, "transport is closing"),
+		status.Error(codes.FailedPrecondition, "30079"),
+	} {
+		calls := 0
+		_, err := waitForReport(context.Background(), func() (int32, error) {
+			calls++
+			return 0, failure
+		})
+		if status.Code(err) != status.Code(failure) || calls != 1 {
+			t.Errorf("%v must not be polled, got %v after %d calls", failure, err, calls)
+		}
+	}
+}
+
+func TestWaitForReportStopsOnDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := waitForReport(ctx, func() (int32, error) {
+		return 0, errReportNotReady
+	})
+	if err == nil || time.Since(start) >= reportPollInterval {
+		t.Errorf("waiting must stop on deadline, got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,118 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how transient failures of idempotent calls are retried.
+type RetryPolicy struct {
+	MaxAttempts    int // 1 or less disables retries
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// mutatingMethods change account state, so they are never retried blindly.
+var mutatingMethods = map[string]bool{
+	"/tinkoff.public.invest.api.contract.v1.InstrumentsService/EditFavorites":   true,
+	"/tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder":            true,
+	"/tinkoff.public.invest.api.contract.v1.OrdersService/CancelOrder":          true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/OpenSandboxAccount":  true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/CloseSandboxAccount": true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/PostSandboxOrder":    true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/CancelSandboxOrder":  true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/SandboxPayIn":        true,
+	"/tinkoff.public.invest.api.contract.v1.StopOrdersService/PostStopOrder":    true,
+	"/tinkoff.public.invest.api.contract.v1.StopOrdersService/CancelStopOrder":  true,
+}
+
+// isIdempotent reports whether request may be safely sent again.
+// Orders with OrderId set are deduplicated by the API, so they are idempotent too.
+func isIdempotent(method string, req interface{}) bool {
+	if !mutatingMethods[method] {
+		return true
+	}
+	if order, ok := req.(*pb.PostOrderRequest); ok {
+		return order.OrderId != ""
+	}
+
+	return false
+}
+
+// retryUnaryInterceptor retries idempotent calls failed with transient codes
+// and wraps the final error into ApiError.
+func retryUnaryInterceptor(policy RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		maxAttempts := 1
+		if policy.MaxAttempts > 1 && isIdempotent(method, req) {
+			maxAttempts = policy.MaxAttempts
+		}
+
+		var err error
+		var header, trailer metadata.MD
+		attempt := 0
+		for attempt < maxAttempts {
+			attempt++
+
+			header, trailer = metadata.MD{}, metadata.MD{}
+			err = invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+			if err == nil {
+				return nil
+			}
+			if _, throttled := err.(*throttledError); throttled || !IsTransient(err) || attempt == maxAttempts {
+				break
+			}
+
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return newApiError(ctx, method, err, header, trailer, attempt)
+			case <-timer.C:
+			}
+		}
+
+		return newApiError(ctx, method, err, header, trailer, attempt)
+	}
+}
+
+// backoff returns exponential delay with full jitter before the next attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff << uint(attempt-1)
+	if delay > p.MaxBackoff || delay <= 0 {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func newApiError(ctx context.Context, method string, err error, header, trailer metadata.MD, attempts int) error {
+	apiErr := &ApiError{
+		Method:   method,
+		Code:     status.Code(err),
+		ApiCode:  parseApiCode(err),
+		Message:  status.Convert(err).Message(),
+		Attempts: attempts,
+		err:      err,
+	}
+
+	if msg := trailer.Get("message"); len(msg) > 0 {
+		apiErr.Message = msg[0]
+	}
+	if id := header.Get("x-tracking-id"); len(id) > 0 {
+		apiErr.TrackingID = id[0]
+	} else if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get("x-tracking-id")) > 0 {
+		apiErr.TrackingID = md.Get("x-tracking-id")[0]
+	}
+
+	return apiErr
+}
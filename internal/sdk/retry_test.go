@@ -0,0 +1,124 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const (
+	getOrdersMethod = "/tinkoff.public.invest.api.contract.v1.OrdersService/GetOrders"
+	postOrderMethod = "/tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+// failingInvoker fails first calls with errs and succeeds afterwards; calls counts all invocations.
+func failingInvoker(calls *int, errs ...error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestRetryUnaryInterceptor(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	invalid := status.Error(codes.InvalidArgument, "invalid")
+
+	tests := []struct {
+		name      string
+		method    string
+		req       interface{}
+		errs      []error
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{"transient failure is retried", getOrdersMethod, &pb.GetOrdersRequest{},
+			[]error{unavailable, unavailable}, 3, codes.OK},
+		{"attempts are limited", getOrdersMethod, &pb.GetOrdersRequest{},
+			[]error{unavailable, unavailable, unavailable, unavailable}, 3, codes.Unavailable},
+		{"permanent failure is not retried", getOrdersMethod, &pb.GetOrdersRequest{},
+			[]error{invalid}, 1, codes.InvalidArgument},
+		{"order without ID is not retried", postOrderMethod, &pb.PostOrderRequest{},
+			[]error{unavailable}, 1, codes.Unavailable},
+		{"order with ID is retried", postOrderMethod, &pb.PostOrderRequest{OrderId: "order"},
+			[]error{unavailable}, 2, codes.OK},
+	}
+
+	for _, tt := range tests {
+		calls := 0
+		interceptor := retryUnaryInterceptor(testRetryPolicy)
+		err := interceptor(context.Background(), tt.method, tt.req, nil, nil, failingInvoker(&calls, tt.errs...))
+
+		if calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if status.Code(err) != tt.wantCode {
+			t.Errorf("%s: code %s, want %s", tt.name, status.Code(err), tt.wantCode)
+		}
+		if err == nil {
+			continue
+		}
+
+		var apiErr *ApiError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%s: error %v is not ApiError", tt.name, err)
+		}
+		if apiErr.Method != tt.method || apiErr.Attempts != tt.wantCalls {
+			t.Errorf("%s: unexpected ApiError %+v", tt.name, apiErr)
+		}
+	}
+}
+
+func TestRetryUnaryInterceptorStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		cancel()
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	err := retryUnaryInterceptor(policy)(ctx, getOrdersMethod, &pb.GetOrdersRequest{}, nil, nil, invoker)
+	if calls != 1 || status.Code(err) != codes.Unavailable {
+		t.Errorf("cancelled call must not wait for backoff, got %d calls and %v", calls, err)
+	}
+}
+
+func TestApiErrorTrackingID(t *testing.T) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tracking-id", "tracking")
+	calls := 0
+	err := retryUnaryInterceptor(RetryPolicy{})(ctx, getOrdersMethod, &pb.GetOrdersRequest{}, nil, nil,
+		failingInvoker(&calls, status.Error(codes.NotFound, "not found")))
+
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.TrackingID != "tracking" || apiErr.Code != codes.NotFound {
+		t.Errorf("expected ApiError with tracking ID, got %v", err)
+	}
+	if IsTransient(err) {
+		t.Errorf("NotFound must not be transient")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 1; attempt < 10; attempt++ {
+		limit := p.InitialBackoff << uint(attempt-1)
+		if limit > p.MaxBackoff {
+			limit = p.MaxBackoff
+		}
+		if d := p.backoff(attempt); d <= 0 || d > limit {
+			t.Errorf("backoff(%d) = %s, want (0, %s]", attempt, d, limit)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 // Package sdk represents internal proto-wrapper for Tinkoff Invest API.
 package sdk
 
-const Version = "0.2.1"
+import "google.golang.org/grpc"
+
+const Version = "0.3.0"
 
 // ServicePool is a ready-to-use scope for all available non-stream services.
 type ServicePool struct {
@@ -12,16 +14,31 @@ type ServicePool struct {
 	SandboxService     SandboxService
 	StopOrdersService  StopOrdersService
 	UsersService       UsersService
+
+	// CandleFetcher loads candles for ranges longer than GetCandles accepts.
+	CandleFetcher CandleFetcher
+	// Candles is CandleStore if it is configured, CandleFetcher otherwise.
+	Candles CandleSource
+	// Instruments answers instrument lookups without a request per lookup.
+	Instruments *InstrumentRegistry
 }
 
-func NewServicePool() *ServicePool {
+// NewServicePool binds all services to the same connection, see Client.ServicePool.
+func NewServicePool(conn grpc.ClientConnInterface) *ServicePool {
+	marketData := NewMarketDataService(conn)
+	instruments := NewInstrumentsService(conn)
+	fetcher := NewCandleFetcher(marketData, defaultCandlesConcurrency)
+
 	return &ServicePool{
-		InstrumentsService: *NewInstrumentsService(),
-		MarketDataService:  *NewMarketDataService(),
-		OperationsService:  *NewOperationsService(),
-		OrdersService:      *NewOrdersService(),
-		StopOrdersService:  *NewStopOrdersService(),
-		SandboxService:     *NewSandboxService(),
-		UsersService:       *NewUsersService(),
+		InstrumentsService: *instruments,
+		MarketDataService:  *marketData,
+		OperationsService:  *NewOperationsService(conn),
+		OrdersService:      *NewOrdersService(conn),
+		StopOrdersService:  *NewStopOrdersService(conn),
+		SandboxService:     *NewSandboxService(conn),
+		UsersService:       *NewUsersService(conn),
+		CandleFetcher:      *fetcher,
+		Candles:            fetcher,
+		Instruments:        NewInstrumentRegistry(instruments, defaultInstrumentsTTL, ""),
 	}
 }
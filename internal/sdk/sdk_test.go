@@ -0,0 +1,12 @@
+package sdk
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain sets the token config requires; tests talk to fake servers, which do not check it.
+func TestMain(m *testing.M) {
+	_ = os.Setenv("TRADEBOT_TOKEN", "test")
+	os.Exit(m.Run())
+}
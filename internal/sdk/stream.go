@@ -0,0 +1,227 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	"time"
+)
+
+// ErrStreamClosed is returned by stream Recv after Close was called.
+var ErrStreamClosed = errors.New("stream is closed")
+
+// errStreamSilent means no message (including pings) came within StreamConfig.SilenceTimeout.
+var errStreamSilent = errors.New("stream is silent for too long")
+
+// defaultReconnectPolicy is used when StreamConfig has no backoff set, e.g. for fake servers.
+var defaultReconnectPolicy = RetryPolicy{InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+
+// StreamConfig describes how broken streams are detected and reopened.
+type StreamConfig struct {
+	Reconnect      RetryPolicy   // only backoff fields are used, reconnects never stop
+	SilenceTimeout time.Duration // 0 disables silence detection
+}
+
+type StreamState int
+
+const (
+	StreamConnected StreamState = iota
+	StreamReconnecting
+	StreamClosed
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StreamConnected:
+		return "connected"
+	case StreamReconnecting:
+		return "reconnecting"
+	case StreamClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StreamEvent describes stream connection state change.
+type StreamEvent struct {
+	State StreamState
+	Err   error // reason of reconnect or failed attempt
+}
+
+type recvResult struct {
+	msg interface{}
+	err error
+}
+
+// streamKeeper reads messages from the current stream connection and
+// reopens it with backoff when it breaks or stays silent for too long.
+type streamKeeper struct {
+	name string
+	cfg  StreamConfig
+	open func(ctx context.Context) (recv func() (interface{}, error), err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	messages chan interface{}
+	events   chan StreamEvent
+}
+
+func newStreamKeeper(ctx context.Context, name string, cfg StreamConfig, open func(ctx context.Context) (func() (interface{}, error), error)) *streamKeeper {
+	if cfg.Reconnect.MaxBackoff <= 0 {
+		cfg.Reconnect = defaultReconnectPolicy
+	}
+
+	ctx, cancel := createStreamContext(ctx)
+
+	return &streamKeeper{
+		name:     name,
+		cfg:      cfg,
+		open:     open,
+		ctx:      ctx,
+		cancel:   cancel,
+		messages: make(chan interface{}),
+		events:   make(chan StreamEvent, 16),
+	}
+}
+
+// start opens the first connection synchronously, so setup errors reach the caller.
+func (k *streamKeeper) start() error {
+	connCtx, connCancel := context.WithCancel(k.ctx)
+	recv, err := k.open(connCtx)
+	if err != nil {
+		connCancel()
+		k.cancel()
+		return err
+	}
+
+	k.emit(StreamEvent{State: StreamConnected})
+	go k.run(recv, connCancel)
+
+	return nil
+}
+
+func (k *streamKeeper) run(recv func() (interface{}, error), connCancel context.CancelFunc) {
+	defer close(k.messages)
+	defer close(k.events)
+	defer k.emit(StreamEvent{State: StreamClosed})
+
+	for {
+		err := k.pump(recv)
+		connCancel()
+		if k.ctx.Err() != nil {
+			return
+		}
+
+		loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+			Warnf("%s is broken, reconnecting: %v", k.name, err)
+		metrics.StreamReconnects.WithLabelValues(loggy.GetBotID(), k.name).Inc()
+		k.emit(StreamEvent{State: StreamReconnecting, Err: err})
+
+		recv, connCancel = k.reconnect()
+		if recv == nil {
+			return
+		}
+		k.emit(StreamEvent{State: StreamConnected})
+	}
+}
+
+// pump forwards messages until the connection breaks, goes silent or keeper is closed.
+func (k *streamKeeper) pump(recv func() (interface{}, error)) error {
+	results := make(chan recvResult)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			msg, err := recv()
+			select {
+			case results <- recvResult{msg: msg, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var silence <-chan time.Time
+	var timer *time.Timer
+	if k.cfg.SilenceTimeout > 0 {
+		timer = time.NewTimer(k.cfg.SilenceTimeout)
+		defer timer.Stop()
+		silence = timer.C
+	}
+
+	for {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				return r.err
+			}
+			if timer != nil {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(k.cfg.SilenceTimeout)
+			}
+
+			select {
+			case k.messages <- r.msg:
+			case <-k.ctx.Done():
+				return k.ctx.Err()
+			}
+		case <-silence:
+			return errStreamSilent
+		case <-k.ctx.Done():
+			return k.ctx.Err()
+		}
+	}
+}
+
+// reconnect opens a new connection with backoff; returns nil recv if keeper was closed.
+func (k *streamKeeper) reconnect() (func() (interface{}, error), context.CancelFunc) {
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(k.cfg.Reconnect.backoff(attempt))
+		select {
+		case <-k.ctx.Done():
+			timer.Stop()
+			return nil, nil
+		case <-timer.C:
+		}
+
+		connCtx, connCancel := context.WithCancel(k.ctx)
+		recv, err := k.open(connCtx)
+		if err == nil {
+			return recv, connCancel
+		}
+
+		connCancel()
+		k.emit(StreamEvent{State: StreamReconnecting, Err: err})
+	}
+}
+
+// recv blocks until the next message; returns ErrStreamClosed once keeper is closed.
+func (k *streamKeeper) recv() (interface{}, error) {
+	msg, ok := <-k.messages
+	if !ok {
+		return nil, ErrStreamClosed
+	}
+	return msg, nil
+}
+
+// emit never blocks, events are dropped if nobody reads them.
+// Events channel is closed after StreamClosed event.
+func (k *streamKeeper) emit(event StreamEvent) {
+	select {
+	case k.events <- event:
+	default:
+	}
+}
+
+func (k *streamKeeper) close() {
+	k.cancel()
+}
@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testStreamConfig = StreamConfig{Reconnect: RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}}
+
+// testConn is a stream connection which delivers msgs until it is broken with an error from errs.
+type testConn struct {
+	msgs chan interface{}
+	errs chan error
+}
+
+// testOpener opens testConn connections and sends every one of them to conns.
+func testOpener(conns chan *testConn) func(ctx context.Context) (func() (interface{}, error), error) {
+	return func(ctx context.Context) (func() (interface{}, error), error) {
+		conn := &testConn{msgs: make(chan interface{}), errs: make(chan error, 1)}
+		conns <- conn
+
+		return func() (interface{}, error) {
+			select {
+			case msg := <-conn.msgs:
+				return msg, nil
+			case err := <-conn.errs:
+				return nil, err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}, nil
+	}
+}
+
+func waitForStreamEvent(t *testing.T, events <-chan StreamEvent, state StreamState) StreamEvent {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("events are closed before %s", state)
+			}
+			if event.State == state {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", state)
+		}
+	}
+}
+
+func TestStreamKeeperReconnects(t *testing.T) {
+	conns := make(chan *testConn, 2)
+	k := newStreamKeeper(context.Background(), "test stream", testStreamConfig, testOpener(conns))
+	if err := k.start(); err != nil {
+		t.Fatalf("can not start stream: %v", err)
+	}
+	defer k.close()
+
+	conn := <-conns
+	waitForStreamEvent(t, k.events, StreamConnected)
+	conn.msgs <- "first"
+	if msg, err := k.recv(); err != nil || msg != "first" {
+		t.Fatalf("recv() = %v, %v, want first", msg, err)
+	}
+
+	broken := errors.New("connection reset")
+	conn.errs <- broken
+	if event := waitForStreamEvent(t, k.events, StreamReconnecting); !errors.Is(event.Err, broken) {
+		t.Errorf("reconnect reason = %v, want %v", event.Err, broken)
+	}
+
+	conn = <-conns
+	waitForStreamEvent(t, k.events, StreamConnected)
+	conn.msgs <- "second"
+	if msg, err := k.recv(); err != nil || msg != "second" {
+		t.Fatalf("recv() after reconnect = %v, %v, want second", msg, err)
+	}
+}
+
+func TestStreamKeeperReconnectsSilentStream(t *testing.T) {
+	cfg := testStreamConfig
+	cfg.SilenceTimeout = 10 * time.Millisecond
+
+	conns := make(chan *testConn, 2)
+	k := newStreamKeeper(context.Background(), "test stream", cfg, testOpener(conns))
+	if err := k.start(); err != nil {
+		t.Fatalf("can not start stream: %v", err)
+	}
+	defer k.close()
+
+	<-conns
+	if event := waitForStreamEvent(t, k.events, StreamReconnecting); event.Err != errStreamSilent {
+		t.Errorf("reconnect reason = %v, want %v", event.Err, errStreamSilent)
+	}
+	<-conns
+}
+
+func TestStreamKeeperClose(t *testing.T) {
+	conns := make(chan *testConn, 1)
+	k := newStreamKeeper(context.Background(), "test stream", testStreamConfig, testOpener(conns))
+	if err := k.start(); err != nil {
+		t.Fatalf("can not start stream: %v", err)
+	}
+	<-conns
+
+	k.close()
+	if _, err := k.recv(); err != ErrStreamClosed {
+		t.Errorf("recv() after close = %v, want %v", err, ErrStreamClosed)
+	}
+	waitForStreamEvent(t, k.events, StreamClosed)
+}
+
+func TestStreamKeeperStartFails(t *testing.T) {
+	failed := errors.New("unauthenticated")
+	k := newStreamKeeper(context.Background(), "test stream", testStreamConfig, func(context.Context) (func() (interface{}, error), error) {
+		return nil, failed
+	})
+	if err := k.start(); err != failed {
+		t.Errorf("start() = %v, want %v", err, failed)
+	}
+}
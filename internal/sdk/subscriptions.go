@@ -0,0 +1,352 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"sync"
+)
+
+// subscriptionBufferSize is a per-consumer channel capacity, newer messages
+// are dropped while the consumer is that far behind.
+const subscriptionBufferSize = 100
+
+// SubscriptionManager shares one MarketDataStream between many consumers.
+// Every consumer gets its own typed channel and unsubscribes independently,
+// the stream itself is subscribed while at least one consumer is interested.
+type SubscriptionManager struct {
+	stream *MarketDataStream
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	nextID int
+	closed bool
+}
+
+type topic struct {
+	unsubscribe *pb.MarketDataRequest
+	consumers   map[int]*consumer
+}
+
+type consumer struct {
+	deliver func(msg *pb.MarketDataResponse) bool // false if the message was dropped
+	close   func()
+}
+
+// NewSubscriptionManager takes over stream: nobody else should call its Recv.
+func NewSubscriptionManager(stream *MarketDataStream) *SubscriptionManager {
+	sm := &SubscriptionManager{stream: stream, topics: make(map[string]*topic)}
+	go sm.dispatch()
+
+	return sm
+}
+
+// SubscribeOrderBook returns order books of given depth for figi and a function to unsubscribe.
+func (sm *SubscriptionManager) SubscribeOrderBook(figi string, depth int32) (<-chan *pb.OrderBook, func(), error) {
+	ch := make(chan *pb.OrderBook, subscriptionBufferSize)
+	instruments := []*pb.OrderBookInstrument{{Figi: figi, Depth: depth}}
+	request := func(action pb.SubscriptionAction) *pb.MarketDataRequest {
+		return &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeOrderBookRequest{
+			SubscribeOrderBookRequest: &pb.SubscribeOrderBookRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+
+	unsubscribe, err := sm.subscribe(orderBookTopic(figi, depth), request, &consumer{
+		deliver: func(msg *pb.MarketDataResponse) bool {
+			select {
+			case ch <- msg.GetOrderbook():
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(ch) },
+	})
+
+	return ch, unsubscribe, err
+}
+
+// SubscribeCandles returns candles of given interval for figi and a function to unsubscribe.
+func (sm *SubscriptionManager) SubscribeCandles(figi string, interval pb.SubscriptionInterval) (<-chan *pb.Candle, func(), error) {
+	ch := make(chan *pb.Candle, subscriptionBufferSize)
+	instruments := []*pb.CandleInstrument{{Figi: figi, Interval: interval}}
+	request := func(action pb.SubscriptionAction) *pb.MarketDataRequest {
+		return &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeCandlesRequest{
+			SubscribeCandlesRequest: &pb.SubscribeCandlesRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+
+	unsubscribe, err := sm.subscribe(candlesTopic(figi, interval), request, &consumer{
+		deliver: func(msg *pb.MarketDataResponse) bool {
+			select {
+			case ch <- msg.GetCandle():
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(ch) },
+	})
+
+	return ch, unsubscribe, err
+}
+
+// SubscribeTrades returns anonymous trades for figi and a function to unsubscribe.
+func (sm *SubscriptionManager) SubscribeTrades(figi string) (<-chan *pb.Trade, func(), error) {
+	ch := make(chan *pb.Trade, subscriptionBufferSize)
+	instruments := []*pb.TradeInstrument{{Figi: figi}}
+	request := func(action pb.SubscriptionAction) *pb.MarketDataRequest {
+		return &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeTradesRequest{
+			SubscribeTradesRequest: &pb.SubscribeTradesRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+
+	unsubscribe, err := sm.subscribe(tradesTopic(figi), request, &consumer{
+		deliver: func(msg *pb.MarketDataResponse) bool {
+			select {
+			case ch <- msg.GetTrade():
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(ch) },
+	})
+
+	return ch, unsubscribe, err
+}
+
+// SubscribeInfo returns trading status changes for figi and a function to unsubscribe.
+func (sm *SubscriptionManager) SubscribeInfo(figi string) (<-chan *pb.TradingStatus, func(), error) {
+	ch := make(chan *pb.TradingStatus, subscriptionBufferSize)
+	instruments := []*pb.InfoInstrument{{Figi: figi}}
+	request := func(action pb.SubscriptionAction) *pb.MarketDataRequest {
+		return &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeInfoRequest{
+			SubscribeInfoRequest: &pb.SubscribeInfoRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+
+	unsubscribe, err := sm.subscribe(infoTopic(figi), request, &consumer{
+		deliver: func(msg *pb.MarketDataResponse) bool {
+			select {
+			case ch <- msg.GetTradingStatus():
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(ch) },
+	})
+
+	return ch, unsubscribe, err
+}
+
+// SubscribeLastPrice returns last prices for figi and a function to unsubscribe.
+func (sm *SubscriptionManager) SubscribeLastPrice(figi string) (<-chan *pb.LastPrice, func(), error) {
+	ch := make(chan *pb.LastPrice, subscriptionBufferSize)
+	instruments := []*pb.LastPriceInstrument{{Figi: figi}}
+	request := func(action pb.SubscriptionAction) *pb.MarketDataRequest {
+		return &pb.MarketDataRequest{Payload: &pb.MarketDataRequest_SubscribeLastPriceRequest{
+			SubscribeLastPriceRequest: &pb.SubscribeLastPriceRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+
+	unsubscribe, err := sm.subscribe(lastPriceTopic(figi), request, &consumer{
+		deliver: func(msg *pb.MarketDataResponse) bool {
+			select {
+			case ch <- msg.GetLastPrice():
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(ch) },
+	})
+
+	return ch, unsubscribe, err
+}
+
+// Events returns connection state changes of the underlying stream.
+func (sm *SubscriptionManager) Events() <-chan StreamEvent {
+	return sm.stream.Events()
+}
+
+// Close closes the stream and all consumer channels.
+func (sm *SubscriptionManager) Close() {
+	sm.stream.Close()
+}
+
+// subscribe registers consumer and subscribes the stream if it is the first one for the topic.
+func (sm *SubscriptionManager) subscribe(key string, request func(pb.SubscriptionAction) *pb.MarketDataRequest, c *consumer) (func(), error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return nil, ErrStreamClosed
+	}
+
+	t, ok := sm.topics[key]
+	if !ok {
+		if err := sm.stream.Send(request(pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE)); err != nil {
+			return nil, fmt.Errorf("can not subscribe to %s: %v", key, err)
+		}
+
+		t = &topic{
+			unsubscribe: request(pb.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE),
+			consumers:   make(map[int]*consumer),
+		}
+		sm.topics[key] = t
+	}
+
+	id := sm.nextID
+	sm.nextID++
+	t.consumers[id] = c
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { sm.unsubscribe(key, id) })
+	}, nil
+}
+
+// unsubscribe removes consumer and unsubscribes the stream if nobody else needs the topic.
+func (sm *SubscriptionManager) unsubscribe(key string, id int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	t, ok := sm.topics[key]
+	if !ok {
+		return // already closed with the whole manager
+	}
+	c, ok := t.consumers[id]
+	if !ok {
+		return
+	}
+
+	delete(t.consumers, id)
+	c.close()
+
+	if len(t.consumers) == 0 {
+		delete(sm.topics, key)
+		_ = sm.stream.Send(t.unsubscribe)
+	}
+}
+
+// dispatch reads the stream and fans out messages until the stream is closed.
+func (sm *SubscriptionManager) dispatch() {
+	logger := loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID())
+
+	for {
+		msg, err := sm.stream.Recv()
+		if err != nil {
+			break
+		}
+
+		key := messageTopic(msg)
+		if key == "" {
+			logSubscriptionFailures(msg)
+			continue
+		}
+
+		sm.mu.Lock()
+		if t, ok := sm.topics[key]; ok {
+			for _, c := range t.consumers {
+				if !c.deliver(msg) {
+					metrics.StreamDroppedMessages.WithLabelValues(loggy.GetBotID(), key).Inc()
+					logger.Debugf("consumer of %s is too slow, message dropped", key)
+				}
+			}
+		}
+		sm.mu.Unlock()
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.closed = true
+	for key, t := range sm.topics {
+		for _, c := range t.consumers {
+			c.close()
+		}
+		delete(sm.topics, key)
+	}
+}
+
+func orderBookTopic(figi string, depth int32) string {
+	return fmt.Sprintf("orderbook/%s/%d", figi, depth)
+}
+
+func candlesTopic(figi string, interval pb.SubscriptionInterval) string {
+	return fmt.Sprintf("candles/%s/%d", figi, interval)
+}
+
+func tradesTopic(figi string) string {
+	return "trades/" + figi
+}
+
+func infoTopic(figi string) string {
+	return "info/" + figi
+}
+
+func lastPriceTopic(figi string) string {
+	return "lastprice/" + figi
+}
+
+// messageTopic returns topic key of a data message, or empty string for service messages.
+func messageTopic(msg *pb.MarketDataResponse) string {
+	switch payload := msg.Payload.(type) {
+	case *pb.MarketDataResponse_Orderbook:
+		return orderBookTopic(payload.Orderbook.Figi, payload.Orderbook.Depth)
+	case *pb.MarketDataResponse_Candle:
+		return candlesTopic(payload.Candle.Figi, payload.Candle.Interval)
+	case *pb.MarketDataResponse_Trade:
+		return tradesTopic(payload.Trade.Figi)
+	case *pb.MarketDataResponse_TradingStatus:
+		return infoTopic(payload.TradingStatus.Figi)
+	case *pb.MarketDataResponse_LastPrice:
+		return lastPriceTopic(payload.LastPrice.Figi)
+	}
+	return ""
+}
+
+// logSubscriptionFailures reports instruments the server refused to subscribe to.
+func logSubscriptionFailures(msg *pb.MarketDataResponse) {
+	logger := loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID())
+
+	if r := msg.GetSubscribeOrderBookResponse(); r != nil {
+		for _, s := range r.OrderBookSubscriptions {
+			if s.SubscriptionStatus != pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				logger.Warnf("order book subscription for %s failed: %s", s.Figi, s.SubscriptionStatus)
+			}
+		}
+	}
+	if r := msg.GetSubscribeCandlesResponse(); r != nil {
+		for _, s := range r.CandlesSubscriptions {
+			if s.SubscriptionStatus != pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				logger.Warnf("candles subscription for %s failed: %s", s.Figi, s.SubscriptionStatus)
+			}
+		}
+	}
+	if r := msg.GetSubscribeTradesResponse(); r != nil {
+		for _, s := range r.TradeSubscriptions {
+			if s.SubscriptionStatus != pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				logger.Warnf("trades subscription for %s failed: %s", s.Figi, s.SubscriptionStatus)
+			}
+		}
+	}
+	if r := msg.GetSubscribeInfoResponse(); r != nil {
+		for _, s := range r.InfoSubscriptions {
+			if s.SubscriptionStatus != pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				logger.Warnf("info subscription for %s failed: %s", s.Figi, s.SubscriptionStatus)
+			}
+		}
+	}
+	if r := msg.GetSubscribeLastPriceResponse(); r != nil {
+		for _, s := range r.LastPriceSubscriptions {
+			if s.SubscriptionStatus != pb.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				logger.Warnf("last price subscription for %s failed: %s", s.Figi, s.SubscriptionStatus)
+			}
+		}
+	}
+}
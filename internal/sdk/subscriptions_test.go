@@ -0,0 +1,108 @@
+package sdk_test
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"testing"
+	"time"
+)
+
+func newTestSubscriptionManager(t *testing.T) (*fake.Server, *sdk.SubscriptionManager) {
+	server, client := newTestClient(t)
+
+	sm, err := client.NewSubscriptionManager(context.Background())
+	if err != nil {
+		t.Fatalf("can not open subscription manager: %v", err)
+	}
+	t.Cleanup(sm.Close)
+
+	return server, sm
+}
+
+// waitForLastPrice sets price on the server until it comes from ch: subscription is asynchronous.
+func waitForLastPrice(t *testing.T, server *fake.Server, ch <-chan *pb.LastPrice, units int64) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		server.SetLastPrice(testFigi, &pb.Quotation{Units: units})
+		select {
+		case price, ok := <-ch:
+			if !ok {
+				t.Fatal("last price channel is closed")
+			}
+			if price.Price.Units == units {
+				return
+			}
+		case <-time.After(20 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("timed out waiting for last price %d", units)
+		}
+	}
+}
+
+func TestSubscriptionManagerSharesTopic(t *testing.T) {
+	server, sm := newTestSubscriptionManager(t)
+
+	first, unsubscribeFirst, err := sm.SubscribeLastPrice(testFigi)
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	second, unsubscribeSecond, err := sm.SubscribeLastPrice(testFigi)
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	defer unsubscribeSecond()
+
+	waitForLastPrice(t, server, first, 101)
+	waitForLastPrice(t, server, second, 101)
+
+	unsubscribeFirst()
+	unsubscribeFirst() // repeated unsubscribe is a no-op
+	for range first {
+	}
+
+	// the other consumer keeps the topic subscribed
+	waitForLastPrice(t, server, second, 102)
+}
+
+func TestSubscriptionManagerDropsForSlowConsumer(t *testing.T) {
+	server, sm := newTestSubscriptionManager(t)
+
+	slow, unsubscribeSlow, err := sm.SubscribeLastPrice(testFigi)
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	defer unsubscribeSlow()
+	fast, unsubscribeFast, err := sm.SubscribeLastPrice(testFigi)
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	defer unsubscribeFast()
+
+	for units := int64(1); units <= int64(2*cap(slow)); units++ {
+		waitForLastPrice(t, server, fast, units)
+	}
+	if len(slow) != cap(slow) {
+		t.Errorf("slow consumer must have a full buffer, got %d of %d", len(slow), cap(slow))
+	}
+}
+
+func TestSubscriptionManagerClose(t *testing.T) {
+	server, sm := newTestSubscriptionManager(t)
+
+	ch, _, err := sm.SubscribeLastPrice(testFigi)
+	if err != nil {
+		t.Fatalf("can not subscribe: %v", err)
+	}
+	waitForLastPrice(t, server, ch, 101)
+
+	sm.Close()
+	for range ch {
+	}
+	if _, _, err := sm.SubscribeTrades(testFigi); err != sdk.ErrStreamClosed {
+		t.Errorf("SubscribeTrades() after close = %v, want %v", err, sdk.ErrStreamClosed)
+	}
+}
@@ -0,0 +1,197 @@
+// Package sdk represents internal proto-wrapper for Tinkoff Invest API.
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	"github.com/elkopass/BITA/internal/loggy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcMetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCheckInterval limits how often a token file is checked for changes.
+const tokenCheckInterval = 10 * time.Second
+
+// tradingServices need a token with trading rights, the rest of the API works with a read-only token.
+var tradingServices = map[string]bool{
+	"OrdersService":       true,
+	"OrdersStreamService": true,
+	"StopOrdersService":   true,
+	"SandboxService":      true,
+}
+
+// Token is an API token set directly or read from a file, e.g. a Docker or Kubernetes secret.
+// A token file is read again when it changes, so a rotated secret is used without restart.
+// Token values are registered in loggy, so they never appear in logs or ApiError messages.
+type Token struct {
+	path string
+
+	mu      sync.Mutex
+	value   string
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+// NewToken creates Token with a fixed value.
+func NewToken(value string) *Token {
+	loggy.AddSecret(value)
+	return &Token{value: value}
+}
+
+// NewFileToken creates Token read from path; leading and trailing spaces are ignored.
+func NewFileToken(path string) (*Token, error) {
+	t := &Token{path: path}
+	if _, err := t.Value(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// String hides the token value in formatted output.
+func (t *Token) String() string {
+	if t.path != "" {
+		return fmt.Sprintf("token from %s", t.path)
+	}
+	return "token"
+}
+
+// Value returns the current token; if the file can not be read after a change, the last value is kept.
+func (t *Token) Value() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.path == "" || (t.value != "" && time.Since(t.checked) < tokenCheckInterval) {
+		return t.value, nil
+	}
+	t.checked = time.Now()
+
+	info, err := os.Stat(t.path)
+	if err == nil && t.value != "" && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.value, nil
+	}
+	if err == nil {
+		err = t.read(info)
+	} else {
+		err = fmt.Errorf("can not read token file: %v", err)
+	}
+	if err != nil && t.value == "" {
+		return "", err
+	}
+	if err != nil {
+		loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).
+			Warnf("can not reload token, the previous one is used: %v", err)
+	}
+
+	return t.value, nil
+}
+
+// read must be called with t.mu held.
+func (t *Token) read(info os.FileInfo) error {
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("can not read token file: %v", err)
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return fmt.Errorf("token file %s is empty", t.path)
+	}
+
+	loggy.AddSecret(value)
+	t.value, t.modTime, t.size = value, info.ModTime(), info.Size()
+
+	return nil
+}
+
+// tokensFromConfig builds tokens from TRADEBOT_TOKEN and TRADEBOT_MARKET_DATA_TOKEN
+// or their *_FILE variants; a file wins over a value. No token is required with SDK_INSECURE,
+// a local server such as cmd/fake-api does not check it.
+func tokensFromConfig() (trading, marketData *Token) {
+	cnf := config.TradeBotConfig()
+	logger := loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID())
+
+	trading, err := configuredToken(cnf.Token, cnf.TokenFile)
+	if err != nil {
+		logger.Fatalf("please check TRADEBOT_TOKEN_FILE env variable: %v", err)
+	}
+	marketData, err = configuredToken(cnf.MarketDataToken, cnf.MarketDataTokenFile)
+	if err != nil {
+		logger.Fatalf("please check TRADEBOT_MARKET_DATA_TOKEN_FILE env variable: %v", err)
+	}
+	if trading == nil && marketData == nil && !config.SdkConfig().Insecure {
+		logger.Fatalf("please set TRADEBOT_TOKEN or TRADEBOT_MARKET_DATA_TOKEN env variable (or their *_FILE variants)")
+	}
+
+	return trading, marketData
+}
+
+func configuredToken(value, path string) (*Token, error) {
+	if path != "" {
+		return NewFileToken(path)
+	}
+	if value != "" {
+		return NewToken(value), nil
+	}
+
+	return nil, nil
+}
+
+// tokenForMethod returns trading token for trading services and market data token for the rest;
+// either is used if the other one is not set.
+func tokenForMethod(method string, trading, marketData *Token) *Token {
+	service, _ := splitMethodName(method)
+	if (tradingServices[service] && trading != nil) || marketData == nil {
+		return trading
+	}
+
+	return marketData
+}
+
+// authorize attaches authorization header with the token of the method, if there is one.
+func authorize(ctx context.Context, method string, trading, marketData *Token) (context.Context, error) {
+	token := tokenForMethod(method, trading, marketData)
+	if token == nil {
+		return ctx, nil
+	}
+
+	value, err := token.Value()
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "can not get %s: %v", token, err)
+	}
+
+	return grpcMetadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+value), nil
+}
+
+// authUnaryInterceptor authorizes every attempt of a call, so a reloaded token is picked up by retries.
+func authUnaryInterceptor(trading, marketData *Token) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := authorize(ctx, method, trading, marketData)
+		if err != nil {
+			return err
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// authStreamInterceptor authorizes every stream, reconnects included.
+func authStreamInterceptor(trading, marketData *Token) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := authorize(ctx, method, trading, marketData)
+		if err != nil {
+			return nil, err
+		}
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,116 @@
+package sdk
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const marketDataMethod = "/tinkoff.public.invest.api.contract.v1.MarketDataService/GetCandles"
+
+func writeTokenFile(t *testing.T, path, value string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+		t.Fatalf("can not write token file: %v", err)
+	}
+}
+
+func TestFileTokenReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, path, " t.first-token-value\n")
+
+	token, err := NewFileToken(path)
+	if err != nil {
+		t.Fatalf("can not read token file: %v", err)
+	}
+	if value, _ := token.Value(); value != "t.first-token-value" {
+		t.Errorf("Value() = %q, want trimmed token", value)
+	}
+	if strings.Contains(token.String(), "first") {
+		t.Errorf("String() = %q reveals the token", token.String())
+	}
+
+	writeTokenFile(t, path, "t.second-token-value")
+	if value, _ := token.Value(); value != "t.first-token-value" {
+		t.Errorf("token file must not be checked more often than %s, got %q", tokenCheckInterval, value)
+	}
+
+	token.checked = time.Time{}
+	token.modTime = time.Time{} // the file may be rewritten within the same mtime tick
+	if value, _ := token.Value(); value != "t.second-token-value" {
+		t.Errorf("changed token file must be reloaded, got %q", value)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("can not remove token file: %v", err)
+	}
+	token.checked = time.Time{}
+	if value, err := token.Value(); err != nil || value != "t.second-token-value" {
+		t.Errorf("the last token must be kept when the file is gone, got %q, %v", value, err)
+	}
+}
+
+func TestFileTokenMustNotBeEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, path, "\n")
+
+	if _, err := NewFileToken(path); err == nil {
+		t.Error("empty token file must be rejected")
+	}
+	if _, err := NewFileToken(path + ".missing"); err == nil {
+		t.Error("missing token file must be rejected")
+	}
+}
+
+func TestTokenForMethod(t *testing.T) {
+	trading, marketData := NewToken("t.trading-token"), NewToken("t.market-data-token")
+
+	tests := []struct {
+		method              string
+		trading, marketData *Token
+		want                *Token
+	}{
+		{postOrderMethod, trading, marketData, trading},
+		{marketDataMethod, trading, marketData, marketData},
+		{marketDataMethod, trading, nil, trading},
+		{postOrderMethod, nil, marketData, marketData},
+	}
+
+	for _, tt := range tests {
+		if got := tokenForMethod(tt.method, tt.trading, tt.marketData); got != tt.want {
+			t.Errorf("tokenForMethod(%s) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := authUnaryInterceptor(NewToken("t.trading-token"), NewToken("t.market-data-token"))
+
+	var header []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		header = md.Get("authorization")
+		return nil
+	}
+
+	if err := interceptor(context.Background(), postOrderMethod, nil, nil, nil, invoker); err != nil {
+		t.Fatalf("can not authorize: %v", err)
+	}
+	if len(header) != 1 || header[0] != "Bearer t.trading-token" {
+		t.Errorf("PostOrder must be sent with trading token, got %v", header)
+	}
+
+	if err := interceptor(context.Background(), marketDataMethod, nil, nil, nil, invoker); err != nil {
+		t.Fatalf("can not authorize: %v", err)
+	}
+	if len(header) != 1 || header[0] != "Bearer t.market-data-token" {
+		t.Errorf("GetCandles must be sent with market data token, got %v", header)
+	}
+}
@@ -2,98 +2,73 @@
 package sdk
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
-	"github.com/elkopass/BITA/internal/metrics"
+	"context"
 	pb "github.com/elkopass/BITA/internal/proto"
+	"google.golang.org/grpc"
 )
 
 type UsersServiceClient interface {
 	// The method of receiving user accounts.
-	GetAccounts() ([]*pb.Account, error)
+	GetAccounts(ctx context.Context) ([]*pb.Account, error)
 	// Calculation of margin indicators on the account.
-	GetMarginAttributes(accountID string) (*pb.GetMarginAttributesResponse, error)
+	GetMarginAttributes(ctx context.Context, accountID string) (*pb.GetMarginAttributesResponse, error)
 	// Request for the user's tariff.
-	GetUserTariff() (*pb.GetUserTariffResponse, error)
+	GetUserTariff(ctx context.Context) (*pb.GetUserTariffResponse, error)
 	// The method of obtaining information about the user.
-	GetInfo() (*pb.GetInfoResponse, error)
+	GetInfo(ctx context.Context) (*pb.GetInfoResponse, error)
 }
 
 type UsersService struct {
 	client pb.UsersServiceClient
 }
 
-func NewUsersService() *UsersService {
-	conn, err := createClientConn()
-	if err != nil {
-		loggy.GetLogger().Sugar().Fatal(err.Error())
-	}
-
+func NewUsersService(conn grpc.ClientConnInterface) *UsersService {
 	client := pb.NewUsersServiceClient(conn)
 	return &UsersService{client: client}
 }
 
-func (us UsersService) GetAccounts() ([]*pb.Account, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (us UsersService) GetAccounts(ctx context.Context) ([]*pb.Account, error) {
+	ctx = createRequestContext(ctx)
 
-	us.incrementRequestsCounter("GetAccounts")
 	res, err := us.client.GetAccounts(ctx, &pb.GetAccountsRequest{})
 	if err != nil {
-		us.incrementApiCallErrors("GetAccounts", err.Error())
 		return nil, err
 	}
 
 	return res.Accounts, nil
 }
 
-func (us UsersService) GetMarginAttributes(accountID string) (*pb.GetMarginAttributesResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (us UsersService) GetMarginAttributes(ctx context.Context, accountID string) (*pb.GetMarginAttributesResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	us.incrementRequestsCounter("GetMarginAttributes")
 	res, err := us.client.GetMarginAttributes(ctx, &pb.GetMarginAttributesRequest{
 		AccountId: accountID,
 	})
 	if err != nil {
-		us.incrementApiCallErrors("GetMarginAttributes", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
 
-func (us UsersService) GetUserTariff() (*pb.GetUserTariffResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (us UsersService) GetUserTariff(ctx context.Context) (*pb.GetUserTariffResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	us.incrementRequestsCounter("GetUserTariff")
 	res, err := us.client.GetUserTariff(ctx, &pb.GetUserTariffRequest{})
 	if err != nil {
-		us.incrementApiCallErrors("GetUserTariff", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
 
-func (us UsersService) GetInfo() (*pb.GetInfoResponse, error) {
-	ctx, cancel := createRequestContext()
-	defer cancel()
+func (us UsersService) GetInfo(ctx context.Context) (*pb.GetInfoResponse, error) {
+	ctx = createRequestContext(ctx)
 
-	us.incrementRequestsCounter("GetInfo")
 	res, err := us.client.GetInfo(ctx, &pb.GetInfoRequest{})
 	if err != nil {
-		us.incrementApiCallErrors("GetInfo", err.Error())
 		return nil, err
 	}
 
 	return res, nil
 }
-
-func (us UsersService) incrementRequestsCounter(method string) {
-	metrics.ApiRequests.WithLabelValues(loggy.GetBotID(), "UsersService", method).Inc()
-}
-
-func (us UsersService) incrementApiCallErrors(method string, error string) {
-	metrics.ApiCallErrors.WithLabelValues(loggy.GetBotID(), "UsersService", method, error).Inc()
-}
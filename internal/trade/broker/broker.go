@@ -0,0 +1,47 @@
+// Package broker stores trade.Broker implementations for every environment.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+)
+
+const (
+	SANDBOX    = "sandbox"
+	PRODUCTION = "production"
+	SIMULATED  = "simulated"
+)
+
+// Kind returns broker chosen in configuration; IsSandbox is used if broker is not set.
+func Kind() string {
+	cnf := config.TradeBotConfig()
+	if cnf.Broker != "" {
+		return cnf.Broker
+	}
+	if cnf.IsSandbox {
+		return SANDBOX
+	}
+
+	return PRODUCTION
+}
+
+// New creates broker chosen in configuration.
+func New(ctx context.Context, services *sdk.ServicePool) (trade.Broker, error) {
+	switch Kind() {
+	case SANDBOX:
+		opts, err := SandboxOptionsFromConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewSandboxBroker(ctx, services, opts)
+	case PRODUCTION:
+		return NewProductionBroker(config.TradeBotConfig().AccountID, services), nil
+	case SIMULATED:
+		return NewSimulatedBroker(services, config.TradeBotConfig().SimulatedBalance), nil
+	}
+
+	return nil, fmt.Errorf("unknown broker '%s'", Kind())
+}
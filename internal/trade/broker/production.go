@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+// ProductionBroker trades on a real account with real money.
+type ProductionBroker struct {
+	accountID string
+	services  *sdk.ServicePool
+}
+
+func NewProductionBroker(accountID string, services *sdk.ServicePool) *ProductionBroker {
+	return &ProductionBroker{accountID: accountID, services: services}
+}
+
+func (b ProductionBroker) Name() string {
+	return PRODUCTION
+}
+
+func (b ProductionBroker) AccountID() string {
+	return b.accountID
+}
+
+func (b ProductionBroker) PostOrder(ctx context.Context, order *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	order.AccountId = b.accountID
+	return b.services.OrdersService.PostOrder(ctx, order)
+}
+
+func (b ProductionBroker) CancelOrder(ctx context.Context, orderID string) (*timestamp.Timestamp, error) {
+	return b.services.OrdersService.CancelOrder(ctx, b.accountID, orderID)
+}
+
+func (b ProductionBroker) GetOrderState(ctx context.Context, orderID string) (*pb.OrderState, error) {
+	return b.services.OrdersService.GetOrderState(ctx, b.accountID, orderID)
+}
+
+func (b ProductionBroker) GetOrders(ctx context.Context) ([]*pb.OrderState, error) {
+	return b.services.OrdersService.GetOrders(ctx, b.accountID)
+}
+
+func (b ProductionBroker) PostStopOrder(ctx context.Context, stopOrder *pb.PostStopOrderRequest) (string, error) {
+	stopOrder.AccountId = b.accountID
+	return b.services.StopOrdersService.PostStopOrder(ctx, stopOrder)
+}
+
+func (b ProductionBroker) CancelStopOrder(ctx context.Context, stopOrderID string) (*timestamp.Timestamp, error) {
+	return b.services.StopOrdersService.CancelStopOrder(ctx, b.accountID, stopOrderID)
+}
+
+func (b ProductionBroker) GetStopOrders(ctx context.Context) ([]*pb.StopOrder, error) {
+	return b.services.StopOrdersService.GetStopOrders(ctx, b.accountID)
+}
+
+func (b ProductionBroker) GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error) {
+	return b.services.OperationsService.GetPortfolio(ctx, b.accountID)
+}
+
+func (b ProductionBroker) GetPositions(ctx context.Context) (*pb.PositionsResponse, error) {
+	return b.services.OperationsService.GetPositions(ctx, b.accountID)
+}
+
+func (b ProductionBroker) GetOperations(ctx context.Context, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error) {
+	return b.services.OperationsService.GetOperations(ctx, b.accountID, from, to, state, figi)
+}
+
+// Close does nothing: production account outlives the bot.
+func (b ProductionBroker) Close(context.Context) error {
+	return nil
+}
@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	"github.com/elkopass/BITA/internal/loggy"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+)
+
+// SandboxOptions describe sandbox account lifecycle, see config.SandboxConfig.
+type SandboxOptions struct {
+	AccountID   string            // reuse account with this ID, it must exist; a new one is opened otherwise
+	Reset       bool              // cancel active orders and sell all positions of a reused account
+	PayIn       []tradeutil.Money // balances the account is topped up to on start
+	CloseOnExit bool              // close the opened account in Close, all its money and positions are lost
+}
+
+// SandboxOptionsFromConfig converts config.SandboxConfig.
+func SandboxOptionsFromConfig() (SandboxOptions, error) {
+	cnf := config.SandboxConfig()
+	opts := SandboxOptions{
+		AccountID:   cnf.AccountID,
+		Reset:       cnf.Reset,
+		CloseOnExit: cnf.CloseOnExit,
+	}
+
+	for currency, amount := range cnf.PayIn {
+		value, err := tradeutil.ParseDecimal(amount)
+		if err != nil || value.Sign() < 0 {
+			return opts, fmt.Errorf("invalid SANDBOX_PAY_IN amount '%s' for %s", amount, currency)
+		}
+		opts.PayIn = append(opts.PayIn, tradeutil.NewMoney(value, strings.ToLower(currency)))
+	}
+	sort.Slice(opts.PayIn, func(i, j int) bool {
+		return opts.PayIn[i].Currency < opts.PayIn[j].Currency
+	})
+
+	return opts, nil
+}
+
+// SandboxBroker trades on a sandbox account, opened for the bot lifetime or reused between runs.
+type SandboxBroker struct {
+	accountID   string
+	reused      bool // the account is passed by SANDBOX_ACCOUNT_ID, it is never closed
+	closeOnExit bool
+	services    *sdk.ServicePool
+	logger      *zap.SugaredLogger
+}
+
+// NewSandboxBroker finds or opens a sandbox account, resets and funds it as opts say.
+func NewSandboxBroker(ctx context.Context, services *sdk.ServicePool, opts SandboxOptions) (*SandboxBroker, error) {
+	b := &SandboxBroker{
+		closeOnExit: opts.CloseOnExit,
+		services:    services,
+		logger:      loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()),
+	}
+
+	if err := b.findAccount(ctx, opts.AccountID); err != nil {
+		return nil, err
+	}
+	reused := b.reused
+	if reused && opts.Reset {
+		if err := b.reset(ctx); err != nil {
+			return nil, fmt.Errorf("can not reset account %s: %v", b.accountID, err)
+		}
+	}
+	if err := b.fund(ctx, opts.PayIn, reused && opts.Reset); err != nil {
+		return nil, fmt.Errorf("can not fund account %s: %v", b.accountID, err)
+	}
+
+	return b, nil
+}
+
+// findAccount sets accountID of the reused account with accountID or of a new one if it is empty;
+// API does not let name sandbox accounts, so they can be reused by ID only.
+func (b *SandboxBroker) findAccount(ctx context.Context, accountID string) error {
+	if accountID != "" {
+		accounts, err := b.services.SandboxService.GetSandboxAccounts(ctx)
+		if err != nil {
+			return fmt.Errorf("can not get accounts: %v", err)
+		}
+
+		for _, acc := range accounts {
+			if acc.Id == accountID {
+				b.accountID = acc.Id
+				b.reused = true
+				b.logger.Infof("reusing sandbox account %s", acc.Id)
+				return nil
+			}
+		}
+		return fmt.Errorf("sandbox account %s not found", accountID)
+	}
+
+	accountID, err := b.services.SandboxService.OpenSandboxAccount(ctx)
+	if err != nil {
+		return fmt.Errorf("can not create account: %v", err)
+	}
+	b.accountID = accountID
+	b.logger.Infof("sandbox account %s opened, set SANDBOX_ACCOUNT_ID to reuse it", accountID)
+
+	return nil
+}
+
+// reset cancels active orders and sells all securities at market price, sandbox fills such orders at once.
+func (b *SandboxBroker) reset(ctx context.Context) error {
+	orders, err := b.GetOrders(ctx)
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		if _, err := b.CancelOrder(ctx, o.OrderId); err != nil {
+			return fmt.Errorf("can not cancel order %s: %v", o.OrderId, err)
+		}
+	}
+
+	positions, err := b.GetPositions(ctx)
+	if err != nil {
+		return err
+	}
+	sold := 0
+	for _, p := range positions.Securities {
+		instrument, err := b.services.Instruments.ByFigi(ctx, p.Figi)
+		if err != nil {
+			return err
+		}
+
+		lots := p.Balance / int64(instrument.Lot)
+		if lots <= 0 {
+			continue
+		}
+		_, err = b.PostOrder(ctx, &pb.PostOrderRequest{
+			Figi:      p.Figi,
+			Quantity:  lots,
+			Direction: pb.OrderDirection_ORDER_DIRECTION_SELL,
+			OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+			OrderId:   uuid.New().String(),
+		})
+		if err != nil {
+			return fmt.Errorf("can not sell %s: %v", p.Figi, err)
+		}
+		sold++
+	}
+	b.logger.Infof("sandbox account %s reset: %d orders cancelled, %d positions sold",
+		b.accountID, len(orders), sold)
+
+	return nil
+}
+
+// fund pays in the difference between target and current balances; sandbox can not withdraw money,
+// so a bigger balance is only reported if exact balance was expected.
+func (b *SandboxBroker) fund(ctx context.Context, targets []tradeutil.Money, exact bool) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	positions, err := b.GetPositions(ctx)
+	if err != nil {
+		return err
+	}
+	balances := make(map[string]tradeutil.Decimal)
+	for _, m := range positions.Money {
+		balances[m.Currency] = tradeutil.DecimalFromMoneyValue(m)
+	}
+
+	for _, target := range targets {
+		balance := balances[target.Currency]
+		switch {
+		case balance.LessThan(target.Amount):
+			amount := tradeutil.NewMoney(target.Amount.Sub(balance), target.Currency)
+			if _, err := b.services.SandboxService.SandboxPayIn(ctx, b.accountID, amount.MoneyValue()); err != nil {
+				return err
+			}
+			b.logger.Infof("paid in %s, balance is %s", amount, target)
+		case exact && balance.GreaterThan(target.Amount):
+			b.logger.Warnf("balance %s is bigger than %s, sandbox money can not be withdrawn",
+				tradeutil.NewMoney(balance, target.Currency), target)
+		}
+	}
+
+	return nil
+}
+
+func (b SandboxBroker) Name() string {
+	return SANDBOX
+}
+
+func (b SandboxBroker) AccountID() string {
+	return b.accountID
+}
+
+func (b SandboxBroker) PostOrder(ctx context.Context, order *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	order.AccountId = b.accountID
+	return b.services.SandboxService.PostSandboxOrder(ctx, order)
+}
+
+func (b SandboxBroker) CancelOrder(ctx context.Context, orderID string) (*timestamp.Timestamp, error) {
+	return b.services.SandboxService.CancelSandboxOrder(ctx, b.accountID, orderID)
+}
+
+func (b SandboxBroker) GetOrderState(ctx context.Context, orderID string) (*pb.OrderState, error) {
+	return b.services.SandboxService.GetSandboxOrderState(ctx, b.accountID, orderID)
+}
+
+func (b SandboxBroker) GetOrders(ctx context.Context) ([]*pb.OrderState, error) {
+	return b.services.SandboxService.GetSandboxOrders(ctx, b.accountID)
+}
+
+// PostStopOrder returns trade.ErrStopOrdersNotSupported: sandbox has no stop orders.
+func (b SandboxBroker) PostStopOrder(context.Context, *pb.PostStopOrderRequest) (string, error) {
+	return "", trade.ErrStopOrdersNotSupported
+}
+
+func (b SandboxBroker) CancelStopOrder(context.Context, string) (*timestamp.Timestamp, error) {
+	return nil, trade.ErrStopOrdersNotSupported
+}
+
+func (b SandboxBroker) GetStopOrders(context.Context) ([]*pb.StopOrder, error) {
+	return nil, trade.ErrStopOrdersNotSupported
+}
+
+func (b SandboxBroker) GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error) {
+	return b.services.SandboxService.GetSandboxPortfolio(ctx, b.accountID)
+}
+
+func (b SandboxBroker) GetPositions(ctx context.Context) (*pb.PositionsResponse, error) {
+	return b.services.SandboxService.GetSandboxPositions(ctx, b.accountID)
+}
+
+func (b SandboxBroker) GetOperations(ctx context.Context, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error) {
+	return b.services.SandboxService.GetSandboxOperations(ctx, &pb.OperationsRequest{
+		AccountId: b.accountID,
+		From:      from,
+		To:        to,
+		State:     state,
+		Figi:      figi,
+	})
+}
+
+// Close closes sandbox account opened on start if CloseOnExit is set, all its money and positions
+// are lost; an account reused by SANDBOX_ACCOUNT_ID is never closed, so the next run finds it.
+func (b SandboxBroker) Close(ctx context.Context) error {
+	if b.reused {
+		b.logger.Infof("reused sandbox account %s is kept", b.accountID)
+		return nil
+	}
+	if !b.closeOnExit {
+		b.logger.Infof("sandbox account %s is kept, set SANDBOX_ACCOUNT_ID to reuse it", b.accountID)
+		return nil
+	}
+
+	return b.services.SandboxService.CloseSandboxAccount(ctx, b.accountID)
+}
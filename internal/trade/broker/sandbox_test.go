@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"testing"
+)
+
+// newSandboxServices returns services of a fake server with the share newTestBroker trades.
+func newSandboxServices(t *testing.T) *sdk.ServicePool {
+	server := fake.NewServer()
+	server.AddShare(&pb.Share{Figi: testFigi, Ticker: "SBER", ClassCode: "TQBR", Lot: 10, Currency: "rub"})
+	setBook(server, 99, 100)
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client.ServicePool()
+}
+
+func rubles(units int64) []tradeutil.Money {
+	return []tradeutil.Money{tradeutil.NewMoney(tradeutil.DecimalFromInt(units), "rub")}
+}
+
+func sandboxBalance(t *testing.T, b *SandboxBroker) (rub int64, securities int) {
+	t.Helper()
+
+	positions, err := b.GetPositions(context.Background())
+	if err != nil {
+		t.Fatalf("can not get positions: %v", err)
+	}
+	for _, m := range positions.Money {
+		if m.Currency == "rub" {
+			rub = m.Units
+		}
+	}
+	for _, s := range positions.Securities {
+		if s.Balance != 0 {
+			securities++
+		}
+	}
+
+	return rub, securities
+}
+
+func sandboxAccountExists(t *testing.T, services *sdk.ServicePool, accountID string) bool {
+	t.Helper()
+
+	accounts, err := services.SandboxService.GetSandboxAccounts(context.Background())
+	if err != nil {
+		t.Fatalf("can not get accounts: %v", err)
+	}
+	for _, acc := range accounts {
+		if acc.Id == accountID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSandboxBrokerOpensAccount(t *testing.T) {
+	services := newSandboxServices(t)
+	ctx := context.Background()
+
+	b, err := NewSandboxBroker(ctx, services, SandboxOptions{PayIn: rubles(10000), CloseOnExit: true})
+	if err != nil {
+		t.Fatalf("can not open sandbox broker: %v", err)
+	}
+	if rub, _ := sandboxBalance(t, b); rub != 10000 {
+		t.Errorf("new account balance = %d rub, want 10000", rub)
+	}
+
+	if err := b.Close(ctx); err != nil {
+		t.Fatalf("can not close sandbox broker: %v", err)
+	}
+	if sandboxAccountExists(t, services, b.AccountID()) {
+		t.Error("account must be closed on exit")
+	}
+}
+
+func TestSandboxBrokerReusesAccount(t *testing.T) {
+	services := newSandboxServices(t)
+	ctx := context.Background()
+
+	first, err := NewSandboxBroker(ctx, services, SandboxOptions{PayIn: rubles(10000)})
+	if err != nil {
+		t.Fatalf("can not open sandbox broker: %v", err)
+	}
+	_, err = first.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  2,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if err != nil {
+		t.Fatalf("can not buy: %v", err)
+	}
+	if err := first.Close(ctx); err != nil {
+		t.Fatalf("can not close sandbox broker: %v", err)
+	}
+
+	second, err := NewSandboxBroker(ctx, services, SandboxOptions{
+		AccountID:   first.AccountID(),
+		Reset:       true,
+		PayIn:       rubles(10000),
+		CloseOnExit: true,
+	})
+	if err != nil {
+		t.Fatalf("can not reuse sandbox account: %v", err)
+	}
+	if second.AccountID() != first.AccountID() {
+		t.Errorf("reused account ID = %s, want %s", second.AccountID(), first.AccountID())
+	}
+	// 2 lots bought by 100 and sold by 99 leave 9980, topped up to 10000
+	if rub, securities := sandboxBalance(t, second); rub != 10000 || securities != 0 {
+		t.Errorf("reset account has %d rub and %d securities, want 10000 rub and none", rub, securities)
+	}
+
+	if err := second.Close(ctx); err != nil {
+		t.Fatalf("can not close sandbox broker: %v", err)
+	}
+	if !sandboxAccountExists(t, services, second.AccountID()) {
+		t.Error("reused account must never be closed")
+	}
+}
+
+func TestSandboxBrokerFailsWithUnknownAccount(t *testing.T) {
+	services := newSandboxServices(t)
+
+	if _, err := NewSandboxBroker(context.Background(), services, SandboxOptions{AccountID: "unknown"}); err == nil {
+		t.Error("unknown account ID must be an error")
+	}
+}
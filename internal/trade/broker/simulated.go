@@ -0,0 +1,582 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sort"
+	"sync"
+)
+
+// simulatedCurrency is the only currency of a SimulatedBroker account: portfolio totals
+// are single amounts, which could not sum positions of different currencies.
+const simulatedCurrency = "rub"
+
+// SimulatedBroker keeps an imaginary account in memory and fills orders
+// by real market data: market orders at the best opposite price of the
+// order book, limit orders once the order book crosses their price.
+// Stop orders are checked against the last price when they are listed.
+type SimulatedBroker struct {
+	services *sdk.ServicePool
+
+	mu          sync.Mutex
+	orders      map[string]*pb.OrderState
+	stopOrders  map[string]*pb.StopOrder
+	money       map[string]tradeutil.Decimal // currency -> amount
+	positions   map[string]int64             // figi -> quantity in pieces
+	averages    map[string]tradeutil.Decimal // figi -> average position price
+	operations  []*pb.Operation
+	instruments map[string]*pb.Instrument // figi -> instrument cache
+}
+
+// NewSimulatedBroker creates an account with balance rubles on it.
+func NewSimulatedBroker(services *sdk.ServicePool, balance int) *SimulatedBroker {
+	return &SimulatedBroker{
+		services:    services,
+		orders:      make(map[string]*pb.OrderState),
+		stopOrders:  make(map[string]*pb.StopOrder),
+		money:       map[string]tradeutil.Decimal{simulatedCurrency: tradeutil.DecimalFromInt(int64(balance))},
+		positions:   make(map[string]int64),
+		averages:    make(map[string]tradeutil.Decimal),
+		instruments: make(map[string]*pb.Instrument),
+	}
+}
+
+func (b *SimulatedBroker) Name() string {
+	return SIMULATED
+}
+
+func (b *SimulatedBroker) AccountID() string {
+	return SIMULATED
+}
+
+func (b *SimulatedBroker) PostOrder(ctx context.Context, order *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	b.mu.Lock()
+	state, ok := b.orders[order.OrderId]
+	if ok {
+		defer b.mu.Unlock()
+		return orderStateToResponse(state), nil // same order ID means the same order, like in API
+	}
+	b.mu.Unlock()
+
+	instrument, err := b.instrument(ctx, order.Figi)
+	if err != nil {
+		return nil, err
+	}
+	if instrument.Currency != simulatedCurrency {
+		return nil, fmt.Errorf("simulated account has %s only, %s is traded for %s",
+			simulatedCurrency, order.Figi, instrument.Currency)
+	}
+	book, err := b.services.MarketDataService.GetOrderBook(ctx, order.Figi, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	orderID := order.OrderId
+	if orderID == "" {
+		orderID = uuid.New().String()
+	}
+
+	price := tradeutil.DecimalFromQuotation(order.Price)
+	if order.OrderType == pb.OrderType_ORDER_TYPE_MARKET {
+		price = marketPrice(book, order.Direction)
+	}
+	amount, err := price.MulInt(order.Quantity * int64(instrument.Lot))
+	if err != nil {
+		return nil, fmt.Errorf("can not calculate order amount: %w", err)
+	}
+
+	state = &pb.OrderState{
+		OrderId:               orderID,
+		ExecutionReportStatus: pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		LotsRequested:         order.Quantity,
+		InitialOrderPrice:     amount.MoneyValue(instrument.Currency),
+		InitialSecurityPrice:  price.MoneyValue(instrument.Currency),
+		Figi:                  order.Figi,
+		Direction:             order.Direction,
+		Currency:              instrument.Currency,
+		OrderType:             order.OrderType,
+		OrderDate:             timestamppb.Now(),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if order.OrderType == pb.OrderType_ORDER_TYPE_MARKET || crosses(book, order.Direction, price) {
+		if err := b.fill(state, instrument, price); err != nil {
+			return nil, err
+		}
+	}
+	b.orders[orderID] = state
+
+	return orderStateToResponse(state), nil
+}
+
+func (b *SimulatedBroker) CancelOrder(_ context.Context, orderID string) (*timestamp.Timestamp, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.orders[orderID]
+	if !ok || state.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+		return nil, sdk.NewApiError("SimulatedBroker.CancelOrder", codes.NotFound, "50005", "Order not found") // same as API for unknown or inactive orders
+	}
+
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return timestamppb.Now(), nil
+}
+
+// GetOrderState also tries to fill active limit order by current order book.
+func (b *SimulatedBroker) GetOrderState(ctx context.Context, orderID string) (*pb.OrderState, error) {
+	b.mu.Lock()
+	state, ok := b.orders[orderID]
+	b.mu.Unlock()
+	if !ok {
+		return nil, sdk.NewApiError("SimulatedBroker.GetOrderState", codes.NotFound, "50005", "Order not found")
+	}
+
+	if err := b.tryToFill(ctx, state); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return proto.Clone(state).(*pb.OrderState), nil
+}
+
+func (b *SimulatedBroker) GetOrders(ctx context.Context) ([]*pb.OrderState, error) {
+	b.mu.Lock()
+	var active []*pb.OrderState
+	for _, state := range b.orders {
+		if state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+			active = append(active, state)
+		}
+	}
+	b.mu.Unlock()
+
+	var orders []*pb.OrderState
+	for _, state := range active {
+		if err := b.tryToFill(ctx, state); err != nil {
+			return nil, err
+		}
+
+		b.mu.Lock()
+		if state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+			orders = append(orders, proto.Clone(state).(*pb.OrderState))
+		}
+		b.mu.Unlock()
+	}
+
+	return orders, nil
+}
+
+func (b *SimulatedBroker) PostStopOrder(ctx context.Context, stopOrder *pb.PostStopOrderRequest) (string, error) {
+	instrument, err := b.instrument(ctx, stopOrder.Figi)
+	if err != nil {
+		return "", err
+	}
+
+	price := stopOrder.Price
+	if price == nil {
+		price = &pb.Quotation{}
+	}
+	so := &pb.StopOrder{
+		StopOrderId:    uuid.New().String(),
+		LotsRequested:  stopOrder.Quantity,
+		Figi:           stopOrder.Figi,
+		Direction:      stopOrder.Direction,
+		Currency:       instrument.Currency,
+		OrderType:      stopOrder.StopOrderType,
+		CreateDate:     timestamppb.Now(),
+		ExpirationTime: stopOrder.ExpireDate,
+		Price:          tradeutil.DecimalFromQuotation(price).MoneyValue(instrument.Currency),
+		StopPrice:      tradeutil.DecimalFromQuotation(stopOrder.StopPrice).MoneyValue(instrument.Currency),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopOrders[so.StopOrderId] = so
+	return so.StopOrderId, nil
+}
+
+func (b *SimulatedBroker) CancelStopOrder(_ context.Context, stopOrderID string) (*timestamp.Timestamp, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.stopOrders[stopOrderID]; !ok {
+		return nil, sdk.NewApiError("SimulatedBroker.CancelStopOrder", codes.NotFound, "50006", "Stop-order not found")
+	}
+
+	delete(b.stopOrders, stopOrderID)
+	return timestamppb.Now(), nil
+}
+
+// GetStopOrders also triggers stop orders activated by the last price: they become
+// market (or limit for stop-limit) orders and disappear from the list, like in API.
+func (b *SimulatedBroker) GetStopOrders(ctx context.Context) ([]*pb.StopOrder, error) {
+	b.mu.Lock()
+	var figi []string
+	seen := make(map[string]bool)
+	for _, so := range b.stopOrders {
+		if !seen[so.Figi] {
+			seen[so.Figi] = true
+			figi = append(figi, so.Figi)
+		}
+	}
+	b.mu.Unlock()
+
+	if len(figi) > 0 {
+		prices, err := b.services.MarketDataService.GetLastPrices(ctx, figi)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range prices {
+			b.triggerStopOrders(ctx, p.Figi, tradeutil.DecimalFromQuotation(p.Price))
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var stopOrders []*pb.StopOrder
+	for _, so := range b.stopOrders {
+		stopOrders = append(stopOrders, proto.Clone(so).(*pb.StopOrder))
+	}
+
+	return stopOrders, nil
+}
+
+func (b *SimulatedBroker) GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error) {
+	b.mu.Lock()
+	var figi []string
+	for f, quantity := range b.positions {
+		if quantity != 0 {
+			figi = append(figi, f)
+		}
+	}
+	b.mu.Unlock()
+	sort.Strings(figi)
+
+	lastPrices := make(map[string]tradeutil.Decimal)
+	if len(figi) > 0 {
+		prices, err := b.services.MarketDataService.GetLastPrices(ctx, figi)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range prices {
+			lastPrices[p.Figi] = tradeutil.DecimalFromQuotation(p.Price)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	totals := make(map[string]tradeutil.Decimal)
+	var expectedYield tradeutil.Decimal
+	res := &pb.PortfolioResponse{}
+	for _, f := range figi {
+		instrument := b.instruments[f]
+		quantity := b.positions[f]
+		average := b.averages[f]
+		current, ok := lastPrices[f]
+		if !ok {
+			current = average
+		}
+
+		positionYield, err := current.Sub(average).MulInt(quantity)
+		if err != nil {
+			return nil, fmt.Errorf("can not calculate yield of %s: %w", f, err)
+		}
+		amount, err := current.MulInt(quantity)
+		if err != nil {
+			return nil, fmt.Errorf("can not calculate amount of %s: %w", f, err)
+		}
+		expectedYield = expectedYield.Add(positionYield)
+		totals[instrument.InstrumentType] = totals[instrument.InstrumentType].Add(amount)
+
+		res.Positions = append(res.Positions, &pb.PortfolioPosition{
+			Figi:                 f,
+			InstrumentType:       instrument.InstrumentType,
+			Quantity:             tradeutil.DecimalFromInt(quantity).Quotation(),
+			QuantityLots:         tradeutil.DecimalFromInt(quantity / int64(instrument.Lot)).Quotation(),
+			AveragePositionPrice: average.MoneyValue(instrument.Currency),
+			CurrentPrice:         current.MoneyValue(instrument.Currency),
+			ExpectedYield:        positionYield.Quotation(),
+		})
+	}
+
+	res.TotalAmountShares = totals["share"].MoneyValue(simulatedCurrency)
+	res.TotalAmountBonds = totals["bond"].MoneyValue(simulatedCurrency)
+	res.TotalAmountEtf = totals["etf"].MoneyValue(simulatedCurrency)
+	res.TotalAmountFutures = totals["futures"].MoneyValue(simulatedCurrency)
+	res.TotalAmountCurrencies = b.money[simulatedCurrency].MoneyValue(simulatedCurrency)
+	res.ExpectedYield = expectedYield.Quotation()
+
+	return res, nil
+}
+
+func (b *SimulatedBroker) GetPositions(context.Context) (*pb.PositionsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := &pb.PositionsResponse{}
+	for currency, amount := range b.money {
+		res.Money = append(res.Money, amount.MoneyValue(currency))
+	}
+	for figi, quantity := range b.positions {
+		if quantity != 0 {
+			res.Securities = append(res.Securities, &pb.PositionsSecurities{Figi: figi, Balance: quantity})
+		}
+	}
+
+	return res, nil
+}
+
+func (b *SimulatedBroker) GetOperations(_ context.Context, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var operations []*pb.Operation
+	for _, o := range b.operations {
+		if figi != "" && o.Figi != figi {
+			continue
+		}
+		if state != pb.OperationState_OPERATION_STATE_UNSPECIFIED && o.State != state {
+			continue
+		}
+		if from != nil && o.Date.AsTime().Before(from.AsTime()) {
+			continue
+		}
+		if to != nil && o.Date.AsTime().After(to.AsTime()) {
+			continue
+		}
+		operations = append(operations, o)
+	}
+
+	return operations, nil
+}
+
+// Close does nothing: simulated account disappears with the bot.
+func (b *SimulatedBroker) Close(context.Context) error {
+	return nil
+}
+
+// triggerStopOrders posts orders for stop orders of figi activated by price;
+// a rejected order is not retried, the same as in API.
+func (b *SimulatedBroker) triggerStopOrders(ctx context.Context, figi string, price tradeutil.Decimal) {
+	b.mu.Lock()
+	var activated []*pb.StopOrder
+	for id, so := range b.stopOrders {
+		if so.Figi == figi && stopOrderIsActivated(so, price) {
+			activated = append(activated, so)
+			delete(b.stopOrders, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, so := range activated {
+		order := &pb.PostOrderRequest{
+			Figi:      so.Figi,
+			Quantity:  so.LotsRequested,
+			Direction: pb.OrderDirection_ORDER_DIRECTION_SELL,
+			OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+			OrderId:   uuid.New().String(),
+		}
+		if so.Direction == pb.StopOrderDirection_STOP_ORDER_DIRECTION_BUY {
+			order.Direction = pb.OrderDirection_ORDER_DIRECTION_BUY
+		}
+		if so.OrderType == pb.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT {
+			order.OrderType = pb.OrderType_ORDER_TYPE_LIMIT
+			order.Price = tradeutil.DecimalFromMoneyValue(so.Price).Quotation()
+		}
+
+		_, _ = b.PostOrder(ctx, order)
+	}
+}
+
+// tryToFill fills active limit order if current order book crosses its price.
+func (b *SimulatedBroker) tryToFill(ctx context.Context, state *pb.OrderState) error {
+	b.mu.Lock()
+	active := state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW
+	b.mu.Unlock()
+	if !active {
+		return nil
+	}
+
+	book, err := b.services.MarketDataService.GetOrderBook(ctx, state.Figi, 1)
+	if err != nil {
+		return err
+	}
+
+	price := tradeutil.DecimalFromMoneyValue(state.InitialSecurityPrice)
+	if !crosses(book, state.Direction, price) {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if state.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+		return nil // cancelled or filled meanwhile
+	}
+	if err := b.fill(state, b.instruments[state.Figi], price); err != nil {
+		state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED
+	}
+
+	return nil
+}
+
+// fill must be called with b.mu held; it executes the whole order at price.
+func (b *SimulatedBroker) fill(state *pb.OrderState, instrument *pb.Instrument, price tradeutil.Decimal) error {
+	quantity := state.LotsRequested * int64(instrument.Lot)
+	amount, err := price.MulInt(quantity)
+	if err != nil {
+		return fmt.Errorf("can not calculate order amount: %w", err)
+	}
+	buy := state.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY
+
+	if buy && b.money[instrument.Currency].LessThan(amount) {
+		return sdk.NewApiError("SimulatedBroker.PostOrder", codes.InvalidArgument, "30042", "Not enough assets for a margin trade") // same as API
+	}
+	if !buy && b.positions[state.Figi] < quantity {
+		return sdk.NewApiError("SimulatedBroker.PostOrder", codes.InvalidArgument, "30042", "Not enough assets for a margin trade")
+	}
+
+	operationType := pb.OperationType_OPERATION_TYPE_SELL
+	payment := amount
+	if buy {
+		held := b.positions[state.Figi]
+		total, err := b.averages[state.Figi].MulInt(held)
+		if err != nil {
+			return fmt.Errorf("can not calculate position amount: %w", err)
+		}
+		average, err := total.Add(amount).Div(tradeutil.DecimalFromInt(held + quantity)) // quantity is never zero
+		if err != nil {
+			return fmt.Errorf("can not calculate average position price: %w", err)
+		}
+		b.averages[state.Figi] = average
+		b.positions[state.Figi] += quantity
+		b.money[instrument.Currency] = b.money[instrument.Currency].Sub(amount)
+
+		operationType = pb.OperationType_OPERATION_TYPE_BUY
+		payment = amount.Neg()
+	} else {
+		b.positions[state.Figi] -= quantity
+		b.money[instrument.Currency] = b.money[instrument.Currency].Add(amount)
+	}
+
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	state.LotsExecuted = state.LotsRequested
+	state.ExecutedOrderPrice = amount.MoneyValue(instrument.Currency)
+	state.TotalOrderAmount = amount.MoneyValue(instrument.Currency)
+	state.AveragePositionPrice = price.MoneyValue(instrument.Currency)
+	state.ExecutedCommission = tradeutil.Decimal{}.MoneyValue(instrument.Currency)
+
+	b.operations = append(b.operations, &pb.Operation{
+		Id:             uuid.New().String(),
+		Currency:       instrument.Currency,
+		Payment:        payment.MoneyValue(instrument.Currency),
+		Price:          price.MoneyValue(instrument.Currency),
+		State:          pb.OperationState_OPERATION_STATE_EXECUTED,
+		Quantity:       quantity,
+		Figi:           state.Figi,
+		InstrumentType: instrument.InstrumentType,
+		Date:           timestamppb.Now(),
+		Type:           operationType.String(),
+		OperationType:  operationType,
+	})
+
+	return nil
+}
+
+// instrument returns cached instrument, lot size and currency are needed for every order.
+func (b *SimulatedBroker) instrument(ctx context.Context, figi string) (*pb.Instrument, error) {
+	b.mu.Lock()
+	instrument, ok := b.instruments[figi]
+	b.mu.Unlock()
+	if ok {
+		return instrument, nil
+	}
+
+	instrument, err := b.services.InstrumentsService.GetInstrumentBy(ctx, pb.InstrumentRequest{
+		IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     figi,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not get instrument %s: %v", figi, err)
+	}
+	if instrument.Lot <= 0 {
+		instrument.Lot = 1
+	}
+
+	b.mu.Lock()
+	b.instruments[figi] = instrument
+	b.mu.Unlock()
+
+	return instrument, nil
+}
+
+// marketPrice returns the best opposite price of the order book, or last price if it is empty.
+func marketPrice(book *pb.GetOrderBookResponse, direction pb.OrderDirection) tradeutil.Decimal {
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY && len(book.Asks) > 0 {
+		return tradeutil.DecimalFromQuotation(book.Asks[0].Price)
+	}
+	if direction == pb.OrderDirection_ORDER_DIRECTION_SELL && len(book.Bids) > 0 {
+		return tradeutil.DecimalFromQuotation(book.Bids[0].Price)
+	}
+
+	return tradeutil.DecimalFromQuotation(book.LastPrice)
+}
+
+// stopOrderIsActivated reports whether stop order is triggered by the last price.
+func stopOrderIsActivated(so *pb.StopOrder, price tradeutil.Decimal) bool {
+	stopPrice := tradeutil.DecimalFromMoneyValue(so.StopPrice)
+	sell := so.Direction == pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+
+	if so.OrderType == pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT {
+		if sell {
+			return !price.LessThan(stopPrice)
+		}
+		return !price.GreaterThan(stopPrice)
+	}
+
+	// stop loss and stop limit
+	if sell {
+		return !price.GreaterThan(stopPrice)
+	}
+	return !price.LessThan(stopPrice)
+}
+
+// crosses reports whether limit order with price can be executed by the order book.
+func crosses(book *pb.GetOrderBookResponse, direction pb.OrderDirection, price tradeutil.Decimal) bool {
+	if direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		return len(book.Asks) > 0 && !tradeutil.DecimalFromQuotation(book.Asks[0].Price).GreaterThan(price)
+	}
+
+	return len(book.Bids) > 0 && !tradeutil.DecimalFromQuotation(book.Bids[0].Price).LessThan(price)
+}
+
+func orderStateToResponse(state *pb.OrderState) *pb.PostOrderResponse {
+	return &pb.PostOrderResponse{
+		OrderId:               state.OrderId,
+		ExecutionReportStatus: state.ExecutionReportStatus,
+		LotsRequested:         state.LotsRequested,
+		LotsExecuted:          state.LotsExecuted,
+		InitialOrderPrice:     state.InitialOrderPrice,
+		ExecutedOrderPrice:    state.ExecutedOrderPrice,
+		TotalOrderAmount:      state.TotalOrderAmount,
+		ExecutedCommission:    state.ExecutedCommission,
+		Figi:                  state.Figi,
+		Direction:             state.Direction,
+		InitialSecurityPrice:  state.InitialSecurityPrice,
+		OrderType:             state.OrderType,
+	}
+}
@@ -0,0 +1,207 @@
+package broker
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testFigi = "BBG004730N88"
+
+// TestMain sets the token config requires; tests talk to fake servers, which do not check it.
+func TestMain(m *testing.M) {
+	_ = os.Setenv("TRADEBOT_TOKEN", "test")
+	os.Exit(m.Run())
+}
+
+// newTestBroker returns a broker with 10000 rub and a share of 10 pieces per lot
+// traded by 99 bid and 100 ask.
+func newTestBroker(t *testing.T) (*fake.Server, *SimulatedBroker) {
+	server := fake.NewServer()
+	server.AddShare(&pb.Share{Figi: testFigi, Ticker: "SBER", ClassCode: "TQBR", Lot: 10, Currency: "rub"})
+	setBook(server, 99, 100)
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return server, NewSimulatedBroker(client.ServicePool(), 10000)
+}
+
+func setBook(server *fake.Server, bid, ask int64) {
+	server.SetOrderBook(&pb.GetOrderBookResponse{
+		Figi: testFigi,
+		Bids: []*pb.Order{{Price: &pb.Quotation{Units: bid}, Quantity: 100}},
+		Asks: []*pb.Order{{Price: &pb.Quotation{Units: ask}, Quantity: 100}},
+	})
+}
+
+func postOrder(b *SimulatedBroker, direction pb.OrderDirection, orderType pb.OrderType, lots, price int64) (*pb.PostOrderResponse, error) {
+	return b.PostOrder(context.Background(), &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  lots,
+		Price:     &pb.Quotation{Units: price},
+		Direction: direction,
+		AccountId: b.AccountID(),
+		OrderType: orderType,
+	})
+}
+
+func TestSimulatedBrokerMarketOrders(t *testing.T) {
+	server, b := newTestBroker(t)
+	ctx := context.Background()
+
+	order, err := postOrder(b, pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_MARKET, 2, 0)
+	if err != nil {
+		t.Fatalf("can not buy: %v", err)
+	}
+	if order.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL || order.LotsExecuted != 2 {
+		t.Errorf("market order must be filled at once, got %v", order)
+	}
+	if order.TotalOrderAmount.Units != 2000 {
+		t.Errorf("2 lots by ask 100 must cost 2000, got %v", order.TotalOrderAmount)
+	}
+
+	if _, err := postOrder(b, pb.OrderDirection_ORDER_DIRECTION_SELL, pb.OrderType_ORDER_TYPE_MARKET, 1, 0); err != nil {
+		t.Fatalf("can not sell: %v", err)
+	}
+
+	positions, err := b.GetPositions(ctx)
+	if err != nil {
+		t.Fatalf("can not get positions: %v", err)
+	}
+	if len(positions.Securities) != 1 || positions.Securities[0].Balance != 10 {
+		t.Errorf("expected 10 pieces left, got %v", positions.Securities)
+	}
+	if len(positions.Money) != 1 || positions.Money[0].Units != 10000-2000+990 {
+		t.Errorf("expected 8990 rub, got %v", positions.Money)
+	}
+
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 105})
+	portfolio, err := b.GetPortfolio(ctx)
+	if err != nil {
+		t.Fatalf("can not get portfolio: %v", err)
+	}
+	if len(portfolio.Positions) != 1 || portfolio.Positions[0].AveragePositionPrice.Units != 100 {
+		t.Fatalf("expected position bought by 100, got %v", portfolio.Positions)
+	}
+	if portfolio.TotalAmountShares.Units != 1050 || portfolio.ExpectedYield.Units != 50 {
+		t.Errorf("expected shares for 1050 with yield 50, got %v and %v", portfolio.TotalAmountShares, portfolio.ExpectedYield)
+	}
+
+	operations, err := b.GetOperations(ctx, nil, nil, pb.OperationState_OPERATION_STATE_EXECUTED, testFigi)
+	if err != nil || len(operations) != 2 {
+		t.Errorf("expected buy and sell operations, got %v, %v", operations, err)
+	}
+}
+
+func TestSimulatedBrokerLimitOrder(t *testing.T) {
+	server, b := newTestBroker(t)
+	ctx := context.Background()
+
+	order, err := postOrder(b, pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_LIMIT, 1, 98)
+	if err != nil {
+		t.Fatalf("can not post order: %v", err)
+	}
+	if order.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+		t.Fatalf("limit order below ask must wait, got %v", order)
+	}
+	if orders, err := b.GetOrders(ctx); err != nil || len(orders) != 1 {
+		t.Fatalf("expected one active order, got %v, %v", orders, err)
+	}
+
+	setBook(server, 97, 98)
+	state, err := b.GetOrderState(ctx, order.OrderId)
+	if err != nil {
+		t.Fatalf("can not get order state: %v", err)
+	}
+	if state.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL || state.AveragePositionPrice.Units != 98 {
+		t.Errorf("limit order must be filled by its price once the book crosses it, got %v", state)
+	}
+
+	if _, err := b.CancelOrder(ctx, order.OrderId); status.Code(err) != codes.NotFound {
+		t.Errorf("cancel of a filled order must fail with NotFound, got %v", err)
+	}
+}
+
+func TestSimulatedBrokerCancelOrder(t *testing.T) {
+	_, b := newTestBroker(t)
+	ctx := context.Background()
+
+	order, err := postOrder(b, pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_LIMIT, 1, 98)
+	if err != nil {
+		t.Fatalf("can not post order: %v", err)
+	}
+	if _, err := b.CancelOrder(ctx, order.OrderId); err != nil {
+		t.Fatalf("can not cancel order: %v", err)
+	}
+	if orders, err := b.GetOrders(ctx); err != nil || len(orders) != 0 {
+		t.Errorf("cancelled order must not be active, got %v, %v", orders, err)
+	}
+}
+
+func TestSimulatedBrokerRejectsOrders(t *testing.T) {
+	_, b := newTestBroker(t)
+
+	_, err := postOrder(b, pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_MARKET, 11, 0)
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != "30042" {
+		t.Errorf("buy over the balance must fail with 30042, got %v", err)
+	}
+	_, err = postOrder(b, pb.OrderDirection_ORDER_DIRECTION_SELL, pb.OrderType_ORDER_TYPE_MARKET, 1, 0)
+	if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != "30042" {
+		t.Errorf("sell without position must fail with 30042, got %v", err)
+	}
+}
+
+func TestSimulatedBrokerRejectsForeignCurrency(t *testing.T) {
+	server, b := newTestBroker(t)
+	const usdFigi = "BBG000B9XRY4"
+	server.AddShare(&pb.Share{Figi: usdFigi, Ticker: "AAPL", ClassCode: "SPBXM", Lot: 1, Currency: "usd"})
+	ctx := context.Background()
+
+	// portfolio totals are in rubles, a position in dollars would be summed with them
+	_, err := b.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      usdFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if err == nil || !strings.Contains(err.Error(), "usd") {
+		t.Fatalf("order of a usd instrument must be rejected for its currency, got %v", err)
+	}
+	if portfolio, err := b.GetPortfolio(ctx); err != nil || len(portfolio.Positions) != 0 {
+		t.Errorf("rejected order must not open a position, got %v, %v", portfolio, err)
+	}
+}
+
+func TestSimulatedBrokerOrderIDIsIdempotent(t *testing.T) {
+	_, b := newTestBroker(t)
+
+	req := &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+		OrderId:   "a5bd4b9c-5a0f-4dbf-8a5e-3c6d1e6b7f00",
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := b.PostOrder(context.Background(), req); err != nil {
+			t.Fatalf("can not post order: %v", err)
+		}
+	}
+
+	positions, err := b.GetPositions(context.Background())
+	if err != nil || len(positions.Securities) != 1 || positions.Securities[0].Balance != 10 {
+		t.Errorf("the same order ID must be executed once, got %v, %v", positions, err)
+	}
+}
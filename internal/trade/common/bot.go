@@ -0,0 +1,110 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/state"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// WorkerBot runs a Worker for every FIGI, see NewWorkerBot.
+type WorkerBot struct {
+	figi        []string
+	config      WorkerConfig
+	newSignals  func(w *Worker) Signals
+	client      *sdk.Client
+	broker      trade.Broker
+	services    *sdk.ServicePool
+	store       *state.Store
+	cancelFuncs []context.CancelFunc
+	logger      *zap.SugaredLogger
+}
+
+// NewWorkerBot creates WorkerBot running a worker for every FIGI with Signals returned by newSignals.
+func NewWorkerBot(client *sdk.Client, broker trade.Broker, figi []string, cnf WorkerConfig,
+	newSignals func(w *Worker) Signals) *WorkerBot {
+	return &WorkerBot{
+		figi:       figi,
+		config:     cnf,
+		newSignals: newSignals,
+		client:     client,
+		broker:     broker,
+		services:   client.ServicePool(),
+		store:      state.NewStoreFromConfig(),
+		logger:     loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()),
+	}
+}
+
+func (wb WorkerBot) Run(ctx context.Context) (err error) {
+	wb.logger.Infof("starting with %s strategy and sdk v%s", config.TradeBotConfig().Strategy, sdk.Version)
+
+	// replace logger
+	wb.logger = wb.logger.With("broker", wb.broker.Name()).With("account_id", wb.broker.AccountID())
+
+	positions, err := wb.reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	// order books and trading statuses are streamed, workers poll them only while the stream has none
+	subscriptions, err := wb.client.NewSubscriptionManager(ctx)
+	if err != nil {
+		wb.logger.Warnf("can not open market data stream, market data is polled: %v", err)
+	} else {
+		defer subscriptions.Close()
+		go LogStreamEvents(wb.logger, "market data stream", subscriptions.Events())
+	}
+
+	figi := wb.figi
+	wg := &sync.WaitGroup{}
+	wg.Add(len(figi))
+
+	for _, f := range figi {
+		workerCtx, cancel := context.WithCancel(ctx)
+
+		w := NewWorker(f, wb.config, wb.broker, wb.services, subscriptions, wb.store, positions[f], wb.newSignals)
+		wb.cancelFuncs = append(wb.cancelFuncs, cancel)
+
+		go func() {
+			if err := w.Run(workerCtx, wg); err != nil {
+				wb.logger.Errorf("worker finished with error: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+
+	for _, cancel := range wb.cancelFuncs {
+		cancel()
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// reconcile finds out how workers without saved state start by the account portfolio and orders,
+// see trade.Reconcile; workers with saved state restore it instead.
+func (wb WorkerBot) reconcile(ctx context.Context) (map[string]*trade.StartPosition, error) {
+	var figi []string
+	for _, f := range wb.figi {
+		if saved, _ := wb.store.Load(wb.config.Strategy, wb.broker.AccountID(), f); saved == nil {
+			figi = append(figi, f)
+		}
+	}
+	if len(figi) == 0 {
+		return nil, nil
+	}
+
+	positions, err := trade.Reconcile(ctx, wb.broker, figi, wb.config.LotsToBuy, config.TradeBotConfig().StrayOrders)
+	if err != nil {
+		return nil, fmt.Errorf("can not reconcile account: %v", err)
+	}
+
+	return positions, nil
+}
@@ -2,11 +2,21 @@
 package common
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
 	"github.com/elkopass/BITA/internal/metrics"
 	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
 	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"time"
 )
 
+// InsufficientBalancePause is how long workers do not try to buy after API answered there is not enough money.
+const InsufficientBalancePause = 15 * time.Minute
+
 func SetPortfolioMetrics(portfolio pb.PortfolioResponse, accountID string) {
 	for _, p := range portfolio.Positions {
 		if p.CurrentPrice != nil {
@@ -27,4 +37,88 @@ func SetPortfolioMetrics(portfolio pb.PortfolioResponse, accountID string) {
 		portfolio.TotalAmountFutures.Currency).Set(tradeutil.MoneyValueToFloat(*portfolio.TotalAmountFutures))
 	metrics.PortfolioInstrumentsAmount.WithLabelValues(accountID, "shares",
 		portfolio.TotalAmountShares.Currency).Set(tradeutil.MoneyValueToFloat(*portfolio.TotalAmountShares))
-}
\ No newline at end of file
+}
+
+// IsBreakerFailure reports whether err should be counted by circuit breaker; expected API answers
+// like insufficient balance or a closed instrument are not failures, workers just wait.
+func IsBreakerFailure(err error) bool {
+	return !errors.Is(err, sdk.ErrInsufficientBalance) && !errors.Is(err, sdk.ErrInstrumentNotTradable)
+}
+
+// RoundPrice rounds price to the minimal price increment of the instrument, API rejects other limit prices.
+func RoundPrice(instrument *pb.Instrument, price *pb.Quotation) (*pb.Quotation, error) {
+	increment := tradeutil.DecimalFromQuotation(instrument.MinPriceIncrement)
+	rounded, err := tradeutil.DecimalFromQuotation(price).RoundToIncrement(increment)
+	if err != nil {
+		return nil, fmt.Errorf("can not round price %s: %w", tradeutil.DecimalFromQuotation(price), err)
+	}
+
+	return rounded.Quotation(), nil
+}
+
+// OrderAmount formats the cost of lots of the instrument at price, in the instrument currency, for logs.
+func OrderAmount(instrument *pb.Instrument, price *pb.Quotation, lots int64) string {
+	amount, err := tradeutil.DecimalFromQuotation(price).MulInt(lots * int64(instrument.Lot))
+	if err != nil {
+		return "out of range"
+	}
+
+	return tradeutil.NewMoney(amount, instrument.Currency).String()
+}
+
+// HeldQuantity returns how many pieces of the instrument are on the broker account.
+func HeldQuantity(ctx context.Context, broker trade.Broker, figi string) (int64, error) {
+	p, err := portfolioPosition(ctx, broker, figi)
+	if err != nil || p == nil {
+		return 0, err
+	}
+
+	return tradeutil.DecimalFromQuotation(p.Quantity).Units(), nil
+}
+
+// portfolioPosition returns the portfolio position of the instrument or nil if it is not held;
+// held quantity is always taken from the portfolio, which also has the average price of it.
+func portfolioPosition(ctx context.Context, broker trade.Broker, figi string) (*pb.PortfolioPosition, error) {
+	portfolio, err := broker.GetPortfolio(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can not get portfolio: %w", err)
+	}
+	for _, p := range portfolio.Positions {
+		if p.Figi == figi {
+			return p, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ResolveInstruments converts FIGIs, tickers, "CLASS_CODE:TICKER" and ISINs to FIGIs of instruments
+// available for trading via API; duplicates are dropped and every resolved instrument is logged.
+func ResolveInstruments(ctx context.Context, registry *sdk.InstrumentRegistry, ids []string) ([]string, error) {
+	logger := loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID())
+
+	var figi []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		instrument, err := registry.Resolve(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("can not resolve instrument '%s': %w", id, err)
+		}
+		if !instrument.ApiTradeAvailableFlag || !instrument.BuyAvailableFlag || !instrument.SellAvailableFlag {
+			return nil, fmt.Errorf("instrument '%s' (%s:%s, %s) is not available for trading via API",
+				id, instrument.ClassCode, instrument.Ticker, instrument.Figi)
+		}
+
+		if instrument.Figi != id {
+			logger.Infof("instrument '%s' resolved to %s (%s:%s, %s)",
+				id, instrument.Figi, instrument.ClassCode, instrument.Ticker, instrument.Name)
+		}
+		if seen[instrument.Figi] {
+			continue
+		}
+		seen[instrument.Figi] = true
+		figi = append(figi, instrument.Figi)
+	}
+
+	return figi, nil
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// testInstrumentsService lists shares only and finds nothing else.
+type testInstrumentsService struct {
+	sdk.InstrumentsInterface
+	shares []*pb.Share
+}
+
+func (s testInstrumentsService) Shares(context.Context, pb.InstrumentStatus) ([]*pb.Share, error) {
+	return s.shares, nil
+}
+
+func (s testInstrumentsService) Etfs(context.Context, pb.InstrumentStatus) ([]*pb.Etf, error) {
+	return nil, nil
+}
+
+func (s testInstrumentsService) Bonds(context.Context, pb.InstrumentStatus) ([]*pb.Bond, error) {
+	return nil, nil
+}
+
+func (s testInstrumentsService) Futures(context.Context, pb.InstrumentStatus) ([]*pb.Future, error) {
+	return nil, nil
+}
+
+func (s testInstrumentsService) Currencies(context.Context, pb.InstrumentStatus) ([]*pb.Currency, error) {
+	return nil, nil
+}
+
+func (s testInstrumentsService) GetInstrumentBy(context.Context, pb.InstrumentRequest) (*pb.Instrument, error) {
+	return nil, status.Error(codes.NotFound, "50002")
+}
+
+func newTestRegistry() *sdk.InstrumentRegistry {
+	return sdk.NewInstrumentRegistry(testInstrumentsService{shares: []*pb.Share{
+		{Figi: "BBG004730N88", Ticker: "SBER", ClassCode: "TQBR", Isin: "RU0009029540",
+			ApiTradeAvailableFlag: true, BuyAvailableFlag: true, SellAvailableFlag: true},
+		{Figi: "BBG004731032", Ticker: "LKOH", ClassCode: "TQBR", Isin: "RU0009024277",
+			ApiTradeAvailableFlag: true, BuyAvailableFlag: true},
+	}}, time.Hour, "")
+}
+
+func TestResolveInstruments(t *testing.T) {
+	figi, err := ResolveInstruments(context.Background(), newTestRegistry(), []string{"SBER", "BBG004730N88", "RU0009029540"})
+	if err != nil {
+		t.Fatalf("can not resolve instruments: %v", err)
+	}
+	if len(figi) != 1 || figi[0] != "BBG004730N88" {
+		t.Errorf("ResolveInstruments() = %v, want [BBG004730N88]", figi)
+	}
+
+	if _, err := ResolveInstruments(context.Background(), newTestRegistry(), []string{"LKOH"}); err == nil {
+		t.Error("instrument not available for selling must be rejected")
+	}
+	if _, err := ResolveInstruments(context.Background(), newTestRegistry(), []string{"GAZP"}); err == nil {
+		t.Error("unknown instrument must be rejected")
+	}
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{sdk.NewApiError("PostOrder", codes.InvalidArgument, "30042", "not enough assets"), false},
+		{sdk.NewApiError("PostOrder", codes.FailedPrecondition, "30079", "instrument is not traded"), false},
+		{sdk.NewApiError("PostOrder", codes.Unavailable, "", "unavailable"), true},
+		{status.Error(codes.Internal, "70001"), true},
+	}
+
+	for _, tt := range tests {
+		if got := IsBreakerFailure(tt.err); got != tt.want {
+			t.Errorf("IsBreakerFailure(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// orderBookDepth is the depth of order books Worker trades by.
+const orderBookDepth = 10
+
+// orderBookMaxAge is how long a streamed order book is trusted; an older one is requested again,
+// e.g. while the stream is reconnecting.
+const orderBookMaxAge = time.Minute
+
+// tradingStatusMaxAge is how long a trading status is trusted: the stream sends it only when
+// it changes, and a change may be missed while the stream is reconnecting, so an older one
+// is requested again.
+const tradingStatusMaxAge = 10 * time.Minute
+
+// marketData keeps the latest order book, last price and trading status of an instrument
+// streamed by sdk.SubscriptionManager, so Worker does not poll them.
+type marketData struct {
+	unsubscribe []func()
+
+	mu         sync.Mutex
+	book       *pb.OrderBook
+	bookTime   time.Time
+	lastPrice  *pb.Quotation
+	status     *pb.TradingStatus
+	statusTime time.Time
+}
+
+// subscribeMarketData subscribes to market data of figi; received messages are kept until unsubscribed.
+func subscribeMarketData(subscriptions *sdk.SubscriptionManager, figi string) (*marketData, error) {
+	md := &marketData{}
+
+	books, unsubscribe, err := subscriptions.SubscribeOrderBook(figi, orderBookDepth)
+	if err != nil {
+		return nil, err
+	}
+	md.unsubscribe = append(md.unsubscribe, unsubscribe)
+
+	lastPrices, unsubscribe, err := subscriptions.SubscribeLastPrice(figi)
+	if err != nil {
+		md.close()
+		return nil, err
+	}
+	md.unsubscribe = append(md.unsubscribe, unsubscribe)
+
+	statuses, unsubscribe, err := subscriptions.SubscribeInfo(figi)
+	if err != nil {
+		md.close()
+		return nil, err
+	}
+	md.unsubscribe = append(md.unsubscribe, unsubscribe)
+
+	go md.listen(books, lastPrices, statuses)
+
+	return md, nil
+}
+
+// listen keeps received messages until all channels are closed.
+func (md *marketData) listen(books <-chan *pb.OrderBook, lastPrices <-chan *pb.LastPrice, statuses <-chan *pb.TradingStatus) {
+	for books != nil || lastPrices != nil || statuses != nil {
+		select {
+		case book, ok := <-books:
+			if !ok {
+				books = nil
+				continue
+			}
+			md.mu.Lock()
+			md.book, md.bookTime = book, time.Now()
+			md.mu.Unlock()
+		case price, ok := <-lastPrices:
+			if !ok {
+				lastPrices = nil
+				continue
+			}
+			md.mu.Lock()
+			md.lastPrice = price.Price
+			md.mu.Unlock()
+		case status, ok := <-statuses:
+			if !ok {
+				statuses = nil
+				continue
+			}
+			md.keepTradingStatus(status)
+		}
+	}
+}
+
+// orderBook returns the latest streamed order book as if it was requested, or nil if there is no fresh one;
+// nil marketData has none.
+func (md *marketData) orderBook() *pb.GetOrderBookResponse {
+	if md == nil {
+		return nil
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	if md.book == nil || time.Since(md.bookTime) > orderBookMaxAge {
+		return nil
+	}
+
+	return &pb.GetOrderBookResponse{
+		Figi:      md.book.Figi,
+		Depth:     md.book.Depth,
+		Bids:      md.book.Bids,
+		Asks:      md.book.Asks,
+		LastPrice: md.lastPrice,
+		LimitUp:   md.book.LimitUp,
+		LimitDown: md.book.LimitDown,
+	}
+}
+
+// tradingStatus returns the latest streamed or kept trading status, or nil if there is no fresh one;
+// nil marketData has none.
+func (md *marketData) tradingStatus() *pb.TradingStatus {
+	if md == nil {
+		return nil
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	if md.status == nil || time.Since(md.statusTime) > tradingStatusMaxAge {
+		return nil
+	}
+
+	return md.status
+}
+
+// keepTradingStatus keeps a streamed or requested trading status for tradingStatusMaxAge;
+// nil marketData keeps nothing.
+func (md *marketData) keepTradingStatus(status *pb.TradingStatus) {
+	if md == nil {
+		return
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	md.status, md.statusTime = status, time.Now()
+}
+
+// close unsubscribes from all market data of the instrument.
+func (md *marketData) close() {
+	for _, unsubscribe := range md.unsubscribe {
+		unsubscribe()
+	}
+}
+
+// LogStreamEvents reports stream connection state changes until the stream is closed.
+func LogStreamEvents(logger *zap.SugaredLogger, name string, events <-chan sdk.StreamEvent) {
+	for event := range events {
+		switch event.State {
+		case sdk.StreamConnected:
+			logger.Infof("%s is connected", name)
+		case sdk.StreamReconnecting:
+			logger.Warnf("%s is reconnecting: %v", name, event.Err)
+		case sdk.StreamClosed:
+			logger.Infof("%s is closed", name)
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"testing"
+	"time"
+)
+
+func TestMarketDataTradingStatusExpires(t *testing.T) {
+	md := &marketData{}
+	if status := md.tradingStatus(); status != nil {
+		t.Fatalf("tradingStatus() before any status = %v, want nil", status)
+	}
+
+	md.keepTradingStatus(&pb.TradingStatus{
+		Figi:          testFigi,
+		TradingStatus: pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+	})
+	if status := md.tradingStatus(); status == nil {
+		t.Fatal("tradingStatus() of a fresh status = nil, want it")
+	}
+
+	// a status change may be missed while the stream is reconnecting
+	md.statusTime = md.statusTime.Add(-tradingStatusMaxAge - time.Second)
+	if status := md.tradingStatus(); status != nil {
+		t.Errorf("tradingStatus() of an old status = %v, want nil", status)
+	}
+
+	var none *marketData
+	none.keepTradingStatus(&pb.TradingStatus{Figi: testFigi})
+	if status := none.tradingStatus(); status != nil {
+		t.Errorf("tradingStatus() of nil marketData = %v, want nil", status)
+	}
+}
@@ -0,0 +1,305 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// OrderStatus is a state of an order tracked by OrderManager.
+type OrderStatus string
+
+const (
+	OrderNew             OrderStatus = "new"
+	OrderPartiallyFilled OrderStatus = "partially filled"
+	OrderFilled          OrderStatus = "filled"
+	OrderCancelled       OrderStatus = "cancelled"
+	OrderRejected        OrderStatus = "rejected"
+	OrderExpired         OrderStatus = "expired" // cancelled by OrderManager after the timeout
+)
+
+// Order is an order tracked by OrderManager.
+type Order struct {
+	ID            string
+	Direction     pb.OrderDirection
+	Price         *pb.MoneyValue // price the order is placed at, nil if unknown
+	AveragePrice  *pb.MoneyValue // average price of executed lots per instrument, nil if nothing is executed
+	PlacedTime    int64          // unix time
+	Status        OrderStatus
+	LotsRequested int64
+	LotsExecuted  int64 // lots executed so far; a cancelled, rejected or expired order may have some too
+}
+
+// ExecutionPrice returns the average price of executed lots or, if it is unknown, the order price.
+func (o Order) ExecutionPrice() *pb.MoneyValue {
+	if o.AveragePrice != nil {
+		return o.AveragePrice
+	}
+	return o.Price
+}
+
+// OrderFromState converts an active order found on the account, e.g. by trade.Reconcile.
+func OrderFromState(state *pb.OrderState) Order {
+	return Order{
+		ID:            state.OrderId,
+		Direction:     state.Direction,
+		Price:         state.InitialSecurityPrice,
+		PlacedTime:    state.OrderDate.AsTime().Unix(),
+		Status:        OrderNew,
+		LotsRequested: state.LotsRequested,
+		LotsExecuted:  state.LotsExecuted,
+		AveragePrice:  averagePrice(state),
+	}
+}
+
+// averagePrice returns the average execution price of the order or nil if nothing is executed.
+func averagePrice(state *pb.OrderState) *pb.MoneyValue {
+	if state.LotsExecuted == 0 || tradeutil.DecimalFromMoneyValue(state.AveragePositionPrice).Sign() <= 0 {
+		return nil
+	}
+	return state.AveragePositionPrice
+}
+
+// OrderCallbacks let a strategy react to order state transitions; all of them are optional.
+type OrderCallbacks struct {
+	// OnFilled is called when the order is executed completely.
+	OnFilled func(ctx context.Context, o Order)
+	// OnCancelled is called when the order is cancelled, rejected or expired;
+	// Order.LotsExecuted tells how many lots were executed before.
+	OnCancelled func(ctx context.Context, o Order)
+	// OnChange is called after every change of the tracked order, e.g. to save worker state.
+	OnChange func()
+}
+
+// OrderManager places orders of one instrument one at a time and drives them through
+// their states by polling the broker: an order not filled in time is cancelled, and order
+// metrics are updated on every transition. A strategy decides what to trade and reacts
+// to the outcome via OrderCallbacks.
+type OrderManager struct {
+	figi        string
+	cancelAfter time.Duration
+	order       *Order // nil if there is no active order
+
+	broker    trade.Broker
+	callbacks OrderCallbacks
+	logger    *zap.SugaredLogger
+}
+
+// NewOrderManager creates OrderManager cancelling orders which are not filled in cancelAfter.
+func NewOrderManager(figi string, broker trade.Broker, cancelAfter time.Duration, logger *zap.SugaredLogger, callbacks OrderCallbacks) *OrderManager {
+	return &OrderManager{
+		figi:        figi,
+		cancelAfter: cancelAfter,
+		broker:      broker,
+		callbacks:   callbacks,
+		logger:      logger,
+	}
+}
+
+// Active returns true if there is an order waiting to be filled.
+func (m *OrderManager) Active() bool {
+	return m.order != nil
+}
+
+// Order returns a copy of the active order or nil if there is none.
+func (m *OrderManager) Order() *Order {
+	if m.order == nil {
+		return nil
+	}
+
+	o := *m.order
+	return &o
+}
+
+// Place posts an order of lots at price; only one order can be active at a time.
+func (m *OrderManager) Place(ctx context.Context, instrument *pb.Instrument, direction pb.OrderDirection,
+	orderType pb.OrderType, price *pb.Quotation, lots int64) error {
+	if m.order != nil {
+		return fmt.Errorf("order %s is still active", m.order.ID)
+	}
+
+	orderResponse, err := m.broker.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      m.figi,
+		OrderId:   uuid.New().String(),
+		Quantity:  lots,
+		Price:     price,
+		AccountId: m.broker.AccountID(),
+		OrderType: orderType,
+		Direction: direction,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.order = &Order{
+		ID:            orderResponse.OrderId,
+		Direction:     direction,
+		Price:         tradeutil.DecimalFromQuotation(price).MoneyValue(instrument.Currency),
+		PlacedTime:    time.Now().Unix(),
+		Status:        OrderNew,
+		LotsRequested: lots,
+	}
+	m.logger.With("order_id", m.order.ID).
+		Infof("%s order created, fair price: %s, amount: %s, initial price: %s, current status: %s",
+			directionName(direction),
+			tradeutil.DecimalFromQuotation(price),
+			OrderAmount(instrument, price, lots),
+			tradeutil.MoneyFromMoneyValue(orderResponse.InitialOrderPrice),
+			orderResponse.ExecutionReportStatus.String())
+
+	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), m.figi, direction.String()).Inc()
+	m.changed()
+
+	return nil
+}
+
+// Track takes over an order placed before, e.g. by a previous run; the order is checked
+// by Check as if it was placed by Place.
+func (m *OrderManager) Track(o Order) {
+	if o.Status == "" {
+		o.Status = OrderNew
+	}
+	m.order = &o
+
+	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), m.figi, o.Direction.String()).Inc()
+}
+
+// Check gets the state of the active order and moves it on: a filled, cancelled or rejected
+// order is released with the callbacks called, and an order older than the timeout is cancelled.
+func (m *OrderManager) Check(ctx context.Context) error {
+	if m.order == nil {
+		return nil
+	}
+
+	state, err := m.broker.GetOrderState(ctx, m.order.ID)
+	if err != nil {
+		return err
+	}
+
+	m.logger.With("order_id", m.order.ID).
+		Infof("order status: %s, fulfilled %d/%d, current price: %s",
+			state.ExecutionReportStatus.String(),
+			state.LotsExecuted, state.LotsRequested,
+			tradeutil.MoneyFromMoneyValue(state.AveragePositionPrice),
+		)
+	m.update(state)
+
+	switch state.ExecutionReportStatus {
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL:
+		m.release(ctx, OrderFilled)
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED:
+		m.release(ctx, OrderCancelled)
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED:
+		m.release(ctx, OrderRejected)
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL:
+		m.order.Status = OrderPartiallyFilled
+		m.cancelIfExpired(ctx)
+	default:
+		m.cancelIfExpired(ctx)
+	}
+
+	return nil
+}
+
+func (m *OrderManager) update(state *pb.OrderState) {
+	m.order.LotsRequested, m.order.LotsExecuted = state.LotsRequested, state.LotsExecuted
+	if price := averagePrice(state); price != nil {
+		m.order.AveragePrice = price
+	}
+}
+
+// Cancel cancels the active order, if there is one; an order which is filled or cancelled
+// in the meantime is released as Check does it.
+func (m *OrderManager) Cancel(ctx context.Context) error {
+	if m.order == nil {
+		return nil
+	}
+
+	err := m.cancel(ctx, OrderCancelled)
+	if errors.Is(err, sdk.ErrOrderNotFound) {
+		return m.Check(ctx)
+	}
+
+	return err
+}
+
+// cancelIfExpired cancels the active order if it is not filled in time; it is checked
+// again on the next turn if cancellation fails.
+func (m *OrderManager) cancelIfExpired(ctx context.Context) {
+	if time.Since(time.Unix(m.order.PlacedTime, 0)) < m.cancelAfter {
+		m.logger.With("order_id", m.order.ID).Debug("order is still placed")
+		return
+	}
+
+	if err := m.cancel(ctx, OrderExpired); err != nil {
+		m.logger.With("order_id", m.order.ID).Warnf("can not cancel expired order: %v", err)
+	}
+}
+
+func (m *OrderManager) cancel(ctx context.Context, status OrderStatus) error {
+	_, err := m.broker.CancelOrder(ctx, m.order.ID)
+	if errors.Is(err, sdk.ErrOrderNotFound) {
+		return fmt.Errorf("order is already filled or cancelled: %w", err) // Check finds out which one
+	}
+	if err != nil {
+		return err
+	}
+
+	// lots could be executed since the last check, the final state tells for sure
+	state, err := m.broker.GetOrderState(ctx, m.order.ID)
+	if err != nil {
+		m.logger.With("order_id", m.order.ID).
+			Warnf("can not get state of cancelled order, %d lots are executed by the last check: %v", m.order.LotsExecuted, err)
+	} else {
+		m.update(state)
+	}
+
+	m.release(ctx, status)
+	return nil
+}
+
+// release forgets the active order which reached a final status.
+func (m *OrderManager) release(ctx context.Context, status OrderStatus) {
+	o := *m.order
+	o.Status = status
+	m.order = nil
+
+	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), m.figi, o.Direction.String()).Dec()
+	if status == OrderFilled {
+		metrics.OrdersFulfilled.WithLabelValues(loggy.GetBotID(), m.figi, o.Direction.String()).Inc()
+		m.logger.With("order_id", o.ID).Infof("%s order is filled", directionName(o.Direction))
+	} else {
+		metrics.OrdersCancelled.WithLabelValues(loggy.GetBotID(), m.figi).Inc()
+		m.logger.With("order_id", o.ID).Warnf("%s order is %s, %d/%d lots executed",
+			directionName(o.Direction), status, o.LotsExecuted, o.LotsRequested)
+	}
+
+	if status == OrderFilled && m.callbacks.OnFilled != nil {
+		m.callbacks.OnFilled(ctx, o)
+	}
+	if status != OrderFilled && m.callbacks.OnCancelled != nil {
+		m.callbacks.OnCancelled(ctx, o)
+	}
+	m.changed()
+}
+
+func (m *OrderManager) changed() {
+	if m.callbacks.OnChange != nil {
+		m.callbacks.OnChange()
+	}
+}
+
+// directionName returns "buy" or "sell".
+func directionName(direction pb.OrderDirection) string {
+	return strings.ToLower(strings.TrimPrefix(direction.String(), "ORDER_DIRECTION_"))
+}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// testOrderCallbacks records orders released by OrderManager.
+type testOrderCallbacks struct {
+	filled    []Order
+	cancelled []Order
+	changes   int
+}
+
+func (c *testOrderCallbacks) callbacks() OrderCallbacks {
+	return OrderCallbacks{
+		OnFilled:    func(_ context.Context, o Order) { c.filled = append(c.filled, o) },
+		OnCancelled: func(_ context.Context, o Order) { c.cancelled = append(c.cancelled, o) },
+		OnChange:    func() { c.changes++ },
+	}
+}
+
+// newTestOrderManager returns OrderManager trading on a fake account, which fills orders
+// only by fake.Server.FillOrder.
+func newTestOrderManager(t *testing.T, cancelAfter time.Duration) (*fake.Server, *OrderManager, *testOrderCallbacks) {
+	server := fake.NewServer()
+	server.AddShare(testShare)
+	server.SetOrderFiller(fake.FillNone)
+	accountID := server.AddAccount("test")
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	c := &testOrderCallbacks{}
+	b := broker.NewProductionBroker(accountID, client.ServicePool())
+	m := NewOrderManager(testFigi, b, cancelAfter, zap.NewNop().Sugar(), c.callbacks())
+
+	return server, m, c
+}
+
+func placeTestOrder(t *testing.T, m *OrderManager, lots int64) *Order {
+	t.Helper()
+
+	instrument := &pb.Instrument{Figi: testFigi, Lot: testShare.Lot, Currency: testShare.Currency}
+	err := m.Place(context.Background(), instrument, pb.OrderDirection_ORDER_DIRECTION_BUY,
+		pb.OrderType_ORDER_TYPE_LIMIT, &pb.Quotation{Units: 100}, lots)
+	if err != nil {
+		t.Fatalf("can not place order: %v", err)
+	}
+
+	return m.Order()
+}
+
+func TestOrderManagerFillsOrder(t *testing.T) {
+	server, m, c := newTestOrderManager(t, time.Hour)
+	ctx := context.Background()
+
+	o := placeTestOrder(t, m, 2)
+	if o.Status != OrderNew || o.Price.Units != 100 || o.LotsRequested != 2 || c.changes != 1 {
+		t.Errorf("placed order = %+v after %d changes, want new order of 2 lots by 100", o, c.changes)
+	}
+	if err := m.Place(ctx, &pb.Instrument{}, pb.OrderDirection_ORDER_DIRECTION_BUY,
+		pb.OrderType_ORDER_TYPE_LIMIT, &pb.Quotation{Units: 100}, 1); err == nil {
+		t.Error("the second order must not be placed while the first one is active")
+	}
+
+	if err := m.Check(ctx); err != nil || !m.Active() {
+		t.Fatalf("Check() of a pending order = %v, active %t, want active", err, m.Active())
+	}
+
+	if err := server.FillOrder(m.broker.AccountID(), o.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("can not check order: %v", err)
+	}
+	if m.Active() || len(c.filled) != 1 || len(c.cancelled) != 0 {
+		t.Fatalf("filled order must be released by OnFilled, got filled %v, cancelled %v", c.filled, c.cancelled)
+	}
+	if f := c.filled[0]; f.ID != o.ID || f.Status != OrderFilled || f.LotsExecuted != 2 {
+		t.Errorf("filled order = %+v", f)
+	}
+}
+
+func TestOrderManagerCancelsExpiredOrder(t *testing.T) {
+	_, m, c := newTestOrderManager(t, 0)
+
+	o := placeTestOrder(t, m, 1)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("can not check order: %v", err)
+	}
+	if m.Active() || len(c.cancelled) != 1 || c.cancelled[0].ID != o.ID || c.cancelled[0].Status != OrderExpired {
+		t.Errorf("order not filled in time must be expired, got cancelled %v", c.cancelled)
+	}
+}
+
+func TestOrderManagerCancel(t *testing.T) {
+	_, m, c := newTestOrderManager(t, time.Hour)
+	ctx := context.Background()
+
+	if err := m.Cancel(ctx); err != nil {
+		t.Errorf("Cancel() without an order = %v, want nil", err)
+	}
+
+	placeTestOrder(t, m, 1)
+	if err := m.Cancel(ctx); err != nil {
+		t.Fatalf("can not cancel order: %v", err)
+	}
+	if m.Active() || len(c.cancelled) != 1 || c.cancelled[0].Status != OrderCancelled {
+		t.Errorf("cancelled order must be released by OnCancelled, got %v", c.cancelled)
+	}
+}
+
+func TestOrderManagerTracksOrder(t *testing.T) {
+	server, m, c := newTestOrderManager(t, time.Hour)
+	ctx := context.Background()
+
+	order, err := m.broker.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Price:     &pb.Quotation{Units: 100},
+		Direction: pb.OrderDirection_ORDER_DIRECTION_SELL,
+		OrderType: pb.OrderType_ORDER_TYPE_LIMIT,
+	})
+	if err != nil {
+		t.Fatalf("can not post order: %v", err)
+	}
+	state, err := m.broker.GetOrderState(ctx, order.OrderId)
+	if err != nil {
+		t.Fatalf("can not get order state: %v", err)
+	}
+
+	m.Track(OrderFromState(state))
+	if o := m.Order(); o == nil || o.ID != order.OrderId || o.Status != OrderNew || o.Direction != pb.OrderDirection_ORDER_DIRECTION_SELL {
+		t.Fatalf("tracked order = %+v", o)
+	}
+
+	if err := server.FillOrder(m.broker.AccountID(), order.OrderId, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("can not check order: %v", err)
+	}
+	if len(c.filled) != 1 || c.filled[0].ID != order.OrderId {
+		t.Errorf("tracked order must be checked as a placed one, got filled %v", c.filled)
+	}
+}
+
+func TestOrderManagerKeepsPartialFill(t *testing.T) {
+	server, m, c := newTestOrderManager(t, time.Hour)
+	ctx := context.Background()
+
+	o := placeTestOrder(t, m, 3)
+	if err := server.FillOrder(m.broker.AccountID(), o.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("can not check order: %v", err)
+	}
+	if o := m.Order(); o.Status != OrderPartiallyFilled || o.LotsExecuted != 1 || o.ExecutionPrice().Units != 100 {
+		t.Errorf("partially filled order = %+v", o)
+	}
+
+	m.cancelAfter = 0
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("can not check order: %v", err)
+	}
+	if len(c.cancelled) != 1 {
+		t.Fatalf("partially filled order must be expired, got cancelled %v", c.cancelled)
+	}
+	if e := c.cancelled[0]; e.Status != OrderExpired || e.LotsExecuted != 1 || e.AveragePrice == nil {
+		t.Errorf("expired order must keep executed lots and their price, got %+v", e)
+	}
+}
+
+func TestOrderManagerCancelReleasesFilledOrder(t *testing.T) {
+	server, m, c := newTestOrderManager(t, time.Hour)
+
+	o := placeTestOrder(t, m, 2)
+	if err := server.FillOrder(m.broker.AccountID(), o.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cancel(context.Background()); err != nil {
+		t.Fatalf("Cancel() of a filled order = %v, want nil", err)
+	}
+	if m.Active() || len(c.filled) != 1 || len(c.cancelled) != 0 {
+		t.Errorf("order filled before cancellation must be released as filled, got filled %v, cancelled %v",
+			c.filled, c.cancelled)
+	}
+}
+
+func TestOrderExecutionPrice(t *testing.T) {
+	o := Order{Price: &pb.MoneyValue{Units: 100}}
+	if p := o.ExecutionPrice(); p.Units != 100 {
+		t.Errorf("ExecutionPrice() without executed lots = %v, want the order price 100", p)
+	}
+
+	o.AveragePrice = &pb.MoneyValue{Units: 99}
+	if p := o.ExecutionPrice(); p.Units != 99 {
+		t.Errorf("ExecutionPrice() = %v, want the average price 99", p)
+	}
+}
@@ -0,0 +1,268 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"go.uber.org/zap"
+	"time"
+)
+
+// stopOrderSettleTime is how long a stop order which disappeared while the position is still held
+// is considered just triggered: the order it posts may not be executed yet.
+const stopOrderSettleTime = time.Minute
+
+// DefaultStopOrdersCheckInterval is how often Check gets stop orders if no interval is given:
+// StopOrdersService has a low request limit, and stop orders trigger rarely.
+const DefaultStopOrdersCheckInterval = time.Minute
+
+// ProtectiveOrders keeps exchange-side stop-loss and take-profit orders of a bought position,
+// so the position is closed even if the bot is down. When one of them is triggered, the other one
+// is cancelled by Check.
+type ProtectiveOrders struct {
+	StopLossID   string
+	TakeProfitID string
+
+	figi          string
+	checkInterval time.Duration // Check gets stop orders at most so often
+	checkedAt     time.Time     // when Check got stop orders the last time
+	missingSince  time.Time     // when a stop order disappeared while the position was still held
+	broker        trade.Broker
+	logger        *zap.SugaredLogger
+}
+
+// NewProtectiveOrders creates ProtectiveOrders of figi checked every checkInterval,
+// or every DefaultStopOrdersCheckInterval if it is zero.
+func NewProtectiveOrders(figi string, broker trade.Broker, checkInterval time.Duration,
+	logger *zap.SugaredLogger) *ProtectiveOrders {
+	if checkInterval <= 0 {
+		checkInterval = DefaultStopOrdersCheckInterval
+	}
+
+	return &ProtectiveOrders{figi: figi, broker: broker, checkInterval: checkInterval, logger: logger}
+}
+
+// Active returns true if at least one of the stop orders is placed.
+func (po *ProtectiveOrders) Active() bool {
+	return po.StopLossID != "" || po.TakeProfitID != ""
+}
+
+// Place places missing stop orders for lots bought at price; stop prices are price
+// multiplied by the coefs and rounded to the instrument price increment.
+func (po *ProtectiveOrders) Place(ctx context.Context, instrument *pb.Instrument, price tradeutil.Decimal, lots int64,
+	stopLossCoef, takeProfitCoef float64) error {
+	if po.StopLossID == "" {
+		stopPrice, err := stopOrderPrice(instrument, price, stopLossCoef)
+		if err != nil {
+			return fmt.Errorf("can not calculate stop loss price: %w", err)
+		}
+		id, err := po.post(ctx, pb.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS, stopPrice, lots)
+		if err != nil {
+			return fmt.Errorf("can not place stop loss: %w", err)
+		}
+		po.StopLossID = id
+		po.logger.With("stop_order_id", id).Infof("stop loss placed, stop price: %s", tradeutil.DecimalFromQuotation(stopPrice))
+	}
+
+	if po.TakeProfitID == "" {
+		stopPrice, err := stopOrderPrice(instrument, price, takeProfitCoef)
+		if err != nil {
+			return fmt.Errorf("can not calculate take profit price: %w", err)
+		}
+		id, err := po.post(ctx, pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT, stopPrice, lots)
+		if err != nil {
+			return fmt.Errorf("can not place take profit: %w", err)
+		}
+		po.TakeProfitID = id
+		po.logger.With("stop_order_id", id).Infof("take profit placed, stop price: %s", tradeutil.DecimalFromQuotation(stopPrice))
+	}
+
+	return nil
+}
+
+// stopOrderPrice returns price multiplied by coef and rounded to the instrument price increment.
+func stopOrderPrice(instrument *pb.Instrument, price tradeutil.Decimal, coef float64) (*pb.Quotation, error) {
+	stopPrice, err := price.Mul(tradeutil.DecimalFromFloat(coef))
+	if err != nil {
+		return nil, err
+	}
+
+	return RoundPrice(instrument, stopPrice.Quotation())
+}
+
+func (po *ProtectiveOrders) post(ctx context.Context, orderType pb.StopOrderType, stopPrice *pb.Quotation, lots int64) (string, error) {
+	return po.broker.PostStopOrder(ctx, &pb.PostStopOrderRequest{
+		Figi:           po.figi,
+		Quantity:       lots,
+		StopPrice:      stopPrice,
+		Direction:      pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL,
+		ExpirationType: pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_CANCEL,
+		StopOrderType:  orderType,
+	})
+}
+
+// Cancel cancels both stop orders, e.g. before the position is sold by the worker itself;
+// already triggered or cancelled ones are skipped.
+func (po *ProtectiveOrders) Cancel(ctx context.Context) error {
+	for _, id := range []*string{&po.StopLossID, &po.TakeProfitID} {
+		if *id == "" {
+			continue
+		}
+
+		_, err := po.broker.CancelStopOrder(ctx, *id)
+		if err != nil && !errors.Is(err, sdk.ErrStopOrderNotFound) {
+			return fmt.Errorf("can not cancel stop order %s: %w", *id, err)
+		}
+		po.logger.With("stop_order_id", *id).Info("stop order cancelled")
+		*id = ""
+	}
+
+	return nil
+}
+
+// Refresh makes the next Check get stop orders at once, e.g. right before the worker sells
+// the position itself.
+func (po *ProtectiveOrders) Refresh() {
+	po.checkedAt = time.Time{}
+}
+
+// Check returns true if the position of lots is closed by a triggered stop order, and how many
+// lots are left if the order of the stop order is executed partially; the other stop order is
+// cancelled then. Stop orders are got once in the check interval, see Refresh, unless one of them
+// is missing. A triggered stop order disappears before its order is executed, so a stop order
+// which disappeared while the position is still held is forgotten (and put back by Place) only
+// when the account has no sell orders of the figi for stopOrderSettleTime, e.g. it is cancelled by hand.
+func (po *ProtectiveOrders) Check(ctx context.Context, instrument *pb.Instrument, lots int64) (bool, int64, error) {
+	if !po.Active() {
+		return false, 0, nil
+	}
+	if po.missingSince.IsZero() && time.Since(po.checkedAt) < po.checkInterval {
+		return false, 0, nil
+	}
+
+	po.checkedAt = time.Now()
+	stopOrders, err := po.broker.GetStopOrders(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("can not get stop orders: %w", err)
+	}
+	active := make(map[string]bool)
+	for _, so := range stopOrders {
+		active[so.StopOrderId] = true
+	}
+	if po.missing(active) == 0 {
+		po.missingSince = time.Time{}
+		return false, 0, nil
+	}
+
+	orders, err := po.broker.GetOrders(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("can not get orders: %w", err)
+	}
+	for _, o := range orders {
+		if o.Figi == po.figi && o.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL {
+			po.logger.With("order_id", o.OrderId).Info("stop order triggered, waiting for its order to be executed")
+			return false, 0, nil
+		}
+	}
+
+	held, err := HeldQuantity(ctx, po.broker, po.figi)
+	if err != nil {
+		return false, 0, err
+	}
+	if held < lots*int64(instrument.Lot) {
+		po.missingSince = time.Time{}
+		if po.StopLossID != "" && !active[po.StopLossID] {
+			po.logger.With("stop_order_id", po.StopLossID).Info("stop loss triggered")
+			metrics.StopOrdersTriggered.WithLabelValues(loggy.GetBotID(), po.figi, "stop_loss").Inc()
+			po.StopLossID = ""
+		}
+		if po.TakeProfitID != "" && !active[po.TakeProfitID] {
+			po.logger.With("stop_order_id", po.TakeProfitID).Info("take profit triggered")
+			metrics.StopOrdersTriggered.WithLabelValues(loggy.GetBotID(), po.figi, "take_profit").Inc()
+			po.TakeProfitID = ""
+		}
+
+		return true, held / int64(instrument.Lot), po.Cancel(ctx) // the sibling, if it is still placed
+	}
+
+	if po.missingSince.IsZero() {
+		po.missingSince = time.Now()
+	}
+	if time.Since(po.missingSince) < stopOrderSettleTime {
+		return false, 0, nil
+	}
+	for _, id := range []*string{&po.StopLossID, &po.TakeProfitID} {
+		if *id != "" && !active[*id] {
+			po.logger.With("stop_order_id", *id).Warn("stop order disappeared, but the position is still held")
+			*id = ""
+		}
+	}
+	po.missingSince = time.Time{}
+
+	return false, 0, nil
+}
+
+// missing returns how many placed stop orders are not active.
+func (po *ProtectiveOrders) missing(active map[string]bool) int {
+	missing := 0
+	for _, id := range []string{po.StopLossID, po.TakeProfitID} {
+		if id != "" && !active[id] {
+			missing++
+		}
+	}
+
+	return missing
+}
+
+// Restore finds stop orders of the figi left by a previous run for a position of lots and returns
+// the average price and lots of the position they protect. Stop orders without a position are
+// cancelled and zero lots are returned; stop orders of a position smaller than lots are cancelled
+// too, they would sell more than is held, and Place puts them back for the held lots.
+func (po *ProtectiveOrders) Restore(ctx context.Context, instrument *pb.Instrument, lots int64) (*pb.MoneyValue, int64, error) {
+	stopOrders, err := po.broker.GetStopOrders(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("can not get stop orders: %w", err)
+	}
+	for _, so := range stopOrders {
+		if so.Figi != po.figi || so.Direction != pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL {
+			continue
+		}
+
+		switch {
+		case so.OrderType == pb.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS && po.StopLossID == "":
+			po.StopLossID = so.StopOrderId
+		case so.OrderType == pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT && po.TakeProfitID == "":
+			po.TakeProfitID = so.StopOrderId
+		}
+	}
+	if !po.Active() {
+		return nil, 0, nil
+	}
+
+	p, err := portfolioPosition(ctx, po.broker, po.figi)
+	if err != nil {
+		return nil, 0, err
+	}
+	held := int64(0)
+	if p != nil {
+		held = tradeutil.DecimalFromQuotation(p.Quantity).Units()
+	}
+	if held <= 0 {
+		po.logger.Warn("stop orders without a position found, cancelling them")
+		return nil, 0, po.Cancel(ctx)
+	}
+	if held < lots*int64(instrument.Lot) {
+		po.logger.Warnf("stop orders of %d lots found, but %d pieces are held, cancelling them", lots, held)
+		return p.AveragePositionPrice, held / int64(instrument.Lot), po.Cancel(ctx)
+	}
+
+	po.logger.Infof("stop orders restored: stop loss %s, take profit %s, average price: %s",
+		po.StopLossID, po.TakeProfitID, tradeutil.MoneyFromMoneyValue(p.AveragePositionPrice))
+	return p.AveragePositionPrice, lots, nil
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"go.uber.org/zap"
+	"testing"
+)
+
+const testFigi = "BBG004730N88"
+
+var testShare = &pb.Share{
+	Figi:              testFigi,
+	Ticker:            "SBER",
+	ClassCode:         "TQBR",
+	Lot:               10,
+	Currency:          "rub",
+	MinPriceIncrement: &pb.Quotation{Nano: 10000000},
+}
+
+// newStopOrdersBroker returns a simulated broker holding a lot bought by 100 and its instrument.
+func newStopOrdersBroker(t *testing.T) (*fake.Server, *broker.SimulatedBroker, *pb.Instrument) {
+	server := fake.NewServer()
+	server.AddShare(testShare)
+	setTestPrice(server, 100)
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx := context.Background()
+	b := broker.NewSimulatedBroker(client.ServicePool(), 10000)
+	_, err = b.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_BUY,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if err != nil {
+		t.Fatalf("can not buy: %v", err)
+	}
+	instrument, err := client.ServicePool().InstrumentsService.GetInstrumentBy(ctx, pb.InstrumentRequest{
+		IdType: pb.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     testFigi,
+	})
+	if err != nil {
+		t.Fatalf("can not get instrument: %v", err)
+	}
+
+	return server, b, instrument
+}
+
+// setTestPrice sets the last price and a narrow order book around it.
+func setTestPrice(server *fake.Server, price int64) {
+	server.SetOrderBook(&pb.GetOrderBookResponse{
+		Figi:      testFigi,
+		Bids:      []*pb.Order{{Price: &pb.Quotation{Units: price}, Quantity: 100}},
+		Asks:      []*pb.Order{{Price: &pb.Quotation{Units: price}, Quantity: 100}},
+		LastPrice: &pb.Quotation{Units: price},
+	})
+}
+
+func placeProtectiveOrders(t *testing.T, b *broker.SimulatedBroker, instrument *pb.Instrument) *ProtectiveOrders {
+	t.Helper()
+
+	po := NewProtectiveOrders(testFigi, b, 0, zap.NewNop().Sugar())
+	if err := po.Place(context.Background(), instrument, tradeutil.DecimalFromInt(100), 1, 0.9, 1.1); err != nil {
+		t.Fatalf("can not place stop orders: %v", err)
+	}
+	if po.StopLossID == "" || po.TakeProfitID == "" {
+		t.Fatalf("both stop orders must be placed, got %+v", po)
+	}
+
+	return po
+}
+
+func TestProtectiveOrdersPlace(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	placeProtectiveOrders(t, b, instrument)
+
+	stopOrders, err := b.GetStopOrders(context.Background())
+	if err != nil {
+		t.Fatalf("can not get stop orders: %v", err)
+	}
+	prices := make(map[pb.StopOrderType]int64)
+	for _, so := range stopOrders {
+		if so.LotsRequested != 1 || so.Direction != pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL {
+			t.Errorf("stop order must sell the bought lot, got %v", so)
+		}
+		prices[so.OrderType] = so.StopPrice.Units
+	}
+	if prices[pb.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS] != 90 || prices[pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT] != 110 {
+		t.Errorf("stop prices = %v, want stop loss 90 and take profit 110", prices)
+	}
+}
+
+func TestProtectiveOrdersCheckTriggered(t *testing.T) {
+	server, b, instrument := newStopOrdersBroker(t)
+	po := placeProtectiveOrders(t, b, instrument)
+	ctx := context.Background()
+
+	closed, _, err := po.Check(ctx, instrument, 1)
+	if err != nil || closed {
+		t.Fatalf("Check() before trigger = %t, %v, want false", closed, err)
+	}
+
+	setTestPrice(server, 111)
+	// stop orders are got once in the check interval, Refresh makes Check get them at once
+	if closed, _, err := po.Check(ctx, instrument, 1); err != nil || closed {
+		t.Fatalf("Check() within the check interval = %t, %v, want false", closed, err)
+	}
+	po.Refresh()
+	closed, left, err := po.Check(ctx, instrument, 1)
+	if err != nil || !closed || left != 0 {
+		t.Fatalf("Check() after take profit = %t, %d, %v, want true, 0", closed, left, err)
+	}
+	if po.Active() {
+		t.Errorf("stop loss must be cancelled after take profit, got %+v", po)
+	}
+	if stopOrders, _ := b.GetStopOrders(ctx); len(stopOrders) != 0 {
+		t.Errorf("no stop orders must be left, got %v", stopOrders)
+	}
+}
+
+func TestProtectiveOrdersCheckWaitsForCancelled(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	po := placeProtectiveOrders(t, b, instrument)
+	ctx := context.Background()
+
+	if _, err := b.CancelStopOrder(ctx, po.StopLossID); err != nil {
+		t.Fatalf("can not cancel stop loss: %v", err)
+	}
+	closed, _, err := po.Check(ctx, instrument, 1)
+	if err != nil || closed {
+		t.Fatalf("Check() with the position held = %t, %v, want false", closed, err)
+	}
+	// the stop loss may be just triggered, it is forgotten only after stopOrderSettleTime
+	if po.StopLossID == "" || po.missingSince.IsZero() {
+		t.Errorf("missing stop loss must be kept until it settles, got %+v", po)
+	}
+
+	po.missingSince = po.missingSince.Add(-stopOrderSettleTime)
+	if closed, _, err := po.Check(ctx, instrument, 1); err != nil || closed {
+		t.Fatalf("Check() after settle time = %t, %v, want false", closed, err)
+	}
+	if po.StopLossID != "" || po.TakeProfitID == "" {
+		t.Errorf("cancelled stop loss must be forgotten, take profit kept, got %+v", po)
+	}
+}
+
+func TestProtectiveOrdersRestore(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	placed := placeProtectiveOrders(t, b, instrument)
+	ctx := context.Background()
+
+	po := NewProtectiveOrders(testFigi, b, 0, zap.NewNop().Sugar())
+	price, lots, err := po.Restore(ctx, instrument, 1)
+	if err != nil || lots != 1 {
+		t.Fatalf("Restore() = %d lots, %v, want 1", lots, err)
+	}
+	if po.StopLossID != placed.StopLossID || po.TakeProfitID != placed.TakeProfitID {
+		t.Errorf("restored %+v, want %+v", po, placed)
+	}
+	if price.Units != 100 {
+		t.Errorf("restored average price = %v, want 100", price)
+	}
+
+	// stop orders without a position are cancelled
+	_, err = b.PostOrder(ctx, &pb.PostOrderRequest{
+		Figi:      testFigi,
+		Quantity:  1,
+		Direction: pb.OrderDirection_ORDER_DIRECTION_SELL,
+		OrderType: pb.OrderType_ORDER_TYPE_MARKET,
+	})
+	if err != nil {
+		t.Fatalf("can not sell: %v", err)
+	}
+	po = NewProtectiveOrders(testFigi, b, 0, zap.NewNop().Sugar())
+	if _, lots, err := po.Restore(ctx, instrument, 1); err != nil || lots != 0 {
+		t.Errorf("Restore() without a position = %d lots, %v, want 0", lots, err)
+	}
+	if stopOrders, _ := b.GetStopOrders(ctx); len(stopOrders) != 0 {
+		t.Errorf("stop orders without a position must be cancelled, got %v", stopOrders)
+	}
+}
+
+// positionlessBroker lists no securities in positions, held quantity has to come from the portfolio.
+type positionlessBroker struct {
+	*broker.SimulatedBroker
+}
+
+func (b positionlessBroker) GetPositions(context.Context) (*pb.PositionsResponse, error) {
+	return &pb.PositionsResponse{}, nil
+}
+
+func TestProtectiveOrdersCheckReadsPortfolio(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	placed := placeProtectiveOrders(t, b, instrument)
+	ctx := context.Background()
+
+	po := NewProtectiveOrders(testFigi, positionlessBroker{b}, 0, zap.NewNop().Sugar())
+	if _, lots, err := po.Restore(ctx, instrument, 1); err != nil || lots != 1 {
+		t.Fatalf("Restore() = %d lots, %v, want 1", lots, err)
+	}
+
+	if _, err := b.CancelStopOrder(ctx, placed.StopLossID); err != nil {
+		t.Fatalf("can not cancel stop loss: %v", err)
+	}
+	closed, _, err := po.Check(ctx, instrument, 1)
+	if err != nil || closed {
+		t.Errorf("Check() of a position held by portfolio = %t, %v, want false", closed, err)
+	}
+}
@@ -0,0 +1,804 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	"github.com/elkopass/BITA/internal/loggy"
+	"github.com/elkopass/BITA/internal/metrics"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	cb "github.com/elkopass/BITA/internal/trade/breaker"
+	"github.com/elkopass/BITA/internal/trade/state"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signals tell Worker when to buy and sell; this is all a strategy trading by them has to implement.
+type Signals interface {
+	// OkToBuy returns true if the instrument should be bought now.
+	OkToBuy(ctx context.Context) (bool, error)
+	// OkToSell returns true if the held position should be sold now; it is also sold
+	// on stop loss or take profit, see WorkerConfig.
+	OkToSell(ctx context.Context) (bool, error)
+}
+
+// WorkerConfig is what Worker needs from a strategy config.
+type WorkerConfig struct {
+	Strategy       string        // strategy name the worker state is saved under
+	LotsToBuy      int64         // lots bought at once
+	StopLossCoef   float64       // sell when price falls below buy price multiplied by it
+	TakeProfitCoef float64       // sell when price rises above buy price multiplied by it
+	StopOrders     bool          // also place stop loss and take profit on exchange
+	SleepDuration  time.Duration // pause between iterations
+	CancelAfter    time.Duration // cancel an order not filled for so long
+
+	// StopOrdersCheckInterval is how often stop orders are checked to find out they have sold
+	// the position, DefaultStopOrdersCheckInterval if zero.
+	StopOrdersCheckInterval time.Duration
+
+	// CrossSpread makes limit orders take the best price of the other side of the order book,
+	// buying at the best ask and selling at the best bid; otherwise they wait at their own side.
+	CrossSpread bool
+}
+
+// Worker trades one instrument by Signals of a strategy: it buys LotsToBuy lots when OkToBuy says so
+// and sells them on OkToSell, stop loss or take profit. Worker places and tracks orders, keeps
+// exchange-side stop orders, persists its state and picks up the position of a previous run.
+type Worker struct {
+	ID        string
+	Figi      string
+	accountID string
+
+	sellFlag       bool           // if true, worker is trying to sell assets
+	buyPrice       *pb.MoneyValue // average price the held position is bought at
+	heldLots       int64          // lots of the held position, fewer than LotsToBuy after a partial fill
+	orders         *OrderManager  // the active order, if any
+	instrument     *pb.Instrument // lot, price increment and currency, see instrumentIsLoaded
+	buyPausedUntil time.Time      // set when there is not enough money to buy
+
+	stopOrders         *ProtectiveOrders    // nil if WorkerConfig.StopOrders is off or broker has no stop orders
+	stopOrdersRestored bool                 // stop orders of a previous run are looked for once
+	stateRestored      bool                 // saved state is loaded and reconciled once
+	start              *trade.StartPosition // how to start if there is no saved state, see trade.Reconcile
+
+	market        *marketData              // nil if market data is not streamed, it is polled then
+	subscriptions *sdk.SubscriptionManager // nil if market data stream is not available
+
+	signals  Signals
+	logger   *zap.SugaredLogger
+	breaker  cb.CircuitBreaker
+	config   WorkerConfig
+	broker   trade.Broker
+	services *sdk.ServicePool
+	store    *state.Store
+}
+
+// NewWorker creates Worker of figi with Signals returned by newSignals for it;
+// market data is streamed by subscriptions, or polled if they are nil.
+func NewWorker(figi string, cnf WorkerConfig, broker trade.Broker, services *sdk.ServicePool,
+	subscriptions *sdk.SubscriptionManager, store *state.Store, start *trade.StartPosition,
+	newSignals func(w *Worker) Signals) *Worker {
+	id := strings.Split(uuid.New().String(), "-")[0]
+
+	w := &Worker{
+		ID:            id,
+		Figi:          figi,
+		accountID:     broker.AccountID(),
+		broker:        broker,
+		config:        cnf,
+		services:      services,
+		store:         store,
+		subscriptions: subscriptions,
+		start:         start,
+		breaker:       *cb.NewCircuitBreaker(),
+		sellFlag:      false,
+		logger: loggy.GetLogger().Sugar().
+			With("bot_id", loggy.GetBotID()).
+			With("account_id", broker.AccountID()).
+			With("worker_id", id).
+			With("figi", figi),
+	}
+	w.orders = NewOrderManager(figi, broker, cnf.CancelAfter, w.logger,
+		OrderCallbacks{OnFilled: w.orderIsDone, OnCancelled: w.orderIsDone, OnChange: w.saveState})
+	if cnf.StopOrders {
+		w.stopOrders = NewProtectiveOrders(figi, broker, cnf.StopOrdersCheckInterval, w.logger)
+	}
+	w.signals = newSignals(w)
+
+	return w
+}
+
+// Logger returns the worker logger, Signals log with it.
+func (w *Worker) Logger() *zap.SugaredLogger {
+	return w.logger
+}
+
+// Services returns the API services, e.g. for Signals to get candles.
+func (w *Worker) Services() *sdk.ServicePool {
+	return w.services
+}
+
+func (w *Worker) Run(ctx context.Context, wg *sync.WaitGroup) (err error) {
+	defer wg.Done()
+
+	w.logger = w.logger.With("sell_flag", w.sellFlag)
+	w.logger.Debug("start trading...")
+
+	if w.subscriptions != nil {
+		if w.market, err = subscribeMarketData(w.subscriptions, w.Figi); err != nil {
+			w.logger.Warnf("can not subscribe to market data, polling it: %v", err)
+		} else {
+			defer w.market.close()
+		}
+	}
+
+	// a ticker, unlike time.After, keeps the pace however often market data comes
+	ticker := time.NewTicker(w.config.SleepDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if w.breaker.WorkerMustExit() {
+				w.logger.Error("worker stopped by circuit breaker")
+				metrics.StoppedByCircuitBreaker.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+				return
+			}
+
+			if !w.instrumentIsLoaded(ctx) {
+				continue // just skip
+			}
+
+			if !w.stateIsRestored(ctx) {
+				continue // just skip
+			}
+
+			if !w.stopOrdersAreRestored(ctx) {
+				continue // just skip
+			}
+
+			if !w.tradingStatusIsOkToTrade(ctx) {
+				continue // just skip
+			}
+
+			if w.orders.Active() {
+				if err := w.orders.Check(ctx); err != nil {
+					w.logger.Errorf("can not check order state: %v", err)
+					w.breaker.IncFailures()
+				}
+				continue
+			}
+
+			if w.sellFlag {
+				if w.positionIsClosedByStopOrder(ctx) {
+					continue
+				}
+				w.tryToSellInstrument(ctx)
+			} else {
+				w.tryToBuyInstrument(ctx)
+			}
+		case <-ctx.Done():
+			w.logger.Info("worker stopped!")
+
+			if config.TradeBotConfig().SellOnExit && (w.sellFlag || w.orderIsPartiallyFilled()) {
+				w.logger.Info("SELL_ON_EXIT flag is set, trying to sell an asset...")
+				// ctx is already cancelled, but the position still has to be closed
+				return w.sellOnExit(context.Background())
+			}
+
+			return nil
+		}
+	}
+}
+
+// sellOnExit immediately creates sell order if worker has an instrument.
+func (w *Worker) sellOnExit(ctx context.Context) error {
+	if !w.instrumentIsLoaded(ctx) {
+		return errors.New("can not sell without instrument details")
+	}
+
+	orderBook, err := w.getOrderBook(ctx)
+	if err != nil {
+		w.logger.Errorf("error getting order book: %v", err)
+		w.breaker.IncFailures()
+		return err
+	}
+
+	fairPrice, err := tradeutil.CalculateFairBuyPrice(*orderBook)
+	if err != nil {
+		w.logger.Errorf("can not calculate fair price: %v", err)
+		return err
+	}
+	fairPrice, err = RoundPrice(w.instrument, fairPrice)
+	if err != nil {
+		w.logger.Errorf("can not round fair price: %v", err)
+		return err
+	}
+
+	// the active order goes first: lots it has executed change the position
+	if err := w.orders.Cancel(ctx); err != nil {
+		return fmt.Errorf("can not cancel active order: %v", err)
+	}
+	if w.heldLots == 0 {
+		return nil // the cancelled buy order has not executed anything
+	}
+
+	if w.positionIsClosedSinceLastCheck(ctx) {
+		return nil
+	}
+	if !w.stopOrdersAreCancelled(ctx) {
+		return errors.New("can not sell while stop orders are placed")
+	}
+
+	err = w.orders.Place(ctx, w.instrument, pb.OrderDirection_ORDER_DIRECTION_SELL, pb.OrderType_ORDER_TYPE_MARKET,
+		fairPrice, w.heldLots)
+	if err != nil {
+		w.logger.Errorf("can not post sell order: %v", err)
+		if IsBreakerFailure(err) {
+			w.breaker.IncFailures()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// checkPortfolio calls sdk.OperationsService.GetPortfolio and updates portfolio metrics.
+func (w *Worker) checkPortfolio(ctx context.Context) {
+	portfolio, err := w.broker.GetPortfolio(ctx)
+	if err != nil {
+		w.logger.Errorf("error getting portfolio: %v", err)
+		w.breaker.IncFailures()
+		return // just ignoring it
+	}
+
+	w.logger.Info("positions: ", tradeutil.GetFormattedPositions(portfolio.Positions))
+	SetPortfolioMetrics(*portfolio, w.accountID)
+
+	if portfolio.ExpectedYield != nil {
+		w.logger.Infof("expected yield: %s", tradeutil.DecimalFromQuotation(portfolio.ExpectedYield))
+		metrics.PortfolioExpectedYieldOverall.WithLabelValues(w.accountID).Set(tradeutil.QuotationToFloat(*portfolio.ExpectedYield))
+	}
+}
+
+// orderIsDone updates the position by lots executed by a filled, cancelled or expired order:
+// the worker sells whatever is bought, even a part of the lots, by the average execution price,
+// and goes back to buying when nothing is left to sell.
+func (w *Worker) orderIsDone(ctx context.Context, o Order) {
+	if o.LotsExecuted == 0 {
+		return
+	}
+
+	if o.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		w.sellFlag = true
+		w.heldLots = o.LotsExecuted
+		w.buyPrice = o.ExecutionPrice()
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+		w.placeStopOrders(ctx)
+	} else if w.heldLots > o.LotsExecuted {
+		w.heldLots -= o.LotsExecuted
+		w.logger.Infof("%d lots are left to sell", w.heldLots) // stop orders are placed again for them
+	} else {
+		w.sellFlag = false
+		w.heldLots = 0
+		w.buyPrice = nil
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), w.Figi).Dec()
+		w.stopOrdersAreCancelled(ctx) // a sold position needs no protection
+	}
+
+	go w.checkPortfolio(ctx)
+}
+
+// orderIsPartiallyFilled returns true if the active order has executed some lots by the last check.
+func (w *Worker) orderIsPartiallyFilled() bool {
+	o := w.orders.Order()
+	return o != nil && o.LotsExecuted > 0
+}
+
+// tryToSellInstrument calls sdk.MarketDataService.GetOrderBook and if priceIsOkToSell
+// or Signals.OkToSell a limit sell order is placed.
+func (w *Worker) tryToSellInstrument(ctx context.Context) {
+	orderBook, err := w.getOrderBook(ctx)
+	if err != nil {
+		w.logger.Errorf("error getting order book: %v", err)
+		w.breaker.IncFailures()
+		return // just ignoring it
+	}
+
+	priceIsOK := w.priceIsOkToSell(*orderBook)
+	signalIsOK, err := w.signals.OkToSell(ctx)
+	if err != nil {
+		w.logger.Errorf("can not check sell signal: %v", err)
+		w.breaker.IncFailures()
+	}
+	if !priceIsOK && !signalIsOK {
+		w.logger.Debug("price is not OK to sell")
+		return // wait for the next turn
+	}
+
+	fairPrice, err := tradeutil.CalculateFairSellPrice(*orderBook)
+	if w.config.CrossSpread {
+		fairPrice, err = tradeutil.CalculateFairBuyPrice(*orderBook)
+	}
+	if err != nil {
+		w.logger.Errorf("can not calculate fair price: %v", err)
+		return // try again next time
+	}
+	fairPrice, err = RoundPrice(w.instrument, fairPrice)
+	if err != nil {
+		w.logger.Errorf("can not round fair price: %v", err)
+		return // try again next time
+	}
+
+	if w.positionIsClosedSinceLastCheck(ctx) {
+		return
+	}
+	if !w.stopOrdersAreCancelled(ctx) {
+		return // the position is still protected, try again next time
+	}
+
+	err = w.orders.Place(ctx, w.instrument, pb.OrderDirection_ORDER_DIRECTION_SELL, pb.OrderType_ORDER_TYPE_LIMIT,
+		fairPrice, w.heldLots)
+	if err != nil {
+		w.logger.Errorf("can not post sell order: %v", err)
+		if IsBreakerFailure(err) {
+			w.breaker.IncFailures()
+		}
+		return // nothing bad happened, let's proceed
+	}
+
+	go w.checkPortfolio(ctx)
+}
+
+// tryToBuyInstrument calls sdk.MarketDataService.GetOrderBook and if Signals.OkToBuy
+// a limit buy order is placed.
+func (w *Worker) tryToBuyInstrument(ctx context.Context) {
+	if time.Now().Before(w.buyPausedUntil) {
+		return // wait for money, see InsufficientBalancePause
+	}
+
+	signalIsOK, err := w.signals.OkToBuy(ctx)
+	if err != nil {
+		w.logger.Errorf("can not check buy signal: %v", err)
+		w.breaker.IncFailures()
+	}
+	if !signalIsOK {
+		return // wait for the next turn
+	}
+
+	orderBook, err := w.getOrderBook(ctx)
+	if err != nil {
+		w.logger.Errorf("error getting order book: %v", err)
+		w.breaker.IncFailures()
+		return // just ignoring it
+	}
+
+	fairPrice, err := tradeutil.CalculateFairBuyPrice(*orderBook)
+	if w.config.CrossSpread {
+		fairPrice, err = tradeutil.CalculateFairSellPrice(*orderBook)
+	}
+	if err != nil {
+		w.logger.Errorf("can not calculate fair price: %v", err)
+		return // try again next time
+	}
+	fairPrice, err = RoundPrice(w.instrument, fairPrice)
+	if err != nil {
+		w.logger.Errorf("can not round fair price: %v", err)
+		return // try again next time
+	}
+
+	lastPrice := tradeutil.DecimalFromQuotation(orderBook.LastPrice).Float64()
+	fairMarketPrice := tradeutil.QuotationToFloat(*fairPrice)
+
+	metrics.InstrumentLastPrice.WithLabelValues(w.Figi).Set(lastPrice)
+	metrics.InstrumentFairPrice.WithLabelValues(w.Figi).Set(fairMarketPrice)
+	w.logger.Infof("last price: %f, fair price: %f", lastPrice, fairMarketPrice)
+
+	err = w.orders.Place(ctx, w.instrument, pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_LIMIT,
+		fairPrice, w.config.LotsToBuy)
+	if errors.Is(err, sdk.ErrInsufficientBalance) {
+		w.buyPausedUntil = time.Now().Add(InsufficientBalancePause)
+		w.logger.Warnf("not enough money to buy, buying is paused for %s", InsufficientBalancePause)
+		return
+	}
+	if err != nil {
+		w.logger.Errorf("can not post buy order: %v", err)
+		return // nothing bad happened, let's proceed
+	}
+}
+
+// priceIsOkToSell returns true if (price > expected profit) or (price < expected loss);
+// without a buy price neither is known, and only Signals.OkToSell sells then.
+func (w *Worker) priceIsOkToSell(orderBook pb.GetOrderBookResponse) bool {
+	buyPrice := tradeutil.DecimalFromMoneyValue(w.buyPrice)
+	if buyPrice.Sign() <= 0 {
+		w.logger.Warnf("buy price %s is unknown, stop loss and take profit are not checked",
+			tradeutil.MoneyFromMoneyValue(w.buyPrice))
+		return false
+	}
+
+	fairPrice, err := tradeutil.CalculateFairSellPrice(orderBook)
+	if err != nil {
+		w.logger.Warnf("can't calculate fairMarketPrice price: %v", err.Error())
+		return false
+	}
+
+	lastPrice := tradeutil.DecimalFromQuotation(orderBook.LastPrice)
+	fairMarketPrice := tradeutil.DecimalFromQuotation(fairPrice)
+
+	expectedProfit, err := buyPrice.Mul(tradeutil.DecimalFromFloat(w.config.TakeProfitCoef))
+	if err != nil {
+		w.logger.Warnf("can not calculate expected profit: %v", err)
+		return false
+	}
+	expectedLoss, err := buyPrice.Mul(tradeutil.DecimalFromFloat(w.config.StopLossCoef))
+	if err != nil {
+		w.logger.Warnf("can not calculate expected loss: %v", err)
+		return false
+	}
+
+	metrics.InstrumentLastPrice.WithLabelValues(w.Figi).Set(lastPrice.Float64())
+	metrics.InstrumentFairPrice.WithLabelValues(w.Figi).Set(fairMarketPrice.Float64())
+	w.logger.Infof("buy price: %s, fair price: %s, expected: %s, last: %s, stop loss: %s",
+		buyPrice, fairMarketPrice, expectedProfit, lastPrice, expectedLoss)
+
+	if fairMarketPrice.LessThan(expectedLoss) {
+		metrics.StopLossDecisions.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+		return true
+	}
+	if fairMarketPrice.GreaterThan(expectedProfit) {
+		metrics.TakeProfitDecisions.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+		return true
+	}
+
+	return false
+}
+
+// instrumentIsLoaded gets instrument details from sdk.InstrumentRegistry once and returns true if they are known.
+func (w *Worker) instrumentIsLoaded(ctx context.Context) bool {
+	if w.instrument != nil {
+		return true
+	}
+
+	instrument, err := w.services.Instruments.ByFigi(ctx, w.Figi)
+	if err != nil {
+		w.logger.Errorf("error getting instrument: %v", err)
+		w.breaker.IncFailures()
+		return false
+	}
+
+	w.instrument = instrument
+	w.logger.Infof("instrument: %s (%s), lot: %d, price increment: %s, currency: %s",
+		instrument.Name, instrument.Ticker, instrument.Lot,
+		tradeutil.DecimalFromQuotation(instrument.MinPriceIncrement), instrument.Currency)
+
+	return true
+}
+
+// stateIsRestored loads the state saved by a previous run once and reconciles it with the broker:
+// an order which is unknown to the broker is forgotten, and so is a position which is not held anymore.
+// Orders filled or cancelled while the bot was down are handled by OrderManager as usual.
+func (w *Worker) stateIsRestored(ctx context.Context) bool {
+	if w.stateRestored {
+		return true
+	}
+
+	saved, err := w.store.Load(w.config.Strategy, w.accountID, w.Figi)
+	if err != nil {
+		w.logger.Errorf("can not load worker state, starting from scratch: %v", err)
+	}
+	if saved == nil {
+		w.applyStartPosition()
+		w.stateRestored = true
+		return true
+	}
+
+	if saved.OrderID != "" {
+		_, err := w.broker.GetOrderState(ctx, saved.OrderID)
+		if errors.Is(err, sdk.ErrOrderNotFound) {
+			w.logger.With("order_id", saved.OrderID).Warn("saved order is not found, forgetting it")
+			saved.OrderID, saved.OrderPlacedTime = "", nil
+		} else if err != nil {
+			w.logger.With("order_id", saved.OrderID).Errorf("can not check saved order: %v", err)
+			w.breaker.IncFailures()
+			return false // try again next time
+		}
+	}
+	if saved.SellFlag && saved.HeldLots == 0 {
+		saved.HeldLots = w.config.LotsToBuy // saved before partial fills were tracked
+	}
+	if saved.SellFlag && saved.OrderID == "" {
+		held, err := HeldQuantity(ctx, w.broker, w.Figi)
+		if err != nil {
+			w.logger.Errorf("can not check saved position: %v", err)
+			w.breaker.IncFailures()
+			return false // try again next time
+		}
+		if lots := held / int64(w.instrument.Lot); lots == 0 {
+			w.logger.Warnf("saved position is not held anymore (%d pieces left), forgetting it", held)
+			saved.SellFlag, saved.HeldLots, saved.OrderPrice = false, 0, nil
+		} else if lots < saved.HeldLots {
+			w.logger.Warnf("only %d of %d saved lots are held, selling them", lots, saved.HeldLots)
+			saved.HeldLots = lots
+		}
+	}
+
+	if saved.SellFlag && tradeutil.DecimalFromMoneyValue(saved.OrderPrice).Sign() <= 0 {
+		p, err := portfolioPosition(ctx, w.broker, w.Figi)
+		if err != nil {
+			w.logger.Errorf("can not get average price of saved position: %v", err)
+			w.breaker.IncFailures()
+			return false // try again next time
+		}
+		if p == nil || tradeutil.DecimalFromMoneyValue(p.AveragePositionPrice).Sign() <= 0 {
+			// without a buy price neither stop loss nor take profit can be checked
+			w.logger.Error("saved position has no buy price, and its average price is unknown")
+			w.breaker.IncFailures()
+			return false // try again next time
+		}
+		w.logger.Warnf("saved position has no buy price, taking its average price %s",
+			tradeutil.MoneyFromMoneyValue(p.AveragePositionPrice))
+		saved.OrderPrice = p.AveragePositionPrice
+	}
+
+	w.sellFlag = saved.SellFlag
+	if w.sellFlag {
+		w.heldLots = saved.HeldLots
+		w.buyPrice = saved.OrderPrice
+	}
+	if saved.OrderID != "" {
+		o := Order{ID: saved.OrderID, Direction: pb.OrderDirection_ORDER_DIRECTION_BUY, PlacedTime: time.Now().Unix()}
+		if w.sellFlag {
+			o.Direction = pb.OrderDirection_ORDER_DIRECTION_SELL
+		} else {
+			o.Price = saved.OrderPrice
+		}
+		if saved.OrderPlacedTime != nil {
+			o.PlacedTime = *saved.OrderPlacedTime
+		}
+		w.orders.Track(o)
+	}
+	w.stopOrdersRestored = true // the saved state knows its stop orders, no need to look for them
+	if w.stopOrders != nil {
+		w.stopOrders.StopLossID, w.stopOrders.TakeProfitID = saved.StopLossID, saved.TakeProfitID
+		if !w.sellFlag && w.stopOrders.Active() {
+			if err := w.stopOrders.Cancel(ctx); err != nil {
+				w.logger.Errorf("can not cancel stop orders of a closed position: %v", err)
+			}
+		}
+	}
+
+	w.countTakenOver()
+
+	w.logger.With("order_id", saved.OrderID).Infof("worker state restored, sell flag: %t, order price: %s",
+		w.sellFlag, tradeutil.MoneyFromMoneyValue(saved.OrderPrice))
+	w.stateRestored = true
+	w.saveState()
+
+	return true
+}
+
+// applyStartPosition starts the worker as trade.Reconcile decided by the account portfolio and orders.
+func (w *Worker) applyStartPosition() {
+	if w.start == nil {
+		return
+	}
+
+	w.sellFlag = w.start.SellMode
+	if w.sellFlag {
+		w.heldLots = w.start.HeldLots
+		if w.heldLots > w.config.LotsToBuy {
+			w.heldLots = w.config.LotsToBuy // lots held above it are not the worker's
+		}
+		w.buyPrice = w.start.OrderPrice
+	}
+	if w.start.Order != nil {
+		w.orders.Track(OrderFromState(w.start.Order))
+	}
+
+	w.countTakenOver()
+	w.saveState()
+}
+
+// countTakenOver updates the gauge for a position the worker takes over on start;
+// a taken over order is counted by OrderManager.Track.
+func (w *Worker) countTakenOver() {
+	if w.sellFlag {
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+	}
+}
+
+// saveState persists the worker state; it is called on every transition, so a restart
+// continues from the last one.
+func (w *Worker) saveState() {
+	st := state.WorkerState{SellFlag: w.sellFlag, HeldLots: w.heldLots, OrderPrice: w.buyPrice}
+	if o := w.orders.Order(); o != nil {
+		st.OrderID, st.OrderPlacedTime = o.ID, &o.PlacedTime
+		if !w.sellFlag {
+			st.OrderPrice = o.Price
+		}
+	}
+	if w.stopOrders != nil {
+		st.StopLossID, st.TakeProfitID = w.stopOrders.StopLossID, w.stopOrders.TakeProfitID
+	}
+
+	if err := w.store.Save(w.config.Strategy, w.accountID, w.Figi, st); err != nil {
+		w.logger.Errorf("can not save worker state: %v", err)
+	}
+}
+
+// stopOrdersAreRestored looks for stop orders left by a previous run once; if they protect a position,
+// the worker goes on selling it with the position average price as order price.
+func (w *Worker) stopOrdersAreRestored(ctx context.Context) bool {
+	if w.stopOrders == nil || w.stopOrdersRestored {
+		return true
+	}
+
+	lots := w.config.LotsToBuy
+	if w.sellFlag {
+		lots = w.heldLots
+	}
+	price, held, err := w.stopOrders.Restore(ctx, w.instrument, lots)
+	if errors.Is(err, trade.ErrStopOrdersNotSupported) {
+		w.disableStopOrders()
+		return true
+	}
+	if err != nil {
+		w.logger.Errorf("can not restore stop orders: %v", err)
+		w.breaker.IncFailures()
+		return false
+	}
+
+	if held > 0 && !w.sellFlag && tradeutil.DecimalFromMoneyValue(price).Sign() <= 0 {
+		w.logger.Errorf("average price %s of the position protected by stop orders is unknown, checking it again",
+			tradeutil.MoneyFromMoneyValue(price))
+		w.breaker.IncFailures()
+		return false // selling needs the buy price, see priceIsOkToSell
+	}
+
+	w.stopOrdersRestored = true
+	if held > 0 && !w.sellFlag {
+		w.sellFlag = true
+		w.buyPrice = price
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), w.Figi).Inc()
+	}
+	if held > 0 {
+		w.heldLots = held
+	}
+	w.saveState()
+
+	return true
+}
+
+// placeStopOrders places missing stop loss and take profit for the bought position;
+// if it fails, they are placed again on the next turn. Stop prices are derived from the buy price,
+// so nothing is placed without it.
+func (w *Worker) placeStopOrders(ctx context.Context) {
+	if w.stopOrders == nil {
+		return
+	}
+	if tradeutil.DecimalFromMoneyValue(w.buyPrice).Sign() <= 0 {
+		w.logger.Errorf("buy price %s is unknown, stop orders are not placed", tradeutil.MoneyFromMoneyValue(w.buyPrice))
+		return
+	}
+
+	err := w.stopOrders.Place(ctx, w.instrument, tradeutil.DecimalFromMoneyValue(w.buyPrice),
+		w.heldLots, w.config.StopLossCoef, w.config.TakeProfitCoef)
+	if errors.Is(err, trade.ErrStopOrdersNotSupported) {
+		w.disableStopOrders()
+		return
+	}
+	if err != nil {
+		w.logger.Errorf("can not place stop orders: %v", err)
+		if IsBreakerFailure(err) {
+			w.breaker.IncFailures()
+		}
+	}
+	w.saveState() // even partially placed stop orders have to be known after restart
+}
+
+// positionIsClosedByStopOrder returns true if stop loss or take profit has sold the position;
+// missing stop orders of a still held position are placed again.
+func (w *Worker) positionIsClosedByStopOrder(ctx context.Context) bool {
+	if w.stopOrders == nil {
+		return false
+	}
+
+	closed, left, err := w.stopOrders.Check(ctx, w.instrument, w.heldLots)
+	if err != nil {
+		w.logger.Errorf("can not check stop orders: %v", err)
+		w.breaker.IncFailures()
+		return false
+	}
+	if closed && left > 0 {
+		w.logger.Warnf("stop order sold the position partially, %d lots are left to sell", left)
+		w.heldLots = left
+		w.saveState()
+	}
+	if !closed || left > 0 {
+		w.placeStopOrders(ctx)
+		return false
+	}
+
+	w.sellFlag = false
+	w.heldLots = 0
+	w.buyPrice = nil
+	metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), w.Figi).Dec()
+	w.saveState()
+	go w.checkPortfolio(ctx)
+
+	return true
+}
+
+// positionIsClosedSinceLastCheck checks stop orders at once, as they are checked only once
+// in a while and may have sold the position the worker is going to sell itself.
+func (w *Worker) positionIsClosedSinceLastCheck(ctx context.Context) bool {
+	if w.stopOrders == nil {
+		return false
+	}
+
+	w.stopOrders.Refresh()
+	return w.positionIsClosedByStopOrder(ctx)
+}
+
+// stopOrdersAreCancelled cancels stop orders before the worker sells the position itself.
+func (w *Worker) stopOrdersAreCancelled(ctx context.Context) bool {
+	if w.stopOrders == nil {
+		return true
+	}
+
+	if err := w.stopOrders.Cancel(ctx); err != nil {
+		w.logger.Errorf("can not cancel stop orders: %v", err)
+		w.breaker.IncFailures()
+		return false
+	}
+
+	return true
+}
+
+func (w *Worker) disableStopOrders() {
+	w.logger.Warnf("%s broker does not support stop orders, stop loss and take profit are checked by polling only",
+		w.broker.Name())
+	w.stopOrders = nil
+}
+
+// getOrderBook returns the streamed order book, or requests it if there is no fresh one.
+func (w *Worker) getOrderBook(ctx context.Context) (*pb.GetOrderBookResponse, error) {
+	if orderBook := w.market.orderBook(); orderBook != nil {
+		return orderBook, nil
+	}
+
+	return w.services.MarketDataService.GetOrderBook(ctx, w.Figi, orderBookDepth)
+}
+
+// tradingStatusIsOkToTrade returns true if trading status is normal; a streamed status is used if any.
+func (w *Worker) tradingStatusIsOkToTrade(ctx context.Context) bool {
+	var tradingStatus pb.SecurityTradingStatus
+	if status := w.market.tradingStatus(); status != nil {
+		tradingStatus = status.TradingStatus
+	} else {
+		status, err := w.services.MarketDataService.GetTradingStatus(ctx, w.Figi)
+		if err != nil {
+			w.logger.Errorf("error getting trading status: %v", err)
+			w.breaker.IncFailures()
+			return false
+		}
+		tradingStatus = status.TradingStatus
+		w.market.keepTradingStatus(&pb.TradingStatus{Figi: status.Figi, TradingStatus: status.TradingStatus})
+	}
+
+	w.logger.Infof("trading status: %s", tradingStatus.String())
+	for _, s := range pb.SecurityTradingStatus_name {
+		metrics.InstrumentTradingStatus.WithLabelValues(w.Figi, s).Set(0)
+	}
+	metrics.InstrumentTradingStatus.WithLabelValues(w.Figi, tradingStatus.String()).Set(1)
+
+	return tradingStatus == pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	"github.com/elkopass/BITA/internal/trade/state"
+	"testing"
+)
+
+// newStopOrdersWorker returns a worker with stop orders selling the lot held by newStopOrdersBroker.
+func newStopOrdersWorker(t *testing.T) *Worker {
+	_, b, instrument := newStopOrdersBroker(t)
+
+	w := newTestWorker(b, instrument)
+	w.sellFlag = true
+	w.heldLots = 1
+
+	return w
+}
+
+// newTestWorker returns a worker with stop orders buying a lot of instrument.
+func newTestWorker(b trade.Broker, instrument *pb.Instrument) *Worker {
+	cnf := WorkerConfig{LotsToBuy: 1, StopLossCoef: 0.9, TakeProfitCoef: 1.1, StopOrders: true}
+
+	w := NewWorker(testFigi, cnf, b, nil, nil, nil, nil, func(*Worker) Signals { return nil })
+	w.instrument = instrument
+
+	return w
+}
+
+// unpricedBroker returns portfolio positions without average price, as API may do right after a trade.
+type unpricedBroker struct {
+	*broker.SimulatedBroker
+}
+
+func (b unpricedBroker) GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error) {
+	portfolio, err := b.SimulatedBroker.GetPortfolio(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range portfolio.Positions {
+		p.AveragePositionPrice = nil
+	}
+
+	return portfolio, nil
+}
+
+func TestWorkerDoesNotSellWithoutBuyPrice(t *testing.T) {
+	w := newStopOrdersWorker(t)
+	crashed := pb.GetOrderBookResponse{
+		Figi:      testFigi,
+		Bids:      []*pb.Order{{Price: &pb.Quotation{Units: 1}, Quantity: 100}},
+		Asks:      []*pb.Order{{Price: &pb.Quotation{Units: 1}, Quantity: 100}},
+		LastPrice: &pb.Quotation{Units: 1},
+	}
+
+	for _, price := range []*pb.MoneyValue{nil, {Currency: "rub"}, {Currency: "rub", Units: -1}} {
+		w.buyPrice = price
+		if w.priceIsOkToSell(crashed) {
+			t.Errorf("priceIsOkToSell() with buy price %v = true, want false", price)
+		}
+	}
+
+	w.buyPrice = &pb.MoneyValue{Currency: "rub", Units: 100}
+	if !w.priceIsOkToSell(crashed) {
+		t.Error("priceIsOkToSell() below stop loss = false, want true")
+	}
+}
+
+func TestWorkerDoesNotPlaceStopOrdersWithoutBuyPrice(t *testing.T) {
+	w := newStopOrdersWorker(t)
+	ctx := context.Background()
+
+	w.buyPrice = &pb.MoneyValue{Currency: "rub"}
+	w.placeStopOrders(ctx)
+	if w.stopOrders.Active() {
+		t.Fatalf("stop orders must not be placed by zero buy price, got %+v", w.stopOrders)
+	}
+
+	w.buyPrice = &pb.MoneyValue{Currency: "rub", Units: 100}
+	w.placeStopOrders(ctx)
+	if w.stopOrders.StopLossID == "" || w.stopOrders.TakeProfitID == "" {
+		t.Errorf("stop orders must be placed by buy price 100, got %+v", w.stopOrders)
+	}
+}
+
+func TestWorkerApplyStartPosition(t *testing.T) {
+	tests := []struct {
+		heldLots int64
+		want     int64
+	}{
+		{3, 2}, // lots held above LotsToBuy are not the worker's
+		{2, 2},
+		{1, 1},
+	}
+
+	for _, tt := range tests {
+		w := newStopOrdersWorker(t)
+		w.sellFlag, w.heldLots = false, 0
+		w.config.LotsToBuy = 2
+		w.start = &trade.StartPosition{
+			Figi:       testFigi,
+			SellMode:   true,
+			HeldLots:   tt.heldLots,
+			OrderPrice: &pb.MoneyValue{Currency: "rub", Units: 100},
+		}
+
+		w.applyStartPosition()
+		if !w.sellFlag || w.heldLots != tt.want || w.buyPrice.Units != 100 {
+			t.Errorf("applyStartPosition() of %d held lots: sell flag %t, %d lots by %v, want %d lots by 100",
+				tt.heldLots, w.sellFlag, w.heldLots, w.buyPrice, tt.want)
+		}
+	}
+}
+
+func TestWorkerRestoresStopOrdersWithBuyPrice(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	placeProtectiveOrders(t, b, instrument)
+	ctx := context.Background()
+
+	w := newTestWorker(unpricedBroker{b}, instrument)
+	if w.stopOrdersAreRestored(ctx) || w.sellFlag {
+		t.Fatalf("stop orders of a position without average price must not be restored, sell flag %t", w.sellFlag)
+	}
+
+	w = newTestWorker(b, instrument)
+	if !w.stopOrdersAreRestored(ctx) {
+		t.Fatal("stop orders must be restored")
+	}
+	if !w.sellFlag || w.heldLots != 1 || w.buyPrice.Units != 100 {
+		t.Errorf("restored position: sell flag %t, %d lots by %v, want 1 lot by 100", w.sellFlag, w.heldLots, w.buyPrice)
+	}
+}
+
+func TestWorkerRestoresSavedStateWithBuyPrice(t *testing.T) {
+	_, b, instrument := newStopOrdersBroker(t)
+	ctx := context.Background()
+	store := state.NewStore(t.TempDir())
+	// a state saved without the price of the position
+	if err := store.Save("test", b.AccountID(), testFigi, state.WorkerState{SellFlag: true, HeldLots: 1}); err != nil {
+		t.Fatalf("can not save state: %v", err)
+	}
+
+	w := newTestWorker(unpricedBroker{b}, instrument)
+	w.config.Strategy, w.store = "test", store
+	if w.stateIsRestored(ctx) || w.sellFlag {
+		t.Fatalf("saved position without any known price must not be restored, sell flag %t", w.sellFlag)
+	}
+
+	w = newTestWorker(b, instrument)
+	w.config.Strategy, w.store = "test", store
+	if !w.stateIsRestored(ctx) {
+		t.Fatal("saved state must be restored")
+	}
+	if !w.sellFlag || w.heldLots != 1 || w.buyPrice.Units != 100 {
+		t.Errorf("restored position: sell flag %t, %d lots by %v, want 1 lot by the average price 100",
+			w.sellFlag, w.heldLots, w.buyPrice)
+	}
+}
@@ -0,0 +1,185 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"github.com/elkopass/BITA/internal/loggy"
+	pb "github.com/elkopass/BITA/internal/proto"
+	tradeutil "github.com/elkopass/BITA/internal/trade/util"
+	"strings"
+)
+
+// Policies for active orders found on the account on start, see Reconcile.
+const (
+	// StrayOrdersAdopt makes a worker track the newest order matching its mode; other orders are cancelled.
+	StrayOrdersAdopt = "adopt"
+	// StrayOrdersCancel cancels all orders of traded instruments.
+	StrayOrdersCancel = "cancel"
+	// StrayOrdersKeep leaves orders as is; workers do not know about them.
+	StrayOrdersKeep = "keep"
+)
+
+// portfolioAttempts is how many times Reconcile gets the portfolio waiting for average prices of held positions.
+const portfolioAttempts = 2
+
+// StartPosition is what a worker finds on the account on start.
+type StartPosition struct {
+	Figi       string
+	SellMode   bool           // enough lots are held, the worker starts with selling them
+	HeldLots   int64          // lots on the account, may be fewer than the worker trades
+	OrderPrice *pb.MoneyValue // average position price in sell mode, price of the adopted order otherwise
+	Order      *pb.OrderState // adopted active order, nil if there is none
+}
+
+// Reconcile decides how workers of figi start by the account portfolio and active orders,
+// so a worker does not buy again what is already bought, e.g. by hand in the app.
+// A worker starts in sell mode if at least lotsToBuy lots are held; Reconcile fails if their average
+// price is unknown, as the worker could not tell stop loss from take profit. Active orders of traded
+// instruments are handled by policy; orders of other instruments are never touched.
+func Reconcile(ctx context.Context, broker Broker, figi []string, lotsToBuy int64, policy string) (map[string]*StartPosition, error) {
+	if policy != StrayOrdersAdopt && policy != StrayOrdersCancel && policy != StrayOrdersKeep {
+		return nil, fmt.Errorf("unknown stray orders policy '%s'; possible values: %s, %s, %s",
+			policy, StrayOrdersAdopt, StrayOrdersCancel, StrayOrdersKeep)
+	}
+	logger := loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()).With("account_id", broker.AccountID())
+
+	positions := make(map[string]*StartPosition)
+	for _, f := range figi {
+		positions[f] = &StartPosition{Figi: f}
+	}
+	// a position may come without its average price, e.g. right after a trade, so it is asked again;
+	// the price is what the worker sells by, it never starts selling without one
+	for attempt := 1; ; attempt++ {
+		unpriced, err := applyPortfolio(ctx, broker, positions, lotsToBuy)
+		if err != nil {
+			return nil, err
+		}
+		if len(unpriced) == 0 {
+			break
+		}
+		if attempt == portfolioAttempts {
+			return nil, fmt.Errorf("average price of held %s is unknown, can not start selling",
+				strings.Join(unpriced, ", "))
+		}
+		logger.Warnf("average price of held %s is unknown, getting portfolio again", strings.Join(unpriced, ", "))
+	}
+
+	orders, err := broker.GetOrders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can not get orders: %w", err)
+	}
+
+	byFigi := make(map[string][]*pb.OrderState)
+	others := 0
+	for _, o := range orders {
+		if _, ok := positions[o.Figi]; !ok {
+			others++
+			continue
+		}
+		byFigi[o.Figi] = append(byFigi[o.Figi], o)
+	}
+
+	for _, f := range figi {
+		position := positions[f]
+
+		var cancelled []string
+		for _, o := range byFigi[f] {
+			if policy == StrayOrdersKeep {
+				continue
+			}
+			if policy == StrayOrdersAdopt && orderMatchesMode(o, position.SellMode) &&
+				(position.Order == nil || o.OrderDate.AsTime().After(position.Order.OrderDate.AsTime())) {
+				if position.Order != nil {
+					cancelled = append(cancelled, position.Order.OrderId)
+				}
+				position.Order = o
+				continue
+			}
+			cancelled = append(cancelled, o.OrderId)
+		}
+
+		for _, orderID := range cancelled {
+			if _, err := broker.CancelOrder(ctx, orderID); err != nil {
+				return nil, fmt.Errorf("can not cancel order %s of %s: %w", orderID, f, err)
+			}
+		}
+		if position.Order != nil && !position.SellMode {
+			position.OrderPrice = position.Order.InitialSecurityPrice
+		}
+
+		logger.With("figi", f).Info(position.summary(len(byFigi[f]), len(cancelled)))
+	}
+	if others > 0 {
+		logger.Infof("%d active orders of other instruments are left as is", others)
+	}
+
+	return positions, nil
+}
+
+// applyPortfolio sets held lots of positions by the portfolio and returns FIGIs held enough to start
+// selling, but without average price.
+func applyPortfolio(ctx context.Context, broker Broker, positions map[string]*StartPosition, lotsToBuy int64) ([]string, error) {
+	portfolio, err := broker.GetPortfolio(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can not get portfolio: %w", err)
+	}
+
+	var unpriced []string
+	for _, p := range portfolio.Positions {
+		position, ok := positions[p.Figi]
+		if !ok {
+			continue
+		}
+
+		position.HeldLots = tradeutil.DecimalFromQuotation(p.QuantityLots).Units()
+		if position.HeldLots < lotsToBuy {
+			continue
+		}
+		if tradeutil.DecimalFromMoneyValue(p.AveragePositionPrice).Sign() <= 0 {
+			unpriced = append(unpriced, p.Figi)
+			continue
+		}
+		position.SellMode = true
+		position.OrderPrice = p.AveragePositionPrice
+	}
+
+	return unpriced, nil
+}
+
+// orderMatchesMode reports whether a worker in the mode can track the order: sell orders
+// close a held position, buy orders open a new one.
+func orderMatchesMode(o *pb.OrderState, sellMode bool) bool {
+	if sellMode {
+		return o.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL
+	}
+	return o.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY
+}
+
+func (p *StartPosition) summary(orders, cancelled int) string {
+	var parts []string
+	if p.SellMode {
+		parts = append(parts, fmt.Sprintf("starting in sell mode: %d lots held, average price %s",
+			p.HeldLots, tradeutil.MoneyFromMoneyValue(p.OrderPrice)))
+	} else {
+		parts = append(parts, "starting in buy mode")
+		if p.HeldLots > 0 {
+			parts = append(parts, fmt.Sprintf("%d lots held are too few to sell and left as is", p.HeldLots))
+		}
+	}
+
+	if p.Order != nil {
+		parts = append(parts, fmt.Sprintf("adopted %s order %s", p.Order.Direction, p.Order.OrderId))
+	}
+	if cancelled > 0 {
+		parts = append(parts, fmt.Sprintf("%d stray orders cancelled", cancelled))
+	}
+	kept := orders - cancelled
+	if p.Order != nil {
+		kept--
+	}
+	if kept > 0 {
+		parts = append(parts, fmt.Sprintf("%d active orders left as is", kept))
+	}
+
+	return strings.Join(parts, ", ")
+}
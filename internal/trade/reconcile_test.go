@@ -0,0 +1,191 @@
+package trade_test
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	"os"
+	"testing"
+)
+
+const testFigi = "BBG004730N88"
+
+// TestMain sets the token config requires; tests talk to fake servers, which do not check it.
+func TestMain(m *testing.M) {
+	_ = os.Setenv("TRADEBOT_TOKEN", "test")
+	os.Exit(m.Run())
+}
+
+// newReconcileBroker returns a simulated broker holding 2 lots bought by 100 with active
+// buy and sell limit orders of 1 lot each.
+func newReconcileBroker(t *testing.T) (*broker.SimulatedBroker, map[pb.OrderDirection]string) {
+	server := fake.NewServer()
+	server.AddShare(&pb.Share{Figi: testFigi, Ticker: "SBER", ClassCode: "TQBR", Lot: 10, Currency: "rub"})
+	server.SetOrderBook(&pb.GetOrderBookResponse{
+		Figi: testFigi,
+		Bids: []*pb.Order{{Price: &pb.Quotation{Units: 99}, Quantity: 100}},
+		Asks: []*pb.Order{{Price: &pb.Quotation{Units: 100}, Quantity: 100}},
+	})
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake server: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	b := broker.NewSimulatedBroker(client.ServicePool(), 10000)
+	post := func(direction pb.OrderDirection, orderType pb.OrderType, lots, price int64) string {
+		order, err := b.PostOrder(context.Background(), &pb.PostOrderRequest{
+			Figi:      testFigi,
+			Quantity:  lots,
+			Price:     &pb.Quotation{Units: price},
+			Direction: direction,
+			OrderType: orderType,
+		})
+		if err != nil {
+			t.Fatalf("can not post order: %v", err)
+		}
+		return order.OrderId
+	}
+
+	post(pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_MARKET, 2, 0)
+	orders := map[pb.OrderDirection]string{
+		pb.OrderDirection_ORDER_DIRECTION_BUY:  post(pb.OrderDirection_ORDER_DIRECTION_BUY, pb.OrderType_ORDER_TYPE_LIMIT, 1, 50),
+		pb.OrderDirection_ORDER_DIRECTION_SELL: post(pb.OrderDirection_ORDER_DIRECTION_SELL, pb.OrderType_ORDER_TYPE_LIMIT, 1, 200),
+	}
+
+	return b, orders
+}
+
+func activeOrders(t *testing.T, b *broker.SimulatedBroker) map[string]bool {
+	t.Helper()
+
+	orders, err := b.GetOrders(context.Background())
+	if err != nil {
+		t.Fatalf("can not get orders: %v", err)
+	}
+	active := make(map[string]bool)
+	for _, o := range orders {
+		active[o.OrderId] = true
+	}
+
+	return active
+}
+
+func TestReconcileAdoptsSellOrder(t *testing.T) {
+	b, orders := newReconcileBroker(t)
+
+	positions, err := trade.Reconcile(context.Background(), b, []string{testFigi}, 2, trade.StrayOrdersAdopt)
+	if err != nil {
+		t.Fatalf("can not reconcile: %v", err)
+	}
+	p := positions[testFigi]
+	if !p.SellMode || p.HeldLots != 2 || p.OrderPrice.Units != 100 {
+		t.Errorf("2 held lots of 2 must start selling by average price 100, got %+v", p)
+	}
+	if p.Order == nil || p.Order.OrderId != orders[pb.OrderDirection_ORDER_DIRECTION_SELL] {
+		t.Errorf("sell order must be adopted, got %v", p.Order)
+	}
+
+	active := activeOrders(t, b)
+	if active[orders[pb.OrderDirection_ORDER_DIRECTION_BUY]] || !active[orders[pb.OrderDirection_ORDER_DIRECTION_SELL]] {
+		t.Errorf("buy order must be cancelled and sell order kept, active: %v", active)
+	}
+}
+
+func TestReconcileStartsBuyingWithFewLots(t *testing.T) {
+	b, orders := newReconcileBroker(t)
+
+	positions, err := trade.Reconcile(context.Background(), b, []string{testFigi}, 3, trade.StrayOrdersAdopt)
+	if err != nil {
+		t.Fatalf("can not reconcile: %v", err)
+	}
+	p := positions[testFigi]
+	if p.SellMode || p.HeldLots != 2 {
+		t.Errorf("2 held lots of 3 must start buying, got %+v", p)
+	}
+	if p.Order == nil || p.Order.OrderId != orders[pb.OrderDirection_ORDER_DIRECTION_BUY] || p.OrderPrice == nil {
+		t.Errorf("buy order must be adopted with its price, got %+v", p)
+	}
+}
+
+func TestReconcileCancelsStrayOrders(t *testing.T) {
+	b, _ := newReconcileBroker(t)
+
+	positions, err := trade.Reconcile(context.Background(), b, []string{testFigi}, 2, trade.StrayOrdersCancel)
+	if err != nil {
+		t.Fatalf("can not reconcile: %v", err)
+	}
+	if positions[testFigi].Order != nil {
+		t.Errorf("no order must be adopted, got %v", positions[testFigi].Order)
+	}
+	if active := activeOrders(t, b); len(active) != 0 {
+		t.Errorf("all orders must be cancelled, active: %v", active)
+	}
+}
+
+func TestReconcileKeepsOrdersOfOtherInstruments(t *testing.T) {
+	b, orders := newReconcileBroker(t)
+
+	positions, err := trade.Reconcile(context.Background(), b, []string{"BBG000000001"}, 2, trade.StrayOrdersCancel)
+	if err != nil {
+		t.Fatalf("can not reconcile: %v", err)
+	}
+	if p := positions["BBG000000001"]; p.SellMode || p.HeldLots != 0 {
+		t.Errorf("instrument without position must start buying, got %+v", p)
+	}
+	if active := activeOrders(t, b); len(active) != len(orders) {
+		t.Errorf("orders of other instruments must be left as is, active: %v", active)
+	}
+}
+
+// unpricedBroker returns portfolio positions without average price, as API may do right after a trade.
+type unpricedBroker struct {
+	*broker.SimulatedBroker
+	portfolios int
+}
+
+func (b *unpricedBroker) GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error) {
+	b.portfolios++
+	portfolio, err := b.SimulatedBroker.GetPortfolio(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range portfolio.Positions {
+		p.AveragePositionPrice = nil
+	}
+
+	return portfolio, nil
+}
+
+func TestReconcileFailsWithoutAveragePrice(t *testing.T) {
+	simulated, orders := newReconcileBroker(t)
+	b := &unpricedBroker{SimulatedBroker: simulated}
+
+	if _, err := trade.Reconcile(context.Background(), b, []string{testFigi}, 2, trade.StrayOrdersCancel); err == nil {
+		t.Fatal("held position without average price must not start selling")
+	}
+	if b.portfolios != 2 {
+		t.Errorf("portfolio must be got again for the missing price, got %d requests", b.portfolios)
+	}
+	if active := activeOrders(t, simulated); len(active) != len(orders) {
+		t.Errorf("failed reconciliation must not cancel orders, active: %v", active)
+	}
+
+	// too few lots to sell need no price
+	positions, err := trade.Reconcile(context.Background(), b, []string{testFigi}, 3, trade.StrayOrdersKeep)
+	if err != nil || positions[testFigi].SellMode {
+		t.Errorf("2 held lots of 3 without price must start buying, got %+v, %v", positions[testFigi], err)
+	}
+}
+
+func TestReconcileFailsWithUnknownPolicy(t *testing.T) {
+	if _, err := trade.Reconcile(context.Background(), nil, []string{testFigi}, 1, "ignore"); err == nil {
+		t.Error("unknown policy must be an error")
+	}
+}
@@ -0,0 +1,118 @@
+// Package state persists trade worker state, so workers go on after restart.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/elkopass/BITA/internal/config"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// WorkerState is what a worker of gamble or crumble strategy needs to continue after restart.
+type WorkerState struct {
+	SellFlag        bool           `json:"sell_flag"`                   // the worker holds a position
+	HeldLots        int64          `json:"held_lots,omitempty"`         // lots of the position, fewer than bought after a partial fill
+	OrderID         string         `json:"order_id,omitempty"`          // active order, if any
+	OrderPrice      *pb.MoneyValue `json:"order_price,omitempty"`       // price of the position or the active buy order
+	OrderPlacedTime *int64         `json:"order_placed_time,omitempty"` // unix time the active order is placed at
+	StopLossID      string         `json:"stop_loss_id,omitempty"`      // exchange-side stop orders of the position
+	TakeProfitID    string         `json:"take_profit_id,omitempty"`
+}
+
+// Store keeps worker states in dir, one JSON file per strategy, account and FIGI.
+// A nil Store keeps nothing: Load finds no state and Save does nothing.
+type Store struct {
+	dir string
+
+	mu   sync.Mutex
+	last map[string][]byte // file name -> last written content
+}
+
+// NewStore creates Store in dir; the directory is created on the first write.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, last: make(map[string][]byte)}
+}
+
+// NewStoreFromConfig creates Store in TRADEBOT_STATE_DIR or returns nil if it is not set.
+func NewStoreFromConfig() *Store {
+	dir := config.TradeBotConfig().StateDir
+	if dir == "" {
+		return nil
+	}
+
+	return NewStore(dir)
+}
+
+// Load returns the saved state or nil if there is none.
+func (s *Store) Load(strategy, accountID, figi string) (*WorkerState, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path(strategy, accountID, figi))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not read state: %v", err)
+	}
+
+	var state WorkerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("can not parse state %s: %v", s.path(strategy, accountID, figi), err)
+	}
+
+	s.mu.Lock()
+	s.last[s.path(strategy, accountID, figi)] = data
+	s.mu.Unlock()
+
+	return &state, nil
+}
+
+// Save replaces the state file atomically, so a crash never leaves a half-written one;
+// nothing is written if the state has not changed.
+func (s *Store) Save(strategy, accountID, figi string, state WorkerState) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.path(strategy, accountID, figi)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bytes.Equal(s.last[path], data) {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.last[path] = data
+
+	return nil
+}
+
+func (s *Store) path(strategy, accountID, figi string) string {
+	name := strings.Join([]string{strategy, accountID, figi}, "_")
+	name = strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(name)
+
+	return filepath.Join(s.dir, name+".json")
+}
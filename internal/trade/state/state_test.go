@@ -0,0 +1,89 @@
+package state
+
+import (
+	pb "github.com/elkopass/BITA/internal/proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	store := NewStore(dir)
+
+	if state, err := store.Load("gamble", "account", "figi"); state != nil || err != nil {
+		t.Fatalf("Load() of a new store = %v, %v, want nil", state, err)
+	}
+
+	placed := int64(1650000000)
+	saved := WorkerState{
+		SellFlag:        true,
+		OrderID:         "order",
+		OrderPrice:      &pb.MoneyValue{Currency: "rub", Units: 100},
+		OrderPlacedTime: &placed,
+		StopLossID:      "stop-loss",
+	}
+	if err := store.Save("gamble", "account/1", "figi", saved); err != nil {
+		t.Fatalf("can not save state: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gamble_account-1_figi.json")); err != nil {
+		t.Errorf("state file must be named by strategy, account and figi: %v", err)
+	}
+
+	loaded, err := NewStore(dir).Load("gamble", "account/1", "figi")
+	if err != nil {
+		t.Fatalf("can not load state: %v", err)
+	}
+	if !loaded.SellFlag || loaded.OrderID != "order" || loaded.OrderPrice.Units != 100 ||
+		*loaded.OrderPlacedTime != placed || loaded.StopLossID != "stop-loss" || loaded.TakeProfitID != "" {
+		t.Errorf("Load() = %+v, want %+v", loaded, saved)
+	}
+}
+
+func TestStoreSkipsUnchangedState(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir)
+	path := filepath.Join(dir, "crumble_account_figi.json")
+
+	if err := store.Save("crumble", "account", "figi", WorkerState{OrderID: "order"}); err != nil {
+		t.Fatalf("can not save state: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("crumble", "account", "figi", WorkerState{OrderID: "order"}); err != nil {
+		t.Fatalf("can not save state: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged state must not be written again, got %v", err)
+	}
+
+	if err := store.Save("crumble", "account", "figi", WorkerState{}); err != nil {
+		t.Fatalf("can not save state: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("changed state must be written: %v", err)
+	}
+}
+
+func TestStoreFailsWithBrokenState(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "gamble_account_figi.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStore(dir).Load("gamble", "account", "figi"); err == nil {
+		t.Error("broken state must be an error")
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	if err := store.Save("gamble", "account", "figi", WorkerState{SellFlag: true}); err != nil {
+		t.Errorf("Save() of nil store = %v, want nil", err)
+	}
+	if state, err := store.Load("gamble", "account", "figi"); state != nil || err != nil {
+		t.Errorf("Load() of nil store = %v, %v, want nil", state, err)
+	}
+}
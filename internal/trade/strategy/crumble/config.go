@@ -1,32 +1,81 @@
 package crumble
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
+	"errors"
 	"github.com/elkopass/BITA/internal/sdk"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/common"
+	"github.com/elkopass/BITA/internal/trade/strategy"
+	"time"
 )
 
-var services = sdk.NewServicePool()
+// maxCandlesIntervalHours is how many hours of candles are requested at most.
+const maxCandlesIntervalHours = 168
+
+func init() {
+	strategy.Register(strategy.Strategy{
+		Name: strategy.CRUMBLE,
+		Description: "buys when the short moving average of hourly candles crosses the long one upwards, " +
+			"sells on the opposite cross, stop loss or take profit",
+		Config: func() strategy.Config { return &TradeConfig{} },
+		New: func(client *sdk.Client, broker trade.Broker, figi []string, cnf strategy.Config) (trade.Trader, error) {
+			return NewTradeBot(client, broker, figi, *cnf.(*TradeConfig)), nil
+		},
+	})
+}
 
 type TradeConfig struct {
-	LotsToBuy      int     `default:"1" split_words:"true"`
-	StopLossCoef   float64 `default:"0.95" split_words:"true"`
-	TakeProfitCoef float64 `default:"1.05" split_words:"true"`
+	LotsToBuy      int     `default:"1" split_words:"true" desc:"lots a worker trades for one instrument"`
+	StopLossCoef   float64 `default:"0.95" split_words:"true" desc:"sell when price falls below buy price multiplied by it"`
+	TakeProfitCoef float64 `default:"1.05" split_words:"true" desc:"sell when price rises above buy price multiplied by it"`
+	StopOrders     bool    `default:"false" split_words:"true" desc:"also place stop loss and take profit on exchange"`
 
-	ShortWindow          int `default:"25" split_words:"true"`
-	LongWindow           int `default:"50" split_words:"true"`
-	CandlesIntervalHours int `default:"144" split_words:"true"`
+	StopOrdersCheckSeconds int64 `default:"60" split_words:"true" desc:"how often stop orders are checked for a sold position"`
 
-	WorkerSleepDurationSeconds int64 `default:"30" split_words:"true"`
-	SecondsToCancelOrder       int64 `default:"3600" split_words:"true"`
+	ShortWindow          int `default:"25" split_words:"true" desc:"window of the short moving average"`
+	LongWindow           int `default:"50" split_words:"true" desc:"window of the long moving average"`
+	CandlesIntervalHours int `default:"144" split_words:"true" desc:"hours of candles to request, from long window to 168"`
+
+	WorkerSleepDurationSeconds int64 `default:"30" split_words:"true" desc:"pause between worker iterations"`
+	SecondsToCancelOrder       int64 `default:"3600" split_words:"true" desc:"cancel an order not filled for so long"`
 }
 
-func NewTradeConfig() *TradeConfig {
-	var c TradeConfig
-	err := envconfig.Process("crumble_strategy", &c)
-	if err != nil {
-		loggy.GetLogger().Sugar().Fatalf("failed to process config: %v", err)
+func (c *TradeConfig) Validate() error {
+	switch {
+	case c.LotsToBuy < 1:
+		return errors.New("CRUMBLE_STRATEGY_LOTS_TO_BUY must be positive")
+	case c.StopLossCoef <= 0 || c.StopLossCoef >= 1:
+		return errors.New("CRUMBLE_STRATEGY_STOP_LOSS_COEF must be between 0 and 1")
+	case c.TakeProfitCoef <= 1:
+		return errors.New("CRUMBLE_STRATEGY_TAKE_PROFIT_COEF must be greater than 1")
+	case c.ShortWindow < 1 || c.ShortWindow >= c.LongWindow:
+		return errors.New("CRUMBLE_STRATEGY_SHORT_WINDOW must be positive and lower than CRUMBLE_STRATEGY_LONG_WINDOW")
+	case c.CandlesIntervalHours < c.LongWindow || c.CandlesIntervalHours > maxCandlesIntervalHours:
+		return errors.New("CRUMBLE_STRATEGY_CANDLES_INTERVAL_HOURS must be " +
+			"between CRUMBLE_STRATEGY_LONG_WINDOW and 168")
+	case c.WorkerSleepDurationSeconds <= 0 || c.SecondsToCancelOrder <= 0:
+		return errors.New("CRUMBLE_STRATEGY_WORKER_SLEEP_DURATION_SECONDS and " +
+			"CRUMBLE_STRATEGY_SECONDS_TO_CANCEL_ORDER must be positive")
+	case c.StopOrdersCheckSeconds <= 0:
+		return errors.New("CRUMBLE_STRATEGY_STOP_ORDERS_CHECK_SECONDS must be positive")
 	}
 
-	return &c
+	return nil
+}
+
+// workerConfig returns the part of TradeConfig common.Worker trades by; crumble buys at the best bid
+// and sells at the best ask, waiting for its orders to be filled.
+func (c TradeConfig) workerConfig() common.WorkerConfig {
+	return common.WorkerConfig{
+		Strategy:       strategy.CRUMBLE,
+		LotsToBuy:      int64(c.LotsToBuy),
+		StopLossCoef:   c.StopLossCoef,
+		TakeProfitCoef: c.TakeProfitCoef,
+		StopOrders:     c.StopOrders,
+		SleepDuration:  time.Duration(c.WorkerSleepDurationSeconds) * time.Second,
+		CancelAfter:    time.Duration(c.SecondsToCancelOrder) * time.Second,
+		CrossSpread:    false,
+
+		StopOrdersCheckInterval: time.Duration(c.StopOrdersCheckSeconds) * time.Second,
+	}
 }
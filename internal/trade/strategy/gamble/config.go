@@ -1,34 +1,78 @@
 package gamble
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
+	"errors"
 	"github.com/elkopass/BITA/internal/sdk"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/common"
+	"github.com/elkopass/BITA/internal/trade/strategy"
+	"time"
 )
 
-var services = sdk.NewServicePool()
+func init() {
+	strategy.Register(strategy.Strategy{
+		Name: strategy.GAMBLE,
+		Description: "buys when price trends on a long and a short interval are steep enough, " +
+			"sells on stop loss or take profit",
+		Config: func() strategy.Config { return &TradeConfig{} },
+		New: func(client *sdk.Client, broker trade.Broker, figi []string, cnf strategy.Config) (trade.Trader, error) {
+			return NewTradeBot(client, broker, figi, *cnf.(*TradeConfig)), nil
+		},
+	})
+}
 
 type TradeConfig struct {
-	LotsToBuy      int     `default:"1" split_words:"true"`
-	StopLossCoef   float64 `default:"0.97" split_words:"true"`
-	TakeProfitCoef float64 `default:"1.02" split_words:"true"`
+	LotsToBuy      int     `default:"1" split_words:"true" desc:"lots a worker trades for one instrument"`
+	StopLossCoef   float64 `default:"0.97" split_words:"true" desc:"sell when price falls below buy price multiplied by it"`
+	TakeProfitCoef float64 `default:"1.02" split_words:"true" desc:"sell when price rises above buy price multiplied by it"`
+	StopOrders     bool    `default:"false" split_words:"true" desc:"also place stop loss and take profit on exchange"`
+
+	StopOrdersCheckSeconds int64 `default:"60" split_words:"true" desc:"how often stop orders are checked for a sold position"`
 
-	LongTrendToTrade  float64 `default:"0.05" split_words:"true"`
-	ShortTrendToTrade float64 `default:"0.1" split_words:"true"`
+	LongTrendToTrade  float64 `default:"0.05" split_words:"true" desc:"minimal slope of the long trend to buy"`
+	ShortTrendToTrade float64 `default:"0.1" split_words:"true" desc:"minimal slope of the short trend to buy"`
 
-	LongTrendIntervalSeconds  int `default:"86400" split_words:"true"`
-	ShortTrendIntervalSeconds int `default:"3600" split_words:"true"`
+	LongTrendIntervalSeconds  int `default:"86400" split_words:"true" desc:"interval of the long trend"`
+	ShortTrendIntervalSeconds int `default:"3600" split_words:"true" desc:"interval of the short trend"`
 
-	WorkerSleepDurationSeconds int64 `default:"30" split_words:"true"`
-	SecondsToCancelOrder       int64 `default:"3600" split_words:"true"`
+	WorkerSleepDurationSeconds int64 `default:"30" split_words:"true" desc:"pause between worker iterations"`
+	SecondsToCancelOrder       int64 `default:"3600" split_words:"true" desc:"cancel an order not filled for so long"`
 }
 
-func NewTradeConfig() *TradeConfig {
-	var c TradeConfig
-	err := envconfig.Process("gamble_strategy", &c)
-	if err != nil {
-		loggy.GetLogger().Sugar().Fatalf("failed to process config: %v", err)
+func (c *TradeConfig) Validate() error {
+	switch {
+	case c.LotsToBuy < 1:
+		return errors.New("GAMBLE_STRATEGY_LOTS_TO_BUY must be positive")
+	case c.StopLossCoef <= 0 || c.StopLossCoef >= 1:
+		return errors.New("GAMBLE_STRATEGY_STOP_LOSS_COEF must be between 0 and 1")
+	case c.TakeProfitCoef <= 1:
+		return errors.New("GAMBLE_STRATEGY_TAKE_PROFIT_COEF must be greater than 1")
+	case c.LongTrendIntervalSeconds <= 0 || c.ShortTrendIntervalSeconds <= 0:
+		return errors.New("GAMBLE_STRATEGY_LONG_TREND_INTERVAL_SECONDS and " +
+			"GAMBLE_STRATEGY_SHORT_TREND_INTERVAL_SECONDS must be positive")
+	case c.WorkerSleepDurationSeconds <= 0 || c.SecondsToCancelOrder <= 0:
+		return errors.New("GAMBLE_STRATEGY_WORKER_SLEEP_DURATION_SECONDS and " +
+			"GAMBLE_STRATEGY_SECONDS_TO_CANCEL_ORDER must be positive")
+	case c.StopOrdersCheckSeconds <= 0:
+		return errors.New("GAMBLE_STRATEGY_STOP_ORDERS_CHECK_SECONDS must be positive")
 	}
 
-	return &c
+	return nil
+}
+
+// workerConfig returns the part of TradeConfig common.Worker trades by; gamble buys at the best ask
+// and sells at the best bid, so its orders are filled at once.
+func (c TradeConfig) workerConfig() common.WorkerConfig {
+	return common.WorkerConfig{
+		Strategy:       strategy.GAMBLE,
+		LotsToBuy:      int64(c.LotsToBuy),
+		StopLossCoef:   c.StopLossCoef,
+		TakeProfitCoef: c.TakeProfitCoef,
+		StopOrders:     c.StopOrders,
+		SleepDuration:  time.Duration(c.WorkerSleepDurationSeconds) * time.Second,
+		CancelAfter:    time.Duration(c.SecondsToCancelOrder) * time.Second,
+		CrossSpread:    true,
+
+		StopOrdersCheckInterval: time.Duration(c.StopOrdersCheckSeconds) * time.Second,
+	}
 }
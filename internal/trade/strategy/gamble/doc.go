@@ -5,13 +5,15 @@
 check out TradeConfig for the exact values to be passed.
 
 Strategy is pretty straightforward:
-	1. For each Figi provided in global config TradeBot will create
-       an independent TradeWorker.
-	2. Each TradeWorker is going to perform some action in a loop:
+	1. For each Figi provided in global config common.WorkerBot will create
+       an independent common.Worker trading by TradeWorker signals.
+	2. Each worker is going to perform some action in a loop:
 	2.1. If trading for Figi is not available, if will proceed to sleep further.
 	2.2. If it has an order on market, it will check it's status:
 		 if order is fulfilled, it will go to the next stage
-		 (TradeWorker.sellFlag will change it's value) or sleep otherwise.
+		 (common.Worker sell flag will change it's value) or sleep otherwise.
+		 Orders are placed and tracked by common.OrderManager; lots executed
+		 by a partially filled order which is cancelled are kept and sold.
 	2.3. If bot has no instrument purchased, it will try to buy one:
 	2.3.1. Two stock trends will be calculated first. Long trend will be taken
 		   on TradeConfig.LongTrendIntervalSeconds period and a short one on
@@ -24,17 +26,23 @@
 		   or proceed to sleep in other way.
 	2.4. If bot has an instrument, it will try to sell it.
 		 Firstly, the current order book will be requested:
-	2.4.1. If (close price / TradeWorker.orderPrice) is greater than
+	2.4.1. If (close price / buy price) is greater than
 		   TradeConfig.TakeProfitCoef, bot will create an order to take profit.
-	2.4.2. If (close price / TradeWorker.orderPrice) is below
+	2.4.2. If (close price / buy price) is below
 		   TradeConfig.StopLossCoef, bot will create an order to stop further loss.
 	2.4.3. Or it will sleep till an asset's price stays still.
 	2.4.4. If order is not fulfilled longer than TradeConfig.SecondsToCancelOrder,
 		   order will be cancelled.
-	2.5. If TradeWorker receives an interrupt signal, it will check a SellOnExit value
+	2.5. If worker receives an interrupt signal, it will check a SellOnExit value
 		 in global config. If it's 'true', bot will try to create a sell order based on
 		 current market price. In other way it will just gracefully exit.
 
+If TradeConfig.StopOrders is set, stop loss and take profit are also placed
+on exchange as stop orders right after a buy order is fulfilled (see
+common.ProtectiveOrders), so the position is closed even if the bot is down.
+When one of them is triggered, the other one is cancelled; stop orders left
+by a previous run are picked up on start.
+
 Gamble (naive) strategy is ready-to-use in a Sandbox environment.
 TradeBot will automatically create a sandbox account and do the same things
 as in a real market (except it's just a sandbox and all money here is virtual).
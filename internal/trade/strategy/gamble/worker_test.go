@@ -0,0 +1,208 @@
+package gamble
+
+import (
+	"context"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/sdk/fake"
+	"github.com/elkopass/BITA/internal/trade/broker"
+	"github.com/elkopass/BITA/internal/trade/common"
+	"github.com/elkopass/BITA/internal/trade/state"
+	"github.com/elkopass/BITA/internal/trade/strategy"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testFigi = "BBG004730N88"
+
+// TestTradeWorkerRestart drives a worker through buy, fill and stop orders placement,
+// then restarts it and checks the position is picked up and closed by the triggered take profit.
+func TestTradeWorkerRestart(t *testing.T) {
+	server, accountID := newTestServer(t)
+	server.StartBufconn()
+	defer server.Stop()
+
+	client, err := sdk.NewClient(server.ClientConfig())
+	if err != nil {
+		t.Fatalf("can not connect to fake API: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	brk := broker.NewProductionBroker(accountID, client.ServicePool())
+	store := state.NewStore(t.TempDir())
+
+	// the first run buys the instrument and protects it with stop orders
+	stop := runTestWorker(t, client, brk, store)
+
+	var buyOrder *pb.OrderState
+	waitFor(t, "buy order", func() bool {
+		orders, err := brk.GetOrders(ctx)
+		if err != nil || len(orders) == 0 {
+			return false
+		}
+		buyOrder = orders[0]
+		return true
+	})
+	if buyOrder.Direction != pb.OrderDirection_ORDER_DIRECTION_BUY || buyOrder.LotsRequested != 1 {
+		t.Fatalf("unexpected order: %v", buyOrder)
+	}
+	if err := server.FillOrder(accountID, buyOrder.OrderId, 1); err != nil {
+		t.Fatalf("can not fill buy order: %v", err)
+	}
+
+	var saved *state.WorkerState
+	waitFor(t, "stop orders", func() bool {
+		saved, _ = store.Load(strategy.GAMBLE, accountID, testFigi)
+		return saved != nil && saved.SellFlag && saved.StopLossID != "" && saved.TakeProfitID != ""
+	})
+	stop()
+
+	if saved.HeldLots != 1 || saved.OrderID != "" {
+		t.Errorf("unexpected saved state after buy: %+v", saved)
+	}
+	stopOrders, err := brk.GetStopOrders(ctx)
+	if err != nil || len(stopOrders) != 2 {
+		t.Fatalf("expected stop loss and take profit, got %v, %v", stopOrders, err)
+	}
+
+	// the second run picks up the saved position with its stop orders
+	stop = runTestWorker(t, client, brk, store)
+	defer stop()
+
+	time.Sleep(20 * testSleepDuration)
+	if orders, err := brk.GetOrders(ctx); err != nil || len(orders) != 0 {
+		t.Errorf("restarted worker must not place orders, got %v, %v", orders, err)
+	}
+	if restored, _ := store.Load(strategy.GAMBLE, accountID, testFigi); restored == nil ||
+		!restored.SellFlag || restored.StopLossID != saved.StopLossID || restored.TakeProfitID != saved.TakeProfitID {
+		t.Errorf("restarted worker must keep the position and its stop orders, got %+v", restored)
+	}
+
+	// take profit is triggered on exchange, its market order is executed later
+	server.SetOrderFiller(fake.FillAll)
+	server.SetLastPrice(testFigi, &pb.Quotation{Units: 103})
+
+	waitFor(t, "closed position", func() bool {
+		restored, _ := store.Load(strategy.GAMBLE, accountID, testFigi)
+		return restored != nil && !restored.SellFlag && restored.StopLossID == "" && restored.TakeProfitID == ""
+	})
+	if stopOrders, err := brk.GetStopOrders(ctx); err != nil || len(stopOrders) != 0 {
+		t.Errorf("stop loss must be cancelled after take profit, got %v, %v", stopOrders, err)
+	}
+}
+
+const testSleepDuration = 10 * time.Millisecond
+
+// newTestServer creates fake API with a share steadily growing in price, so gamble buys it,
+// and an account to trade it on; orders are not filled until the test fills them.
+func newTestServer(t *testing.T) (*fake.Server, string) {
+	server := fake.NewServer()
+	server.AddShare(&pb.Share{
+		Figi:                  testFigi,
+		Ticker:                "SBER",
+		ClassCode:             "TQBR",
+		Lot:                   10,
+		Currency:              "rub",
+		Name:                  "Test share",
+		MinPriceIncrement:     &pb.Quotation{Nano: 10000000},
+		ApiTradeAvailableFlag: true,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		TradingStatus:         pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+	})
+	server.SetCandles(testFigi, pb.CandleInterval_CANDLE_INTERVAL_1_MIN, growingCandles(time.Minute))
+	server.SetCandles(testFigi, pb.CandleInterval_CANDLE_INTERVAL_5_MIN, growingCandles(5*time.Minute))
+	server.SetOrderBook(&pb.GetOrderBookResponse{
+		Figi:  testFigi,
+		Depth: 10,
+		Bids:  []*pb.Order{{Price: &pb.Quotation{Units: 99, Nano: 900000000}, Quantity: 100}},
+		Asks:  []*pb.Order{{Price: &pb.Quotation{Units: 100}, Quantity: 100}},
+	})
+	server.SetOrderFiller(fake.FillNone)
+
+	accountID := server.AddAccount("test")
+	if err := server.PayIn(accountID, &pb.MoneyValue{Units: 100000, Currency: "rub"}); err != nil {
+		t.Fatalf("can not pay in: %v", err)
+	}
+
+	return server, accountID
+}
+
+// growingCandles returns candles of the last hours, each one closing a ruble higher than the previous.
+func growingCandles(interval time.Duration) []*pb.HistoricCandle {
+	var candles []*pb.HistoricCandle
+	start := time.Now().Add(-30 * interval)
+	for i := 0; i < 30; i++ {
+		price := &pb.Quotation{Units: int64(70 + i)}
+		candles = append(candles, &pb.HistoricCandle{
+			Open: price, High: price, Low: price, Close: price, Volume: 100,
+			Time:       timestamppb.New(start.Add(time.Duration(i) * interval)),
+			IsComplete: true,
+		})
+	}
+
+	return candles
+}
+
+// runTestWorker starts a gamble worker with stop orders and returns a function stopping it.
+func runTestWorker(t *testing.T, client *sdk.Client, brk *broker.ProductionBroker, store *state.Store) func() {
+	subscriptions, err := client.NewSubscriptionManager(context.Background())
+	if err != nil {
+		t.Fatalf("can not open market data stream: %v", err)
+	}
+
+	cnf := TradeConfig{
+		LotsToBuy:                 1,
+		StopLossCoef:              0.97,
+		TakeProfitCoef:            1.02,
+		StopOrders:                true,
+		LongTrendToTrade:          0.05,
+		ShortTrendToTrade:         0.1,
+		LongTrendIntervalSeconds:  86400,
+		ShortTrendIntervalSeconds: 3600,
+		SecondsToCancelOrder:      3600,
+	}
+	workerConfig := cnf.workerConfig()
+	workerConfig.SleepDuration = testSleepDuration
+	workerConfig.StopOrdersCheckInterval = testSleepDuration
+
+	w := common.NewWorker(testFigi, workerConfig, brk, client.ServicePool(), subscriptions, store, nil,
+		func(w *common.Worker) common.Signals {
+			return &TradeWorker{Worker: w, config: cnf}
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- w.Run(ctx, wg)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			if err := <-errc; err != nil {
+				t.Errorf("worker finished with error: %v", err)
+			}
+			subscriptions.Close()
+		})
+	}
+}
+
+// waitFor fails the test if cond is not met in a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(testSleepDuration)
+	}
+}
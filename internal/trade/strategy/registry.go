@@ -0,0 +1,124 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/kelseyhightower/envconfig"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Config is a strategy config loaded from <NAME>_STRATEGY_* env variables by envconfig,
+// so its fields and their tags (default, required, desc) are the config schema.
+type Config interface {
+	// Validate checks the values envconfig can not check, e.g. relations between fields.
+	Validate() error
+}
+
+// Strategy describes a trading strategy which can be run by trade bot.
+type Strategy struct {
+	Name        string
+	Description string
+
+	// Config returns an empty config of the strategy to load or describe.
+	Config func() Config
+	// New creates a trade bot for the figi with the config returned by Config and loaded.
+	New func(client *sdk.Client, broker trade.Broker, figi []string, config Config) (trade.Trader, error)
+}
+
+var (
+	mu         sync.RWMutex
+	strategies = make(map[string]Strategy)
+)
+
+// Register makes a strategy available by its name; it is called from init functions
+// of strategy packages, so linking a package in is enough to use its strategy.
+// Register panics if the name is already registered or the strategy is incomplete.
+func Register(s Strategy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s.Name == "" || s.Config == nil || s.New == nil {
+		panic(fmt.Sprintf("strategy: incomplete strategy '%s' registered", s.Name))
+	}
+	if _, ok := strategies[s.Name]; ok {
+		panic(fmt.Sprintf("strategy: strategy '%s' registered twice", s.Name))
+	}
+
+	strategies[s.Name] = s
+}
+
+// Get returns a registered strategy by its name.
+func Get(name string) (Strategy, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	s, ok := strategies[name]
+	if !ok {
+		return Strategy{}, fmt.Errorf("unknown strategy '%s'; possible values: %s", name, strings.Join(names(), ", "))
+	}
+
+	return s, nil
+}
+
+// List returns registered strategies sorted by name.
+func List() []Strategy {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var list []Strategy
+	for _, name := range names() {
+		list = append(list, strategies[name])
+	}
+
+	return list
+}
+
+// names must be called with mu held.
+func names() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// EnvPrefix returns the prefix of strategy config env variables, e.g. GAMBLE_STRATEGY.
+func (s Strategy) EnvPrefix() string {
+	return strings.ToUpper(s.Name) + "_STRATEGY"
+}
+
+// LoadConfig loads the strategy config from env variables and validates it.
+func (s Strategy) LoadConfig() (Config, error) {
+	c := s.Config()
+	if err := envconfig.Process(s.EnvPrefix(), c); err != nil {
+		return nil, fmt.Errorf("invalid %s strategy config: %v", s.Name, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s strategy config: %v", s.Name, err)
+	}
+
+	return c, nil
+}
+
+// PrintParameters writes a table of the strategy config env variables with their types,
+// defaults and descriptions.
+func (s Strategy) PrintParameters(w io.Writer) error {
+	tabs := tabwriter.NewWriter(w, 1, 0, 4, ' ', 0)
+	err := envconfig.Usagef(s.EnvPrefix(), s.Config(), tabs, parametersFormat)
+	if err != nil {
+		return err
+	}
+
+	return tabs.Flush()
+}
+
+const parametersFormat = `KEY	TYPE	DEFAULT	DESCRIPTION
+{{range .}}{{usage_key .}}	{{usage_type .}}	{{usage_default .}}	{{usage_description .}}
+{{end}}`
@@ -9,21 +9,29 @@
 	"github.com/elkopass/BITA/internal/metrics"
 	pb "github.com/elkopass/BITA/internal/proto"
 	"github.com/elkopass/BITA/internal/sdk"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/broker"
 	"github.com/elkopass/BITA/internal/trade/common"
 	tradeutil "github.com/elkopass/BITA/internal/trade/util"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
-	"strings"
+	"sync"
 	"time"
 )
 
 type TradeBot struct {
+	figi      []string
 	accountID string
-	orders    map[string]Order // figi == key
 	config    TradeConfig
 	logger    *zap.SugaredLogger
 
-	tradesStream sdk.OrdersStream
+	mu     sync.Mutex       // guards orders: order books and the trades stream are handled concurrently
+	orders map[string]Order // figi == key
+
+	broker       trade.Broker
+	client       *sdk.Client
+	services     *sdk.ServicePool
+	tradesStream *sdk.OrdersStream
 }
 
 type Order struct {
@@ -33,156 +41,144 @@ type Order struct {
 	OrderPlacedTime *int64         // if order is set
 }
 
-func NewTradeBot() *TradeBot {
+// NewTradeBot creates TradeBot watching order books of every FIGI.
+func NewTradeBot(client *sdk.Client, broker trade.Broker, figi []string, cnf TradeConfig) *TradeBot {
 	return &TradeBot{
-		orders: make(map[string]Order),
-		config: *NewTradeConfig(),
-		logger: loggy.GetLogger().Sugar().With("bot_id", loggy.GetBotID()),
+		figi:      figi,
+		accountID: broker.AccountID(),
+		orders:    make(map[string]Order),
+		config:    cnf,
+		client:    client,
+		services:  client.ServicePool(),
+		broker:    broker,
+		logger: loggy.GetLogger().Sugar().
+			With("bot_id", loggy.GetBotID()).
+			With("account_id", broker.AccountID()),
 	}
 }
 
-func (tb TradeBot) Run(ctx context.Context) (err error) {
+func (tb *TradeBot) Run(ctx context.Context) (err error) {
 	tb.logger.Infof("starting with %s strategy and sdk v%s", config.TradeBotConfig().Strategy, sdk.Version)
 
-	err = tb.setAccountID()
-	if err != nil {
-		return err
-	}
-
-	if config.TradeBotConfig().IsSandbox {
-		return errors.New("strategy is not available in sandbox, " +
+	// trades stream is available for real accounts only
+	if tb.broker.Name() != broker.PRODUCTION {
+		return errors.New("strategy is available with production broker only, " +
 			"see https://github.com/Tinkoff/investAPI/issues/176")
-	} else {
-		tb.tradesStream = *sdk.NewOrdersStream(&pb.TradesStreamRequest{Accounts: []string{tb.accountID}})
 	}
 
-	figi := config.TradeBotConfig().Figi
-
-	var instruments []*pb.OrderBookInstrument
-	for _, f := range figi {
-		instruments = append(instruments, &pb.OrderBookInstrument{Figi: f, Depth: int32(tb.config.OrderBookDepth)})
-	}
-
-	mds := sdk.NewMarketDataStream()
-
-	request := pb.SubscribeOrderBookRequest{
-		Instruments:        instruments,
-		SubscriptionAction: pb.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE,
+	tb.tradesStream, err = tb.client.NewOrdersStream(ctx, &pb.TradesStreamRequest{Accounts: []string{tb.accountID}})
+	if err != nil {
+		return fmt.Errorf("can not open trades stream: %v", err)
 	}
-	payload := &pb.MarketDataRequest_SubscribeOrderBookRequest{SubscribeOrderBookRequest: &request}
 
-	err = mds.Send(&pb.MarketDataRequest{Payload: payload})
+	subscriptions, err := tb.client.NewSubscriptionManager(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("can not open market data stream: %v", err)
 	}
+	defer subscriptions.Close()
+	defer tb.tradesStream.Close()
 
-	tradeStreamCtx, cancelTradeStreamListener := context.WithCancel(context.Background())
-	go tb.listenTradeStream(tradeStreamCtx)
-
-	for {
-		msg, err := mds.Recv()
+	var subscribed []<-chan *pb.OrderBook
+	for _, figi := range tb.figi {
+		books, _, err := subscriptions.SubscribeOrderBook(figi, int32(tb.config.OrderBookDepth))
 		if err != nil {
-			tb.logger.Error(err)
-		}
-
-		orderBook := msg.GetOrderbook()
-		if orderBook == nil {
-			continue
+			return err
 		}
+		subscribed = append(subscribed, books)
+	}
 
-		tb.logger.Debug(tradeutil.GetFormattedOrderBook(orderBook))
-		tb.makeDecision(orderBook)
-
-		select {
-		case <-time.After(1 * time.Millisecond):
-			// pass
-		case <-ctx.Done():
-			cancelTradeStreamListener()
-
-			// TODO: implement sell logic on interrupt
-			if config.TradeBotConfig().IsSandbox {
-				err = services.SandboxService.CloseSandboxAccount(tb.accountID)
-				if err != nil {
-					tb.logger.Errorf("can't close an account: %v", err)
-				}
-				tb.logger.Infof("account with ID %s closed successfully", tb.accountID)
+	// order books of all instruments are merged, so decisions are made one at a time
+	orderBooks := make(chan *pb.OrderBook)
+	var wg sync.WaitGroup
+	for _, books := range subscribed {
+		wg.Add(1)
+		go func(books <-chan *pb.OrderBook) {
+			defer wg.Done()
+			for book := range books {
+				orderBooks <- book
 			}
-
-			tb.logger.Info("bot stopped!")
-			return nil
-		}
+		}(books)
 	}
-}
+	go func() {
+		wg.Wait()
+		close(orderBooks)
+	}()
 
-// setAccountID gets account ID from config or creates a new one in sandbox.
-func (tb *TradeBot) setAccountID() error {
-	accountID := config.TradeBotConfig().AccountID
-	if config.TradeBotConfig().IsSandbox {
-		accountID, err := services.SandboxService.OpenSandboxAccount()
-		if err != nil {
-			return fmt.Errorf("can not create account: %v", err)
-		}
-		tb.logger.Infof("created new account with ID %s", accountID)
+	go common.LogStreamEvents(tb.logger, "market data stream", subscriptions.Events())
+	go common.LogStreamEvents(tb.logger, "trades stream", tb.tradesStream.Events())
+	go tb.listenTradeStream(ctx)
 
-		tb.logger = tb.logger.With("account_id", accountID)
-		tb.accountID = accountID
-	} else {
-		info, err := services.UsersService.GetInfo()
-		if err != nil {
-			return fmt.Errorf("can not get user info: %v", err)
-		}
-		tb.logger.Infof("user tariff: %s, qualified for work with %s",
-			info.Tariff, strings.Join(info.QualifiedForWorkWith, ","))
-
-		tb.logger = tb.logger.With("account_id", accountID)
-		tb.accountID = accountID
+	// channels are closed only when ctx is cancelled, broken connections are restored by sdk
+	for orderBook := range orderBooks {
+		tb.logger.Debug(tradeutil.GetFormattedOrderBook(orderBook))
+		tb.makeDecision(ctx, orderBook)
 	}
 
+	// TODO: implement sell logic on interrupt
+	tb.logger.Info("bot stopped!")
 	return nil
 }
 
-// listenTradeStream receives fulfilled orders from stream.
+// listenTradeStream receives fulfilled orders from stream until it is closed.
 func (tb *TradeBot) listenTradeStream(ctx context.Context) {
 	for {
 		msg, err := tb.tradesStream.Recv()
 		if err != nil {
-			tb.logger.Error(err)
+			tb.logger.Debug("stop trade stream listener")
+			return
 		}
 
 		orderTrades := msg.GetOrderTrades()
-		if orderTrades != nil {
-			tb.logger.With("order_id", orderTrades.OrderId).
-				With("figi", orderTrades.Figi).
-				Info("order is fulfilled")
-
-			metrics.OrdersFulfilled.WithLabelValues(loggy.GetBotID(),
-				orderTrades.Figi, orderTrades.Direction.String()).Inc()
-			metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), orderTrades.Figi,
-				orderTrades.Direction.String()).Dec()
-
-			switch orderTrades.Direction {
-			case pb.OrderDirection_ORDER_DIRECTION_BUY:
-				metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), orderTrades.Figi).Inc()
-			case pb.OrderDirection_ORDER_DIRECTION_SELL:
-				metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), orderTrades.Figi).Dec()
-			}
-
-			delete(tb.orders, orderTrades.Figi)
-			go tb.checkPortfolio()
+		if orderTrades == nil {
+			continue
 		}
+		tb.orderIsFulfilled(ctx, orderTrades)
+	}
+}
 
-		select {
-		case <-time.After(1000 * time.Millisecond):
-			// pass
-		case <-ctx.Done():
-			tb.logger.Debug("stop trade stream listener")
-			return
-		}
+// orderIsFulfilled forgets the fulfilled order of the figi, so a new one can be placed.
+func (tb *TradeBot) orderIsFulfilled(ctx context.Context, orderTrades *pb.OrderTrades) {
+	tb.logger.With("order_id", orderTrades.OrderId).
+		With("figi", orderTrades.Figi).
+		Info("order is fulfilled")
+
+	metrics.OrdersFulfilled.WithLabelValues(loggy.GetBotID(),
+		orderTrades.Figi, orderTrades.Direction.String()).Inc()
+	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), orderTrades.Figi,
+		orderTrades.Direction.String()).Dec()
+
+	switch orderTrades.Direction {
+	case pb.OrderDirection_ORDER_DIRECTION_BUY:
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), orderTrades.Figi).Inc()
+	case pb.OrderDirection_ORDER_DIRECTION_SELL:
+		metrics.InstrumentsPurchased.WithLabelValues(loggy.GetBotID(), orderTrades.Figi).Dec()
 	}
+
+	tb.mu.Lock()
+	delete(tb.orders, orderTrades.Figi)
+	tb.mu.Unlock()
+	go tb.checkPortfolio(ctx)
+}
+
+// order returns the placed order of the figi, if any.
+func (tb *TradeBot) order(figi string) (Order, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	order, ok := tb.orders[figi]
+	return order, ok
+}
+
+// keepOrder remembers the placed order of the figi until it is fulfilled.
+func (tb *TradeBot) keepOrder(figi string, order Order) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.orders[figi] = order
 }
 
 // makeDecision checks pb.OrderBook volumes with the goal to create buy/sell order.
-func (tb *TradeBot) makeDecision(orderBook *pb.OrderBook) {
+func (tb *TradeBot) makeDecision(ctx context.Context, orderBook *pb.OrderBook) {
 	var asksQuantity float64
 	for _, ask := range orderBook.Asks {
 		asksQuantity += float64(ask.Quantity)
@@ -198,21 +194,31 @@ func (tb *TradeBot) makeDecision(orderBook *pb.OrderBook) {
 	tb.logger.Debugf("bids/asks ratio: %f, expected: %f",
 		bidsQuantity/asksQuantity, tb.config.BidsAsksRatio)
 
-	if order, ok := tb.orders[orderBook.Figi]; ok {
+	if order, ok := tb.order(orderBook.Figi); ok {
 		tb.logger.With("order_id", order.OrderID).Debug("order already exists")
 		return
 	}
 
 	if bidsQuantity/asksQuantity > tb.config.BidsAsksRatio {
-		tb.tryToBuy(orderBook)
+		tb.tryToBuy(ctx, orderBook)
 	}
 	if asksQuantity/bidsQuantity > tb.config.AsksBidsRatio {
-		tb.tryToSell(orderBook)
+		tb.tryToSell(ctx, orderBook)
 	}
 }
 
 // tryToBuy tries to create buy order with price calculated on pb.OrderBook.
-func (tb *TradeBot) tryToBuy(orderBook *pb.OrderBook) {
+func (tb *TradeBot) tryToBuy(ctx context.Context, orderBook *pb.OrderBook) {
+	instrument, err := tb.services.Instruments.ByFigi(ctx, orderBook.Figi)
+	if err != nil {
+		tb.logger.Errorf("error getting instrument: %v", err)
+		return // try again on the next order book
+	}
+
+	if len(orderBook.Bids) <= tb.config.OrderBookFairBidDepth {
+		tb.logger.Warnf("order book has %d bids, fair price level %d is missing", len(orderBook.Bids), tb.config.OrderBookFairBidDepth)
+		return // try again on the next order book
+	}
 	fairPrice := orderBook.Bids[tb.config.OrderBookFairBidDepth].Price
 	fairMarketPrice := tradeutil.QuotationToFloat(*fairPrice)
 
@@ -230,13 +236,7 @@ func (tb *TradeBot) tryToBuy(orderBook *pb.OrderBook) {
 	}
 
 	var orderResponse *pb.PostOrderResponse
-	var err error
-
-	if config.TradeBotConfig().IsSandbox {
-		orderResponse, err = services.SandboxService.PostSandboxOrder(orderRequest)
-	} else {
-		orderResponse, err = services.OrdersService.PostOrder(orderRequest)
-	}
+	orderResponse, err = tb.broker.PostOrder(ctx, orderRequest)
 	if err != nil {
 		tb.logger.Errorf("can not post buy order: %v", err)
 		return // nothing bad happened, let's proceed
@@ -246,30 +246,37 @@ func (tb *TradeBot) tryToBuy(orderBook *pb.OrderBook) {
 
 	order.SellFlag = false
 	order.OrderID = orderResponse.OrderId
-	order.OrderPrice = &pb.MoneyValue{
-		Units:    fairPrice.Units,
-		Nano:     fairPrice.Nano,
-		Currency: orderResponse.InitialOrderPrice.Currency,
-	}
+	order.OrderPrice = tradeutil.DecimalFromQuotation(fairPrice).MoneyValue(instrument.Currency)
 
 	t := time.Now().Unix()
 	order.OrderPlacedTime = &t
 
 	tb.logger.With("order_id", order.OrderID).
-		Infof("buy order created, fair price: %d.%d, initial price: %d.%d %s, current status: %s",
-			fairPrice.Units, fairPrice.Nano,
-			orderResponse.InitialOrderPrice.Units, orderResponse.InitialOrderPrice.Nano,
-			orderResponse.InitialOrderPrice.Currency, orderResponse.ExecutionReportStatus.String())
+		Infof("buy order created, fair price: %s, amount: %s, initial price: %s, current status: %s",
+			tradeutil.DecimalFromQuotation(fairPrice),
+			common.OrderAmount(instrument, fairPrice, orderRequest.Quantity),
+			tradeutil.MoneyFromMoneyValue(orderResponse.InitialOrderPrice),
+			orderResponse.ExecutionReportStatus.String())
 
 	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), orderBook.Figi,
 		pb.OrderDirection_ORDER_DIRECTION_BUY.String()).Inc()
 
-	tb.orders[orderBook.Figi] = order
+	tb.keepOrder(orderBook.Figi, order)
 }
 
 // tryToSell tries to create sell order with price calculated on pb.OrderBook.
-func (tb *TradeBot) tryToSell(orderBook *pb.OrderBook) {
-	fairPrice := orderBook.Asks[5].Price
+func (tb *TradeBot) tryToSell(ctx context.Context, orderBook *pb.OrderBook) {
+	instrument, err := tb.services.Instruments.ByFigi(ctx, orderBook.Figi)
+	if err != nil {
+		tb.logger.Errorf("error getting instrument: %v", err)
+		return // try again on the next order book
+	}
+
+	if len(orderBook.Asks) <= tb.config.OrderBookFairAskDepth {
+		tb.logger.Warnf("order book has %d asks, fair price level %d is missing", len(orderBook.Asks), tb.config.OrderBookFairAskDepth)
+		return // try again on the next order book
+	}
+	fairPrice := orderBook.Asks[tb.config.OrderBookFairAskDepth].Price
 	fairMarketPrice := tradeutil.QuotationToFloat(*fairPrice)
 
 	metrics.InstrumentFairPrice.WithLabelValues(orderBook.Figi).Set(fairMarketPrice)
@@ -286,13 +293,7 @@ func (tb *TradeBot) tryToSell(orderBook *pb.OrderBook) {
 	}
 
 	var orderResponse *pb.PostOrderResponse
-	var err error
-
-	if config.TradeBotConfig().IsSandbox {
-		orderResponse, err = services.SandboxService.PostSandboxOrder(orderRequest)
-	} else {
-		orderResponse, err = services.OrdersService.PostOrder(orderRequest)
-	}
+	orderResponse, err = tb.broker.PostOrder(ctx, orderRequest)
 	if err != nil {
 		tb.logger.Errorf("can not post sell order: %v", err)
 		return // nothing bad happened, let's proceed
@@ -302,37 +303,30 @@ func (tb *TradeBot) tryToSell(orderBook *pb.OrderBook) {
 
 	order.SellFlag = true
 	order.OrderID = orderResponse.OrderId
-	order.OrderPrice = &pb.MoneyValue{
-		Units:    fairPrice.Units,
-		Nano:     fairPrice.Nano,
-		Currency: orderResponse.InitialOrderPrice.Currency,
-	}
+	order.OrderPrice = tradeutil.DecimalFromQuotation(fairPrice).MoneyValue(instrument.Currency)
 
 	t := time.Now().Unix()
 	order.OrderPlacedTime = &t
 
 	tb.logger.With("order_id", order.OrderID).
-		Infof("sell order created, fair price: %d.%d, initial price: %d.%d %s, current status: %s",
-			fairPrice.Units, fairPrice.Nano,
-			orderResponse.InitialOrderPrice.Units, orderResponse.InitialOrderPrice.Nano,
-			orderResponse.InitialOrderPrice.Currency, orderResponse.ExecutionReportStatus.String())
+		Infof("sell order created, fair price: %s, amount: %s, initial price: %s, current status: %s",
+			tradeutil.DecimalFromQuotation(fairPrice),
+			common.OrderAmount(instrument, fairPrice, orderRequest.Quantity),
+			tradeutil.MoneyFromMoneyValue(orderResponse.InitialOrderPrice),
+			orderResponse.ExecutionReportStatus.String())
 
 	metrics.OrdersPlaced.WithLabelValues(loggy.GetBotID(), orderBook.Figi,
 		pb.OrderDirection_ORDER_DIRECTION_SELL.String()).Inc()
 
-	tb.orders[orderBook.Figi] = order
+	tb.keepOrder(orderBook.Figi, order)
 }
 
 // checkPortfolio calls sdk.OperationsService.GetPortfolio and updates portfolio metrics.
-func (tb *TradeBot) checkPortfolio() {
+func (tb *TradeBot) checkPortfolio(ctx context.Context) {
 	var portfolio *pb.PortfolioResponse
 	var err error
 
-	if config.TradeBotConfig().IsSandbox {
-		portfolio, err = services.SandboxService.GetSandboxPortfolio(tb.accountID)
-	} else {
-		portfolio, err = services.OperationsService.GetPortfolio(tb.accountID)
-	}
+	portfolio, err = tb.broker.GetPortfolio(ctx)
 
 	if err != nil {
 		tb.logger.Errorf("error getting order book: %v", err)
@@ -343,7 +337,7 @@ func (tb *TradeBot) checkPortfolio() {
 	common.SetPortfolioMetrics(*portfolio, tb.accountID)
 
 	if portfolio.ExpectedYield != nil {
-		tb.logger.Infof("expected yield: %d.%d", portfolio.ExpectedYield.Units, portfolio.ExpectedYield.Nano)
+		tb.logger.Infof("expected yield: %s", tradeutil.DecimalFromQuotation(portfolio.ExpectedYield))
 		metrics.PortfolioExpectedYieldOverall.WithLabelValues(tb.accountID).Set(tradeutil.QuotationToFloat(*portfolio.ExpectedYield))
 	}
 }
@@ -0,0 +1,62 @@
+package tumble
+
+import (
+	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/elkopass/BITA/internal/trade"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+const testFigi = "BBG004730N88"
+
+// offlineBroker fails portfolio requests at once, the rest of trade.Broker is not used by the test.
+type offlineBroker struct {
+	trade.Broker
+}
+
+func (offlineBroker) GetPortfolio(context.Context) (*pb.PortfolioResponse, error) {
+	return nil, errors.New("offline")
+}
+
+// TestTradeBotOrdersOfConcurrentStreams places and checks orders as order books do while
+// the trades stream reports them fulfilled, as Run does; run with -race.
+func TestTradeBotOrdersOfConcurrentStreams(t *testing.T) {
+	tb := &TradeBot{
+		orders: make(map[string]Order),
+		config: TradeConfig{LotsToBuy: 1, AsksBidsRatio: 1.5, BidsAsksRatio: 1.5, OrderBookDepth: 10},
+		broker: offlineBroker{},
+		logger: zap.NewNop().Sugar(), // a shared log would synchronize goroutines and hide races
+	}
+	ctx := context.Background()
+	book := &pb.OrderBook{
+		Figi: testFigi,
+		Bids: []*pb.Order{{Price: &pb.Quotation{Units: 100}, Quantity: 10}},
+		Asks: []*pb.Order{{Price: &pb.Quotation{Units: 101}, Quantity: 10}},
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tb.keepOrder(testFigi, Order{OrderID: "order"})
+			tb.makeDecision(ctx, book) // the order exists or the ratios are not reached
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tb.orderIsFulfilled(ctx, &pb.OrderTrades{Figi: testFigi, Direction: pb.OrderDirection_ORDER_DIRECTION_BUY})
+		}
+	}()
+	wg.Wait()
+
+	tb.keepOrder(testFigi, Order{OrderID: "order"})
+	tb.orderIsFulfilled(ctx, &pb.OrderTrades{Figi: testFigi, Direction: pb.OrderDirection_ORDER_DIRECTION_BUY})
+	if order, ok := tb.order(testFigi); ok {
+		t.Errorf("fulfilled order must be forgotten, got %+v", order)
+	}
+}
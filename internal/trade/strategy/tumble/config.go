@@ -1,37 +1,46 @@
 package tumble
 
 import (
-	"github.com/elkopass/BITA/internal/loggy"
+	"errors"
 	"github.com/elkopass/BITA/internal/sdk"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/elkopass/BITA/internal/trade"
+	"github.com/elkopass/BITA/internal/trade/strategy"
 )
 
-var services = sdk.NewServicePool()
+func init() {
+	strategy.Register(strategy.Strategy{
+		Name: strategy.TUMBLE,
+		Description: "trades by the ratio of bids and asks in order books, " +
+			"production broker only, in development",
+		Config: func() strategy.Config { return &TradeConfig{} },
+		New: func(client *sdk.Client, broker trade.Broker, figi []string, cnf strategy.Config) (trade.Trader, error) {
+			return NewTradeBot(client, broker, figi, *cnf.(*TradeConfig)), nil
+		},
+	})
+}
 
 type TradeConfig struct {
-	LotsToBuy int `default:"1" split_words:"true"`
+	LotsToBuy int `default:"1" split_words:"true" desc:"lots to trade for one instrument"`
 
-	AsksBidsRatio float64 `default:"1.5" split_words:"true"`
-	BidsAsksRatio float64 `default:"1.5" split_words:"true"`
+	AsksBidsRatio float64 `default:"1.5" split_words:"true" desc:"minimal asks to bids ratio to sell"`
+	BidsAsksRatio float64 `default:"1.5" split_words:"true" desc:"minimal bids to asks ratio to buy"`
 
-	OrderBookDepth        int `default:"10" split_words:"true"`
-	OrderBookFairAskDepth int `default:"5" split_words:"true"`
-	OrderBookFairBidDepth int `default:"5" split_words:"true"`
+	OrderBookDepth        int `default:"10" split_words:"true" desc:"depth of requested order books"`
+	OrderBookFairAskDepth int `default:"5" split_words:"true" desc:"order book level of the fair ask price, counted from 0, below order book depth"`
+	OrderBookFairBidDepth int `default:"5" split_words:"true" desc:"order book level of the fair bid price, counted from 0, below order book depth"`
 }
 
-func NewTradeConfig() *TradeConfig {
-	var c TradeConfig
-	err := envconfig.Process("tumble_strategy", &c)
-	if err != nil {
-		loggy.GetLogger().Sugar().Fatalf("failed to process config: %v", err)
-	}
-
-	if c.OrderBookFairBidDepth > c.OrderBookDepth {
-		loggy.GetLogger().Sugar().Fatal("fair bid depth must be lower than order book depth")
-	}
-	if c.OrderBookFairAskDepth > c.OrderBookDepth {
-		loggy.GetLogger().Sugar().Fatal("fair ask depth must be lower than order book depth")
+func (c *TradeConfig) Validate() error {
+	switch {
+	case c.LotsToBuy < 1:
+		return errors.New("TUMBLE_STRATEGY_LOTS_TO_BUY must be positive")
+	case c.OrderBookDepth < 1:
+		return errors.New("TUMBLE_STRATEGY_ORDER_BOOK_DEPTH must be positive")
+	case c.OrderBookFairBidDepth < 0 || c.OrderBookFairBidDepth >= c.OrderBookDepth:
+		return errors.New("TUMBLE_STRATEGY_ORDER_BOOK_FAIR_BID_DEPTH must be non-negative and less than TUMBLE_STRATEGY_ORDER_BOOK_DEPTH")
+	case c.OrderBookFairAskDepth < 0 || c.OrderBookFairAskDepth >= c.OrderBookDepth:
+		return errors.New("TUMBLE_STRATEGY_ORDER_BOOK_FAIR_ASK_DEPTH must be non-negative and less than TUMBLE_STRATEGY_ORDER_BOOK_DEPTH")
 	}
 
-	return &c
+	return nil
 }
@@ -1,4 +1,5 @@
-// Package strategy enumerates available trading strategies to use.
+// Package strategy enumerates built-in trading strategies and keeps the registry
+// of strategies trade bot can run.
 package strategy
 
 const (
@@ -3,9 +3,15 @@
 
 import (
 	"context"
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"sync"
 )
 
+// ErrStopOrdersNotSupported is returned by brokers which can not place stop orders, e.g. in sandbox.
+var ErrStopOrdersNotSupported = errors.New("stop orders are not supported by broker")
+
 // Trader is a common interface for all trading bots.
 type Trader interface {
 	// Run starts a trading bot with multiple workers;
@@ -19,3 +25,38 @@ type TradeWorker interface {
 	// and a *sync.WaitGroup is required for graceful shutdown.
 	Run(ctx context.Context, wg *sync.WaitGroup) (err error)
 }
+
+// Broker places and tracks orders of a single account, so strategies
+// do not care whether they trade in sandbox, production or simulation.
+type Broker interface {
+	// Name returns broker kind, see broker package constants.
+	Name() string
+	// AccountID returns ID of the account all orders are placed on.
+	AccountID() string
+
+	// PostOrder places an order; AccountId of the request is filled by broker.
+	PostOrder(ctx context.Context, order *pb.PostOrderRequest) (*pb.PostOrderResponse, error)
+	// CancelOrder cancels an active order.
+	CancelOrder(ctx context.Context, orderID string) (*timestamp.Timestamp, error)
+	// GetOrderState returns current order state.
+	GetOrderState(ctx context.Context, orderID string) (*pb.OrderState, error)
+	// GetOrders returns all active orders.
+	GetOrders(ctx context.Context) ([]*pb.OrderState, error)
+
+	// PostStopOrder places a stop order and returns its ID; AccountId of the request is filled by broker.
+	PostStopOrder(ctx context.Context, stopOrder *pb.PostStopOrderRequest) (string, error)
+	// CancelStopOrder cancels an active stop order.
+	CancelStopOrder(ctx context.Context, stopOrderID string) (*timestamp.Timestamp, error)
+	// GetStopOrders returns all active stop orders; triggered ones are not in the list.
+	GetStopOrders(ctx context.Context) ([]*pb.StopOrder, error)
+
+	// GetPortfolio returns account portfolio.
+	GetPortfolio(ctx context.Context) (*pb.PortfolioResponse, error)
+	// GetPositions returns money and securities of the account.
+	GetPositions(ctx context.Context) (*pb.PositionsResponse, error)
+	// GetOperations returns account operations filtered by period, state and figi.
+	GetOperations(ctx context.Context, from, to *timestamp.Timestamp, state pb.OperationState, figi string) ([]*pb.Operation, error)
+
+	// Close releases the account, e.g. closes sandbox account on exit.
+	Close(ctx context.Context) error
+}
@@ -0,0 +1,389 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const nanoPerUnit = 1000000000
+
+var (
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	// ErrDecimalOverflow is returned by operations whose result does not fit into Decimal.
+	ErrDecimalOverflow = errors.New("decimal overflow")
+)
+
+var (
+	bigNanoPerUnit = big.NewInt(nanoPerUnit)
+	maxUnits       = big.NewInt(math.MaxInt64)
+)
+
+// Decimal is an exact fixed-point number with 9 fractional digits, stored
+// the same way pb.Quotation and pb.MoneyValue are: integer units and nano part
+// which has the sign of units and is less than a unit by absolute value.
+// So any value sent by API is represented exactly; units are within ±math.MaxInt64.
+type Decimal struct {
+	units int64
+	nano  int32
+}
+
+// NewDecimal creates Decimal from units and nano parts, as they are stored in API messages.
+func NewDecimal(units int64, nano int32) (Decimal, error) {
+	if nano <= -nanoPerUnit || nano >= nanoPerUnit {
+		return Decimal{}, fmt.Errorf("nano part %d is not less than a unit", nano)
+	}
+	if (units > 0 && nano < 0) || (units < 0 && nano > 0) {
+		return Decimal{}, fmt.Errorf("signs of units %d and nano %d differ", units, nano)
+	}
+	if units == math.MinInt64 {
+		return Decimal{}, ErrDecimalOverflow
+	}
+
+	return Decimal{units: units, nano: nano}, nil
+}
+
+// DecimalFromInt creates Decimal from an integer.
+func DecimalFromInt(i int64) Decimal {
+	return Decimal{units: i}
+}
+
+// DecimalFromFloat creates Decimal from float64 rounded to nano; it is meant for
+// coefficients from config, f must be finite and within the Decimal range.
+func DecimalFromFloat(f float64) Decimal {
+	units := math.Trunc(f)
+	nano := math.Round((f - units) * nanoPerUnit)
+	return Decimal{}.add(int64(units), int64(nano))
+}
+
+// DecimalFromQuotation converts pb.Quotation to Decimal; nil is treated as zero.
+func DecimalFromQuotation(q *pb.Quotation) Decimal {
+	if q == nil {
+		return Decimal{}
+	}
+	return Decimal{}.add(q.Units, int64(q.Nano))
+}
+
+// DecimalFromMoneyValue converts amount of pb.MoneyValue to Decimal; nil is treated as zero.
+func DecimalFromMoneyValue(m *pb.MoneyValue) Decimal {
+	if m == nil {
+		return Decimal{}
+	}
+	return Decimal{}.add(m.Units, int64(m.Nano))
+}
+
+// ParseDecimal parses strings like "-12.345"; a single leading sign is allowed.
+func ParseDecimal(s string) (Decimal, error) {
+	raw := strings.TrimSpace(s)
+	negative := strings.HasPrefix(raw, "-")
+	if strings.HasPrefix(raw, "-") || strings.HasPrefix(raw, "+") {
+		raw = raw[1:]
+	}
+
+	parts := strings.SplitN(raw, ".", 2)
+	if parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		return Decimal{}, fmt.Errorf("can not parse decimal '%s'", s)
+	}
+	for _, part := range parts {
+		if strings.TrimLeft(part, "0123456789") != "" {
+			return Decimal{}, fmt.Errorf("can not parse decimal '%s'", s)
+		}
+	}
+
+	var units, nano int64
+	var err error
+	if parts[0] != "" {
+		units, err = strconv.ParseInt(parts[0], 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return Decimal{}, fmt.Errorf("can not parse decimal '%s': %w", s, ErrDecimalOverflow)
+		}
+		if err != nil {
+			return Decimal{}, fmt.Errorf("can not parse decimal '%s': %v", s, err)
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		fraction := parts[1]
+		if len(fraction) > 9 {
+			return Decimal{}, fmt.Errorf("can not parse decimal '%s': more than 9 fractional digits", s)
+		}
+		nano, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("can not parse decimal '%s': %v", s, err)
+		}
+	}
+
+	d := Decimal{units: units, nano: int32(nano)}
+	if negative {
+		return d.Neg(), nil
+	}
+	return d, nil
+}
+
+// Units returns integer part of the number, truncated toward zero.
+func (d Decimal) Units() int64 {
+	return d.units
+}
+
+// Nano returns fractional part of the number in billionths, it has the same sign as Units.
+func (d Decimal) Nano() int32 {
+	return d.nano
+}
+
+// Quotation converts Decimal to pb.Quotation.
+func (d Decimal) Quotation() *pb.Quotation {
+	return &pb.Quotation{Units: d.units, Nano: d.nano}
+}
+
+// MoneyValue converts Decimal to pb.MoneyValue with given currency.
+func (d Decimal) MoneyValue(currency string) *pb.MoneyValue {
+	return &pb.MoneyValue{Units: d.units, Nano: d.nano, Currency: currency}
+}
+
+// Float64 returns the nearest float64, use it for metrics and logs only.
+func (d Decimal) Float64() float64 {
+	return float64(d.units) + float64(d.nano)/nanoPerUnit
+}
+
+// String returns number without trailing zeros, e.g. "1.05" or "-0.5".
+func (d Decimal) String() string {
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+
+	units := new(big.Int).Abs(big.NewInt(d.units))
+	nano := d.nano
+	if nano < 0 {
+		nano = -nano
+	}
+	if nano == 0 {
+		return sign + units.String()
+	}
+
+	fraction := strings.TrimRight(fmt.Sprintf("%09d", nano), "0")
+	return fmt.Sprintf("%s%s.%s", sign, units, fraction)
+}
+
+// Add sums two decimals; like Sub it does not check the range, which is far beyond
+// any amount of money.
+func (d Decimal) Add(o Decimal) Decimal {
+	return d.add(o.units, int64(o.nano))
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	return d.Add(o.Neg())
+}
+
+// add adds units and nano, which may be a unit or more by absolute value, and normalizes the result.
+func (d Decimal) add(units, nano int64) Decimal {
+	units += d.units + nano/nanoPerUnit
+	nano = int64(d.nano) + nano%nanoPerUnit
+	units, nano = units+nano/nanoPerUnit, nano%nanoPerUnit
+
+	switch {
+	case units > 0 && nano < 0:
+		units, nano = units-1, nano+nanoPerUnit
+	case units < 0 && nano > 0:
+		units, nano = units+1, nano-nanoPerUnit
+	}
+	return Decimal{units: units, nano: int32(nano)}
+}
+
+// MulInt multiplies Decimal by an integer, e.g. price by quantity.
+func (d Decimal) MulInt(i int64) (Decimal, error) {
+	return decimalFromNano(new(big.Int).Mul(d.bigNano(), big.NewInt(i)))
+}
+
+// Mul multiplies two decimals, result is rounded half away from zero to nano.
+func (d Decimal) Mul(o Decimal) (Decimal, error) {
+	product := new(big.Int).Mul(d.bigNano(), o.bigNano())
+	return decimalFromNano(roundedQuo(product, bigNanoPerUnit))
+}
+
+// Div divides two decimals, result is rounded half away from zero to nano.
+func (d Decimal) Div(o Decimal) (Decimal, error) {
+	if o.IsZero() {
+		return Decimal{}, errors.New("division by zero")
+	}
+
+	dividend := new(big.Int).Mul(d.bigNano(), bigNanoPerUnit)
+	return decimalFromNano(roundedQuo(dividend, o.bigNano()))
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{units: -d.units, nano: -d.nano}
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.Sign() < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+// Sign returns -1, 0 or 1.
+func (d Decimal) Sign() int {
+	switch {
+	case d.units < 0 || d.nano < 0:
+		return -1
+	case d.units > 0 || d.nano > 0:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) IsZero() bool {
+	return d.units == 0 && d.nano == 0
+}
+
+// Cmp returns -1 if d < o, 0 if d == o and 1 if d > o.
+func (d Decimal) Cmp(o Decimal) int {
+	// both parts have the same sign, so numbers compare as (units, nano) pairs
+	switch {
+	case d.units < o.units || (d.units == o.units && d.nano < o.nano):
+		return -1
+	case d.units > o.units || (d.units == o.units && d.nano > o.nano):
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Equal(o Decimal) bool {
+	return d == o
+}
+
+func (d Decimal) LessThan(o Decimal) bool {
+	return d.Cmp(o) < 0
+}
+
+func (d Decimal) GreaterThan(o Decimal) bool {
+	return d.Cmp(o) > 0
+}
+
+// RoundToIncrement rounds Decimal half away from zero to a multiple of increment,
+// e.g. to instrument's min price increment; zero increment leaves number as is.
+func (d Decimal) RoundToIncrement(increment Decimal) (Decimal, error) {
+	if increment.IsZero() {
+		return d, nil
+	}
+
+	step := increment.Abs().bigNano()
+	steps := roundedQuo(d.bigNano(), step)
+	return decimalFromNano(steps.Mul(steps, step))
+}
+
+// FloorToIncrement rounds Decimal down to a multiple of increment.
+func (d Decimal) FloorToIncrement(increment Decimal) (Decimal, error) {
+	if increment.IsZero() {
+		return d, nil
+	}
+
+	step := increment.Abs().bigNano()
+	steps := new(big.Int).Div(d.bigNano(), step) // Euclidean division rounds down for a positive step
+	return decimalFromNano(steps.Mul(steps, step))
+}
+
+// CeilToIncrement rounds Decimal up to a multiple of increment.
+func (d Decimal) CeilToIncrement(increment Decimal) (Decimal, error) {
+	if increment.IsZero() {
+		return d, nil
+	}
+
+	floor, err := d.Neg().FloorToIncrement(increment)
+	return floor.Neg(), err
+}
+
+// bigNano returns the number in billionths.
+func (d Decimal) bigNano() *big.Int {
+	n := new(big.Int).Mul(big.NewInt(d.units), bigNanoPerUnit)
+	return n.Add(n, big.NewInt(int64(d.nano)))
+}
+
+// decimalFromNano converts a number in billionths to Decimal, checking the range.
+func decimalFromNano(n *big.Int) (Decimal, error) {
+	units, nano := new(big.Int).QuoRem(n, bigNanoPerUnit, new(big.Int))
+	if new(big.Int).Abs(units).Cmp(maxUnits) > 0 {
+		return Decimal{}, ErrDecimalOverflow
+	}
+
+	return Decimal{units: units.Int64(), nano: int32(nano.Int64())}, nil
+}
+
+// roundedQuo returns x/y rounded half away from zero.
+func roundedQuo(x, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	// |2r| >= |y| means the remainder is at least a half
+	if new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(y)) >= 0 {
+		if (x.Sign() < 0) != (y.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return q
+}
+
+// Money is an amount of Decimal in a single currency.
+type Money struct {
+	Amount   Decimal
+	Currency string
+}
+
+// NewMoney creates Money; currency is stored in lower case, as API returns it.
+func NewMoney(amount Decimal, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToLower(currency)}
+}
+
+// MoneyFromMoneyValue converts pb.MoneyValue to Money; nil is treated as zero without currency.
+func MoneyFromMoneyValue(m *pb.MoneyValue) Money {
+	if m == nil {
+		return Money{}
+	}
+	return NewMoney(DecimalFromMoneyValue(m), m.Currency)
+}
+
+// MoneyValue converts Money to pb.MoneyValue.
+func (m Money) MoneyValue() *pb.MoneyValue {
+	return m.Amount.MoneyValue(m.Currency)
+}
+
+// Add sums two amounts of the same currency; zero Money without currency
+// can be added to any amount, so it can be used as an initial value of a sum.
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.commonCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount.Add(o.Amount), Currency: currency}, nil
+}
+
+// Sub subtracts two amounts of the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(Money{Amount: o.Amount.Neg(), Currency: o.Currency})
+}
+
+// String returns amount with currency, e.g. "1.05 rub".
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Amount.String()
+	}
+	return m.Amount.String() + " " + m.Currency
+}
+
+func (m Money) commonCurrency(o Money) (string, error) {
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount.IsZero():
+		return o.Currency, nil
+	case o.Currency == "" && o.Amount.IsZero():
+		return m.Currency, nil
+	}
+
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+}
@@ -0,0 +1,224 @@
+package util
+
+import (
+	"errors"
+	pb "github.com/elkopass/BITA/internal/proto"
+	"math"
+	"testing"
+)
+
+func TestDecimalFromQuotation(t *testing.T) {
+	tests := []struct {
+		units int64
+		nano  int32
+		want  string
+	}{
+		{1, 50000000, "1.05"}, // used to be formatted as "1.5"
+		{1, 500000000, "1.5"},
+		{-1, -50000000, "-1.05"},
+		{0, -500000000, "-0.5"},
+		{0, 1, "0.000000001"},
+		{math.MaxInt64, 999999999, "9223372036854775807.999999999"},
+	}
+
+	for _, tt := range tests {
+		d := DecimalFromQuotation(&pb.Quotation{Units: tt.units, Nano: tt.nano})
+		if got := d.String(); got != tt.want {
+			t.Errorf("DecimalFromQuotation(%d, %d) = %s, want %s", tt.units, tt.nano, got, tt.want)
+		}
+		if q := d.Quotation(); q.Units != tt.units || q.Nano != tt.nano {
+			t.Errorf("DecimalFromQuotation(%d, %d).Quotation() = %d, %d", tt.units, tt.nano, q.Units, q.Nano)
+		}
+	}
+
+	if f := QuotationToFloat(pb.Quotation{Units: 1, Nano: 50000000}); f != 1.05 {
+		t.Errorf("QuotationToFloat(1, 50000000) = %v, want 1.05", f)
+	}
+}
+
+func TestNewDecimal(t *testing.T) {
+	tests := []struct {
+		units   int64
+		nano    int32
+		wantErr bool
+	}{
+		{1, 50000000, false},
+		{-1, -1, false},
+		{0, -999999999, false},
+		{1, 1000000000, true},
+		{1, -1, true},
+		{-1, 1, true},
+		{math.MinInt64, 0, true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewDecimal(tt.units, tt.nano)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewDecimal(%d, %d) error = %v, wantErr %v", tt.units, tt.nano, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"1.05", "1.05", false},
+		{"1.5", "1.5", false},
+		{"-1.05", "-1.05", false},
+		{"+1.05", "1.05", false},
+		{" 12.345 ", "12.345", false},
+		{".5", "0.5", false},
+		{"-.5", "-0.5", false},
+		{"5.", "5", false},
+		{"0.000000001", "0.000000001", false},
+		{"9223372036854775807.999999999", "9223372036854775807.999999999", false},
+		{"--5", "", true},
+		{"+-5", "", true},
+		{"-+-5", "", true},
+		{"-", "", true},
+		{".", "", true},
+		{"", "", true},
+		{"1.-5", "", true},
+		{"1.+5", "", true},
+		{"1e3", "", true},
+		{"0.0000000001", "", true},
+		{"9223372036854775808", "", true},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDecimal(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && d.String() != tt.want {
+			t.Errorf("ParseDecimal(%q) = %s, want %s", tt.s, d, tt.want)
+		}
+	}
+
+	if _, err := ParseDecimal("9223372036854775808"); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("ParseDecimal of too many units error = %v, want %v", err, ErrDecimalOverflow)
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a := mustParse(t, "1.05")
+	b := mustParse(t, "-0.5")
+
+	if got := a.Add(b).String(); got != "0.55" {
+		t.Errorf("1.05 + -0.5 = %s, want 0.55", got)
+	}
+	if got := b.Sub(a).String(); got != "-1.55" {
+		t.Errorf("-0.5 - 1.05 = %s, want -1.55", got)
+	}
+	if got := mustParse(t, "0.7").Add(mustParse(t, "0.6")).String(); got != "1.3" {
+		t.Errorf("0.7 + 0.6 = %s, want 1.3", got)
+	}
+	if got := mustParse(t, "1.2").Sub(mustParse(t, "1.7")).String(); got != "-0.5" {
+		t.Errorf("1.2 - 1.7 = %s, want -0.5", got)
+	}
+
+	product, err := a.Mul(b)
+	if err != nil || product.String() != "-0.525" {
+		t.Errorf("1.05 * -0.5 = %s, %v, want -0.525", product, err)
+	}
+	quotient, err := DecimalFromInt(2).Div(DecimalFromInt(3))
+	if err != nil || quotient.String() != "0.666666667" {
+		t.Errorf("2 / 3 = %s, %v, want 0.666666667", quotient, err)
+	}
+	if _, err := a.Div(Decimal{}); err == nil {
+		t.Error("division by zero succeeded")
+	}
+	amount, err := a.MulInt(10)
+	if err != nil || amount.String() != "10.5" {
+		t.Errorf("1.05 * 10 = %s, %v, want 10.5", amount, err)
+	}
+
+	if a.Cmp(b) != 1 || b.Cmp(a) != -1 || a.Cmp(mustParse(t, "1.050")) != 0 {
+		t.Error("Cmp of 1.05 and -0.5 is wrong")
+	}
+	if !mustParse(t, "-1.5").LessThan(mustParse(t, "-1.2")) || !mustParse(t, "1.5").GreaterThan(mustParse(t, "0.9")) {
+		t.Error("comparison of numbers with fractions is wrong")
+	}
+}
+
+func TestDecimalOverflow(t *testing.T) {
+	max := DecimalFromQuotation(&pb.Quotation{Units: math.MaxInt64, Nano: 999999999})
+
+	if _, err := max.MulInt(2); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("MulInt overflow error = %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := max.Mul(DecimalFromInt(2)); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("Mul overflow error = %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := max.Div(mustParse(t, "0.5")); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("Div overflow error = %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := max.RoundToIncrement(DecimalFromInt(1)); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("RoundToIncrement overflow error = %v, want %v", err, ErrDecimalOverflow)
+	}
+
+	// the former int64 of nano overflowed at about 9.2 billion units
+	price := DecimalFromInt(10000000000)
+	amount, err := price.MulInt(100)
+	if err != nil || amount.String() != "1000000000000" {
+		t.Errorf("10000000000 * 100 = %s, %v, want 1000000000000", amount, err)
+	}
+}
+
+func TestDecimalRounding(t *testing.T) {
+	tests := []struct {
+		d, increment       string
+		round, floor, ceil string
+	}{
+		{"1.234", "0.01", "1.23", "1.23", "1.24"},
+		{"1.235", "0.01", "1.24", "1.23", "1.24"},
+		{"-1.235", "0.01", "-1.24", "-1.24", "-1.23"},
+		{"-1.234", "0.01", "-1.23", "-1.24", "-1.23"},
+		{"102.5", "5", "105", "100", "105"},
+		{"1.2", "0.2", "1.2", "1.2", "1.2"},
+		{"1.234", "0", "1.234", "1.234", "1.234"},
+	}
+
+	for _, tt := range tests {
+		d, increment := mustParse(t, tt.d), mustParse(t, tt.increment)
+
+		round, err := d.RoundToIncrement(increment)
+		if err != nil || round.String() != tt.round {
+			t.Errorf("RoundToIncrement(%s, %s) = %s, %v, want %s", tt.d, tt.increment, round, err, tt.round)
+		}
+		floor, err := d.FloorToIncrement(increment)
+		if err != nil || floor.String() != tt.floor {
+			t.Errorf("FloorToIncrement(%s, %s) = %s, %v, want %s", tt.d, tt.increment, floor, err, tt.floor)
+		}
+		ceil, err := d.CeilToIncrement(increment)
+		if err != nil || ceil.String() != tt.ceil {
+			t.Errorf("CeilToIncrement(%s, %s) = %s, %v, want %s", tt.d, tt.increment, ceil, err, tt.ceil)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	rub := NewMoney(mustParse(t, "1.05"), "RUB")
+
+	sum, err := Money{}.Add(rub)
+	if err != nil || sum.String() != "1.05 rub" {
+		t.Errorf("0 + 1.05 rub = %s, %v, want 1.05 rub", sum, err)
+	}
+	if _, err := rub.Add(NewMoney(DecimalFromInt(1), "usd")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("rub + usd error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatalf("ParseDecimal(%q): %v", s, err)
+	}
+	return d
+}
@@ -32,23 +32,20 @@ func GetFormattedPositions(positions []*pb.PortfolioPosition) string {
 		)
 		if p.Quantity != nil {
 			formattedPositions += fmt.Sprintf(
-				", %d.%d quantity",
-				p.Quantity.Units,
-				p.Quantity.Nano,
+				", %s quantity",
+				DecimalFromQuotation(p.Quantity),
 			)
 		}
 		if p.CurrentPrice != nil {
 			formattedPositions += fmt.Sprintf(
-				", %d.%d price",
-				p.CurrentPrice.Units,
-				p.CurrentPrice.Nano,
+				", %s price",
+				MoneyFromMoneyValue(p.CurrentPrice),
 			)
 		}
 		if p.ExpectedYield != nil {
 			formattedPositions += fmt.Sprintf(
-				", %d.%d yield",
-				p.ExpectedYield.Units,
-				p.ExpectedYield.Nano,
+				", %s yield",
+				DecimalFromQuotation(p.ExpectedYield),
 			)
 		}
 
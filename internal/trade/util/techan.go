@@ -2,7 +2,6 @@
 package util
 
 import (
-	"fmt"
 	pb "github.com/elkopass/BITA/internal/proto"
 	"github.com/sdcoffey/big"
 	"github.com/sdcoffey/techan"
@@ -22,24 +21,13 @@ func CandlesToTimeSeries(candles []*pb.HistoricCandle) *techan.TimeSeries {
 				End:   candles[i+1].Time.AsTime(),
 			},
 			Volume:     big.NewFromInt(int(c.Volume)),
-			OpenPrice:  big.NewFromString(fmt.Sprintf("%d.%d", c.Close.Units, abs(c.Close.Nano))),
-			ClosePrice: big.NewFromString(fmt.Sprintf("%d.%d", c.Close.Units, abs(c.Close.Nano))),
-			MaxPrice:   big.NewFromString(fmt.Sprintf("%d.%d", c.High.Units, abs(c.High.Nano))),
-			MinPrice:   big.NewFromString(fmt.Sprintf("%d.%d", c.Low.Units, abs(c.Low.Nano))),
+			OpenPrice:  big.NewFromString(DecimalFromQuotation(c.Open).String()),
+			ClosePrice: big.NewFromString(DecimalFromQuotation(c.Close).String()),
+			MaxPrice:   big.NewFromString(DecimalFromQuotation(c.High).String()),
+			MinPrice:   big.NewFromString(DecimalFromQuotation(c.Low).String()),
 		}
 		techanCandles = append(techanCandles, tc)
 	}
 
 	return &techan.TimeSeries{Candles: techanCandles}
 }
-
-func abs(x int32) int32 {
-	return absDiff(x, 0)
-}
-
-func absDiff(x, y int32) int32 {
-	if x < y {
-		return y - x
-	}
-	return x - y
-}